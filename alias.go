@@ -0,0 +1,121 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry of the kid aliases attached to a config
+type kidAliases struct {
+	mu      sync.RWMutex
+	aliases map[string]string
+}
+
+func newKidAliases() *kidAliases {
+	return &kidAliases{aliases: map[string]string{}}
+}
+
+// AliasKid makes oldKid resolve to the key currently identified by currentKid.
+// The alias is only a lookup name, the key keeps its real kid.
+func (c *Config) AliasKid(oldKid, currentKid string) error {
+	active := c.active()
+	if active == nil {
+		return fmt.Errorf("private key cannot be nil")
+	}
+	if oldKid == "" {
+		return fmt.Errorf("alias kid cannot be empty")
+	}
+
+	key := active.key
+	if currentKid != key.KeyID() {
+		return fmt.Errorf("unknown kid %q", currentKid)
+	}
+	if oldKid == key.KeyID() || c.isAdditionalKid(oldKid) {
+		return fmt.Errorf("alias %q collides with an existing key", oldKid)
+	}
+
+	c.aliases.mu.Lock()
+	if _, ok := c.aliases.aliases[oldKid]; ok {
+		c.aliases.mu.Unlock()
+		return fmt.Errorf("alias %q already exists", oldKid)
+	}
+	c.aliases.aliases[oldKid] = currentKid
+	c.aliases.mu.Unlock()
+	if c.publishesAliases() {
+		c.keySetChanged()
+	}
+
+	c.audit(AuditEvent{
+		Action:  AuditKeyAliased,
+		KeyID:   currentKid,
+		Trigger: AuditTriggerAPI,
+		Details: map[string]string{"alias": oldKid},
+	})
+
+	return nil
+}
+
+// RemoveKidAlias removes a previously registered alias
+func (c *Config) RemoveKidAlias(oldKid string) error {
+	c.aliases.mu.Lock()
+	kid, ok := c.aliases.aliases[oldKid]
+	if !ok {
+		c.aliases.mu.Unlock()
+		return fmt.Errorf("unknown alias %q", oldKid)
+	}
+	delete(c.aliases.aliases, oldKid)
+	c.aliases.mu.Unlock()
+	if c.publishesAliases() {
+		c.keySetChanged()
+	}
+
+	c.audit(AuditEvent{
+		Action:  AuditKeyAliasRemoved,
+		KeyID:   kid,
+		Trigger: AuditTriggerAPI,
+		Details: map[string]string{"alias": oldKid},
+	})
+
+	return nil
+}
+
+// KidAliases returns a copy of the registered aliases indexed by alias
+func (c *Config) KidAliases() map[string]string {
+	c.aliases.mu.RLock()
+	defer c.aliases.mu.RUnlock()
+	res := make(map[string]string, len(c.aliases.aliases))
+	for alias, kid := range c.aliases.aliases {
+		res[alias] = kid
+	}
+
+	return res
+}
+
+// ResolveKid returns the real kid behind kid, following aliases if needed
+func (c *Config) ResolveKid(kid string) (string, bool) {
+	if active := c.active(); (active != nil && kid == active.key.KeyID()) || c.isAdditionalKid(kid) {
+		return kid, true
+	}
+
+	c.aliases.mu.RLock()
+	defer c.aliases.mu.RUnlock()
+	realKid, ok := c.aliases.aliases[kid]
+
+	return realKid, ok
+}
+
+// Sorted list of the aliases pointing at kid
+func (c *Config) aliasesOf(kid string) []string {
+	c.aliases.mu.RLock()
+	defer c.aliases.mu.RUnlock()
+	var res []string
+	for alias, realKid := range c.aliases.aliases {
+		if realKid == kid {
+			res = append(res, alias)
+		}
+	}
+	sort.Strings(res)
+
+	return res
+}
@@ -0,0 +1,95 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// kids of the key set Jkws serves for config, in order
+func aliasServedKids(t *testing.T, config *Config) []string {
+	t.Helper()
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	kids := make([]string, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		kids = append(kids, key.Kid)
+	}
+	return kids
+}
+
+func TestKidAliasResolutionAndRemoval(t *testing.T) {
+	config, err := NewConfigBuilder().WithPublishedKidAliases().NewPrivateKey().WithKeyLength(2048).WithKeyId("test").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.ResolveKid("legacy"); ok {
+		t.Fatal("unknown kid resolved")
+	}
+
+	if err = config.AliasKid("legacy", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if kid, ok := config.ResolveKid("legacy"); !ok || kid != "test" {
+		t.Fatalf("legacy resolves to %q %v", kid, ok)
+	}
+	if kid, ok := config.ResolveKid("test"); !ok || kid != "test" {
+		t.Fatalf("test resolves to %q %v", kid, ok)
+	}
+	if aliases := config.KidAliases(); !reflect.DeepEqual(aliases, map[string]string{"legacy": "test"}) {
+		t.Fatalf("aliases %v", aliases)
+	}
+	if kids := aliasServedKids(t, config); !reflect.DeepEqual(kids, []string{"legacy", "test"}) {
+		t.Fatalf("served %v", kids)
+	}
+
+	for name, args := range map[string][2]string{
+		"duplicate":   {"legacy", "test"},
+		"empty alias": {"", "test"},
+		"unknown kid": {"other", "unknown"},
+		"real kid":    {"test", "test"},
+	} {
+		if err = config.AliasKid(args[0], args[1]); err == nil {
+			t.Fatalf("%s alias registered", name)
+		}
+	}
+
+	if err = config.RemoveKidAlias("legacy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.ResolveKid("legacy"); ok {
+		t.Fatal("removed alias still resolves")
+	}
+	if kids := aliasServedKids(t, config); !reflect.DeepEqual(kids, []string{"test"}) {
+		t.Fatalf("served %v after the removal", kids)
+	}
+	if err = config.RemoveKidAlias("legacy"); err == nil {
+		t.Fatal("alias removed twice")
+	}
+}
+
+func TestKidAliasesAreNotPublishedByDefault(t *testing.T) {
+	config, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).WithKeyId("test").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.AliasKid("legacy", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if kids := aliasServedKids(t, config); !reflect.DeepEqual(kids, []string{"test"}) {
+		t.Fatalf("served %v", kids)
+	}
+}
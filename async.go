@@ -0,0 +1,88 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"fmt"
+)
+
+// Let Build return before the key is generated, e.g. a large RSA key which
+// would delay the startup. The config serves no key, and its handlers answer
+// like a config without a key, until the generation is over.
+func (n *ConfigNewKeyBuilder) WithAsyncGeneration() *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.async = true
+	return n
+}
+
+// Outcome of a key generation running after Build returned
+type asyncGeneration struct {
+	done chan struct{}
+	// set before done is closed
+	err error
+}
+
+// Build the config in the background, only the settings are checked before
+// returning. The key generation stops with Close or when ctx ends.
+func (b *ConfigBuilder) buildAsync(ctx context.Context) (*Config, error) {
+	if err := b.config.validate(); err != nil {
+		return nil, err
+	}
+	// the copies taken by the handlers would not see them
+	if b.config.encKeyBits != 0 || b.config.expvarPrefix != "" {
+		return nil, &invalidConfigError{err: fmt.Errorf("an asynchronous generation cannot come with an encryption key or metrics")}
+	}
+
+	generation := &asyncGeneration{done: make(chan struct{})}
+	b.config.generation = generation
+	// builds the key into the key ring shared with the returned config
+	shadow := &ConfigBuilder{config: new(Config)}
+	*shadow.config = *b.config
+
+	b.config.background.goRun(func(done <-chan struct{}) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if _, err := shadow.build(ctx); err != nil {
+			generation.err = fmt.Errorf("cannot build the config in the background %w", err)
+			b.config.reportError(generation.err)
+		}
+		close(generation.done)
+	})
+
+	return b.config, nil
+}
+
+// WaitReady waits for the key generation of an asynchronous Build, and returns
+// its error. It returns at once for a config built synchronously.
+func (c *Config) WaitReady(ctx context.Context) error {
+	if c == nil || c.generation == nil {
+		return nil
+	}
+	select {
+	case <-c.generation.done:
+		return c.generation.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Err returns why the key generation of an asynchronous Build failed, nil while
+// it runs or when it succeeded
+func (c *Config) Err() error {
+	if c == nil || c.generation == nil {
+		return nil
+	}
+	select {
+	case <-c.generation.done:
+		return c.generation.err
+	default:
+		return nil
+	}
+}
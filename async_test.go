@@ -0,0 +1,83 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAsyncGeneration(t *testing.T) {
+	// long enough to still run when Build returns
+	config, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(4096).WithKeyId("async").WithAsyncGeneration().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	if err = config.Ready(); !errors.Is(err, ErrNoServableKey) {
+		t.Fatalf("ready before the key was generated: %v", err)
+	}
+	if w := serveJWKS(Jkws(*config)); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("key set answered %d before the key was generated", w.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err = config.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = config.Ready(); err != nil || config.Err() != nil {
+		t.Fatalf("not ready once generated: %v %v", err, config.Err())
+	}
+	// the copy taken by the handler sees the key as well
+	if kids := servedKids(t, config); len(kids) != 1 || kids[0] != "async" {
+		t.Fatalf("served %v", kids)
+	}
+	checkSignsAndVerifies(t, config)
+}
+
+func TestAsyncGenerationFailure(t *testing.T) {
+	errs := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config, err := NewConfigBuilder().OnError(func(err error) { errs <- err }).
+		NewPrivateKey().WithKeyLength(2048).WithAsyncGeneration().BuildContext(ctx)
+	if err != nil {
+		t.Fatalf("the settings were refused: %v", err)
+	}
+	defer config.Close()
+
+	if err = config.WaitReady(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("generation ended with %v", err)
+	}
+	if !errors.Is(config.Err(), context.Canceled) || !errors.Is(config.Ready(), ErrNoServableKey) {
+		t.Fatalf("failed generation reported %v, ready %v", config.Err(), config.Ready())
+	}
+	select {
+	case reported := <-errs:
+		if !errors.Is(reported, context.Canceled) {
+			t.Fatalf("reported %v", reported)
+		}
+	default:
+		t.Fatal("the failure was not reported")
+	}
+	if w := serveJWKS(Jkws(*config)); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("key set answered %d after a failed generation", w.Code)
+	}
+}
+
+func TestAsyncGenerationStopsWithClose(t *testing.T) {
+	config, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(4096).WithAsyncGeneration().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = config.WaitReady(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("closed generation ended with %v", err)
+	}
+}
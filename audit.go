@@ -0,0 +1,117 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Key lifecycle actions recorded by the audit sink
+type AuditAction string
+
+const (
+	AuditKeyGenerated    AuditAction = "generated"
+	AuditKeyImported     AuditAction = "imported"
+	AuditKeyPublished    AuditAction = "published"
+	AuditKeyRotated      AuditAction = "rotated"
+	AuditKeyPruned       AuditAction = "pruned"
+	AuditKeyRemoved      AuditAction = "removed"
+	AuditKeyExported     AuditAction = "exported"
+	AuditKeyReloaded     AuditAction = "reloaded"
+	AuditKeyAliased      AuditAction = "aliased"
+	AuditKeyAliasRemoved AuditAction = "alias_removed"
+)
+
+// What triggered a key lifecycle event
+const (
+	AuditTriggerBuild = "build"
+	AuditTriggerAPI   = "api"
+	// the end of a grace period
+	AuditTriggerExpiry = "expiry"
+	// a change of the imported key file
+	AuditTriggerFileChange = "file_change"
+	// a rotation of WithAutoRotation
+	AuditTriggerSchedule = "schedule"
+	// a new key of a RenewableKeyProvider
+	AuditTriggerProviderRenewal = "provider_renewal"
+)
+
+// AuditEvent describes a key lifecycle event, it never carries key material
+type AuditEvent struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Action    AuditAction `json:"action"`
+	KeyID     string      `json:"kid"`
+	Actor     string      `json:"actor,omitempty"`
+	Trigger   string      `json:"trigger"`
+	// where the key comes from, e.g. the path of an imported key
+	Source string `json:"source,omitempty"`
+	// extra non secret details, e.g. the aliased kid
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// AuditSink records key lifecycle events in an append-only log
+type AuditSink interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// Record the key lifecycle events to the given sink
+func (n *ConfigBuilder) WithAuditSink(sink AuditSink) *ConfigBuilder {
+	n.config.auditSink = sink
+	return n
+}
+
+// Send an event to the audit sink, a failing sink never fails the operation
+func (c *Config) audit(event AuditEvent) {
+	if c.auditSink == nil {
+		return
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	if err := c.auditSink.Record(context.Background(), event); err != nil {
+		c.reportError(fmt.Errorf("cannot record audit event %s for kid %q %v", event.Action, event.KeyID, err))
+	}
+}
+
+// FileAuditSink appends the events to a file as JSON lines
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open or create the JSON lines file at path
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log %v", err)
+	}
+
+	return &FileAuditSink{file: file}, nil
+}
+
+func (s *FileAuditSink) Record(_ context.Context, event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit event %v", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.file.Write(line); err != nil {
+		return fmt.Errorf("cannot write audit event %v", err)
+	}
+
+	return nil
+}
+
+// Close the underlying file
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
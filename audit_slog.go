@@ -0,0 +1,44 @@
+//go:build go1.21
+
+package gin_jwks_rsa
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogAuditSink writes the events to a structured logger
+type SlogAuditSink struct {
+	logger *slog.Logger
+}
+
+// Record the events with the given logger, slog.Default() if nil
+func NewSlogAuditSink(logger *slog.Logger) *SlogAuditSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SlogAuditSink{logger: logger}
+}
+
+func (s *SlogAuditSink) Record(ctx context.Context, event AuditEvent) error {
+	attrs := []slog.Attr{
+		slog.Time("timestamp", event.Timestamp),
+		slog.String("action", string(event.Action)),
+		slog.String("kid", event.KeyID),
+		slog.String("trigger", event.Trigger),
+	}
+	if event.Actor != "" {
+		attrs = append(attrs, slog.String("actor", event.Actor))
+	}
+	if event.Source != "" {
+		attrs = append(attrs, slog.String("source", event.Source))
+	}
+	for k, v := range event.Details {
+		attrs = append(attrs, slog.String(k, v))
+	}
+
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "jwks key lifecycle event", attrs...)
+
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewSlogAuditSink(slog.New(slog.NewJSONHandler(&buf, nil)))
+	config := newAuditedConfig(t, sink)
+	buf.Reset()
+	if err := config.AliasKid("legacy", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("%q: %v", buf.String(), err)
+	}
+	for name, value := range map[string]string{
+		"msg":     "jwks key lifecycle event",
+		"action":  string(AuditKeyAliased),
+		"kid":     "test",
+		"trigger": AuditTriggerAPI,
+		"alias":   "legacy",
+	} {
+		if record[name] != value {
+			t.Fatalf("%s is %v in %s", name, record[name], buf.String())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package gin_jwks_rsa
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Audit sink keeping the events it records
+type recordingAuditSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (s *recordingAuditSink) Record(_ context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Record(context.Context, AuditEvent) error {
+	return errors.New("audit log unavailable")
+}
+
+func newAuditedConfig(t *testing.T, sink AuditSink) *Config {
+	t.Helper()
+	config, err := NewConfigBuilder().WithAuditSink(sink).NewPrivateKey().WithKeyLength(2048).WithKeyId("test").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestAliasesAreAudited(t *testing.T) {
+	sink := &recordingAuditSink{}
+	config := newAuditedConfig(t, sink)
+	if err := config.AliasKid("legacy", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.RemoveKidAlias("legacy"); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	var actions []string
+	for _, event := range sink.events {
+		actions = append(actions, string(event.Action)+" "+event.KeyID)
+	}
+	if got := strings.Join(actions, ", "); got != "generated test, published test, aliased test, alias_removed test" {
+		t.Fatalf("audited %s", got)
+	}
+	event := sink.events[len(sink.events)-1]
+	if event.Trigger != AuditTriggerAPI || !reflect.DeepEqual(event.Details, map[string]string{"alias": "legacy"}) {
+		t.Fatalf("last event %+v", event)
+	}
+	if event.Timestamp.IsZero() || event.Timestamp.Location().String() != "UTC" {
+		t.Fatalf("timestamp %v", event.Timestamp)
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigBuilder().WithAuditSink(sink).ImportPrivateKey().WithPath(keyPath).WithKeyId("test").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.AliasKid("legacy", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var actions []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		if event.Action == AuditKeyImported && event.Source != keyPath {
+			t.Fatalf("import audited with the source %q", event.Source)
+		}
+		actions = append(actions, string(event.Action)+" "+event.KeyID)
+	}
+	if got := strings.Join(actions, ", "); got != "imported test, published test, aliased test" {
+		t.Fatalf("audit log holds %s", got)
+	}
+}
+
+func TestFailingAuditSinkDoesNotFailTheOperation(t *testing.T) {
+	errs := make(chan error, 8)
+	config, err := NewConfigBuilder().WithAuditSink(failingAuditSink{}).OnError(func(err error) { errs <- err }).
+		NewPrivateKey().WithKeyLength(2048).WithKeyId("test").Build()
+	if err != nil {
+		t.Fatalf("build failed along with the audit sink: %v", err)
+	}
+	for len(errs) > 0 {
+		<-errs
+	}
+	if err = config.AliasKid("legacy", "test"); err != nil {
+		t.Fatalf("alias failed along with the audit sink: %v", err)
+	}
+	if kid, ok := config.ResolveKid("legacy"); !ok || kid != "test" {
+		t.Fatal("alias not registered")
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "aliased") {
+			t.Fatalf("reported %v", err)
+		}
+	default:
+		t.Fatal("the audit failure was not reported")
+	}
+}
@@ -0,0 +1,77 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	mathrand "math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Rotate the key every interval, as Rotate does, and keep publishing the
+// previous one for grace as WithRotationGrace does. Each interval is shortened
+// by up to a tenth so a fleet started together does not rotate at once. Close
+// stops the rotations.
+func (n *ConfigNewKeyBuilder) WithAutoRotation(every, grace time.Duration) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	atomic.StoreInt64(&n.config.settings.autoRotation, int64(every))
+	atomic.StoreInt64(&n.config.settings.rotationGrace, int64(grace))
+	return n
+}
+
+// Delay before the next scheduled rotation
+func autoRotationDelay(every time.Duration) time.Duration {
+	delay := every
+	if jitter := int64(every / 10); jitter > 0 {
+		delay -= time.Duration(mathrand.Int63n(jitter))
+	}
+	return delay
+}
+
+// Rotate on schedule until Close, following the changes of the interval.
+// Started once, it waits for an interval while there is none.
+func (c *Config) startAutoRotation() {
+	c.settings.autoRotationStarted.Do(func() {
+		c.background.goRun(c.runAutoRotation)
+	})
+}
+
+func (c *Config) runAutoRotation(done <-chan struct{}) {
+	for {
+		// a timer stopped too late sends to a channel nobody reads any more
+		due := make(chan struct{}, 1)
+		var timer refreshTimer
+		if every := c.autoRotation(); every > 0 {
+			timer = c.keys.afterFunc(autoRotationDelay(every), func() {
+				due <- struct{}{}
+			})
+		}
+		select {
+		case <-done:
+			stopTimer(timer)
+			return
+		case <-c.settings.autoRotationChanged:
+			stopTimer(timer)
+		case <-due:
+			c.autoRotate()
+		}
+	}
+}
+
+func stopTimer(timer refreshTimer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// Scheduled rotation, skipped when another rotation is in flight: the key was
+// just replaced anyway
+func (c *Config) autoRotate() {
+	if !c.keys.rotating.TryLock() {
+		return
+	}
+	defer c.keys.rotating.Unlock()
+
+	if err := c.rotate("", AuditTriggerSchedule); err != nil {
+		c.reportError(fmt.Errorf("scheduled rotation failed %v", err))
+	}
+}
@@ -0,0 +1,129 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Timers of the clock which have not fired nor been stopped
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var pending int
+	for _, t := range c.timers {
+		if !t.done {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Wait for the background task to arm its next timer
+func waitForTimer(t *testing.T, clock *fakeClock) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.pending() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no rotation was scheduled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Wait for a rotation away from kid, returning the new active kid
+func waitForRotation(t *testing.T, config *Config, kid string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for config.active().key.KeyID() == kid {
+		if time.Now().After(deadline) {
+			t.Fatalf("key %q was not rotated", kid)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return config.active().key.KeyID()
+}
+
+func TestAutoRotation(t *testing.T) {
+	clock := newFakeClock()
+	sink := &recordingAuditSink{}
+	builder := NewConfigBuilder().WithAuditSink(sink)
+	// before Build starts the background tasks reading them
+	builder.config.keys.now = clock.Now
+	builder.config.keys.afterFunc = clock.AfterFunc
+	config, err := builder.NewPrivateKey().
+		WithKeyType(jwa.EC).
+		WithKeyId("first").
+		WithAutoRotation(time.Hour, 10*time.Minute).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	// the jitter only ever shortens the interval, by a tenth at most
+	waitForTimer(t, clock)
+	clock.Advance(53 * time.Minute)
+	if kid := config.active().key.KeyID(); kid != "first" {
+		t.Fatalf("key rotated to %q before the interval", kid)
+	}
+	clock.Advance(7 * time.Minute)
+	second := waitForRotation(t, config, "first")
+	// armed once the rotation, its audit event included, is over
+	waitForTimer(t, clock)
+	if got := servedKids(t, config); !reflect.DeepEqual(got, sortedKids("first", second)) {
+		t.Fatalf("during the grace period the key set holds %v", got)
+	}
+	if actions := sink.actions(second); len(actions) == 0 {
+		t.Fatalf("rotation to %q was not audited", second)
+	}
+	sink.mu.Lock()
+	var scheduled bool
+	for _, event := range sink.events {
+		scheduled = scheduled || (event.KeyID == second && event.Trigger == AuditTriggerSchedule)
+	}
+	sink.mu.Unlock()
+	if !scheduled {
+		t.Fatalf("rotation to %q was not audited as scheduled", second)
+	}
+
+	// the next interval starts from the rotation, the first key is past its grace
+	clock.Advance(time.Hour)
+	third := waitForRotation(t, config, second)
+	if got := servedKids(t, config); !reflect.DeepEqual(got, sortedKids(second, third)) {
+		t.Fatalf("after a second rotation the key set holds %v", got)
+	}
+
+	waitForTimer(t, clock)
+	config.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.pending() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Close did not stop the schedule")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoRotationIsSkippedDuringARotation(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	config.keys.rotating.Lock()
+	config.autoRotate()
+	config.keys.rotating.Unlock()
+	if kid := config.active().key.KeyID(); kid != "test" {
+		t.Fatalf("scheduled rotation ran during another one, the key is %q", kid)
+	}
+}
+
+func TestAutoRotationIntervalCannotBeNegative(t *testing.T) {
+	if _, err := NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.EC).WithAutoRotation(-time.Hour, 0).Build(); err == nil {
+		t.Fatal("a negative auto rotation interval was accepted")
+	}
+}
+
+func sortedKids(kids ...string) []string {
+	sort.Strings(kids)
+	return kids
+}
@@ -0,0 +1,45 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBuildContextGivesUpOnTheGeneration(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).BuildContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("built with a cancelled context: %v", err)
+	}
+
+	// a 4096-bit key takes far longer than the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(4096).BuildContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("built past the deadline: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("gave up after %v", elapsed)
+	}
+
+	config, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).BuildContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	checkSignsAndVerifies(t, config)
+}
+
+// Only the generation of a key waits for the context, an import never does
+func TestBuildContextImportIgnoresTheContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeTestKey(t, newRSAKey(t))).BuildContext(ctx)
+	if err != nil {
+		t.Fatalf("import refused with a cancelled context: %v", err)
+	}
+	config.Close()
+}
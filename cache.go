@@ -0,0 +1,80 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Max age of the key set in client caches when none is configured
+	DefaultCacheMaxAge = 5 * time.Minute
+	// Media type of a key set, RFC 7517 section 8.5
+	JWKSetContentType      = "application/jwk-set+json"
+	defaultJWKSContentType = "application/json; charset=utf-8"
+)
+
+// Serve the key set as application/jwk-set+json rather than application/json,
+// which some clients of RFC 7517 expect and others refuse
+func (n *ConfigBuilder) WithJWKSetContentType() *ConfigBuilder {
+	n.config.jwkSetContentType = true
+	return n
+}
+
+// Write a serialized key set with its media type and length, only the headers
+// for a HEAD request
+func (c *Config) writeJWKS(ctx *gin.Context, status int, body []byte) {
+	contentType := defaultJWKSContentType
+	if c.jwkSetContentType {
+		contentType = JWKSetContentType
+	}
+	ctx.Header("Content-Length", strconv.Itoa(len(body)))
+	// the headers of a GET, from the same bytes, without the body
+	if ctx.Request.Method == http.MethodHead {
+		ctx.Header("Content-Type", contentType)
+		ctx.Status(status)
+		return
+	}
+	ctx.Data(status, contentType, body)
+}
+
+// Let clients cache the key set for maxAge, DefaultCacheMaxAge if not set. A
+// client may keep serving a key set up to maxAge after a rotation, so keep it
+// well below the time a new key is published before it signs anything. Zero
+// makes clients revalidate on every use.
+func (n *ConfigBuilder) WithCacheMaxAge(maxAge time.Duration) *ConfigBuilder {
+	atomic.StoreInt64(&n.config.settings.cacheMaxAge, int64(maxAge))
+	return n
+}
+
+// Set the Cache-Control and Expires headers of a served key set, private when
+// the endpoint requires authorization so shared caches do not keep it
+func (c *Config) setCacheHeaders(ctx *gin.Context) {
+	maxAge := c.cacheMaxAge()
+	if maxAge <= 0 {
+		ctx.Header("Cache-Control", "no-cache")
+		return
+	}
+	visibility := "public"
+	if c.endpointAuthorization != nil {
+		visibility = "private"
+	}
+	ctx.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int64(maxAge/time.Second)))
+	ctx.Header("Expires", time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+}
+
+// Whether the If-None-Match header of a request names etag. Weak tags match
+// as well, If-None-Match uses the weak comparison (RFC 9110 section 13.1.2).
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWithCacheMaxAge(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		builder      *ConfigBuilder
+		cacheControl string
+		expires      time.Duration
+	}{
+		{
+			name:         "default",
+			builder:      NewConfigBuilder(),
+			cacheControl: "public, max-age=300",
+			expires:      DefaultCacheMaxAge,
+		},
+		{
+			name:         "one hour",
+			builder:      NewConfigBuilder().WithCacheMaxAge(time.Hour),
+			cacheControl: "public, max-age=3600",
+			expires:      time.Hour,
+		},
+		{
+			name:         "zero",
+			builder:      NewConfigBuilder().WithCacheMaxAge(0),
+			cacheControl: "no-cache",
+		},
+		{
+			name: "behind authorization",
+			builder: NewConfigBuilder().WithCacheMaxAge(time.Minute).
+				WithEndpointAuthorization(func(*gin.Context) bool { return true }),
+			cacheControl: "private, max-age=60",
+			expires:      time.Minute,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := newTestConfig(t, tc.builder)
+			before := time.Now()
+			w := serveJWKS(Jkws(*config))
+			if w.Code != http.StatusOK {
+				t.Fatalf("key set answered %d", w.Code)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tc.cacheControl {
+				t.Fatalf("Cache-Control %q, expected %q", got, tc.cacheControl)
+			}
+
+			expires := w.Header().Get("Expires")
+			if tc.expires == 0 {
+				if expires != "" {
+					t.Fatalf("Expires %q on an uncached key set", expires)
+				}
+				return
+			}
+			at, err := http.ParseTime(expires)
+			if err != nil {
+				t.Fatalf("Expires %q: %v", expires, err)
+			}
+			// the header has a one second resolution
+			if want := before.Add(tc.expires).Truncate(time.Second); at.Before(want) || at.After(want.Add(2*time.Second)) {
+				t.Fatalf("Expires %s, expected about %s", at, want)
+			}
+		})
+	}
+}
+
+// Answer of the key set endpoint of RegisterJWKS to a method
+func serveJWKSMethod(t *testing.T, r *gin.Engine, method string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, DefaultJwksPath, nil))
+	return w
+}
+
+func TestHeadAnswersTheHeadersOfGet(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	r := gin.New()
+	if err := RegisterJWKS(r, config); err != nil {
+		t.Fatal(err)
+	}
+
+	get := serveJWKSMethod(t, r, http.MethodGet)
+	head := serveJWKSMethod(t, r, http.MethodHead)
+	if get.Code != http.StatusOK || head.Code != get.Code {
+		t.Fatalf("GET answered %d, HEAD %d", get.Code, head.Code)
+	}
+	if head.Body.Len() != 0 {
+		t.Fatalf("HEAD answered a %d bytes body", head.Body.Len())
+	}
+	if got := get.Header().Get("Content-Length"); got != strconv.Itoa(get.Body.Len()) {
+		t.Fatalf("GET Content-Length %s for a %d bytes body", got, get.Body.Len())
+	}
+	for _, name := range []string{"Content-Type", "Content-Length", "ETag", "Cache-Control"} {
+		if head.Header().Get(name) == "" || head.Header().Get(name) != get.Header().Get(name) {
+			t.Fatalf("HEAD %s %q, GET %q", name, head.Header().Get(name), get.Header().Get(name))
+		}
+	}
+}
+
+func TestHeadFollowsRotations(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	r := gin.New()
+	if err := RegisterJWKS(r, config); err != nil {
+		t.Fatal(err)
+	}
+	before := serveJWKSMethod(t, r, http.MethodHead)
+
+	// a longer kid makes a longer key set
+	if err := config.Rotate("a-much-longer-key-id"); err != nil {
+		t.Fatal(err)
+	}
+	get := serveJWKSMethod(t, r, http.MethodGet)
+	head := serveJWKSMethod(t, r, http.MethodHead)
+	if head.Header().Get("Content-Length") != strconv.Itoa(get.Body.Len()) {
+		t.Fatalf("HEAD Content-Length %s after the rotation, the key set has %d bytes", head.Header().Get("Content-Length"), get.Body.Len())
+	}
+	if head.Header().Get("Content-Length") == before.Header().Get("Content-Length") {
+		t.Fatal("HEAD reports the length of the previous key set")
+	}
+	if head.Header().Get("ETag") == before.Header().Get("ETag") || head.Header().Get("ETag") != get.Header().Get("ETag") {
+		t.Fatalf("HEAD ETag %s after the rotation, %s before, GET %s", head.Header().Get("ETag"), before.Header().Get("ETag"), get.Header().Get("ETag"))
+	}
+}
+
+func TestOptionsListsTheMethods(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	r := gin.New()
+	if err := RegisterJWKS(r, config); err != nil {
+		t.Fatal(err)
+	}
+	// no Origin, not a CORS preflight
+	w := serveJWKSMethod(t, r, http.MethodOptions)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("OPTIONS answered %d %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Fatalf("Allow %q", got)
+	}
+}
+
+func TestWithJWKSetContentType(t *testing.T) {
+	for name, tt := range map[string]struct {
+		builder     *ConfigBuilder
+		contentType string
+	}{
+		"default":         {NewConfigBuilder(), "application/json; charset=utf-8"},
+		"JWK set of 7517": {NewConfigBuilder().WithJWKSetContentType(), "application/jwk-set+json"},
+	} {
+		w := serveJWKS(Jkws(*newTestConfig(t, tt.builder)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: key set answered %d", name, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Fatalf("%s: Content-Type %q, expected %q", name, got, tt.contentType)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+			t.Fatalf("%s: Content-Length %s for a %d bytes body", name, got, w.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/cert"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"time"
+)
+
+// Structure used when the user imports a certificate, only its public key is served
+type ImportPublicKeyOptions struct {
+	keyId           string
+	certificatePath string
+	kidFromSerial   bool
+	strictValidity  bool
+	maxInputSize    int64
+	// revocation checking is off without a policy
+	revocationPolicy   RevocationPolicy
+	revocationInterval time.Duration
+}
+
+func (o *ImportPublicKeyOptions) KeyId() string {
+	return o.keyId
+}
+
+// Import public key facet of the config builder
+type ConfigImportPublicKeyBuilder struct {
+	ConfigBuilder
+}
+
+func (n *ConfigBuilder) ImportPublicKey() *ConfigImportPublicKeyBuilder {
+	return &ConfigImportPublicKeyBuilder{*n}
+}
+
+// Initiate the import public key opts obj if nil
+func (n *ConfigImportPublicKeyBuilder) initiateImportPublicOptsIfNil() {
+	if n.config.importPubOpts == nil {
+		n.config.importPubOpts = &ImportPublicKeyOptions{}
+	}
+}
+
+// Add the certificate path, a chain file starts with the leaf
+func (n *ConfigImportPublicKeyBuilder) WithCertificatePath(certificatePath string) *ConfigImportPublicKeyBuilder {
+	n.initiateImportPublicOptsIfNil()
+	n.config.importPubOpts.certificatePath = certificatePath
+	return n
+}
+
+// Add a key id to the public key, the key thumbprint by default
+func (n *ConfigImportPublicKeyBuilder) WithKeyId(keyId string) *ConfigImportPublicKeyBuilder {
+	n.initiateImportPublicOptsIfNil()
+	n.config.importPubOpts.keyId = keyId
+	return n
+}
+
+// Use the certificate serial number as the default key id
+func (n *ConfigImportPublicKeyBuilder) WithSerialAsKeyId() *ConfigImportPublicKeyBuilder {
+	n.initiateImportPublicOptsIfNil()
+	n.config.importPubOpts.kidFromSerial = true
+	return n
+}
+
+// Refuse expired or not yet valid certificates instead of reporting them
+func (n *ConfigImportPublicKeyBuilder) WithStrictValidity() *ConfigImportPublicKeyBuilder {
+	n.initiateImportPublicOptsIfNil()
+	n.config.importPubOpts.strictValidity = true
+	return n
+}
+
+// Import the public key of a certificate with its x5c, x5t and x5t#S256 members,
+// the parsed chain is returned along
+func importCertificate(opts ImportPublicKeyOptions, reportError func(error)) (jwk.Key, []*x509.Certificate, error) {
+	certs, err := readCertificates(opts.certificatePath, opts.maxInputSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf := certs[0]
+
+	if err = checkCertificateValidity(leaf); err != nil {
+		if opts.strictValidity {
+			return nil, nil, err
+		}
+		reportError(err)
+	}
+
+	key, err := jwk.FromRaw(leaf.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot convert the certificate public key %v", err)
+	}
+	if !isSignaturePublicKey(key) {
+		return nil, nil, fmt.Errorf("%w, expected an RSA, EC or Ed25519 certificate, got %T", ErrUnsupportedKeyType, leaf.PublicKey)
+	}
+
+	kid := leaf.SerialNumber.String()
+	if !opts.kidFromSerial {
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot compute key thumbprint %v", err)
+		}
+		kid = EncodeToString(thumbprint)
+	}
+	if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+		return nil, nil, fmt.Errorf("cannot add an id property to the public key %v", err)
+	}
+
+	if err = attachCertificates(key, certs); err != nil {
+		return nil, nil, err
+	}
+
+	return key, certs, nil
+}
+
+// Whether key is a public key the config can publish for signatures: RSA, EC
+// or Ed25519, as certified by a certificate or held by a provider
+func isSignaturePublicKey(key jwk.Key) bool {
+	// a private key has the methods of its public key as well
+	switch key.(type) {
+	case jwk.RSAPrivateKey, jwk.ECDSAPrivateKey, jwk.OKPPrivateKey:
+		return false
+	case jwk.RSAPublicKey, jwk.ECDSAPublicKey:
+		return true
+	}
+	return isEd25519PublicKey(key)
+}
+
+// Read the PEM certificates of a file, leaf first
+func readCertificates(path string, maxInputSize int64) ([]*x509.Certificate, error) {
+	data, err := readLimitedFile(path, inputSizeLimit(maxInputSize), "certificate")
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for i := 1; ; i++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse certificate in PEM block %d %v", i, err)
+		}
+		certs = append(certs, parsed)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return certs, nil
+}
+
+func checkCertificateValidity(leaf *x509.Certificate) error {
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate is only valid from %s to %s", leaf.NotBefore, leaf.NotAfter)
+	}
+	return nil
+}
+
+// Publish the certificate chain at path with the imported private key, the
+// leaf must certify that very key
+func attachKeyCertificates(key jwk.Key, path string, maxInputSize int64, reportError func(error)) error {
+	certs, err := readCertificates(path, maxInputSize)
+	if err != nil {
+		return err
+	}
+	if err = checkCertificateValidity(certs[0]); err != nil {
+		reportError(err)
+	}
+
+	certKey, err := jwk.FromRaw(certs[0].PublicKey)
+	if err != nil {
+		return fmt.Errorf("cannot convert the certificate public key %v", err)
+	}
+	pubKey, err := key.PublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to create public key %v", err)
+	}
+	certThumbprint, err := certKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("cannot compute the thumbprint of the certificate key %v", err)
+	}
+	keyThumbprint, err := pubKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("cannot compute the thumbprint of the private key %v", err)
+	}
+	if !bytes.Equal(certThumbprint, keyThumbprint) {
+		return fmt.Errorf("certificate %s does not certify the private key", certs[0].Subject)
+	}
+
+	return attachCertificates(key, certs)
+}
+
+// Set x5c to the chain, leaf first, along with the x5t and x5t#S256 of the leaf
+func attachCertificates(key jwk.Key, certs []*x509.Certificate) error {
+	var chain cert.Chain
+	for _, c := range certs {
+		if err := chain.AddString(base64.StdEncoding.EncodeToString(c.Raw)); err != nil {
+			return fmt.Errorf("cannot add certificate to x5c %v", err)
+		}
+	}
+	sha1Thumbprint := sha1.Sum(certs[0].Raw)
+	sha256Thumbprint := sha256.Sum256(certs[0].Raw)
+
+	members := map[string]interface{}{
+		jwk.X509CertChainKey:          &chain,
+		jwk.X509CertThumbprintKey:     EncodeToString(sha1Thumbprint[:]),
+		jwk.X509CertThumbprintS256Key: EncodeToString(sha256Thumbprint[:]),
+	}
+	for k, v := range members {
+		if err := key.Set(k, v); err != nil {
+			return fmt.Errorf("cannot set %q on the key %v", k, err)
+		}
+	}
+
+	return nil
+}
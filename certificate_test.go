@@ -0,0 +1,216 @@
+package gin_jwks_rsa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Chain file of a leaf certifying key, issued by a CA of its own, leaf first.
+// The certificates are returned in the same order.
+func writeCertificateChain(t *testing.T, key crypto.PublicKey, serial int64, notBefore, notAfter time.Time) (string, []*x509.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "partner"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, key, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	for _, der := range [][]byte{leafDER, caDER} {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	path := filepath.Join(t.TempDir(), "chain.pem")
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, []*x509.Certificate{leaf, ca}
+}
+
+// Check the x5c, x5t and x5t#S256 members of a served key against chain
+func checkCertificateMembers(t *testing.T, key jwk.Key, chain []*x509.Certificate) {
+	t.Helper()
+	x5c := key.X509CertChain()
+	if x5c == nil || x5c.Len() != len(chain) {
+		t.Fatalf("x5c %v, expected %d certificates", x5c, len(chain))
+	}
+	for i, c := range chain {
+		if got, _ := x5c.Get(i); string(got) != base64.StdEncoding.EncodeToString(c.Raw) {
+			t.Fatalf("x5c[%d] is not certificate %s", i, c.Subject)
+		}
+	}
+	sha1Thumbprint := sha1.Sum(chain[0].Raw)
+	sha256Thumbprint := sha256.Sum256(chain[0].Raw)
+	if got := key.X509CertThumbprint(); got != EncodeToString(sha1Thumbprint[:]) {
+		t.Fatalf("x5t %q, expected the SHA-1 thumbprint of the leaf", got)
+	}
+	if got := key.X509CertThumbprintS256(); got != EncodeToString(sha256Thumbprint[:]) {
+		t.Fatalf("x5t#S256 %q, expected the SHA-256 thumbprint of the leaf", got)
+	}
+}
+
+// Only key served for config
+func servedCertificateKey(t *testing.T, config *Config) jwk.Key {
+	t.Helper()
+	set := servedKeySet(t, config)
+	if set.Len() != 1 {
+		t.Fatalf("%d keys served", set.Len())
+	}
+	key, _ := set.Key(0)
+	return key
+}
+
+func TestImportPublicKeyOfACertificate(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tt := range map[string]struct {
+		key crypto.Signer
+		kty jwa.KeyType
+		alg jwa.SignatureAlgorithm
+	}{
+		"RSA":     {newRSAKey(t), jwa.RSA, jwa.RS256},
+		"EC":      {newECKey(t), jwa.EC, jwa.ES256},
+		"Ed25519": {edKey, jwa.OKP, jwa.EdDSA},
+	} {
+		path, chain := writeCertificateChain(t, tt.key.Public(), 42, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		config, err := NewConfigBuilder().ImportPublicKey().WithCertificatePath(path).Build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer config.Close()
+
+		key := servedCertificateKey(t, config)
+		if key.KeyType() != tt.kty || key.Algorithm().String() != tt.alg.String() {
+			t.Fatalf("%s: served kty %s, alg %s", name, key.KeyType(), key.Algorithm())
+		}
+		certKey, err := jwk.FromRaw(tt.key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		thumbprint, err := certKey.Thumbprint(crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.KeyID() != EncodeToString(thumbprint) {
+			t.Fatalf("%s: kid %q, expected the key thumbprint", name, key.KeyID())
+		}
+		// the chain file holds the leaf then its CA, published in that order
+		checkCertificateMembers(t, key, chain)
+
+		if _, err = config.signToken(jwt.New()); err == nil {
+			t.Fatalf("%s: the public key of a certificate signed a token", name)
+		}
+	}
+}
+
+func TestImportPublicKeyWithTheSerialAsKeyId(t *testing.T) {
+	path, _ := writeCertificateChain(t, newRSAKey(t).Public(), 1234567, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	config, err := NewConfigBuilder().ImportPublicKey().WithCertificatePath(path).WithSerialAsKeyId().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	if kid := servedCertificateKey(t, config).KeyID(); kid != "1234567" {
+		t.Fatalf("kid %q, expected the serial number", kid)
+	}
+}
+
+func TestImportPublicKeyOutsideItsValidity(t *testing.T) {
+	for name, validity := range map[string][2]time.Time{
+		"expired":       {time.Now().Add(-2 * time.Hour), time.Now().Add(-time.Hour)},
+		"not yet valid": {time.Now().Add(time.Hour), time.Now().Add(2 * time.Hour)},
+	} {
+		path, _ := writeCertificateChain(t, newRSAKey(t).Public(), 42, validity[0], validity[1])
+
+		// reported, yet imported
+		var reported []error
+		config, err := NewConfigBuilder().OnError(func(err error) {
+			reported = append(reported, err)
+		}).ImportPublicKey().WithCertificatePath(path).Build()
+		if err != nil {
+			t.Fatalf("%s certificate refused without WithStrictValidity: %v", name, err)
+		}
+		config.Close()
+		if len(reported) != 1 {
+			t.Fatalf("%s certificate reported %d errors", name, len(reported))
+		}
+
+		if _, err = NewConfigBuilder().ImportPublicKey().WithCertificatePath(path).WithStrictValidity().Build(); err == nil {
+			t.Fatalf("%s certificate imported with WithStrictValidity", name)
+		}
+	}
+}
+
+func TestImportPrivateKeyWithItsCertificate(t *testing.T) {
+	key := newRSAKey(t)
+	path, chain := writeCertificateChain(t, key.Public(), 42, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeTestKey(t, key)).WithCertificatePath(path).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	checkCertificateMembers(t, servedCertificateKey(t, config), chain)
+}
+
+func TestImportPrivateKeyRefusesTheCertificateOfAnotherKey(t *testing.T) {
+	path, _ := writeCertificateChain(t, newRSAKey(t).Public(), 42, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	for name, key := range map[string]interface{}{
+		"another RSA key": newRSAKey(t),
+		"an EC key":       newECKey(t),
+	} {
+		_, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeTestKey(t, key)).WithCertificatePath(path).Build()
+		if err == nil || !strings.Contains(err.Error(), "does not certify the private key") {
+			t.Fatalf("the certificate of %s gave %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package gin_jwks_rsa
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate of a store along with the key signing for it, e.g. a CNG key
+type storeKey interface {
+	crypto.Signer
+	certificate() *x509.Certificate
+	// release the key, called once no config signs with it any more
+	free()
+}
+
+// Keys of a certificate store provider: the one of the current certificate
+// and the ones of the certificates it replaced, which a config may still sign
+// with until it installed the new one
+type storeKeys struct {
+	mu       sync.RWMutex
+	current  storeKey
+	previous []storeKey
+	onRenew  []func()
+}
+
+// Key of the current certificate
+func (s *storeKeys) get() storeKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Call fn after a re-scan installed a renewed certificate
+func (s *storeKeys) subscribe(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRenew = append(s.onRenew, fn)
+}
+
+// Look the certificate up again with scan and install it if it is not the
+// current one, then tell the subscribers and onRescan
+func (s *storeKeys) rescan(scan func() (storeKey, error), onRescan func(thumbprint string, err error)) {
+	found, err := scan()
+	if err != nil {
+		if onRescan != nil {
+			onRescan("", err)
+		}
+		return
+	}
+
+	thumbprint := certificateThumbprint(found.certificate())
+	s.mu.Lock()
+	previous := s.current
+	if certificateThumbprint(previous.certificate()) == thumbprint {
+		s.mu.Unlock()
+		found.free()
+		return
+	}
+	s.current = found
+	s.previous = append(s.previous, previous)
+	onRenew := append([]func(){}, s.onRenew...)
+	s.mu.Unlock()
+
+	for _, fn := range onRenew {
+		fn()
+	}
+	if onRescan != nil {
+		onRescan(thumbprint, nil)
+	}
+}
+
+// Release the current key and the previous ones
+func (s *storeKeys) free() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.free()
+	for _, previous := range s.previous {
+		previous.free()
+	}
+	s.previous = nil
+}
+
+// Hex encoded SHA-1 thumbprint of cert, the way the Windows store shows it
+func certificateThumbprint(cert *x509.Certificate) string {
+	thumbprint := sha1.Sum(cert.Raw)
+	return hex.EncodeToString(thumbprint[:])
+}
+
+// Lower case thumbprint without the spaces the certificate manager shows
+func normalizeThumbprint(thumbprint string) string {
+	return strings.ToLower(strings.ReplaceAll(thumbprint, " ", ""))
+}
+
+// Whether cert is the certificate designated by a normalized thumbprint or,
+// without one, by its distinguished name or common name
+func certificateMatches(thumbprint, subject string, cert *x509.Certificate) bool {
+	if thumbprint != "" {
+		return certificateThumbprint(cert) == thumbprint
+	}
+
+	return cert.Subject.String() == subject || cert.Subject.CommonName == subject
+}
+
+// Whether candidate is to be picked over best, nil if none is yet. A
+// thumbprint designates a single certificate, valid or not; among the
+// certificates of a subject the valid one with the latest NotBefore wins.
+func preferCertificate(best, candidate *x509.Certificate, byThumbprint bool, now time.Time) bool {
+	if !byThumbprint && (now.Before(candidate.NotBefore) || now.After(candidate.NotAfter)) {
+		return false
+	}
+
+	return best == nil || candidate.NotBefore.After(best.NotBefore)
+}
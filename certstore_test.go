@@ -0,0 +1,151 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Key of a certificate store, in memory
+type fakeStoreKey struct {
+	*ecdsa.PrivateKey
+	cert  *x509.Certificate
+	freed bool
+}
+
+func (k *fakeStoreKey) certificate() *x509.Certificate {
+	return k.cert
+}
+
+func (k *fakeStoreKey) free() {
+	k.freed = true
+}
+
+// Self-signed certificate of the organization "test" as a store holds it
+func newStoreKey(t *testing.T, commonName string, notBefore, notAfter time.Time) *fakeStoreKey {
+	t.Helper()
+	key := newECKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notBefore.UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"test"}},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeStoreKey{PrivateKey: key, cert: cert}
+}
+
+func TestCertificateMatches(t *testing.T) {
+	now := time.Now()
+	cert := newStoreKey(t, "signing", now.Add(-time.Hour), now.Add(time.Hour)).cert
+	other := newStoreKey(t, "other", now.Add(-time.Hour), now.Add(time.Hour)).cert
+	// as the certificate manager shows it
+	shown := strings.ToUpper(certificateThumbprint(cert)[:8]) + " " + certificateThumbprint(cert)[8:]
+
+	for name, tt := range map[string]struct {
+		thumbprint string
+		subject    string
+		want       bool
+	}{
+		"thumbprint":                       {thumbprint: normalizeThumbprint(shown), want: true},
+		"thumbprint of another":            {thumbprint: certificateThumbprint(other), want: false},
+		"thumbprint before the subject":    {thumbprint: certificateThumbprint(other), subject: "signing", want: false},
+		"distinguished name":               {subject: "CN=signing,O=test", want: true},
+		"common name":                      {subject: "signing", want: true},
+		"another subject":                  {subject: "other", want: false},
+		"part of the distinguished name":   {subject: "O=test", want: false},
+		"distinguished name in lower case": {subject: "cn=signing,o=test", want: false},
+	} {
+		if got := certificateMatches(tt.thumbprint, tt.subject, cert); got != tt.want {
+			t.Fatalf("%s: matches is %v", name, got)
+		}
+	}
+}
+
+func TestPreferCertificate(t *testing.T) {
+	now := time.Now()
+	valid := newStoreKey(t, "signing", now.Add(-2*time.Hour), now.Add(time.Hour)).cert
+	renewed := newStoreKey(t, "signing", now.Add(-time.Hour), now.Add(2*time.Hour)).cert
+	expired := newStoreKey(t, "signing", now.Add(-3*time.Hour), now.Add(-time.Minute)).cert
+	upcoming := newStoreKey(t, "signing", now.Add(time.Minute), now.Add(3*time.Hour)).cert
+
+	for name, tt := range map[string]struct {
+		best, candidate *x509.Certificate
+		byThumbprint    bool
+		want            bool
+	}{
+		"first valid":                  {candidate: valid, want: true},
+		"renewed":                      {best: valid, candidate: renewed, want: true},
+		"older":                        {best: renewed, candidate: valid, want: false},
+		"expired":                      {candidate: expired, want: false},
+		"not yet valid":                {best: valid, candidate: upcoming, want: false},
+		"expired, by thumbprint":       {candidate: expired, byThumbprint: true, want: true},
+		"not yet valid, by thumbprint": {candidate: upcoming, byThumbprint: true, want: true},
+	} {
+		if got := preferCertificate(tt.best, tt.candidate, tt.byThumbprint, now); got != tt.want {
+			t.Fatalf("%s: prefer is %v", name, got)
+		}
+	}
+}
+
+func TestStoreKeysRescan(t *testing.T) {
+	now := time.Now()
+	first := newStoreKey(t, "signing", now.Add(-time.Hour), now.Add(time.Hour))
+	keys := &storeKeys{current: first}
+	renewals := 0
+	keys.subscribe(func() {
+		renewals++
+	})
+	var thumbprints []string
+	var errs []error
+	onRescan := func(thumbprint string, err error) {
+		thumbprints = append(thumbprints, thumbprint)
+		errs = append(errs, err)
+	}
+
+	// the same certificate, found again with its own key handle
+	again := &fakeStoreKey{PrivateKey: first.PrivateKey, cert: first.cert}
+	keys.rescan(func() (storeKey, error) { return again, nil }, onRescan)
+	if keys.get() != first || !again.freed || first.freed || renewals != 0 || len(thumbprints) != 0 {
+		t.Fatalf("the current certificate was renewed: %d renewals, re-scans %q", renewals, thumbprints)
+	}
+
+	// a failed scan keeps the current certificate
+	failure := errors.New("store unavailable")
+	keys.rescan(func() (storeKey, error) { return nil, failure }, onRescan)
+	if keys.get() != first || renewals != 0 || len(errs) != 1 || !errors.Is(errs[0], failure) || thumbprints[0] != "" {
+		t.Fatalf("failed re-scan reported %q %v, %d renewals", thumbprints, errs, renewals)
+	}
+
+	renewed := newStoreKey(t, "signing", now, now.Add(2*time.Hour))
+	keys.rescan(func() (storeKey, error) { return renewed, nil }, onRescan)
+	if keys.get() != renewed || renewals != 1 {
+		t.Fatalf("renewed certificate not installed, %d renewals", renewals)
+	}
+	if len(thumbprints) != 2 || thumbprints[1] != certificateThumbprint(renewed.cert) || errs[1] != nil {
+		t.Fatalf("renewal reported %q %v", thumbprints, errs)
+	}
+	// a config may still sign with the previous key until it installed the new one
+	if first.freed || len(keys.previous) != 1 || keys.previous[0] != first {
+		t.Fatalf("previous key released before Close, %d kept", len(keys.previous))
+	}
+
+	keys.free()
+	if !first.freed || !renewed.freed || keys.previous != nil {
+		t.Fatal("keys not released")
+	}
+}
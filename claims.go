@@ -0,0 +1,52 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Token returns the token verified by Verify or VerifyRemote, false on a route
+// without them
+func Token(c *gin.Context) (jwt.Token, bool) {
+	return ClaimsFromContext(c)
+}
+
+// RawToken returns the verified token as the client sent it, e.g. to forward
+// it upstream
+func RawToken(c *gin.Context) (string, bool) {
+	token, ok := c.Get(RawTokenContextKey)
+	if !ok {
+		return "", false
+	}
+	raw, ok := token.(string)
+	return raw, ok
+}
+
+// Subject returns the sub claim of the verified token, false when it has none
+func Subject(c *gin.Context) (string, bool) {
+	token, ok := ClaimsFromContext(c)
+	if !ok || token.Subject() == "" {
+		return "", false
+	}
+	return token.Subject(), true
+}
+
+// Claim returns the claim name of the verified token as a T, the zero value and
+// false when it is missing or of another type. JSON numbers are float64 and
+// objects map[string]interface{}, exp, iat and nbf are time.Time.
+func Claim[T any](c *gin.Context, name string) (T, bool) {
+	var zero T
+	token, ok := ClaimsFromContext(c)
+	if !ok {
+		return zero, false
+	}
+	v, ok := token.Get(name)
+	if !ok {
+		return zero, false
+	}
+	claim, ok := v.(T)
+	if !ok {
+		return zero, false
+	}
+	return claim, true
+}
@@ -0,0 +1,77 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClaimAccessors(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	token := signTestToken(t, config, map[string]interface{}{
+		"sub":   "user-1",
+		"scope": "read",
+		"level": 3,
+		"exp":   time.Now().Add(time.Hour),
+	})
+
+	var checked bool
+	r := gin.New()
+	r.GET("/verified", Verify(*config), func(c *gin.Context) {
+		checked = true
+		if parsed, ok := Token(c); !ok || parsed.Subject() != "user-1" {
+			t.Errorf("Token gave %v %v", parsed, ok)
+		}
+		if raw, ok := RawToken(c); !ok || raw != token {
+			t.Errorf("RawToken gave %q %v", raw, ok)
+		}
+		if sub, ok := Subject(c); !ok || sub != "user-1" {
+			t.Errorf("Subject gave %q %v", sub, ok)
+		}
+		if scope, ok := Claim[string](c, "scope"); !ok || scope != "read" {
+			t.Errorf("scope claim gave %q %v", scope, ok)
+		}
+		if level, ok := Claim[float64](c, "level"); !ok || level != 3 {
+			t.Errorf("level claim gave %v %v", level, ok)
+		}
+		if exp, ok := Claim[time.Time](c, "exp"); !ok || exp.IsZero() {
+			t.Errorf("exp claim gave %v %v", exp, ok)
+		}
+		if level, ok := Claim[string](c, "level"); ok || level != "" {
+			t.Errorf("level claim as a string gave %q %v", level, ok)
+		}
+		if missing, ok := Claim[string](c, "missing"); ok || missing != "" {
+			t.Errorf("missing claim gave %q %v", missing, ok)
+		}
+	})
+	r.GET("/public", func(c *gin.Context) {
+		checked = true
+		if _, ok := Token(c); ok {
+			t.Error("Token found on a route without verification")
+		}
+		if _, ok := RawToken(c); ok {
+			t.Error("RawToken found on a route without verification")
+		}
+		if _, ok := Subject(c); ok {
+			t.Error("Subject found on a route without verification")
+		}
+		if _, ok := Claim[string](c, "sub"); ok {
+			t.Error("Claim found on a route without verification")
+		}
+	})
+
+	for path, authorization := range map[string]string{"/verified": "Bearer " + token, "/public": ""} {
+		checked = false
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !checked {
+			t.Fatalf("%s answered %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// gin context key holding the authenticated client id
+	ClientIDContextKey = "gin-jwks-client-id"
+)
+
+// RFC 6749 token endpoint error codes
+const (
+	OAuthErrorInvalidRequest = "invalid_request"
+	OAuthErrorInvalidClient  = "invalid_client"
+	// RFC 9101 authorization error
+	OAuthErrorInvalidRequestObject = "invalid_request_object"
+	// RFC 6749 authorization error, also answered by the key set endpoint
+	OAuthErrorServerError = "server_error"
+	// RFC 6750 resource server error
+	OAuthErrorInvalidToken = "invalid_token"
+)
+
+// OAuthError is an RFC 6749 error response
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	// detail kept for the logs, never sent to the client
+	cause error
+}
+
+func (e *OAuthError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Description, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func (e *OAuthError) Unwrap() error {
+	return e.cause
+}
+
+// Status code going with the error on a token endpoint
+func (e *OAuthError) StatusCode() int {
+	if e.Code == OAuthErrorInvalidClient {
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
+
+// Registered keys of a client, either a key set or the URL of its JWKS
+type ClientKeys struct {
+	Set     jwk.Set
+	JWKSURL string
+}
+
+// ClientKeyResolver returns the keys registered for a client
+type ClientKeyResolver interface {
+	ResolveClientKeys(ctx context.Context, clientID string) (ClientKeys, error)
+}
+
+// Everything needed to authenticate a client with an RFC 7523 assertion
+type ClientAssertionOptions struct {
+	// URL of the token endpoint, the assertion audience must match it
+	TokenEndpoint string
+	Resolver      ClientKeyResolver
+	// remembers the jti of the accepted assertions
+	ReplayCache    ReplayCache
+	AcceptableSkew time.Duration
+	// DefaultTokenLimits if nil
+	Limits *TokenLimits
+	// key sets fetched from the JWKSURL of the clients, fetched on each
+	// assertion if nil. RequireClientAssertion creates one if not set.
+	KeySetCache *ClientKeySetCache
+}
+
+// ClientKeySetCache keeps the key sets fetched from the JWKS URLs of the
+// clients for a while, so every assertion does not cost a request to the
+// client. A set is fetched again once older than the TTL.
+type ClientKeySetCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	sets map[string]clientKeySet
+}
+
+type clientKeySet struct {
+	set       jwk.Set
+	fetchedAt time.Time
+}
+
+// NewClientKeySetCache keeps each set for ttl, DefaultRemoteRefreshInterval
+// if zero
+func NewClientKeySetCache(ttl time.Duration) *ClientKeySetCache {
+	if ttl <= 0 {
+		ttl = DefaultRemoteRefreshInterval
+	}
+	return &ClientKeySetCache{ttl: ttl, sets: map[string]clientKeySet{}}
+}
+
+// Key set published at jwksURL, from the cache while it is fresh
+func (c *ClientKeySetCache) fetch(ctx context.Context, jwksURL string) (jwk.Set, error) {
+	if c == nil {
+		return jwk.Fetch(ctx, jwksURL)
+	}
+	c.mu.Lock()
+	cached, ok := c.sets[jwksURL]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < c.ttl {
+		return cached.set, nil
+	}
+
+	set, err := jwk.Fetch(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.sets[jwksURL] = clientKeySet{set: set, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return set, nil
+}
+
+// Replay cache entry of an assertion: its jti is only unique for its issuer,
+// the length prefix keeps two pairs from making the same key
+func clientAssertionReplayKey(issuer, jti string) string {
+	return strconv.Itoa(len(issuer)) + ":" + issuer + jti
+}
+
+// VerifyClientAssertion authenticates a client with an RFC 7523 JWT bearer
+// assertion and returns its client id. clientID is the optional client_id
+// request parameter. Errors are *OAuthError.
+func VerifyClientAssertion(ctx context.Context, assertionType, assertion, clientID string, opts ClientAssertionOptions) (string, error) {
+	if assertionType != ClientAssertionTypeJWTBearer {
+		return "", &OAuthError{Code: OAuthErrorInvalidRequest, Description: "unsupported client_assertion_type"}
+	}
+	if assertion == "" {
+		return "", &OAuthError{Code: OAuthErrorInvalidRequest, Description: "missing client_assertion"}
+	}
+	if opts.Resolver == nil || opts.ReplayCache == nil || opts.TokenEndpoint == "" {
+		return "", fmt.Errorf("client assertion needs a resolver, a replay cache and the token endpoint")
+	}
+
+	limits := DefaultTokenLimits
+	if opts.Limits != nil {
+		limits = *opts.Limits
+	}
+	if err := checkTokenLimits(assertion, limits); err != nil {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "client_assertion exceeds parsing limits"}
+	}
+	if err := checkKeyURLHeaders(assertion, KeyURLHeadersReject); err != nil {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "client_assertion cannot carry jku or x5u"}
+	}
+
+	// the signature cannot be checked before knowing whose keys to use
+	unverified, err := jwt.ParseInsecure([]byte(assertion))
+	if err != nil {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "malformed client_assertion"}
+	}
+	subject := unverified.Subject()
+	if subject == "" || unverified.Issuer() != subject {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "iss and sub must both be the client id"}
+	}
+	if clientID != "" && clientID != subject {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "client_id does not match the assertion"}
+	}
+
+	keys, err := opts.Resolver.ResolveClientKeys(ctx, subject)
+	if err != nil {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "unknown client"}
+	}
+	set := keys.Set
+	if set == nil && keys.JWKSURL != "" {
+		if set, err = opts.KeySetCache.fetch(ctx, keys.JWKSURL); err != nil {
+			return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "cannot fetch client keys", cause: err}
+		}
+	}
+	if set == nil {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "client has no registered keys"}
+	}
+
+	token, err := jwt.Parse([]byte(assertion),
+		jwt.WithKeySet(set, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(subject),
+		jwt.WithSubject(subject),
+		jwt.WithAudience(opts.TokenEndpoint),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+		jwt.WithRequiredClaim(jwt.JwtIDKey),
+		jwt.WithAcceptableSkew(opts.AcceptableSkew),
+	)
+	if err != nil {
+		// the parse error may echo claims, it only goes to the logs
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "invalid client_assertion", cause: err}
+	}
+
+	// single use per client, a failing cache rejects the assertion
+	seen, err := opts.ReplayCache.Seen(ctx, clientAssertionReplayKey(subject, token.JwtID()), token.Expiration())
+	if err != nil {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "cannot check client_assertion replay", cause: err}
+	}
+	if seen {
+		return "", &OAuthError{Code: OAuthErrorInvalidClient, Description: "client_assertion already used"}
+	}
+
+	return subject, nil
+}
+
+// RequireClientAssertion middleware authenticating the caller of a token endpoint
+// with the client_assertion form parameters, the client id is stored under ClientIDContextKey.
+// Errors are answered in the JSON encoding of config.
+func RequireClientAssertion(config Config, opts ClientAssertionOptions) gin.HandlerFunc {
+	if opts.KeySetCache == nil {
+		opts.KeySetCache = NewClientKeySetCache(0)
+	}
+	return func(c *gin.Context) {
+		clientID, err := VerifyClientAssertion(c.Request.Context(),
+			c.PostForm("client_assertion_type"),
+			c.PostForm("client_assertion"),
+			c.PostForm("client_id"),
+			opts,
+		)
+		if err != nil {
+			c.Error(err)
+			if oauthErr, ok := err.(*OAuthError); ok {
+				config.abortWithJSON(c, oauthErr.StatusCode(), oauthErr)
+				return
+			}
+			c.AbortWithStatus(500)
+			return
+		}
+
+		c.Set(ClientIDContextKey, clientID)
+		c.Next()
+	}
+}
@@ -0,0 +1,206 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTokenEndpoint = "https://auth.example.com/token"
+
+// Clients and the keys they registered, by client id
+type staticClientKeys map[string]ClientKeys
+
+func (s staticClientKeys) ResolveClientKeys(_ context.Context, clientID string) (ClientKeys, error) {
+	keys, ok := s[clientID]
+	if !ok {
+		return ClientKeys{}, errors.New("unknown client")
+	}
+	return keys, nil
+}
+
+func clientPublicSet(t *testing.T, key *ecdsa.PrivateKey) jwk.Set {
+	t.Helper()
+	public, err := jwk.FromRaw(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jwk.AssignKeyID(public); err != nil {
+		t.Fatal(err)
+	}
+	if err = public.Set(jwk.AlgorithmKey, jwa.ES256); err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	if err = set.AddKey(public); err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func clientAssertion(t *testing.T, key *ecdsa.PrivateKey, clientID, jti string, exp time.Time) string {
+	t.Helper()
+	private, err := jwk.FromRaw(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jwk.AssignKeyID(private); err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewBuilder().
+		Issuer(clientID).
+		Subject(clientID).
+		Audience([]string{testTokenEndpoint}).
+		JwtID(jti).
+		Expiration(exp).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256, private))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestClientAssertionReplayIsPerClient(t *testing.T) {
+	alice, bob := newECKey(t), newECKey(t)
+	opts := ClientAssertionOptions{
+		TokenEndpoint: testTokenEndpoint,
+		Resolver: staticClientKeys{
+			"alice": {Set: clientPublicSet(t, alice)},
+			"bob":   {Set: clientPublicSet(t, bob)},
+		},
+		ReplayCache: NewMemoryReplayCache(100),
+	}
+	exp := time.Now().Add(time.Minute)
+	verify := func(key *ecdsa.PrivateKey, clientID string) error {
+		_, err := VerifyClientAssertion(context.Background(), ClientAssertionTypeJWTBearer,
+			clientAssertion(t, key, clientID, "same-jti", exp), "", opts)
+		return err
+	}
+
+	if err := verify(alice, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// another client picking the same jti is not a replay
+	if err := verify(bob, "bob"); err != nil {
+		t.Fatalf("the jti of another client was taken for a replay: %v", err)
+	}
+	if err := verify(alice, "alice"); err == nil {
+		t.Fatal("a replayed assertion was accepted")
+	}
+}
+
+func TestClientAssertionKeySetIsCached(t *testing.T) {
+	key := newECKey(t)
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(clientPublicSet(t, key))
+	}))
+	defer server.Close()
+
+	opts := ClientAssertionOptions{
+		TokenEndpoint: testTokenEndpoint,
+		Resolver:      staticClientKeys{"alice": {JWKSURL: server.URL}},
+		ReplayCache:   NewMemoryReplayCache(100),
+		KeySetCache:   NewClientKeySetCache(time.Hour),
+	}
+	for _, jti := range []string{"1", "2", "3"} {
+		assertion := clientAssertion(t, key, "alice", jti, time.Now().Add(time.Minute))
+		if _, err := VerifyClientAssertion(context.Background(), ClientAssertionTypeJWTBearer, assertion, "alice", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("client key set fetched %d times, expected once", n)
+	}
+}
+
+func TestClientAssertionErrorDoesNotEchoTheParseError(t *testing.T) {
+	key := newECKey(t)
+	opts := ClientAssertionOptions{
+		TokenEndpoint: testTokenEndpoint,
+		Resolver:      staticClientKeys{"alice": {Set: clientPublicSet(t, key)}},
+		ReplayCache:   NewMemoryReplayCache(100),
+	}
+	expired := clientAssertion(t, key, "alice", "1", time.Now().Add(-time.Hour))
+	_, err := VerifyClientAssertion(context.Background(), ClientAssertionTypeJWTBearer, expired, "", opts)
+
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		t.Fatalf("expected an OAuthError, got %v", err)
+	}
+	if oauthErr.Description != "invalid client_assertion" {
+		t.Fatalf("error_description %q carries the parse error", oauthErr.Description)
+	}
+	if errors.Unwrap(oauthErr) == nil || !strings.Contains(oauthErr.Error(), "exp") {
+		t.Fatalf("the cause is not kept for the logs: %v", oauthErr)
+	}
+	body, _ := json.Marshal(oauthErr)
+	if strings.Contains(string(body), "exp") {
+		t.Fatalf("response body %s carries the parse error", body)
+	}
+}
+
+func TestRequireClientAssertion(t *testing.T) {
+	key := newECKey(t)
+	config := newTestConfig(t, NewConfigBuilder().WithJSONCodec(indentJSONCodec{}))
+	r := gin.New()
+	r.POST("/token", RequireClientAssertion(*config, ClientAssertionOptions{
+		TokenEndpoint: testTokenEndpoint,
+		Resolver:      staticClientKeys{"alice": {Set: clientPublicSet(t, key)}},
+		ReplayCache:   NewMemoryReplayCache(100),
+	}), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(ClientIDContextKey))
+	})
+	post := func(assertionType, assertion string) *httptest.ResponseRecorder {
+		form := url.Values{"client_assertion_type": {assertionType}, "client_assertion": {assertion}}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assertion := clientAssertion(t, key, "alice", "1", time.Now().Add(time.Minute))
+	if w := post(ClientAssertionTypeJWTBearer, assertion); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("valid assertion answered %d %q", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct {
+		name, assertionType, assertion, code string
+		status                               int
+	}{
+		{"replayed", ClientAssertionTypeJWTBearer, assertion, OAuthErrorInvalidClient, http.StatusUnauthorized},
+		{"unsupported type", "urn:example:other", assertion, OAuthErrorInvalidRequest, http.StatusBadRequest},
+		{"missing", ClientAssertionTypeJWTBearer, "", OAuthErrorInvalidRequest, http.StatusBadRequest},
+	} {
+		w := post(tt.assertionType, tt.assertion)
+		if w.Code != tt.status {
+			t.Fatalf("%s: answered %d, expected %d", tt.name, w.Code, tt.status)
+		}
+		// the error goes through the codec of the config
+		if !strings.Contains(w.Body.String(), "\n\t") {
+			t.Fatalf("%s: error not encoded by the codec\n%s", tt.name, w.Body)
+		}
+		var body OAuthError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != tt.code {
+			t.Fatalf("%s: error body %s", tt.name, w.Body)
+		}
+	}
+}
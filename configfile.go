@@ -0,0 +1,345 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// How often WatchConfigFile looks at the file
+const configFileCheckInterval = time.Second
+
+// Declarative configuration, read from a JSON file by LoadConfigFile
+type ConfigFile struct {
+	Key ConfigFileKey `json:"key"`
+	// e.g. {"old-kid": "current-kid"}
+	KidAliases          map[string]string `json:"kid_aliases,omitempty"`
+	PublishKidAliases   bool              `json:"publish_kid_aliases,omitempty"`
+	PublishKeyLifecycle bool              `json:"publish_key_lifecycle,omitempty"`
+	// max-age of the key set responses, DefaultCacheMaxAge if not set
+	CacheMaxAge ConfigFileDuration `json:"cache_max_age,omitempty"`
+	// see WithRotationGrace
+	RotationGrace ConfigFileDuration `json:"rotation_grace,omitempty"`
+}
+
+// Key source of a ConfigFile: the private key at Path, or a generated one when Path is empty
+type ConfigFileKey struct {
+	Path  string `json:"path,omitempty"`
+	KeyID string `json:"kid,omitempty"`
+	Bits  int    `json:"bits,omitempty"`
+	// interval of the scheduled rotations of a generated key, see WithAutoRotation
+	AutoRotation ConfigFileDuration `json:"auto_rotation,omitempty"`
+}
+
+// Duration of a ConfigFile, written the way time.ParseDuration reads it, e.g. "10m"
+type ConfigFileDuration time.Duration
+
+func (d *ConfigFileDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("a duration is a string such as \"10m\" %v", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = ConfigFileDuration(duration)
+	return nil
+}
+
+func (d ConfigFileDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfigFile reads a declarative configuration, unknown fields are refused
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file %v", err)
+	}
+	return parseConfigFile(data)
+}
+
+func parseConfigFile(data []byte) (*ConfigFile, error) {
+	var file ConfigFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("cannot parse config file %v", err)
+	}
+	return &file, nil
+}
+
+// Build the config described by the file, onError is the config error hook
+func (f *ConfigFile) Build(onError func(error)) (*Config, error) {
+	builder := NewConfigBuilder().OnError(onError)
+	if f.PublishKidAliases {
+		builder.WithPublishedKidAliases()
+	}
+	if f.PublishKeyLifecycle {
+		builder.WithPublishKeyLifecycle()
+	}
+	if f.CacheMaxAge != 0 {
+		builder.WithCacheMaxAge(time.Duration(f.CacheMaxAge))
+	}
+	builder.WithRotationGrace(time.Duration(f.RotationGrace))
+
+	var config *Config
+	var err error
+	if f.Key.Path != "" {
+		if f.Key.AutoRotation != 0 {
+			return nil, fmt.Errorf("only a generated key can be rotated automatically, remove the key path")
+		}
+		config, err = builder.ImportPrivateKey().WithPath(f.Key.Path).WithKeyId(f.Key.KeyID).Build()
+	} else {
+		newKey := builder.NewPrivateKey().WithKeyId(f.Key.KeyID)
+		if f.Key.Bits != 0 {
+			newKey.WithKeyLength(f.Key.Bits)
+		}
+		if f.Key.AutoRotation != 0 {
+			newKey.WithAutoRotation(time.Duration(f.Key.AutoRotation), time.Duration(f.RotationGrace))
+		}
+		config, err = newKey.Build()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.applyKidAliases(config, nil); err != nil {
+		config.Close()
+		return nil, err
+	}
+	return config, nil
+}
+
+// Bring the aliases of config from the ones of previous to the ones of the
+// file, all of them or none: a failure restores the aliases config had
+func (f *ConfigFile) applyKidAliases(config *Config, previous map[string]string) (err error) {
+	before := config.KidAliases()
+	defer func() {
+		if err != nil {
+			restoreKidAliases(config, before)
+		}
+	}()
+
+	for oldKid := range previous {
+		if _, ok := f.KidAliases[oldKid]; !ok {
+			if err := config.RemoveKidAlias(oldKid); err != nil {
+				return err
+			}
+		}
+	}
+	for oldKid, currentKid := range f.KidAliases {
+		if previous[oldKid] == currentKid {
+			continue
+		}
+		if _, ok := previous[oldKid]; ok {
+			if err := config.RemoveKidAlias(oldKid); err != nil {
+				return err
+			}
+		}
+		if err := config.AliasKid(oldKid, currentKid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put back the aliases of config as they were
+func restoreKidAliases(config *Config, aliases map[string]string) {
+	for oldKid, kid := range config.KidAliases() {
+		if aliases[oldKid] != kid {
+			if err := config.RemoveKidAlias(oldKid); err != nil {
+				config.reportError(err)
+			}
+		}
+	}
+	current := config.KidAliases()
+	for oldKid, kid := range aliases {
+		if _, ok := current[oldKid]; !ok {
+			if err := config.AliasKid(oldKid, kid); err != nil {
+				config.reportError(err)
+			}
+		}
+	}
+}
+
+// Whether going from f to next needs a new config, rather than changes in place
+func (f *ConfigFile) needsRebuild(next *ConfigFile) bool {
+	return f.Key != next.Key ||
+		f.PublishKidAliases != next.PublishKidAliases ||
+		f.PublishKeyLifecycle != next.PublishKeyLifecycle ||
+		f.RotationGrace != next.RotationGrace
+}
+
+// max-age of the key set responses described by the file
+func (f *ConfigFile) cacheMaxAge() time.Duration {
+	if f.CacheMaxAge == 0 {
+		return DefaultCacheMaxAge
+	}
+	return time.Duration(f.CacheMaxAge)
+}
+
+// ConfigHolder holds the running config and lets it be swapped while serving.
+// Handlers registered from the holder always use the current config.
+type ConfigHolder struct {
+	mu     sync.RWMutex
+	config *Config
+	// reports the errors of WatchConfigFile while the holder is empty
+	onError func(error)
+}
+
+func NewConfigHolder(config *Config) *ConfigHolder {
+	return &ConfigHolder{config: config}
+}
+
+// Load returns the current config
+func (h *ConfigHolder) Load() *Config {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.config
+}
+
+// Swap replaces the current config and returns the previous one
+func (h *ConfigHolder) Swap(config *Config) *Config {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	previous := h.config
+	h.config = config
+	return previous
+}
+
+// Report the errors of WatchConfigFile while the holder holds no config, the
+// hook is given to the configs it builds then
+func (h *ConfigHolder) OnError(hook func(error)) *ConfigHolder {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onError = hook
+	return h
+}
+
+// Error hook of the watcher: the one of the current config, or of the holder
+// when it is empty
+func (h *ConfigHolder) errorHook() func(error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.config != nil {
+		return h.config.onError
+	}
+	return h.onError
+}
+
+func (h *ConfigHolder) reportError(err error) {
+	if config := h.Load(); config != nil {
+		config.reportError(err)
+		return
+	}
+	if hook := h.errorHook(); hook != nil {
+		hook(err)
+	}
+}
+
+// Jkws handler serving the key set of the current config
+func (h *ConfigHolder) Jkws() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config := h.Load()
+		if config == nil {
+			// nothing loaded yet, served as a config without key
+			config = &Config{}
+		}
+		Jkws(*config)(c)
+	}
+}
+
+// WatchConfigFile applies the changes made to the configuration file at path
+// until ctx ends. Alias and cache changes are applied to the running config in
+// place, a different key source, rotation or publication setting builds a new
+// config swapped in the holder, the previous one being closed. An empty holder
+// gets the config of the file. A file which cannot be read, parsed or built is
+// reported through the error hook of the running config, or of the holder when
+// it is empty, and the running config keeps serving.
+func WatchConfigFile(ctx context.Context, path string, holder *ConfigHolder) error {
+	return watchConfigFile(ctx, path, holder, configFileCheckInterval)
+}
+
+func watchConfigFile(ctx context.Context, path string, holder *ConfigHolder, interval time.Duration) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file %v", err)
+	}
+	current, err := parseConfigFile(data)
+	if err != nil {
+		return err
+	}
+	if holder.Load() == nil {
+		applyConfigFile(holder, current, current)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		next, err := os.ReadFile(path)
+		if err != nil {
+			holder.reportError(fmt.Errorf("cannot read config file %v", err))
+			continue
+		}
+		if bytes.Equal(next, data) {
+			continue
+		}
+		data = next
+
+		file, err := parseConfigFile(next)
+		if err != nil {
+			holder.reportError(err)
+			continue
+		}
+		if reflect.DeepEqual(file, current) && holder.Load() != nil {
+			continue
+		}
+		if applyConfigFile(holder, current, file) {
+			current = file
+		}
+	}
+}
+
+// Bring the config of holder from the file current to file, false when the
+// running config is kept
+func applyConfigFile(holder *ConfigHolder, current, file *ConfigFile) bool {
+	running := holder.Load()
+	if running == nil || current.needsRebuild(file) {
+		config, err := file.Build(holder.errorHook())
+		if err != nil {
+			holder.reportError(fmt.Errorf("cannot apply config file, keeping the running config %v", err))
+			return false
+		}
+		if previous := holder.Swap(config); previous != nil {
+			previous.Close()
+		}
+		return true
+	}
+
+	if err := file.applyKidAliases(running, current.KidAliases); err != nil {
+		running.reportError(fmt.Errorf("cannot apply kid aliases of the config file %v", err))
+		return false
+	}
+	// a copy shares the keys and the background work of the running config,
+	// which is not closed
+	if maxAge := file.cacheMaxAge(); maxAge != running.cacheMaxAge {
+		updated := *running
+		updated.cacheMaxAge = maxAge
+		holder.Swap(&updated)
+	}
+	return true
+}
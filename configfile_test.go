@@ -0,0 +1,151 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Watch the file at path into holder until the test ends
+func watchTestConfigFile(t *testing.T, path string, holder *ConfigHolder) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- watchConfigFile(ctx, path, holder, 10*time.Millisecond)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+		if config := holder.Load(); config != nil {
+			config.Close()
+		}
+	})
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Poll until cond holds, the watcher applies the file on its next check
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchConfigFileWithAnEmptyHolder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jwks.json")
+	writeConfigFile(t, path, `{"key": {"path": "`+filepath.Join(dir, "missing.pem")+`"}}`)
+
+	var mu sync.Mutex
+	var reported []error
+	holder := NewConfigHolder(nil).OnError(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	})
+	watchTestConfigFile(t, path, holder)
+
+	// the key cannot be read, nothing is served and the error goes to the
+	// hook of the holder
+	eventually(t, "the build error", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reported) > 0
+	})
+	if holder.Load() != nil {
+		t.Fatal("a config was installed from an invalid file")
+	}
+
+	writeConfigFile(t, path, `{not json`)
+	time.Sleep(50 * time.Millisecond)
+
+	writeConfigFile(t, path, `{"key": {"kid": "from-file", "bits": 2048}}`)
+	eventually(t, "the config of the file", func() bool {
+		return holder.Load() != nil
+	})
+	if kid := holder.Load().active().key.KeyID(); kid != "from-file" {
+		t.Fatalf("holder serves kid %q, expected from-file", kid)
+	}
+}
+
+func TestWatchConfigFileChangesTheCacheMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeConfigFile(t, path, `{"key": {"kid": "k1", "bits": 2048}, "cache_max_age": "1m"}`)
+	holder := NewConfigHolder(nil)
+	watchTestConfigFile(t, path, holder)
+
+	r := gin.New()
+	r.GET("/jwks", holder.Jkws())
+	cacheControl := func() string {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+		return w.Header().Get("Cache-Control")
+	}
+	eventually(t, "the config of the file", func() bool {
+		return holder.Load() != nil
+	})
+	if got := cacheControl(); got != "public, max-age=60" {
+		t.Fatalf("Cache-Control %q, expected public, max-age=60", got)
+	}
+
+	kid := holder.Load().active().key.KeyID()
+	writeConfigFile(t, path, `{"key": {"kid": "k1", "bits": 2048}, "cache_max_age": "5m"}`)
+	eventually(t, "the new max-age", func() bool {
+		return cacheControl() == "public, max-age=300"
+	})
+	if holder.Load().active().key.KeyID() != kid {
+		t.Fatal("a cache change replaced the key")
+	}
+}
+
+func TestApplyKidAliasesIsAllOrNothing(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	if err := config.AliasKid("kept", "test"); err != nil {
+		t.Fatal(err)
+	}
+	before := config.KidAliases()
+
+	file := &ConfigFile{KidAliases: map[string]string{"new": "test", "broken": "unknown"}}
+	if err := file.applyKidAliases(config, map[string]string{"kept": "test"}); err == nil {
+		t.Fatal("an alias to an unknown kid was accepted")
+	}
+	if after := config.KidAliases(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("aliases %v after a failed change, expected %v", after, before)
+	}
+}
+
+func TestConfigFileDuration(t *testing.T) {
+	file, err := parseConfigFile([]byte(`{"key": {"auto_rotation": "24h"}, "rotation_grace": "90m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(file.Key.AutoRotation) != 24*time.Hour || time.Duration(file.RotationGrace) != 90*time.Minute {
+		t.Fatalf("durations read as %v and %v", time.Duration(file.Key.AutoRotation), time.Duration(file.RotationGrace))
+	}
+	if _, err = parseConfigFile([]byte(`{"cache_max_age": 60}`)); err == nil {
+		t.Fatal("a duration without a unit was accepted")
+	}
+	if _, err = (&ConfigFile{Key: ConfigFileKey{Path: "key.pem", AutoRotation: ConfigFileDuration(time.Hour)}}).Build(nil); err == nil {
+		t.Fatal("automatic rotation of a key read from a file was accepted")
+	}
+}
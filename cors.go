@@ -0,0 +1,70 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// Seconds a browser may keep the answer to a preflight request
+	corsMaxAge = 24 * 60 * 60
+	// Methods answered by the key set handler
+	jwksAllowedMethods = "GET, HEAD, OPTIONS"
+)
+
+// Let browsers read the key set from the given origins, or from any origin
+// when none is given or one of them is "*". Register the handler for OPTIONS
+// as well so preflight requests are answered.
+func (n *ConfigBuilder) WithCORS(origins ...string) *ConfigBuilder {
+	n.config.cors = true
+	n.config.corsOrigins = origins
+	return n
+}
+
+// Set the CORS headers of the response, true when the request was a preflight
+// request, answered with a 204
+func (c *Config) handleCORS(ctx *gin.Context) bool {
+	if !c.cors {
+		return false
+	}
+
+	origin := ctx.GetHeader("Origin")
+	allowOrigin := c.corsAllowedOrigin(origin)
+	if allowOrigin != "*" {
+		// the answer depends on the origin, shared caches must know
+		ctx.Writer.Header().Add("Vary", "Origin")
+	}
+	if allowOrigin != "" {
+		ctx.Header("Access-Control-Allow-Origin", allowOrigin)
+		ctx.Header("Access-Control-Expose-Headers", "ETag")
+	}
+
+	if ctx.Request.Method != http.MethodOptions || origin == "" || ctx.GetHeader("Access-Control-Request-Method") == "" {
+		return false
+	}
+	if allowOrigin != "" {
+		ctx.Header("Access-Control-Allow-Methods", jwksAllowedMethods)
+		ctx.Header("Access-Control-Allow-Headers", "Authorization, If-None-Match")
+		ctx.Header("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+	}
+	ctx.Header("Allow", jwksAllowedMethods)
+	ctx.AbortWithStatus(http.StatusNoContent)
+	return true
+}
+
+// Value of Access-Control-Allow-Origin for origin, empty when it is not allowed
+func (c *Config) corsAllowedOrigin(origin string) string {
+	if len(c.corsOrigins) == 0 {
+		return "*"
+	}
+	for _, allowed := range c.corsOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
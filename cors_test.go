@@ -0,0 +1,78 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveCORS(config *Config, method, origin string, preflight bool) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	r.OPTIONS("/jwks", Jkws(*config))
+	req := httptest.NewRequest(method, "/jwks", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORS(t *testing.T) {
+	for name, tt := range map[string]struct {
+		origins     []string
+		origin      string
+		allowOrigin string
+		vary        bool
+	}{
+		"any origin":          {origin: "https://app.example.com", allowOrigin: "*"},
+		"wildcard":            {origins: []string{"https://app.example.com", "*"}, origin: "https://other.example.com", allowOrigin: "*"},
+		"allowed origin":      {origins: []string{"https://app.example.com"}, origin: "https://app.example.com", allowOrigin: "https://app.example.com", vary: true},
+		"other origin":        {origins: []string{"https://app.example.com"}, origin: "https://other.example.com", vary: true},
+		"no origin, filtered": {origins: []string{"https://app.example.com"}, vary: true},
+	} {
+		config := newTestConfig(t, NewConfigBuilder().WithCORS(tt.origins...))
+
+		w := serveCORS(config, http.MethodGet, tt.origin, false)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != tt.allowOrigin {
+			t.Fatalf("%s: GET answered %d with origin %q", name, w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if exposed := w.Header().Get("Access-Control-Expose-Headers"); (exposed == "ETag") != (tt.allowOrigin != "") {
+			t.Fatalf("%s: exposed headers %q", name, exposed)
+		}
+		if vary := w.Header().Get("Vary") == "Origin"; vary != tt.vary {
+			t.Fatalf("%s: Vary %q", name, w.Header().Get("Vary"))
+		}
+		if tt.origin == "" {
+			continue
+		}
+
+		w = serveCORS(config, http.MethodOptions, tt.origin, true)
+		if w.Code != http.StatusNoContent || w.Header().Get("Allow") != jwksAllowedMethods || w.Body.Len() != 0 {
+			t.Fatalf("%s: preflight answered %d %s", name, w.Code, w.Body)
+		}
+		allowed := w.Header().Get("Access-Control-Allow-Methods") == jwksAllowedMethods &&
+			w.Header().Get("Access-Control-Max-Age") == "86400"
+		if allowed != (tt.allowOrigin != "") {
+			t.Fatalf("%s: preflight headers %v", name, w.Header())
+		}
+	}
+}
+
+func TestWithoutCORS(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	w := serveCORS(config, http.MethodGet, "https://app.example.com", false)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "" {
+		t.Fatalf("GET answered %d %v", w.Code, w.Header())
+	}
+	// a plain OPTIONS request, the handler lists its methods
+	w = serveCORS(config, http.MethodOptions, "https://app.example.com", true)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Allow") != jwksAllowedMethods {
+		t.Fatalf("OPTIONS answered %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,272 @@
+package cwt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Just enough CBOR (RFC 8949) for CWT and COSE: integers, byte and text
+// strings, arrays, maps, tags, booleans, null and floats on decoding.
+// Maps are encoded in the core deterministic order.
+
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+
+	// nesting accepted when decoding
+	maxDepth = 16
+)
+
+// Tagged CBOR data item
+type Tag struct {
+	Number  uint64
+	Content interface{}
+}
+
+func encodeHead(buf *bytes.Buffer, major byte, n uint64) {
+	var size int
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+		return
+	case n <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		size = 1
+	case n <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		size = 2
+	case n <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		size = 4
+	default:
+		buf.WriteByte(major<<5 | 27)
+		size = 8
+	}
+	for i := size - 1; i >= 0; i-- {
+		buf.WriteByte(byte(n >> (8 * i)))
+	}
+}
+
+// Encode v, maps keys must be int64 or string
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeTo(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeTo(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(majorSimple<<5 | 22)
+	case bool:
+		if v {
+			buf.WriteByte(majorSimple<<5 | 21)
+		} else {
+			buf.WriteByte(majorSimple<<5 | 20)
+		}
+	case int:
+		return encodeTo(buf, int64(v))
+	case int64:
+		if v < 0 {
+			encodeHead(buf, majorNegative, uint64(-(v + 1)))
+		} else {
+			encodeHead(buf, majorUnsigned, uint64(v))
+		}
+	case uint64:
+		encodeHead(buf, majorUnsigned, v)
+	case []byte:
+		encodeHead(buf, majorBytes, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		encodeHead(buf, majorText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		encodeHead(buf, majorArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encodeTo(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, len(v))
+		for key, value := range v {
+			switch key.(type) {
+			case int, int64, string:
+			default:
+				return fmt.Errorf("cannot encode a %T map key", key)
+			}
+			k, err := encode(key)
+			if err != nil {
+				return err
+			}
+			val, err := encode(value)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{k, val})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		encodeHead(buf, majorMap, uint64(len(entries)))
+		for _, e := range entries {
+			buf.Write(e.key)
+			buf.Write(e.value)
+		}
+	case Tag:
+		encodeHead(buf, majorTag, v.Number)
+		return encodeTo(buf, v.Content)
+	default:
+		return fmt.Errorf("cannot encode %T", v)
+	}
+	return nil
+}
+
+// Decode a single data item spanning the whole of data
+func decode(data []byte) (interface{}, error) {
+	d := decoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("trailing bytes after CBOR item")
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) head() (byte, byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, 0, fmt.Errorf("indefinite lengths are not supported")
+	}
+	if len(d.data)-d.pos < size {
+		return 0, 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	var n uint64
+	for _, b := range d.data[d.pos : d.pos+size] {
+		n = n<<8 | uint64(b)
+	}
+	d.pos += size
+	return major, info, n, nil
+}
+
+func (d *decoder) item(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("CBOR nested deeper than %d", maxDepth)
+	}
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	// every element takes at least a byte, so no length may exceed what is left
+	if (major >= majorBytes && major <= majorMap) && n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("CBOR length %d exceeds the data", n)
+	}
+
+	switch major {
+	case majorUnsigned:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer overflows int64")
+		}
+		return int64(n), nil
+	case majorNegative:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer overflows int64")
+		}
+		return -1 - int64(n), nil
+	case majorBytes:
+		v := append([]byte{}, d.data[d.pos:d.pos+int(n)]...)
+		d.pos += int(n)
+		return v, nil
+	case majorText:
+		v := string(d.data[d.pos : d.pos+int(n)])
+		d.pos += int(n)
+		return v, nil
+	case majorArray:
+		v := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, item)
+		}
+		return v, nil
+	case majorMap:
+		v := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("unsupported %T map key", key)
+			}
+			if _, ok := v[key]; ok {
+				return nil, fmt.Errorf("duplicate map key %v", key)
+			}
+			if v[key], err = d.item(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case majorTag:
+		content, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Tag{Number: n, Content: content}, nil
+	default:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(n))), nil
+		case 27:
+			return math.Float64frombits(n), nil
+		}
+		return nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+	}
+}
@@ -0,0 +1,464 @@
+// Package cwt issues and verifies CBOR Web Tokens (RFC 8392) signed with
+// COSE_Sign1 (RFC 9052) by the key of a gin_jwks_rsa config, for devices which
+// cannot afford JSON. It brings its own minimal CBOR codec so importing the
+// root package alone pulls nothing CBOR related.
+package cwt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	gin_jwks_rsa "github.com/v4lproik/gin-jwks-rsa"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ContentType        = "application/cwt"
+	KeySetContentType  = "application/cose-key-set"
+	ClaimsContextKey   = "gin-jwks-cwt-claims"
+	tagCWT             = 61
+	tagCOSESign1       = 18
+	signatureContext   = "Signature1"
+	maxCWTSize         = 8 * 1024
+	defaultCWTLifetime = 5 * time.Minute
+)
+
+// RFC 8392 registered claim keys
+const (
+	ClaimIssuer     int64 = 1
+	ClaimSubject    int64 = 2
+	ClaimAudience   int64 = 3
+	ClaimExpiration int64 = 4
+	ClaimNotBefore  int64 = 5
+	ClaimIssuedAt   int64 = 6
+	ClaimCWTID      int64 = 7
+)
+
+// COSE header parameters and key parameters, RFC 9052 and RFC 9053
+const (
+	headerAlg int64 = 1
+	headerKid int64 = 4
+
+	keyKty int64 = 1
+	keyKid int64 = 2
+	keyAlg int64 = 3
+	// -1 is crv for EC2 and OKP, n for RSA
+	keyParam1 int64 = -1
+	keyParam2 int64 = -2
+	keyParam3 int64 = -3
+
+	ktyOKP int64 = 1
+	ktyEC2 int64 = 2
+	ktyRSA int64 = 3
+)
+
+// COSE algorithm identifiers of the JWS algorithms, the signature bytes are the same
+var coseAlgorithms = map[jwa.SignatureAlgorithm]int64{
+	jwa.ES256: -7,
+	jwa.EdDSA: -8,
+	jwa.ES384: -35,
+	jwa.ES512: -36,
+	jwa.PS256: -37,
+	jwa.PS384: -38,
+	jwa.PS512: -39,
+	jwa.RS256: -257,
+	jwa.RS384: -258,
+	jwa.RS512: -259,
+}
+
+var coseCurves = map[string]int64{
+	"P-256":   1,
+	"P-384":   2,
+	"P-521":   3,
+	"Ed25519": 6,
+}
+
+// Claims of a CWT, other claims go in Extra keyed by int64 or string
+type Claims struct {
+	Issuer     string
+	Subject    string
+	Audience   string
+	Expiration time.Time
+	NotBefore  time.Time
+	IssuedAt   time.Time
+	CWTID      []byte
+	Extra      map[interface{}]interface{}
+}
+
+func (c Claims) toMap() map[interface{}]interface{} {
+	m := map[interface{}]interface{}{}
+	for k, v := range c.Extra {
+		m[k] = v
+	}
+	for k, v := range map[int64]string{ClaimIssuer: c.Issuer, ClaimSubject: c.Subject, ClaimAudience: c.Audience} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	for k, v := range map[int64]time.Time{ClaimExpiration: c.Expiration, ClaimNotBefore: c.NotBefore, ClaimIssuedAt: c.IssuedAt} {
+		if !v.IsZero() {
+			m[k] = v.Unix()
+		}
+	}
+	if c.CWTID != nil {
+		m[ClaimCWTID] = c.CWTID
+	}
+	return m
+}
+
+func claimsFromMap(m map[interface{}]interface{}) (Claims, error) {
+	var c Claims
+	c.Extra = map[interface{}]interface{}{}
+	for k, v := range m {
+		var ok bool
+		switch k {
+		case ClaimIssuer:
+			c.Issuer, ok = v.(string)
+		case ClaimSubject:
+			c.Subject, ok = v.(string)
+		case ClaimAudience:
+			c.Audience, ok = v.(string)
+		case ClaimExpiration:
+			c.Expiration, ok = numericDate(v)
+		case ClaimNotBefore:
+			c.NotBefore, ok = numericDate(v)
+		case ClaimIssuedAt:
+			c.IssuedAt, ok = numericDate(v)
+		case ClaimCWTID:
+			c.CWTID, ok = v.([]byte)
+		default:
+			c.Extra[k], ok = v, true
+		}
+		if !ok {
+			return Claims{}, fmt.Errorf("invalid claim %v", k)
+		}
+	}
+	return c, nil
+}
+
+// NumericDate, integer or floating point seconds
+func numericDate(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case int64:
+		return time.Unix(v, 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
+
+// Issue signs claims into a tagged COSE_Sign1 CWT with the config key. iat is
+// set to now when zero and exp to 5 minutes later when zero.
+func Issue(config *gin_jwks_rsa.Config, claims Claims) ([]byte, error) {
+	now := time.Now()
+	if claims.IssuedAt.IsZero() {
+		claims.IssuedAt = now
+	}
+	if claims.Expiration.IsZero() {
+		claims.Expiration = now.Add(defaultCWTLifetime)
+	}
+	payload, err := encode(claims.toMap())
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode claims %v", err)
+	}
+
+	keys, err := config.VerificationKeys()
+	if err != nil {
+		return nil, err
+	}
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("no signing key")
+	}
+	signingKey, _ := keys.Key(0)
+	alg, ok := coseAlgorithms[jwa.SignatureAlgorithm(signingKey.Algorithm().String())]
+	if !ok {
+		return nil, fmt.Errorf("no COSE algorithm for %s", signingKey.Algorithm())
+	}
+	protected, err := encode(map[interface{}]interface{}{
+		headerAlg: alg,
+		headerKid: []byte(signingKey.KeyID()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode protected header %v", err)
+	}
+
+	toBeSigned, err := sigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	signature, _, _, err := config.SignBytes(toBeSigned)
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(Tag{Number: tagCWT, Content: Tag{Number: tagCOSESign1, Content: []interface{}{
+		protected,
+		map[interface{}]interface{}{},
+		payload,
+		signature,
+	}}})
+}
+
+// RFC 9052 section 4.4 Sig_structure, without external AAD
+func sigStructure(protected, payload []byte) ([]byte, error) {
+	return encode([]interface{}{signatureContext, protected, []byte{}, payload})
+}
+
+// What Verify checks besides the signature
+type VerifyOptions struct {
+	// expected iss and aud, not checked when empty
+	Issuer   string
+	Audience string
+	// tolerated clock difference on exp and nbf
+	ClockSkew time.Duration
+}
+
+// Verify checks the COSE_Sign1 signature of a CWT against the keys of the
+// config, then exp, nbf and the expected iss and aud. exp is required.
+func Verify(config *gin_jwks_rsa.Config, token []byte, opts VerifyOptions) (Claims, error) {
+	if len(token) > maxCWTSize {
+		return Claims{}, fmt.Errorf("CWT larger than %d bytes", maxCWTSize)
+	}
+	item, err := decode(token)
+	if err != nil {
+		return Claims{}, fmt.Errorf("cannot decode CWT %v", err)
+	}
+	if tag, ok := item.(Tag); ok && tag.Number == tagCWT {
+		item = tag.Content
+	}
+	if tag, ok := item.(Tag); ok {
+		if tag.Number != tagCOSESign1 {
+			return Claims{}, fmt.Errorf("unsupported COSE message tag %d", tag.Number)
+		}
+		item = tag.Content
+	}
+	message, ok := item.([]interface{})
+	if !ok || len(message) != 4 {
+		return Claims{}, fmt.Errorf("CWT is not a COSE_Sign1 message")
+	}
+	protected, ok1 := message[0].([]byte)
+	payload, ok2 := message[2].([]byte)
+	signature, ok3 := message[3].([]byte)
+	if !ok1 || !ok2 || !ok3 {
+		return Claims{}, fmt.Errorf("CWT is not a COSE_Sign1 message")
+	}
+
+	header, err := decodeMap(protected)
+	if err != nil {
+		return Claims{}, fmt.Errorf("invalid protected header %v", err)
+	}
+	kid, _ := header[headerKid].([]byte)
+	algID, _ := header[headerAlg].(int64)
+
+	keys, err := config.VerificationKeys()
+	if err != nil {
+		return Claims{}, err
+	}
+	key, ok := keys.LookupKeyID(string(kid))
+	if !ok {
+		return Claims{}, fmt.Errorf("unknown kid %q", kid)
+	}
+	alg := jwa.SignatureAlgorithm(key.Algorithm().String())
+	if expected, ok := coseAlgorithms[alg]; !ok || expected != algID {
+		return Claims{}, fmt.Errorf("unexpected COSE algorithm %d", algID)
+	}
+
+	toBeSigned, err := sigStructure(protected, payload)
+	if err != nil {
+		return Claims{}, err
+	}
+	verifier, err := jws.NewVerifier(alg)
+	if err != nil {
+		return Claims{}, fmt.Errorf("cannot create verifier %v", err)
+	}
+	if err = verifier.Verify(toBeSigned, signature, key); err != nil {
+		return Claims{}, fmt.Errorf("invalid signature %v", err)
+	}
+
+	m, err := decodeMap(payload)
+	if err != nil {
+		return Claims{}, fmt.Errorf("invalid claims %v", err)
+	}
+	claims, err := claimsFromMap(m)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	now := time.Now()
+	if claims.Expiration.IsZero() {
+		return Claims{}, fmt.Errorf("CWT has no exp claim")
+	}
+	if now.After(claims.Expiration.Add(opts.ClockSkew)) {
+		return Claims{}, fmt.Errorf("CWT expired")
+	}
+	if !claims.NotBefore.IsZero() && now.Before(claims.NotBefore.Add(-opts.ClockSkew)) {
+		return Claims{}, fmt.Errorf("CWT not valid yet")
+	}
+	if opts.Issuer != "" && claims.Issuer != opts.Issuer {
+		return Claims{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if opts.Audience != "" && claims.Audience != opts.Audience {
+		return Claims{}, fmt.Errorf("unexpected audience %q", claims.Audience)
+	}
+
+	return claims, nil
+}
+
+func decodeMap(data []byte) (map[interface{}]interface{}, error) {
+	item, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a CBOR map")
+	}
+	return m, nil
+}
+
+// RequireCWT middleware verifying the CWT of a request, sent either as an
+// application/cwt body or base64url encoded in a Bearer Authorization header.
+// The claims are stored under ClaimsContextKey.
+func RequireCWT(config *gin_jwks_rsa.Config, opts VerifyOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := requestToken(c.Request)
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := Verify(config, token, opts)
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(ClaimsContextKey, claims)
+		c.Next()
+	}
+}
+
+func requestToken(r *http.Request) ([]byte, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		const prefix = "Bearer "
+		if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+			return nil, fmt.Errorf("unsupported authorization scheme")
+		}
+		token, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(auth[len(prefix):], "="))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode CWT %v", err)
+		}
+		return token, nil
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), ContentType) {
+		return nil, fmt.Errorf("no CWT in the request")
+	}
+	token, err := io.ReadAll(io.LimitReader(r.Body, maxCWTSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CWT %v", err)
+	}
+	return token, nil
+}
+
+// ClaimsFromContext returns the claims verified by RequireCWT
+func ClaimsFromContext(c *gin.Context) (Claims, bool) {
+	v, ok := c.Get(ClaimsContextKey)
+	if !ok {
+		return Claims{}, false
+	}
+	claims, ok := v.(Claims)
+	return claims, ok
+}
+
+// KeySet handler serving the verification keys of the config as a COSE_KeySet
+func KeySet(config *gin_jwks_rsa.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.AuthorizeEndpoint(c) {
+			return
+		}
+		body, err := coseKeySet(c.Request.Context(), config)
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(500)
+			return
+		}
+		c.Data(http.StatusOK, KeySetContentType, body)
+	}
+}
+
+func coseKeySet(ctx context.Context, config *gin_jwks_rsa.Config) ([]byte, error) {
+	keys, err := config.VerificationKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var set []interface{}
+	for it := keys.Keys(ctx); it.Next(ctx); {
+		key := it.Pair().Value.(jwk.Key)
+		coseKey, err := toCOSEKey(key)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, coseKey)
+	}
+	return encode(set)
+}
+
+// COSE_Key of a public JWK, RFC 9053 section 7 and RFC 8230 for RSA
+func toCOSEKey(key jwk.Key) (map[interface{}]interface{}, error) {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, fmt.Errorf("cannot read key %v", err)
+	}
+
+	m := map[interface{}]interface{}{keyKid: []byte(key.KeyID())}
+	if alg, ok := coseAlgorithms[jwa.SignatureAlgorithm(key.Algorithm().String())]; ok {
+		m[keyAlg] = alg
+	}
+	switch raw := raw.(type) {
+	case *rsa.PublicKey:
+		m[keyKty] = ktyRSA
+		m[keyParam1] = raw.N.Bytes()
+		m[keyParam2] = big.NewInt(int64(raw.E)).Bytes()
+	case *ecdsa.PublicKey:
+		crv, ok := coseCurves[raw.Curve.Params().Name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", raw.Curve.Params().Name)
+		}
+		size := (raw.Curve.Params().BitSize + 7) / 8
+		m[keyKty] = ktyEC2
+		m[keyParam1] = crv
+		m[keyParam2] = padded(raw.X.Bytes(), size)
+		m[keyParam3] = padded(raw.Y.Bytes(), size)
+	case ed25519.PublicKey:
+		m[keyKty] = ktyOKP
+		m[keyParam1] = coseCurves["Ed25519"]
+		m[keyParam2] = []byte(raw)
+	default:
+		return nil, fmt.Errorf("unsupported %T key", raw)
+	}
+	return m, nil
+}
+
+// Left pad with zeros, EC2 coordinates are fixed length
+func padded(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(bytes.Repeat([]byte{0}, size-len(b)), b...)
+}
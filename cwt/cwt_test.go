@@ -0,0 +1,197 @@
+package cwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	gin_jwks_rsa "github.com/v4lproik/gin-jwks-rsa"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConfig(t *testing.T, build func(*gin_jwks_rsa.ConfigNewKeyBuilder) *gin_jwks_rsa.ConfigNewKeyBuilder, kid string) *gin_jwks_rsa.Config {
+	t.Helper()
+	config, err := build(gin_jwks_rsa.NewConfigBuilder().NewPrivateKey()).WithKeyId(kid).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.Close() })
+	return config
+}
+
+func rsaKey(n *gin_jwks_rsa.ConfigNewKeyBuilder) *gin_jwks_rsa.ConfigNewKeyBuilder {
+	return n.WithKeyLength(2048)
+}
+
+func issue(t *testing.T, config *gin_jwks_rsa.Config, claims Claims) []byte {
+	t.Helper()
+	token, err := Issue(config, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestIssueVerifyRoundTrip(t *testing.T) {
+	for name, build := range map[string]func(*gin_jwks_rsa.ConfigNewKeyBuilder) *gin_jwks_rsa.ConfigNewKeyBuilder{
+		"RSA": rsaKey,
+		"EC": func(n *gin_jwks_rsa.ConfigNewKeyBuilder) *gin_jwks_rsa.ConfigNewKeyBuilder {
+			return n.WithKeyType(jwa.EC).WithCurve(jwa.P256)
+		},
+		"Ed25519": func(n *gin_jwks_rsa.ConfigNewKeyBuilder) *gin_jwks_rsa.ConfigNewKeyBuilder {
+			return n.WithKeyType(jwa.OKP).WithCurve(jwa.Ed25519)
+		},
+	} {
+		config := newTestConfig(t, build, "test")
+		sent := Claims{
+			Issuer:     "https://auth.example.com",
+			Subject:    "device-1",
+			Audience:   "sensors",
+			Expiration: time.Now().Add(time.Hour).Truncate(time.Second),
+			CWTID:      []byte{1, 2, 3},
+			Extra:      map[interface{}]interface{}{int64(-70000): "firmware", "room": int64(12)},
+		}
+		token := issue(t, config, sent)
+
+		got, err := Verify(config, token, VerifyOptions{Issuer: sent.Issuer, Audience: sent.Audience})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.IssuedAt.IsZero() {
+			t.Fatalf("%s: iat not set", name)
+		}
+		sent.IssuedAt = got.IssuedAt
+		if !reflect.DeepEqual(got, sent) {
+			t.Fatalf("%s: got %+v, expected %+v", name, got, sent)
+		}
+
+		if _, err = Verify(config, token, VerifyOptions{Issuer: "https://other.example.com"}); err == nil {
+			t.Fatalf("%s: verified with another issuer", name)
+		}
+		if _, err = Verify(config, token, VerifyOptions{Audience: "other"}); err == nil {
+			t.Fatalf("%s: verified with another audience", name)
+		}
+	}
+}
+
+func TestVerifyRefusesTamperedSignature(t *testing.T) {
+	config := newTestConfig(t, rsaKey, "test")
+	token := issue(t, config, Claims{Subject: "device-1"})
+
+	tampered := append([]byte{}, token...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Verify(config, tampered, VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("tampered signature gave %v", err)
+	}
+
+	// the payload is covered by the signature as well
+	tampered = bytes.Replace(token, []byte("device-1"), []byte("device-2"), 1)
+	if bytes.Equal(tampered, token) {
+		t.Fatal("subject not found in the token")
+	}
+	if _, err := Verify(config, tampered, VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("tampered payload gave %v", err)
+	}
+}
+
+func TestVerifyRefusesWrongKid(t *testing.T) {
+	config := newTestConfig(t, rsaKey, "test")
+
+	other := newTestConfig(t, rsaKey, "other")
+	if _, err := Verify(config, issue(t, other, Claims{}), VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "unknown kid") {
+		t.Fatalf("token of an unknown kid gave %v", err)
+	}
+
+	// another key under the same kid
+	impostor := newTestConfig(t, rsaKey, "test")
+	if _, err := Verify(config, issue(t, impostor, Claims{}), VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("token of another key under the same kid gave %v", err)
+	}
+}
+
+func TestVerifyRefusesExpiredCWT(t *testing.T) {
+	config := newTestConfig(t, rsaKey, "test")
+	expired := issue(t, config, Claims{Expiration: time.Now().Add(-time.Minute)})
+
+	if _, err := Verify(config, expired, VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("expired CWT gave %v", err)
+	}
+	if _, err := Verify(config, expired, VerifyOptions{ClockSkew: 2 * time.Minute}); err != nil {
+		t.Fatalf("expired CWT within the clock skew gave %v", err)
+	}
+
+	early := issue(t, config, Claims{NotBefore: time.Now().Add(time.Minute)})
+	if _, err := Verify(config, early, VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "not valid yet") {
+		t.Fatalf("CWT not valid yet gave %v", err)
+	}
+}
+
+func TestRequireCWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := newTestConfig(t, rsaKey, "test")
+	r := gin.New()
+	r.POST("/telemetry", RequireCWT(config, VerifyOptions{}), func(c *gin.Context) {
+		claims, ok := ClaimsFromContext(c)
+		if !ok {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, claims.Subject)
+	})
+	token := issue(t, config, Claims{Subject: "device-1"})
+
+	for name, tc := range map[string]struct {
+		header, value string
+		body          []byte
+		status        int
+	}{
+		"bearer":  {"Authorization", "Bearer " + base64.RawURLEncoding.EncodeToString(token), nil, http.StatusOK},
+		"body":    {"Content-Type", ContentType, token, http.StatusOK},
+		"basic":   {"Authorization", "Basic dXNlcjpwYXNz", nil, http.StatusUnauthorized},
+		"missing": {"Content-Type", "application/json", token, http.StatusUnauthorized},
+		"expired": {"Content-Type", ContentType, issue(t, config, Claims{Expiration: time.Now().Add(-time.Minute)}), http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/telemetry", bytes.NewReader(tc.body))
+		req.Header.Set(tc.header, tc.value)
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status || (tc.status == http.StatusOK && w.Body.String() != "device-1") {
+			t.Fatalf("%s: got %d %q", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := newTestConfig(t, func(n *gin_jwks_rsa.ConfigNewKeyBuilder) *gin_jwks_rsa.ConfigNewKeyBuilder {
+		return n.WithKeyType(jwa.EC).WithCurve(jwa.P256)
+	}, "test")
+	r := gin.New()
+	r.GET("/cose-keys", KeySet(config))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cose-keys", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != KeySetContentType {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	item, err := decode(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, ok := item.([]interface{})
+	if !ok || len(set) != 1 {
+		t.Fatalf("got %v", item)
+	}
+	key := set[0].(map[interface{}]interface{})
+	if !bytes.Equal(key[keyKid].([]byte), []byte("test")) || key[keyKty] != ktyEC2 || key[keyAlg] != int64(-7) || key[keyParam1] != int64(1) {
+		t.Fatalf("got COSE key %v", key)
+	}
+	if len(key[keyParam2].([]byte)) != 32 || len(key[keyParam3].([]byte)) != 32 {
+		t.Fatal("coordinates are not 32 bytes long")
+	}
+}
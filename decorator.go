@@ -0,0 +1,116 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Members a decorator can never add, along with the private key members
+var reservedJWKMembers = map[string]bool{
+	"kty": true, "alg": true, "use": true, "kid": true, "key_ops": true,
+	"e": true, "n": true, "crv": true, "x": true, "y": true,
+	"x5c": true, "x5t": true, "x5t#S256": true, "x5u": true,
+	"iat": true, "nbf": true, "exp": true,
+}
+
+// Add members of your own to each published key, e.g. {"env": "prod"}. decorate
+// gets the members already set and may add others; changing or removing one of
+// them, or adding a member RFC 7517 or RFC 7518 defines, fails the key set.
+func (n *ConfigBuilder) WithKeyDecorator(decorate func(kid string, entry map[string]interface{})) *ConfigBuilder {
+	n.config.keyDecorator = decorate
+	return n
+}
+
+// Run the decorator over each key, keeping what it added apart from the standard members
+func (c *Config) decorateKeys(keys []JkwsResponse) error {
+	if c.keyDecorator == nil {
+		return nil
+	}
+	for i := range keys {
+		standard, err := keys[i].members()
+		if err != nil {
+			return err
+		}
+		entry, err := keys[i].members()
+		if err != nil {
+			return err
+		}
+		c.keyDecorator(keys[i].KeyIDKey, entry)
+
+		for name := range standard {
+			if _, ok := entry[name]; !ok {
+				return fmt.Errorf("key decorator removed member %q of kid %q", name, keys[i].KeyIDKey)
+			}
+		}
+		extra := map[string]interface{}{}
+		for name, value := range entry {
+			if standardValue, ok := standard[name]; ok {
+				if !reflect.DeepEqual(value, standardValue) {
+					return fmt.Errorf("key decorator changed member %q of kid %q", name, keys[i].KeyIDKey)
+				}
+				continue
+			}
+			if reservedJWKMembers[name] || isPrivateJWKMember(name) {
+				return fmt.Errorf("key decorator cannot add member %q to kid %q", name, keys[i].KeyIDKey)
+			}
+			extra[name] = value
+		}
+		if _, err = json.Marshal(extra); err != nil {
+			return fmt.Errorf("cannot serialize the members added to kid %q %v", keys[i].KeyIDKey, err)
+		}
+		keys[i].extra = extra
+	}
+	return nil
+}
+
+// Standard members of the key as they are published
+func (r JkwsResponse) members() (map[string]interface{}, error) {
+	type standardMembers JkwsResponse
+	body, err := json.Marshal(standardMembers(r))
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize kid %q %v", r.KeyIDKey, err)
+	}
+	var members map[string]interface{}
+	if err = json.Unmarshal(body, &members); err != nil {
+		return nil, fmt.Errorf("cannot read the members of kid %q %v", r.KeyIDKey, err)
+	}
+	return members, nil
+}
+
+// MarshalJSON writes the standard members followed by the ones of the key
+// decorator, sorted by name
+func (r JkwsResponse) MarshalJSON() ([]byte, error) {
+	type standardMembers JkwsResponse
+	body, err := json.Marshal(standardMembers(r))
+	if err != nil || len(r.extra) == 0 {
+		return body, err
+	}
+
+	names := make([]string, 0, len(r.extra))
+	for name := range r.extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.Write(body[:len(body)-1])
+	for _, name := range names {
+		nameJSON, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(r.extra[name])
+		if err != nil {
+			return nil, fmt.Errorf("cannot serialize member %q %v", name, err)
+		}
+		buf.WriteByte(',')
+		buf.Write(nameJSON)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,57 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestKeyDecorator(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithKeyDecorator(func(kid string, entry map[string]interface{}) {
+		entry["env"] = "prod"
+		entry["region"] = map[string]interface{}{"name": "eu", "zone": 1}
+	}))
+	w := serveJWKS(Jkws(*config))
+	if w.Code != http.StatusOK {
+		t.Fatalf("decorated key set answered %d %s", w.Code, w.Body)
+	}
+	var document struct {
+		Keys []map[string]json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Keys) != 1 {
+		t.Fatalf("got %d keys", len(document.Keys))
+	}
+	key := document.Keys[0]
+	if string(key["env"]) != `"prod"` || string(key["region"]) != `{"name":"eu","zone":1}` || string(key["kid"]) != `"test"` {
+		t.Fatalf("decorated key is %s", w.Body)
+	}
+	// the added members follow the standard ones, sorted
+	body := w.Body.String()
+	if !(strings.Index(body, `"kid"`) < strings.Index(body, `"env"`) && strings.Index(body, `"env"`) < strings.Index(body, `"region"`)) {
+		t.Fatalf("members out of order in %s", body)
+	}
+	// the key still parses as a JWK
+	servedKeySet(t, config)
+}
+
+func TestKeyDecoratorCannotTouchStandardMembers(t *testing.T) {
+	for name, decorate := range map[string]func(string, map[string]interface{}){
+		"changed kid":    func(kid string, entry map[string]interface{}) { entry["kid"] = "other" },
+		"removed n":      func(kid string, entry map[string]interface{}) { delete(entry, "n") },
+		"added d":        func(kid string, entry map[string]interface{}) { entry["d"] = "secret" },
+		"added x5u":      func(kid string, entry map[string]interface{}) { entry["x5u"] = "https://example.com" },
+		"not serialized": func(kid string, entry map[string]interface{}) { entry["ch"] = make(chan int) },
+	} {
+		config := newTestConfig(t, NewConfigBuilder().WithKeyDecorator(decorate))
+		if _, err := config.jwksKeys(); err == nil {
+			t.Fatalf("%s: key set rendered", name)
+		}
+		if w := serveJWKS(Jkws(*config)); w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: key set answered %d", name, w.Code)
+		}
+	}
+}
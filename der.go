@@ -0,0 +1,65 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Set the encoding of the imported key, detected from its first bytes if not set
+func (n *ConfigImportKeyBuilder) WithFormat(format KeyFormat) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.format = format
+	return n
+}
+
+// Guess the encoding of key material, empty when it looks like none
+func detectKeyFormat(data []byte) KeyFormat {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("-----")):
+		return FormatPEM
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return FormatJWK
+	// a DER key is an ASN.1 SEQUENCE, never preceded by whitespace
+	case len(data) > 0 && data[0] == 0x30:
+		return FormatDER
+	}
+	return ""
+}
+
+// Resolve the format to parse data with, the detected one unless format is
+// set. A mismatch is reported with what the data looks like.
+func keyFormat(format KeyFormat, data []byte) (KeyFormat, error) {
+	detected := detectKeyFormat(data)
+	switch format {
+	case "":
+		if detected == "" {
+			return "", fmt.Errorf("expected PEM, DER or JWK")
+		}
+		return detected, nil
+	case FormatPEM, FormatDER, FormatJWK:
+	default:
+		return "", fmt.Errorf("unknown key format %q", format)
+	}
+	if detected != "" && detected != format {
+		return "", fmt.Errorf("expected %s, got %s?",
+			strings.ToUpper(string(format)), strings.ToUpper(string(detected)))
+	}
+	return format, nil
+}
+
+// Parse a PKCS#8, PKCS#1 or SEC 1 DER private key
+func parseDERKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("cannot parse DER private key, expected PKCS#8, PKCS#1 or SEC 1")
+}
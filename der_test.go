@@ -0,0 +1,62 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestImportedDERKeys(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(newECKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, der := range map[string][]byte{
+		"PKCS#8": pkcs8,
+		"PKCS#1": x509.MarshalPKCS1PrivateKey(rsaKey),
+		"SEC 1":  sec1,
+	} {
+		config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeKeyFile(t, der)).WithKeyId("der").Build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer config.Close()
+		checkSignsAndVerifies(t, config)
+	}
+}
+
+func TestKeyFormatMismatch(t *testing.T) {
+	seeds := importSeeds(t)
+	pemKey, der, jwkKey := seeds[0], seeds[4], seeds[5]
+	for _, tt := range []struct {
+		name   string
+		data   []byte
+		format KeyFormat
+		want   string
+	}{
+		{"DER as PEM", der, FormatPEM, "expected PEM, got DER?"},
+		{"PEM as DER", pemKey, FormatDER, "expected DER, got PEM?"},
+		{"JWK as DER", jwkKey, FormatDER, "expected DER, got JWK?"},
+		{"unknown format", pemKey, "pkcs12", `unknown key format "pkcs12"`},
+		{"undetectable", []byte("  \x30 key"), "", "expected PEM, DER or JWK"},
+		{"broken DER", []byte{0x30, 0x82, 0xff, 0xff}, "", "cannot parse DER private key"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeKeyFile(t, tt.data)).WithFormat(tt.format).Build()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeKeyFile(t, der)).WithFormat(FormatDER).Build()
+	if err != nil {
+		t.Fatalf("DER key forced as DER refused: %v", err)
+	}
+	config.Close()
+}
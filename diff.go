@@ -0,0 +1,172 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"sort"
+	"strings"
+)
+
+// Key as seen by the key set diff
+type DiffKey struct {
+	KeyID      string `json:"kid"`
+	Thumbprint string `json:"thumbprint"`
+}
+
+// Key present on both sides under the same kid but with a different material
+type ChangedKey struct {
+	KeyID            string `json:"kid"`
+	LocalThumbprint  string `json:"local_thumbprint"`
+	RemoteThumbprint string `json:"remote_thumbprint"`
+}
+
+// Diff between a local key set and a remote one, keys are compared by their
+// RFC 7638 thumbprint so ordering and member ordering do not matter
+type Diff struct {
+	OnlyLocal  []DiffKey    `json:"only_local"`
+	OnlyRemote []DiffKey    `json:"only_remote"`
+	Changed    []ChangedKey `json:"changed"`
+}
+
+// Empty reports whether both key sets hold the same keys
+func (d Diff) Empty() bool {
+	return len(d.OnlyLocal) == 0 && len(d.OnlyRemote) == 0 && len(d.Changed) == 0
+}
+
+// String returns a human-readable report of the diff
+func (d Diff) String() string {
+	if d.Empty() {
+		return "key sets are identical"
+	}
+
+	var sb strings.Builder
+	for _, k := range d.Changed {
+		fmt.Fprintf(&sb, "~ kid=%q local=%s remote=%s\n", k.KeyID, k.LocalThumbprint, k.RemoteThumbprint)
+	}
+	for _, k := range d.OnlyLocal {
+		fmt.Fprintf(&sb, "- kid=%q thumbprint=%s (only local)\n", k.KeyID, k.Thumbprint)
+	}
+	for _, k := range d.OnlyRemote {
+		fmt.Fprintf(&sb, "+ kid=%q thumbprint=%s (only remote)\n", k.KeyID, k.Thumbprint)
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// DiffKeySets fetches the key set published at remoteURL and compares it with local
+func DiffKeySets(local jwk.Set, remoteURL string) (Diff, error) {
+	remote, err := jwk.Fetch(context.Background(), remoteURL)
+	if err != nil {
+		return Diff{}, fmt.Errorf("cannot fetch remote key set %v", err)
+	}
+
+	return diffSets(local, remote)
+}
+
+// DiffAgainst compares the key set served by the config with the one published at url
+func (c *Config) DiffAgainst(url string) (Diff, error) {
+	local, err := c.PublicJWKS()
+	if err != nil {
+		return Diff{}, err
+	}
+
+	return DiffKeySets(local, url)
+}
+
+// Compare two key sets by thumbprint
+func diffSets(local jwk.Set, remote jwk.Set) (Diff, error) {
+	localKeys, err := diffKeysOf(local)
+	if err != nil {
+		return Diff{}, fmt.Errorf("cannot read local key set %v", err)
+	}
+	remoteKeys, err := diffKeysOf(remote)
+	if err != nil {
+		return Diff{}, fmt.Errorf("cannot read remote key set %v", err)
+	}
+
+	// drop the keys which are present on both sides
+	remoteThumbprints := map[string]int{}
+	for _, k := range remoteKeys {
+		remoteThumbprints[k.Thumbprint]++
+	}
+	var unmatchedLocal []DiffKey
+	for _, k := range localKeys {
+		if remoteThumbprints[k.Thumbprint] > 0 {
+			remoteThumbprints[k.Thumbprint]--
+			continue
+		}
+		unmatchedLocal = append(unmatchedLocal, k)
+	}
+	var unmatchedRemote []DiffKey
+	for _, k := range remoteKeys {
+		if remoteThumbprints[k.Thumbprint] > 0 {
+			remoteThumbprints[k.Thumbprint]--
+			unmatchedRemote = append(unmatchedRemote, k)
+		}
+	}
+
+	// same kid on both sides with a different material
+	var diff Diff
+	remoteByKid := map[string]int{}
+	for i, k := range unmatchedRemote {
+		if k.KeyID != "" {
+			remoteByKid[k.KeyID] = i
+		}
+	}
+	changedRemote := map[int]bool{}
+	for _, k := range unmatchedLocal {
+		if i, ok := remoteByKid[k.KeyID]; ok && !changedRemote[i] {
+			changedRemote[i] = true
+			diff.Changed = append(diff.Changed, ChangedKey{
+				KeyID:            k.KeyID,
+				LocalThumbprint:  k.Thumbprint,
+				RemoteThumbprint: unmatchedRemote[i].Thumbprint,
+			})
+			continue
+		}
+		diff.OnlyLocal = append(diff.OnlyLocal, k)
+	}
+	for i, k := range unmatchedRemote {
+		if !changedRemote[i] {
+			diff.OnlyRemote = append(diff.OnlyRemote, k)
+		}
+	}
+
+	sortDiffKeys(diff.OnlyLocal)
+	sortDiffKeys(diff.OnlyRemote)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].KeyID < diff.Changed[j].KeyID
+	})
+
+	return diff, nil
+}
+
+// Thumbprint every key of the set
+func diffKeysOf(set jwk.Set) ([]DiffKey, error) {
+	res := make([]DiffKey, 0, set.Len())
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		pubKey, err := key.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get public key of kid %q %v", key.KeyID(), err)
+		}
+		thumbprint, err := pubKey.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compute thumbprint of kid %q %v", key.KeyID(), err)
+		}
+		res = append(res, DiffKey{KeyID: key.KeyID(), Thumbprint: EncodeToString(thumbprint)})
+	}
+
+	return res, nil
+}
+
+func sortDiffKeys(keys []DiffKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].KeyID != keys[j].KeyID {
+			return keys[i].KeyID < keys[j].KeyID
+		}
+		return keys[i].Thumbprint < keys[j].Thumbprint
+	})
+}
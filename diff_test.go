@@ -0,0 +1,97 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Server publishing the key set of config
+func newKeySetServer(t *testing.T, config *Config) *httptest.Server {
+	t.Helper()
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newDiffConfig(t *testing.T, kid string) *Config {
+	t.Helper()
+	config, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).WithKeyId(kid).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestDiffAgainst(t *testing.T) {
+	config := newDiffConfig(t, "test")
+
+	diff, err := config.DiffAgainst(newKeySetServer(t, config).URL + "/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() || diff.String() != "key sets are identical" {
+		t.Fatalf("a key set differs from itself: %s", diff)
+	}
+
+	// another key under the same kid
+	remote := newDiffConfig(t, "test")
+	diff, err = config.DiffAgainst(newKeySetServer(t, remote).URL + "/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].KeyID != "test" || diff.Changed[0].LocalThumbprint == diff.Changed[0].RemoteThumbprint {
+		t.Fatalf("changed keys %v", diff.Changed)
+	}
+	if len(diff.OnlyRemote) != 0 || len(diff.OnlyLocal) != 0 {
+		t.Fatalf("diff %+v", diff)
+	}
+	if report := diff.String(); !strings.Contains(report, `~ kid="test"`) {
+		t.Fatalf("report %s", report)
+	}
+
+	if _, err = config.DiffAgainst(newKeySetServer(t, config).URL + "/missing"); err == nil {
+		t.Fatal("diffed against a missing key set")
+	}
+}
+
+func TestDiffKeySets(t *testing.T) {
+	config := newDiffConfig(t, "test")
+	next := newDiffConfig(t, "next")
+	local, err := config.PublicJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextSet, err := next.PublicJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextKey, _ := nextSet.Key(0)
+	if err = local.AddKey(nextKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// a key only published locally
+	diff, err := DiffKeySets(local, newKeySetServer(t, config).URL+"/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.OnlyLocal) != 1 || diff.OnlyLocal[0].KeyID != "next" || len(diff.OnlyRemote) != 0 || len(diff.Changed) != 0 {
+		t.Fatalf("diff %+v", diff)
+	}
+
+	// and one only published remotely
+	diff, err = DiffKeySets(nextSet, newKeySetServer(t, config).URL+"/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.OnlyRemote) != 1 || diff.OnlyRemote[0].KeyID != "test" || len(diff.OnlyLocal) != 1 {
+		t.Fatalf("diff %+v", diff)
+	}
+	if report := diff.String(); !strings.Contains(report, `+ kid="test"`) || !strings.Contains(report, `- kid="next"`) {
+		t.Fatalf("report %s", report)
+	}
+}
@@ -0,0 +1,186 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RFC 9449 error code of a rejected proof
+	OAuthErrorInvalidDPoPProof = "invalid_dpop_proof"
+	// typ header of a DPoP proof
+	DPoPProofType = "dpop+jwt"
+	// How long a proof is accepted after its iat when no window is set
+	DefaultDPoPProofWindow = time.Minute
+)
+
+// ErrDPoPReplay is the cause of the error rejecting a proof whose jti was
+// already accepted
+var ErrDPoPReplay = errors.New("dpop proof already used")
+
+// Everything needed to validate the RFC 9449 DPoP proofs of a request
+type DPoPOptions struct {
+	// remembers the jti of the accepted proofs, required
+	ReplayCache ReplayCache
+	// a proof is accepted for Window after its iat, DefaultDPoPProofWindow if zero
+	Window         time.Duration
+	AcceptableSkew time.Duration
+	// DefaultTokenLimits if nil
+	Limits *TokenLimits
+}
+
+// What a valid proof tells about the request
+type DPoPProof struct {
+	// public key the proof is signed with
+	Key jwk.Key
+	// RFC 7638 thumbprint of Key, to match against the cnf.jkt of the access token
+	JWKThumbprint string
+	JwtID         string
+	IssuedAt      time.Time
+}
+
+// Asymmetric algorithms a proof may be signed with
+var dpopAlgorithms = map[jwa.SignatureAlgorithm]bool{
+	jwa.RS256: true, jwa.RS384: true, jwa.RS512: true,
+	jwa.PS256: true, jwa.PS384: true, jwa.PS512: true,
+	jwa.ES256: true, jwa.ES384: true, jwa.ES512: true,
+	jwa.EdDSA: true,
+}
+
+// Replay cache entry of a proof: its jti is only unique for its key, the
+// length prefix keeps two pairs from making the same key
+func dpopReplayKey(thumbprint, jti string) string {
+	return "dpop:" + strconv.Itoa(len(thumbprint)) + ":" + thumbprint + jti
+}
+
+// VerifyDPoPProof validates the DPoP header of a request made with method to
+// htu: a dpop+jwt signed by the public key it carries, for this method and
+// URL, issued within the window and never seen before. accessToken is the
+// token the proof goes with, its ath claim is then required. A proof seen
+// before is rejected with an error wrapping ErrDPoPReplay, and a failing
+// replay cache rejects the proof. Errors are *OAuthError.
+func VerifyDPoPProof(ctx context.Context, proof, method, htu, accessToken string, opts DPoPOptions) (DPoPProof, error) {
+	if proof == "" {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "missing DPoP proof"}
+	}
+	if opts.ReplayCache == nil {
+		return DPoPProof{}, fmt.Errorf("DPoP proof validation needs a replay cache")
+	}
+	limits := DefaultTokenLimits
+	if opts.Limits != nil {
+		limits = *opts.Limits
+	}
+	if err := checkTokenLimits(proof, limits); err != nil {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof exceeds parsing limits"}
+	}
+	if err := checkKeyURLHeaders(proof, KeyURLHeadersReject); err != nil {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof cannot carry jku or x5u"}
+	}
+
+	msg, err := jws.Parse([]byte(proof))
+	if err != nil || len(msg.Signatures()) != 1 {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "malformed DPoP proof"}
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	if headers.Type() != DPoPProofType {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof typ must be " + DPoPProofType}
+	}
+	alg := headers.Algorithm()
+	if !dpopAlgorithms[alg] {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof must be signed with an asymmetric algorithm"}
+	}
+	key := headers.JWK()
+	if key == nil || isPrivateJWK(key) {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof must carry a public jwk"}
+	}
+
+	token, err := jwt.Parse([]byte(proof), jwt.WithKey(alg, key), jwt.WithValidate(false))
+	if err != nil {
+		// the parse error may echo claims, it only goes to the logs
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "invalid DPoP proof", cause: err}
+	}
+	if token.JwtID() == "" {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof has no jti"}
+	}
+	if claimString(token, "htm") != method {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof htm does not match the request"}
+	}
+	if !sameDPoPURI(claimString(token, "htu"), htu) {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof htu does not match the request"}
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claimString(token, "ath") != EncodeToString(sum[:]) {
+			return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof ath does not match the access token"}
+		}
+	}
+
+	window := opts.Window
+	if window <= 0 {
+		window = DefaultDPoPProofWindow
+	}
+	issuedAt := token.IssuedAt()
+	now := time.Now()
+	if issuedAt.IsZero() || issuedAt.After(now.Add(opts.AcceptableSkew)) ||
+		!now.Before(issuedAt.Add(window+opts.AcceptableSkew)) {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof is not fresh"}
+	}
+
+	thumbprint, err := thumbprintKeyId(key)
+	if err != nil {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "invalid DPoP proof key", cause: err}
+	}
+	// single use until it is stale anyway, a failing cache rejects the proof
+	seen, err := opts.ReplayCache.Seen(ctx, dpopReplayKey(thumbprint, token.JwtID()), issuedAt.Add(window+opts.AcceptableSkew))
+	if err != nil {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "cannot check DPoP proof replay", cause: err}
+	}
+	if seen {
+		return DPoPProof{}, &OAuthError{Code: OAuthErrorInvalidDPoPProof, Description: "DPoP proof already used", cause: ErrDPoPReplay}
+	}
+
+	return DPoPProof{Key: key, JWKThumbprint: thumbprint, JwtID: token.JwtID(), IssuedAt: issuedAt}, nil
+}
+
+// String claim of token, empty when missing or of another type
+func claimString(token jwt.Token, name string) string {
+	v, ok := token.Get(name)
+	if !ok {
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// Whether a key holds private or secret material
+func isPrivateJWK(key jwk.Key) bool {
+	switch key.(type) {
+	case jwk.RSAPrivateKey, jwk.ECDSAPrivateKey, jwk.OKPPrivateKey, jwk.SymmetricKey:
+		return true
+	}
+	return false
+}
+
+// RFC 9449 htu comparison: query and fragment are ignored, scheme and host
+// are case insensitive
+func sameDPoPURI(claimed, requested string) bool {
+	a, err := url.Parse(claimed)
+	if err != nil || claimed == "" {
+		return false
+	}
+	b, err := url.Parse(requested)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
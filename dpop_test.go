@@ -0,0 +1,185 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testDPoPURI = "https://api.example.com/resource"
+
+// Claims and headers of a proof, changed by the test cases
+type dpopProofSpec struct {
+	claims    map[string]interface{}
+	typ       string
+	alg       jwa.SignatureAlgorithm
+	secret    []byte
+	publicJWK bool
+}
+
+// Proof for a GET of testDPoPURI signed by key, after edit changed its spec
+func dpopProof(t *testing.T, key *ecdsa.PrivateKey, edit func(*dpopProofSpec)) string {
+	t.Helper()
+	spec := dpopProofSpec{
+		claims: map[string]interface{}{
+			"jti": "proof-1",
+			"htm": "GET",
+			"htu": testDPoPURI,
+			"iat": time.Now(),
+		},
+		typ:       DPoPProofType,
+		alg:       jwa.ES256,
+		publicJWK: true,
+	}
+	if edit != nil {
+		edit(&spec)
+	}
+
+	private, err := jwk.FromRaw(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	carried := private
+	if spec.publicJWK {
+		if carried, err = private.PublicKey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	headers := jws.NewHeaders()
+	if err = headers.Set(jws.TypeKey, spec.typ); err != nil {
+		t.Fatal(err)
+	}
+	if err = headers.Set(jws.JWKKey, carried); err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.New()
+	for name, value := range spec.claims {
+		if err = token.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var signingKey interface{} = private
+	if spec.secret != nil {
+		signingKey = spec.secret
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(spec.alg, signingKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	key := newECKey(t)
+	opts := DPoPOptions{ReplayCache: NewMemoryReplayCache(100)}
+	accessToken := "access-token"
+	athSum := sha256.Sum256([]byte(accessToken))
+
+	proof, err := VerifyDPoPProof(context.Background(), dpopProof(t, key, func(s *dpopProofSpec) {
+		s.claims["ath"] = EncodeToString(athSum[:])
+	}), "GET", testDPoPURI+"?page=2", accessToken, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _ := jwk.FromRaw(key.Public())
+	if thumbprint, _ := thumbprintKeyId(public); proof.JWKThumbprint != thumbprint || proof.JwtID != "proof-1" {
+		t.Fatalf("proof validated as %+v", proof)
+	}
+
+	for name, tt := range map[string]struct {
+		edit        func(*dpopProofSpec)
+		accessToken string
+	}{
+		"other method": {func(s *dpopProofSpec) { s.claims["htm"] = "POST" }, accessToken},
+		"other URI":    {func(s *dpopProofSpec) { s.claims["htu"] = "https://api.example.com/other" }, accessToken},
+		"stale":        {func(s *dpopProofSpec) { s.claims["iat"] = time.Now().Add(-2 * DefaultDPoPProofWindow) }, accessToken},
+		"issued later": {func(s *dpopProofSpec) { s.claims["iat"] = time.Now().Add(time.Hour) }, accessToken},
+		"no iat":       {func(s *dpopProofSpec) { delete(s.claims, "iat") }, accessToken},
+		"no jti":       {func(s *dpopProofSpec) { delete(s.claims, "jti") }, accessToken},
+		"not a proof":  {func(s *dpopProofSpec) { s.typ = "JWT" }, accessToken},
+		"private jwk":  {func(s *dpopProofSpec) { s.publicJWK = false }, accessToken},
+		"symmetric":    {func(s *dpopProofSpec) { s.alg, s.secret = jwa.HS256, []byte("0123456789abcdef0123456789abcdef") }, accessToken},
+		"wrong ath":    {func(s *dpopProofSpec) { s.claims["ath"] = "AAAA" }, accessToken},
+		"other token":  {func(s *dpopProofSpec) {}, "another-token"},
+	} {
+		_, err := VerifyDPoPProof(context.Background(), dpopProof(t, key, func(s *dpopProofSpec) {
+			s.claims["jti"] = name
+			s.claims["ath"] = EncodeToString(athSum[:])
+			tt.edit(s)
+		}), "GET", testDPoPURI, tt.accessToken, opts)
+		var oauthErr *OAuthError
+		if !errors.As(err, &oauthErr) || oauthErr.Code != OAuthErrorInvalidDPoPProof {
+			t.Fatalf("%s: validated with %v", name, err)
+		}
+	}
+}
+
+func TestDPoPProofReplay(t *testing.T) {
+	key := newECKey(t)
+	proof := dpopProof(t, key, nil)
+	opts := DPoPOptions{ReplayCache: NewMemoryReplayCache(100)}
+	if _, err := VerifyDPoPProof(context.Background(), proof, "GET", testDPoPURI, "", opts); err != nil {
+		t.Fatal(err)
+	}
+	_, err := VerifyDPoPProof(context.Background(), proof, "GET", testDPoPURI, "", opts)
+	if !errors.Is(err, ErrDPoPReplay) {
+		t.Fatalf("replayed proof validated with %v", err)
+	}
+
+	// a proof of another key may reuse the jti
+	if _, err = VerifyDPoPProof(context.Background(), dpopProof(t, newECKey(t), nil), "GET", testDPoPURI, "", opts); err != nil {
+		t.Fatalf("jti of another key refused: %v", err)
+	}
+}
+
+func TestDPoPProofReplayCacheFailsClosed(t *testing.T) {
+	opts := DPoPOptions{ReplayCache: failingReplayCache{}}
+	_, err := VerifyDPoPProof(context.Background(), dpopProof(t, newECKey(t), nil), "GET", testDPoPURI, "", opts)
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || errors.Is(err, ErrDPoPReplay) {
+		t.Fatalf("proof validated with a failing replay cache: %v", err)
+	}
+}
+
+type failingReplayCache struct{}
+
+func (failingReplayCache) Seen(context.Context, string, time.Time) (bool, error) {
+	return false, errors.New("cache unreachable")
+}
+
+func TestDPoPProofIsAcceptedOnceAcrossGoroutines(t *testing.T) {
+	proof := dpopProof(t, newECKey(t), nil)
+	opts := DPoPOptions{ReplayCache: NewMemoryReplayCache(100)}
+
+	var accepted, replayed int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := VerifyDPoPProof(context.Background(), proof, "GET", testDPoPURI, "", opts)
+			switch {
+			case err == nil:
+				atomic.AddInt32(&accepted, 1)
+			case errors.Is(err, ErrDPoPReplay):
+				atomic.AddInt32(&replayed, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if accepted != 1 || replayed != 63 {
+		t.Fatalf("%d goroutines accepted the proof and %d saw a replay", accepted, replayed)
+	}
+}
@@ -0,0 +1,74 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// Signature algorithm of each supported EC curve
+var ecSignatureAlgorithms = map[jwa.EllipticCurveAlgorithm]jwa.SignatureAlgorithm{
+	jwa.P256: jwa.ES256,
+	jwa.P384: jwa.ES384,
+	jwa.P521: jwa.ES512,
+}
+
+// Curve named by curve, P-256 if no curve is given
+func ellipticCurve(curve jwa.EllipticCurveAlgorithm) (elliptic.Curve, error) {
+	switch curve {
+	case "", jwa.P256:
+		return elliptic.P256(), nil
+	case jwa.P384:
+		return elliptic.P384(), nil
+	case jwa.P521:
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported EC curve %q", curve)
+}
+
+// Generate an EC private key, on P-256 if no curve is given
+func generateECKey(curve jwa.EllipticCurveAlgorithm) (jwk.Key, error) {
+	namedCurve, err := ellipticCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	rawPrivateKey, err := ecdsa.GenerateKey(namedCurve, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new EC private key: %v", err)
+	}
+
+	key, err := jwk.FromRaw(rawPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key: %v", err)
+	}
+
+	return key, nil
+}
+
+// Curve of an EC key, private or public
+func ecCurve(key jwk.Key) (jwa.EllipticCurveAlgorithm, bool) {
+	switch ecKey := key.(type) {
+	case jwk.ECDSAPrivateKey:
+		return ecKey.Crv(), true
+	case jwk.ECDSAPublicKey:
+		return ecKey.Crv(), true
+	}
+	return "", false
+}
+
+// The algorithm of an EC key follows from its curve, an alg carried by the
+// key must agree with it
+func ecSignatureAlgorithm(key jwk.Key, curve jwa.EllipticCurveAlgorithm) (jwa.SignatureAlgorithm, error) {
+	alg, ok := ecSignatureAlgorithms[curve]
+	if !ok {
+		return "", fmt.Errorf("unsupported EC curve %q", curve)
+	}
+	if keyAlg := key.Algorithm(); keyAlg != nil && keyAlg.String() != "" && keyAlg.String() != alg.String() {
+		return "", fmt.Errorf("algorithm %q does not match curve %s", keyAlg, curve)
+	}
+	return alg, nil
+}
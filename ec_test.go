@@ -0,0 +1,123 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"testing"
+)
+
+func newECKey(t testing.TB) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Members of the only key served by config
+func servedKeyMembers(t *testing.T, config *Config) map[string]interface{} {
+	t.Helper()
+	w := serveJWKS(Jkws(*config))
+	var document struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil || len(document.Keys) != 1 {
+		t.Fatalf("served %d %s", w.Code, w.Body)
+	}
+	return document.Keys[0]
+}
+
+// The key set of config validates the tokens it signs
+func checkSignsAndVerifies(t *testing.T, config *Config) {
+	t.Helper()
+	signed, err := config.signToken(jwt.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jwt.Parse(signed, jwt.WithKeySet(servedKeySet(t, config))); err != nil {
+		t.Fatalf("token does not verify against the served key set: %v", err)
+	}
+}
+
+func TestGeneratedECKeys(t *testing.T) {
+	for curve, alg := range map[jwa.EllipticCurveAlgorithm]string{
+		jwa.P256: "ES256",
+		jwa.P384: "ES384",
+		jwa.P521: "ES512",
+	} {
+		config, err := NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.EC).WithCurve(curve).WithKeyId("ec").Build()
+		if err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		defer config.Close()
+
+		members := servedKeyMembers(t, config)
+		if members["kty"] != "EC" || members["crv"] != curve.String() || members["alg"] != alg {
+			t.Fatalf("%s: served %v", curve, members)
+		}
+		for _, name := range []string{"x", "y"} {
+			if members[name] == nil || members[name] == "" {
+				t.Fatalf("%s: %s is missing from %v", curve, name, members)
+			}
+		}
+		for _, name := range []string{"e", "n", "d"} {
+			if _, ok := members[name]; ok {
+				t.Fatalf("%s: %s is served", curve, name)
+			}
+		}
+		checkSignsAndVerifies(t, config)
+	}
+}
+
+func TestImportedECKeys(t *testing.T) {
+	key := newECKey(t)
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, path := range map[string]string{
+		"PKCS#8": writeTestKey(t, key),
+		"SEC 1":  writeKeyFile(t, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})),
+	} {
+		config, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).WithKeyId("ec").Build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer config.Close()
+		if members := servedKeyMembers(t, config); members["alg"] != "ES256" || members["crv"] != "P-256" {
+			t.Fatalf("%s: served %v", name, members)
+		}
+		checkSignsAndVerifies(t, config)
+	}
+}
+
+func TestECKeyAlgMustMatchTheCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwkKey, err := jwk.FromRaw(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jwkKey.Set(jwk.AlgorithmKey, jwa.ES256); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(jwkKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeKeyFile(t, data)).Build()
+	if err == nil {
+		config.Close()
+		t.Fatal("a P-384 key carrying ES256 was imported")
+	}
+}
@@ -0,0 +1,42 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// Generate an Ed25519 private key
+func generateEd25519Key() (jwk.Key, error) {
+	_, rawPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new Ed25519 private key: %v", err)
+	}
+
+	key, err := jwk.FromRaw(rawPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key: %v", err)
+	}
+
+	return key, nil
+}
+
+func isEd25519PrivateKey(key jwk.Key) bool {
+	okpKey, ok := key.(jwk.OKPPrivateKey)
+	return ok && okpKey.Crv() == jwa.Ed25519
+}
+
+func isEd25519PublicKey(key jwk.Key) bool {
+	okpKey, ok := key.(jwk.OKPPublicKey)
+	return ok && okpKey.Crv() == jwa.Ed25519
+}
+
+// Ed25519 keys only sign with EdDSA, RFC 8037
+func ed25519SignatureAlgorithm(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	if keyAlg := key.Algorithm(); keyAlg != nil && keyAlg.String() != "" && keyAlg.String() != jwa.EdDSA.String() {
+		return "", fmt.Errorf("algorithm %q does not match curve Ed25519", keyAlg)
+	}
+	return jwa.EdDSA, nil
+}
@@ -0,0 +1,58 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"testing"
+)
+
+// RFC 8037 appendix A.1
+const (
+	rfc8037Seed = "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"
+	rfc8037X    = "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+)
+
+func checkEd25519Members(t *testing.T, members map[string]interface{}) {
+	t.Helper()
+	if members["kty"] != "OKP" || members["crv"] != "Ed25519" || members["alg"] != "EdDSA" || members["use"] != "sig" {
+		t.Fatalf("served %v", members)
+	}
+	if x, _ := members["x"].(string); len(x) != 43 {
+		t.Fatalf("x %v is not a base64url encoded 32 bytes key", members["x"])
+	}
+	for _, name := range []string{"d", "e", "n", "y"} {
+		if _, ok := members[name]; ok {
+			t.Fatalf("%s is served", name)
+		}
+	}
+}
+
+func TestGeneratedEd25519Key(t *testing.T) {
+	config, err := NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.OKP).WithCurve(jwa.Ed25519).WithKeyId("ed").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	checkEd25519Members(t, servedKeyMembers(t, config))
+	checkSignsAndVerifies(t, config)
+}
+
+func TestImportedEd25519KeyMatchesRFC8037(t *testing.T) {
+	seed, err := base64.RawURLEncoding.DecodeString(rfc8037Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeTestKey(t, ed25519.NewKeyFromSeed(seed))).WithKeyId("ed").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	members := servedKeyMembers(t, config)
+	checkEd25519Members(t, members)
+	if members["x"] != rfc8037X {
+		t.Fatalf("x %v, want %s", members["x"], rfc8037X)
+	}
+	checkSignsAndVerifies(t, config)
+}
@@ -0,0 +1,205 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"time"
+)
+
+const KeyUsageAsEncryption = "enc"
+
+// Generate a dedicated RSA-OAEP-256 encryption key next to the signing key
+func (n *ConfigBuilder) WithEncryptionKey(bits int) *ConfigBuilder {
+	n.config.encKeyBits = bits
+	return n
+}
+
+// Publish the encryption key in the key set next to the signing key
+func (n *ConfigBuilder) WithPublishedEncryptionKey() *ConfigBuilder {
+	n.config.publishEncKey = true
+	return n
+}
+
+// Encryption key of WithEncryptionKey. It is never modified once set, like
+// activeKey.
+type encryptionKey struct {
+	key       jwk.Key
+	createdAt time.Time
+	// end of the grace period once replaced
+	until time.Time
+}
+
+// RotateEncryptionKey replaces the key of WithEncryptionKey with a new one of
+// the same size, independently of the signing key. The previous key is no
+// longer published but keeps decrypting for grace, since partners may still
+// encrypt to the key they fetched. Config copies and handlers see the new key
+// as soon as it returns.
+func (c *Config) RotateEncryptionKey(grace time.Duration) error {
+	if grace < 0 {
+		return fmt.Errorf("grace period cannot be negative")
+	}
+	if c.encryptionKey() == nil {
+		return fmt.Errorf("no encryption key configured")
+	}
+	c.keys.rotating.Lock()
+	defer c.keys.rotating.Unlock()
+
+	key, err := generateEncryptionKey(c.encKeyBits)
+	if err != nil {
+		return fmt.Errorf("cannot generate encryption key %v", err)
+	}
+	now := time.Now()
+
+	c.keys.mu.Lock()
+	previous := *c.keys.encryption
+	retired := make([]*encryptionKey, 0, len(c.keys.retiredEncryption)+1)
+	for _, r := range c.keys.retiredEncryption {
+		if now.Before(r.until) {
+			retired = append(retired, r)
+		}
+	}
+	if grace > 0 {
+		previous.until = now.Add(grace)
+		retired = append(retired, &previous)
+	}
+	c.keys.encryption = &encryptionKey{key: key, createdAt: now}
+	c.keys.retiredEncryption = retired
+	c.keys.mu.Unlock()
+
+	if c.publishEncKey {
+		c.keySetChanged()
+	}
+	c.audit(AuditEvent{Action: AuditKeyRotated, KeyID: key.KeyID(), Trigger: AuditTriggerAPI})
+	return nil
+}
+
+// Current encryption key, nil when there is none
+func (c *Config) encryptionKey() *encryptionKey {
+	if c.keys == nil {
+		return nil
+	}
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	return c.keys.encryption
+}
+
+// Keys Decrypt accepts: the current encryption key and the replaced ones still
+// in their grace period
+func (c *Config) decryptionKeys() []jwk.Key {
+	if c.keys == nil {
+		return nil
+	}
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	if c.keys.encryption == nil {
+		return nil
+	}
+	keys := []jwk.Key{c.keys.encryption.key}
+	now := time.Now()
+	for _, retired := range c.keys.retiredEncryption {
+		if now.Before(retired.until) {
+			keys = append(keys, retired.key)
+		}
+	}
+	return keys
+}
+
+// Use of key given the requested one, RFC 7517 defines sig and enc. RSA and
+// EC keys do both, X25519 keys only encrypt and Ed25519 keys only sign.
+func keyUsage(key jwk.Key, usage, requested string) (string, error) {
+	switch requested {
+	case "", usage:
+		return usage, nil
+	case KeyUsageAsSignature, KeyUsageAsEncryption:
+	default:
+		return "", fmt.Errorf("unknown key use %q, expected %q or %q", requested, KeyUsageAsSignature, KeyUsageAsEncryption)
+	}
+
+	_, isRSA := key.(jwk.RSAPrivateKey)
+	_, isEC := key.(jwk.ECDSAPrivateKey)
+	if requested == KeyUsageAsEncryption && (isRSA || isEC) {
+		return requested, nil
+	}
+	return "", fmt.Errorf("a %s key cannot be used for %q", keyDescription(key), requested)
+}
+
+// Key management algorithms a JWE may use with an encryption key, the one it
+// carries if any
+func keyEncryptionAlgorithms(key jwk.Key) []jwa.KeyEncryptionAlgorithm {
+	if alg := key.Algorithm(); alg != nil && alg.String() != "" {
+		return []jwa.KeyEncryptionAlgorithm{jwa.KeyEncryptionAlgorithm(alg.String())}
+	}
+	if key.KeyType() == jwa.RSA {
+		return []jwa.KeyEncryptionAlgorithm{jwa.RSA_OAEP_256, jwa.RSA_OAEP}
+	}
+	return x25519KeyEncryptionAlgorithms
+}
+
+// Generate an encryption key, its kid is the RFC 7638 thumbprint
+func generateEncryptionKey(bits int) (jwk.Key, error) {
+	rawPrivateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new RSA private key: %v", err)
+	}
+
+	key, err := jwk.FromRaw(rawPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key: %v", err)
+	}
+
+	if err = key.Set(jwk.KeyUsageKey, KeyUsageAsEncryption); err != nil {
+		return nil, fmt.Errorf("cannot add a usage property to the encryption key %v", err)
+	}
+	if err = key.Set(jwk.AlgorithmKey, jwa.RSA_OAEP_256); err != nil {
+		return nil, fmt.Errorf("cannot add an algorithm property to the encryption key %v", err)
+	}
+	if err = jwk.AssignKeyID(key); err != nil {
+		return nil, fmt.Errorf("cannot add an id property to the encryption key %v", err)
+	}
+
+	return key, nil
+}
+
+// Decrypt a JWE encrypted to one of the encryption keys: the RSA-OAEP-256 key
+// or one it replaced during its grace period, or a signing key configured for
+// enc, with the algorithm it carries or else RSA-OAEP-256 or RSA-OAEP for an
+// RSA key and any of the ECDH-ES algorithms for an EC or X25519 key
+func (c *Config) Decrypt(payload []byte) ([]byte, error) {
+	var opts []jwe.DecryptOption
+	for _, key := range c.decryptionKeys() {
+		opts = append(opts, jwe.WithKey(jwa.RSA_OAEP_256, key))
+	}
+
+	var plaintext []byte
+	decrypt := func() (err error) {
+		if len(opts) == 0 {
+			return fmt.Errorf("no encryption key configured")
+		}
+		plaintext, err = jwe.Decrypt(payload, opts...)
+		if err != nil {
+			return fmt.Errorf("cannot decrypt payload %v", err)
+		}
+		return nil
+	}
+
+	var err error
+	if active := c.active(); active != nil && active.key.KeyUsage() == KeyUsageAsEncryption {
+		err = active.withPrivateKey(func(key jwk.Key) error {
+			for _, alg := range keyEncryptionAlgorithms(key) {
+				opts = append(opts, jwe.WithKey(alg, key))
+			}
+			return decrypt()
+		})
+	} else {
+		err = decrypt()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,97 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"testing"
+	"time"
+)
+
+// Encrypt payload to the encryption key the way a partner does, from the
+// published key set
+func encryptToPublishedKey(t *testing.T, config *Config, payload string) []byte {
+	t.Helper()
+	set, err := config.PublicJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if key.KeyUsage() != KeyUsageAsEncryption {
+			continue
+		}
+		encrypted, err := jwe.Encrypt([]byte(payload), jwe.WithKey(jwa.RSA_OAEP_256, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encrypted
+	}
+	t.Fatal("no encryption key published")
+	return nil
+}
+
+func TestRotateEncryptionKeyKeepsDecryptingDuringTheGrace(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithEncryptionKey(2048).WithPublishedEncryptionKey())
+	signingKid := config.active().key.KeyID()
+	before := encryptToPublishedKey(t, config, "before")
+
+	// a copy taken before the rotation, as a handler holds one
+	copied := *config
+	if err := config.RotateEncryptionKey(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if config.active().key.KeyID() != signingKid {
+		t.Fatal("the encryption key rotation replaced the signing key")
+	}
+	after := encryptToPublishedKey(t, &copied, "after")
+
+	for payload, encrypted := range map[string][]byte{"before": before, "after": after} {
+		plaintext, err := copied.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("cannot decrypt the payload encrypted %s the rotation: %v", payload, err)
+		}
+		if string(plaintext) != payload {
+			t.Fatalf("decrypted %q, expected %q", plaintext, payload)
+		}
+	}
+
+	set, err := copied.PublicJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encKeys := 0
+	for i := 0; i < set.Len(); i++ {
+		if key, _ := set.Key(i); key.KeyUsage() == KeyUsageAsEncryption {
+			encKeys++
+		}
+	}
+	if encKeys != 1 {
+		t.Fatalf("expected only the new encryption key to be published, got %d", encKeys)
+	}
+}
+
+func TestRotateEncryptionKeyWithoutGrace(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithEncryptionKey(2048))
+	previous := config.encryptionKey().key
+	publicKey, err := jwk.PublicKeyOf(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := jwe.Encrypt([]byte("before"), jwe.WithKey(jwa.RSA_OAEP_256, publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.RotateEncryptionKey(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = config.Decrypt(encrypted); err == nil {
+		t.Fatal("the replaced encryption key still decrypts without a grace period")
+	}
+}
+
+func TestRotateEncryptionKeyWithoutOne(t *testing.T) {
+	if err := newTestConfig(t, NewConfigBuilder()).RotateEncryptionKey(time.Hour); err == nil {
+		t.Fatal("rotated an encryption key which was not configured")
+	}
+}
@@ -0,0 +1,110 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// Only serve the key set to the requests authorize accepts. Public keys are
+// meant to be public, this is for deployments whose policy says otherwise.
+// A rejected request gets a 401 without any key material, unless authorize
+// aborted it with its own status, e.g. a 403. Combined with the guards below,
+// a request must pass all of them.
+func (n *ConfigBuilder) WithEndpointAuthorization(authorize func(*gin.Context) bool) *ConfigBuilder {
+	n.config.addEndpointAuthorization(authorize)
+	return n
+}
+
+// Only serve the key set to the requests bearing token in their Authorization
+// header. A request without a token gets a 401, one with another token a 403.
+func (n *ConfigBuilder) WithBearerGuard(token string) *ConfigBuilder {
+	if token == "" {
+		n.config.endpointGuardErr = fmt.Errorf("bearer guard token cannot be empty")
+		return n
+	}
+	n.config.addEndpointAuthorization(func(c *gin.Context) bool {
+		got, ok := bearerToken(c.Request)
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			return false
+		}
+		if !secretEquals(got, token) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatus(http.StatusForbidden)
+			return false
+		}
+		return true
+	})
+	return n
+}
+
+// Only serve the key set to the requests whose header name holds value. A
+// request without the header gets a 401, one with another value a 403.
+func (n *ConfigBuilder) WithAPIKeyHeader(name, value string) *ConfigBuilder {
+	if strings.TrimSpace(name) == "" || value == "" {
+		n.config.endpointGuardErr = fmt.Errorf("API key header name and value cannot be empty")
+		return n
+	}
+	n.config.addEndpointAuthorization(func(c *gin.Context) bool {
+		got := c.GetHeader(name)
+		if got == "" {
+			return false
+		}
+		if !secretEquals(got, value) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return false
+		}
+		return true
+	})
+	return n
+}
+
+// Every authorization of the config must accept a request
+func (c *Config) addEndpointAuthorization(authorize func(*gin.Context) bool) {
+	if authorize == nil {
+		return
+	}
+	previous := c.endpointAuthorization
+	if previous == nil {
+		c.endpointAuthorization = authorize
+		return
+	}
+	c.endpointAuthorization = func(ctx *gin.Context) bool {
+		return previous(ctx) && authorize(ctx)
+	}
+}
+
+// Compare the digests so neither the content nor the length of the secret
+// leaks through the timing
+func secretEquals(got, want string) bool {
+	gotSum := sha256.Sum256([]byte(got))
+	wantSum := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(gotSum[:], wantSum[:]) == 1
+}
+
+// AuthorizeEndpoint reports whether the request may read the keys of the
+// config, a rejected request is aborted. Handlers serving keys call it first.
+func (c *Config) AuthorizeEndpoint(ctx *gin.Context) bool {
+	if c.endpointAuthorization == nil || c.endpointAuthorization(ctx) {
+		return true
+	}
+	if !ctx.IsAborted() && !ctx.Writer.Written() {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+	}
+	ctx.Abort()
+	return false
+}
+
+// EndpointAuthorization middleware applying the endpoint authorization of the
+// config to other handlers, e.g. the metadata documents
+func (c *Config) EndpointAuthorization() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if c.AuthorizeEndpoint(ctx) {
+			ctx.Next()
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEndpointAuthorization(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithEndpointAuthorization(func(c *gin.Context) bool {
+		switch c.GetHeader("Authorization") {
+		case "Bearer reader":
+			return true
+		case "Bearer other":
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+		return false
+	}))
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	r.GET("/metadata", config.EndpointAuthorization(), func(c *gin.Context) {
+		c.String(http.StatusOK, "metadata")
+	})
+
+	for _, tt := range []struct {
+		path, authorization string
+		code                int
+	}{
+		{"/jwks", "", http.StatusUnauthorized},
+		{"/jwks", "Bearer other", http.StatusForbidden},
+		{"/jwks", "Bearer reader", http.StatusOK},
+		{"/metadata", "", http.StatusUnauthorized},
+		{"/metadata", "Bearer reader", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Fatalf("%s with %q answered %d, want %d", tt.path, tt.authorization, w.Code, tt.code)
+		}
+		if tt.code != http.StatusOK && (w.Body.Len() != 0 || w.Header().Get("Cache-Control") != "") {
+			t.Fatalf("%s with %q: rejection carries %q %v", tt.path, tt.authorization, w.Body, w.Header())
+		}
+	}
+
+	// no authorization configured, the key set stays public
+	if w := serveJWKS(Jkws(*newTestConfig(t, NewConfigBuilder()))); w.Code != http.StatusOK {
+		t.Fatalf("public key set answered %d", w.Code)
+	}
+}
+
+func TestEndpointGuards(t *testing.T) {
+	var authorized int
+	config := newTestConfig(t, NewConfigBuilder().
+		WithBearerGuard("s3cret").
+		WithAPIKeyHeader("X-API-Key", "k3y").
+		WithEndpointAuthorization(func(*gin.Context) bool {
+			authorized++
+			return true
+		}))
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+
+	for _, tt := range []struct {
+		name, bearer, apiKey string
+		code                 int
+		challenge            string
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized, "Bearer"},
+		{"wrong token", "other", "k3y", http.StatusForbidden, `Bearer error="invalid_token"`},
+		{"longer token", "s3cret-and-more", "k3y", http.StatusForbidden, `Bearer error="invalid_token"`},
+		{"token only", "s3cret", "", http.StatusUnauthorized, ""},
+		{"wrong API key", "s3cret", "other", http.StatusForbidden, ""},
+		{"both secrets", "s3cret", "k3y", http.StatusOK, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/jwks", nil)
+		if tt.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+		if tt.apiKey != "" {
+			req.Header.Set("X-API-Key", tt.apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.code || w.Header().Get("WWW-Authenticate") != tt.challenge {
+			t.Fatalf("%s answered %d %v", tt.name, w.Code, w.Header())
+		}
+		if tt.code != http.StatusOK && w.Body.Len() != 0 {
+			t.Fatalf("%s: rejection carries %q", tt.name, w.Body)
+		}
+	}
+	// the custom authorization only runs once the guards passed
+	if authorized != 1 {
+		t.Fatalf("custom authorization ran %d times", authorized)
+	}
+
+	for name, builder := range map[string]*ConfigBuilder{
+		"empty token":       NewConfigBuilder().WithBearerGuard(""),
+		"empty header name": NewConfigBuilder().WithAPIKeyHeader(" ", "k3y"),
+		"empty API key":     NewConfigBuilder().WithAPIKeyHeader("X-API-Key", ""),
+	} {
+		if _, err := builder.NewPrivateKey().Build(); err == nil {
+			t.Fatalf("%s accepted", name)
+		}
+	}
+}
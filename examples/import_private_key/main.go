@@ -1,9 +1,9 @@
 package main
 
 import (
-	"fmt"
 	"github.com/gin-gonic/gin"
 	. "github.com/v4lproik/gin-jwks-rsa"
+	"log"
 )
 
 func main() {
@@ -12,15 +12,15 @@ func main() {
 	builder := NewConfigBuilder()
 	config, err := builder.
 		ImportPrivateKey().
-		WithPath("../testdata/private.pem").
+		WithPath("../../testdata/private.pem").
 		WithKeyId("my-id").
 		Build()
 
 	if err != nil {
-		fmt.Errorf("error generating conf %v", err)
-		return
+		log.Fatalf("error generating conf %v", err)
 	}
+	defer config.Close()
 
-	r.GET("/.well-known/jwks.json", Jkws(*config))
+	r.GET("/.well-known/jwks.json", config.Jkws())
 	r.Run()
 }
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"fmt"
 	"github.com/gin-gonic/gin"
 	. "github.com/v4lproik/gin-jwks-rsa"
+	"log"
 )
 
 func main() {
@@ -17,10 +17,10 @@ func main() {
 		Build()
 
 	if err != nil {
-		fmt.Errorf("error generating conf %v", err)
-		return
+		log.Fatalf("error generating conf %v", err)
 	}
+	defer config.Close()
 
-	r.GET("/.well-known/jwks.json", Jkws(*config))
+	r.GET("/.well-known/jwks.json", config.Jkws())
 	r.Run()
 }
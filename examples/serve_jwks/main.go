@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	. "github.com/v4lproik/gin-jwks-rsa"
+	"log"
+	"os"
+	"os/signal"
+)
+
+// Serve the key set on a side port of a program which does not use gin,
+// e.g. a gRPC service
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	config, err := NewConfigBuilder().
+		NewPrivateKey().
+		WithKeyId("my-id").
+		WithKeyLength(2048).
+		Build()
+	if err != nil {
+		log.Fatalf("error generating conf %v", err)
+	}
+	defer config.Close()
+
+	// start the gRPC server here
+
+	if err = config.ServeJWKS(ctx, ":8081"); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Serialization of the key set shared by the requests asking for it at once,
+// so a burst of requests does not serialize the same keys over and over
+type jwksFlight struct {
+	mu   sync.Mutex
+	call *jwksFlightCall
+}
+
+type jwksFlightCall struct {
+	done chan struct{}
+	// generation of the published keys the call serializes
+	generation uint64
+	body       []byte
+	err        error
+}
+
+// Run build, or wait for the one in progress for the same generation of the
+// keys and take its result. A build for an older generation is not joined,
+// its document is stale. The result is not kept once handed over, a failure
+// is retried by the next request.
+func (f *jwksFlight) do(generation uint64, build func() ([]byte, error)) ([]byte, error) {
+	if f == nil {
+		return build()
+	}
+	f.mu.Lock()
+	if call := f.call; call != nil && call.generation == generation {
+		f.mu.Unlock()
+		<-call.done
+		return call.body, call.err
+	}
+	call := &jwksFlightCall{done: make(chan struct{}), generation: generation}
+	f.call = call
+	f.mu.Unlock()
+
+	// release the waiting requests even when build panics
+	defer func() {
+		if call.body == nil && call.err == nil {
+			call.err = fmt.Errorf("the key set serialization panicked")
+		}
+		f.mu.Lock()
+		if f.call == call {
+			f.call = nil
+		}
+		f.mu.Unlock()
+		close(call.done)
+	}()
+	call.body, call.err = build()
+	return call.body, call.err
+}
@@ -0,0 +1,130 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serializer taking its time and counting its calls
+type slowBuild struct {
+	delay time.Duration
+	calls int32
+	err   error
+}
+
+func (s *slowBuild) build() ([]byte, error) {
+	atomic.AddInt32(&s.calls, 1)
+	time.Sleep(s.delay)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []byte(`{"keys":[]}`), nil
+}
+
+func TestJWKSFlightBuildsOnceForConcurrentRequests(t *testing.T) {
+	flight := &jwksFlight{}
+	serializer := &slowBuild{delay: 50 * time.Millisecond}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			body, err := flight.do(0, serializer.build)
+			if err != nil || !bytes.Equal(body, []byte(`{"keys":[]}`)) {
+				t.Errorf("request got %q, %v", body, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&serializer.calls); calls != 1 {
+		t.Fatalf("the key set was serialized %d times for concurrent requests", calls)
+	}
+}
+
+func TestJWKSFlightRetriesAfterAFailure(t *testing.T) {
+	flight := &jwksFlight{}
+	serializer := &slowBuild{err: errors.New("serialization failure")}
+	if _, err := flight.do(0, serializer.build); err == nil {
+		t.Fatal("the failure was not returned")
+	}
+
+	serializer.err = nil
+	if _, err := flight.do(0, serializer.build); err != nil {
+		t.Fatalf("the request after a failure got %v", err)
+	}
+	if calls := atomic.LoadInt32(&serializer.calls); calls != 2 {
+		t.Fatalf("the key set was serialized %d times, expected a retry", calls)
+	}
+}
+
+func TestJWKSFlightReleasesWaitersWhenTheBuildPanics(t *testing.T) {
+	flight := &jwksFlight{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() {
+			recover()
+		}()
+		flight.do(0, func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("codec panic")
+		})
+	}()
+	<-started
+
+	waited := make(chan error)
+	go func() {
+		_, err := flight.do(0, func() ([]byte, error) {
+			return []byte(`{"keys":[]}`), nil
+		})
+		waited <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Fatal("a request waiting on a panicked serialization got no error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a request waiting on a panicked serialization was never released")
+	}
+}
+
+func TestJWKSFlightDoesNotJoinAStaleGeneration(t *testing.T) {
+	flight := &jwksFlight{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	stale := make(chan []byte)
+	go func() {
+		body, _ := flight.do(1, func() ([]byte, error) {
+			close(started)
+			<-release
+			return []byte("stale"), nil
+		})
+		stale <- body
+	}()
+	<-started
+
+	body, err := flight.do(2, func() ([]byte, error) {
+		return []byte("fresh"), nil
+	})
+	if err != nil || string(body) != "fresh" {
+		t.Fatalf("a request after a change got %q, %v", body, err)
+	}
+	close(release)
+	if body = <-stale; string(body) != "stale" {
+		t.Fatalf("the stale build got %q", body)
+	}
+}
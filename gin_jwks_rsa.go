@@ -1,23 +1,96 @@
 package gin_jwks_rsa
 
 import (
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
-	"io/ioutil"
+	"io"
+	"io/fs"
+	"math/big"
+	"net/http"
+	"time"
 )
 
 const KeyUsageAsSignature = "sig"
 
-// Config represents the available options for the middleware.
+// Config represents the available options for the middleware. Handlers take
+// it by value: the keys, aliases and background work live behind pointers
+// shared by every copy, so a rotation or a removal through any copy is seen
+// by all of them, and a handler always reads the key set in one piece.
 type Config struct {
-	key          *jwk.Key
+	keys         *keyRing
 	newPkOpts    *NewKeyOptions
 	importPkOpts *ImportKeyOptions
+	// public-only mode, nothing can be signed
+	importPubOpts *ImportPublicKeyOptions
+	// verification-only mode around a shared secret, nothing is published
+	symmetricOpts *ImportSymmetricKeyOptions
+	symmetricKey  jwk.Key
+	aliases       *kidAliases
+	// cache, grace, rotation and publication settings, see runtimeSettings
+	settings *runtimeSettings
+	// adds members of its own to the published keys
+	keyDecorator func(kid string, entry map[string]interface{})
+	auditSink    AuditSink
+	onError      func(error)
+	onServe      func(*gin.Context, ServeStats)
+	onRotate     func(oldKid, newKid string)
+	expvarPrefix string
+	// key generation of an async Build, nil otherwise
+	generation  *asyncGeneration
+	instruments []instrumentation
+	selfTest    bool
+	// keep the profile from turning the self-test on
+	selfTestSkipped bool
+	// indent the JSON bodies other than the key set, prettyJSONSet once
+	// WithPrettyJSON was called
+	prettyJSON    bool
+	prettyJSONSet bool
+	sealKey       func(jwk.Key) (sealedKey, error)
+	encKeyBits    int
+	publishEncKey bool
+	keyAgeMax     time.Duration
+	keyAgeAlert   func(KeyAgeEvent)
+	minKeyBits    int
+	background    *background
+	profile       Profile
+	// serve an empty key set rather than a 503 when there is no key
+	emptyKeySetWhenNoKey bool
+	jsonCodec            JSONCodec
+	publisher            *keySetPublisher
+	httpClient           *http.Client
+	revocation           *revocationChecker
+	// nil when the key set is public
+	endpointAuthorization func(*gin.Context) bool
+	// set by a guard given an empty secret, reported by Build
+	endpointGuardErr error
+	// keys given to WithAdditionalKey, until Build publishes them
+	pendingKeys []jwk.Key
+	// kid is always the thumbprint of the key
+	thumbprintKid     bool
+	jwkSetContentType bool
+	// honor ?kid= on the key set endpoint
+	kidQueryFilter bool
+	// origins allowed to read the key set, any when empty
+	cors        bool
+	corsOrigins []string
+	// configs whose keys are served together, see MergeConfigs
+	merged []*Config
+	// serialization of the key set shared by concurrent requests
+	jwksFlight *jwksFlight
+	// withhold the signing key past its planned end of validity
+	expiredKeyPruning bool
+	onKeyExpired      func(kid string, notAfter time.Time)
+	// report keys below the minimum size rather than refusing them
+	allowWeakKeys bool
 }
 
 type Options interface {
@@ -26,18 +99,71 @@ type Options interface {
 
 // Structure used when the user generates a new private key
 type NewKeyOptions struct {
-	keyId string
-	bits  int
+	keyId   string
+	bits    int
+	keyType jwa.KeyType
+	curve   jwa.EllipticCurveAlgorithm
+	usage   string
+	alg     jwa.SignatureAlgorithm
+	// generated key kept there, loaded from there when it exists
+	persistPath string
+	// self-signed certificate published as x5c, none without a subject
+	selfSignedSubject  string
+	selfSignedValidity time.Duration
+	// Build returns before the key is generated
+	async bool
+	// key derived from seed rather than random, seedSet once WithDeterministicSeed
+	// was called, even with a nil seed
+	seed    []byte
+	seedSet bool
 }
 
 func (o *NewKeyOptions) KeyId() string {
 	return o.keyId
 }
 
+// Encoding of an imported private key
+type KeyFormat string
+
+const (
+	FormatPEM KeyFormat = "pem"
+	// binary PKCS#8, PKCS#1 or SEC 1 key, e.g. exported from an HSM
+	FormatDER KeyFormat = "der"
+	// JWK or JWKS document
+	FormatJWK KeyFormat = "jwk"
+)
+
 // Structure used when the user imports an existing private key
 type ImportKeyOptions struct {
 	keyId             string
 	privateKeyPemPath string
+	// WithPath was called, possibly with an empty path
+	pathGiven bool
+	// key material given in memory instead of a path
+	pemBytes []byte
+	reader   io.Reader
+	// file of a file system, e.g. an embed.FS
+	fsys   fs.FS
+	fsPath string
+	rawKey crypto.PrivateKey
+	jwkKey jwk.Key
+	format KeyFormat
+	alg    jwa.SignatureAlgorithm
+	// chain published as x5c, leaf first
+	certificatePath  string
+	allowMultiPrime  bool
+	overrideMetadata bool
+	jks              *jksOptions
+	wrapped          *wrappedKeyOptions
+	maxInputSize     int64
+	usage            string
+	// signs without handing over the private key
+	provider KeyProvider
+	// poll the key file, 0 to read it once
+	reloadInterval time.Duration
+	reloadHook     func(KeyReloadEvent)
+	// planned end of validity, none if zero
+	notAfter time.Time
 }
 
 func (o *ImportKeyOptions) KeyId() string {
@@ -69,7 +195,38 @@ func (n *ConfigBuilder) ImportPrivateKey() *ConfigImportKeyBuilder {
 
 // Initialise a new config builder
 func NewConfigBuilder() *ConfigBuilder {
-	return &ConfigBuilder{config: &Config{}}
+	return &ConfigBuilder{config: &Config{
+		keys:       newKeyRing(),
+		aliases:    newKidAliases(),
+		background: newBackground(),
+		settings:   newRuntimeSettings(),
+	}}
+}
+
+// Report the errors which cannot be returned to the caller, e.g. a failing audit sink
+func (n *ConfigBuilder) OnError(hook func(error)) *ConfigBuilder {
+	n.config.onError = hook
+	return n
+}
+
+// Publish the iat, nbf and exp members of the keys, exp being the planned end
+// of validity when there is one. Some strict consumers reject unknown members.
+func (n *ConfigBuilder) WithPublishKeyLifecycle() *ConfigBuilder {
+	storeFlag(&n.config.settings.publishLifecycle, true)
+	return n
+}
+
+// Use the RFC 7638 thumbprint of the key as its kid even when one is given,
+// so the kid changes with the key on rotation
+func (n *ConfigBuilder) WithThumbprintKeyId() *ConfigBuilder {
+	n.config.thumbprintKid = true
+	return n
+}
+
+// Publish the key a second time under each of its kid aliases
+func (n *ConfigBuilder) WithPublishedKidAliases() *ConfigBuilder {
+	storeFlag(&n.config.settings.publishAliases, true)
+	return n
 }
 
 // Initiate the import opts obj if nil
@@ -83,6 +240,65 @@ func (n *ConfigImportKeyBuilder) initiateImportOptsIfNil() {
 func (n *ConfigImportKeyBuilder) WithPath(privateKeyPemPath string) *ConfigImportKeyBuilder {
 	n.initiateImportOptsIfNil()
 	n.config.importPkOpts.privateKeyPemPath = privateKeyPemPath
+	n.config.importPkOpts.pathGiven = true
+	return n
+}
+
+// Import the key at path in fsys, e.g. an embed.FS, PEM or JWK as with a path
+func (n *ConfigImportKeyBuilder) WithFS(fsys fs.FS, path string) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.fsys = fsys
+	n.config.importPkOpts.fsPath = path
+	return n
+}
+
+// Import the key from data, PEM or JWK as with a path. data is left untouched,
+// wipe it once Build returned if it came from a secret manager.
+func (n *ConfigImportKeyBuilder) WithPEMBytes(data []byte) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.pemBytes = data
+	return n
+}
+
+// Import the key read from r at Build, PEM or JWK as with a path
+func (n *ConfigImportKeyBuilder) WithReader(r io.Reader) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.reader = r
+	return n
+}
+
+// Publish the certificate chain at path, leaf first, as the x5c, x5t and
+// x5t#S256 members of the key. Build fails when the leaf does not certify the
+// imported key.
+func (n *ConfigImportKeyBuilder) WithCertificatePath(certificatePath string) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.certificatePath = certificatePath
+	return n
+}
+
+// Import a JWK or JWKS document from a path, without looking for PEM
+func (n *ConfigImportKeyBuilder) WithJWKPath(path string) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.privateKeyPemPath = path
+	n.config.importPkOpts.pathGiven = true
+	n.config.importPkOpts.format = FormatJWK
+	return n
+}
+
+// Import a parsed JWK, its kid is kept unless WithKeyId is called. The key
+// is copied, later changes to it are not seen.
+func (n *ConfigImportKeyBuilder) WithJWK(key jwk.Key) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.jwkKey = key
+	return n
+}
+
+// Import a key already held by the program, e.g. an *rsa.PrivateKey or an
+// *ecdsa.PrivateKey shared with another signer. It replaces the path, PEM
+// bytes and reader, and is decorated by Build like any imported key.
+func (n *ConfigImportKeyBuilder) WithRawKey(key crypto.PrivateKey) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.rawKey = key
 	return n
 }
 
@@ -93,6 +309,27 @@ func (n *ConfigImportKeyBuilder) WithKeyId(keyId string) *ConfigImportKeyBuilder
 	return n
 }
 
+// Replace the use and alg carried by the imported key with the defaults
+func (n *ConfigImportKeyBuilder) WithOverrideMetadata() *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.overrideMetadata = true
+	return n
+}
+
+// Set the use member of the key, sig or enc, the use carried by the key or else sig if not set
+func (n *ConfigImportKeyBuilder) WithKeyUsage(usage string) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.usage = usage
+	return n
+}
+
+// Set the alg published with the key, RS256 for an RSA key if not set
+func (n *ConfigImportKeyBuilder) WithAlgorithm(alg jwa.SignatureAlgorithm) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.alg = alg
+	return n
+}
+
 // Initiate the new opts obj if nil
 func (n *ConfigNewKeyBuilder) initiateNewOptsIfNil() {
 	if n.config.newPkOpts == nil {
@@ -114,21 +351,100 @@ func (n *ConfigNewKeyBuilder) WithKeyId(keyId string) *ConfigNewKeyBuilder {
 	return n
 }
 
+// Set the key type, RSA if not set
+func (n *ConfigNewKeyBuilder) WithKeyType(keyType jwa.KeyType) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.keyType = keyType
+	return n
+}
+
+// Set the curve of an OKP key
+func (n *ConfigNewKeyBuilder) WithCurve(curve jwa.EllipticCurveAlgorithm) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.curve = curve
+	return n
+}
+
+// Set the use member of the key, sig or enc, sig if not set
+func (n *ConfigNewKeyBuilder) WithKeyUsage(usage string) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.usage = usage
+	return n
+}
+
+// Set the alg published with the key, RS256 for an RSA key if not set
+func (n *ConfigNewKeyBuilder) WithAlgorithm(alg jwa.SignatureAlgorithm) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.alg = alg
+	return n
+}
+
+// Algorithm given to WithAlgorithm, empty if none
+func (c *Config) requestedAlgorithm() jwa.SignatureAlgorithm {
+	if c.newPkOpts != nil {
+		return c.newPkOpts.alg
+	}
+	if c.importPkOpts != nil {
+		return c.importPkOpts.alg
+	}
+	return ""
+}
+
 // Build the config object in order to initiate the middleware
 func (b *ConfigBuilder) Build() (*Config, error) {
+	return b.BuildContext(context.Background())
+}
+
+// BuildContext builds the config like Build, and gives up on generating the
+// key once ctx ends, e.g. a large RSA key on a slow machine during shutdown
+func (b *ConfigBuilder) BuildContext(ctx context.Context) (*Config, error) {
+	if b.config.newPkOpts != nil && b.config.newPkOpts.async {
+		return b.buildAsync(ctx)
+	}
+	return b.build(ctx)
+}
+
+func (b *ConfigBuilder) build(ctx context.Context) (*Config, error) {
 	var key jwk.Key
 	var opts Options
 	var err error
-	if b.config.newPkOpts != nil && b.config.importPkOpts != nil {
-		return nil, fmt.Errorf("cannot import and generate a new private key")
+	// creation date carried by a wrapped key
+	var importedCreatedAt time.Time
+	// chain of an imported certificate
+	var certs []*x509.Certificate
+	// raw key of a multi-prime import, jwk cannot hold it
+	var multiPrimeKey *rsa.PrivateKey
+	// signer of a key provider, key is then its public key
+	var signer crypto.Signer
+	// the new key was read from its persist path
+	var persistedKeyLoaded bool
+	// nothing is generated nor read before the settings are known to be valid
+	if err = b.config.validate(); err != nil {
+		return nil, err
+	}
+	if b.config.symmetricOpts != nil {
+		return b.buildSymmetric()
 	}
 
 	// generate a new private key
 	if b.config.newPkOpts != nil {
 		newPkOpts := b.config.newPkOpts
-		key, err = generatePrivateKey(*newPkOpts)
+		err = generateWithContext(ctx, func() (err error) {
+			if newPkOpts.persistPath != "" {
+				key, persistedKeyLoaded, err = loadOrGeneratePersistedKey(*newPkOpts)
+			} else {
+				key, err = generatePrivateKey(*newPkOpts)
+			}
+			return err
+		})
 		if err != nil {
-			return nil, fmt.Errorf("cannot generate new private key %v", err)
+			return nil, fmt.Errorf("cannot generate new private key %w", err)
+		}
+		if newPkOpts.selfSignedSubject != "" {
+			err = attachSelfSignedCertificate(key, newPkOpts.selfSignedSubject, newPkOpts.selfSignedValidity)
+			if err != nil {
+				return nil, err
+			}
 		}
 		opts = newPkOpts
 	}
@@ -136,46 +452,334 @@ func (b *ConfigBuilder) Build() (*Config, error) {
 	// import the private key
 	if b.config.importPkOpts != nil {
 		importPkOpts := b.config.importPkOpts
-		key, err = importPrivateKey(*importPkOpts)
+		if importPkOpts.provider != nil {
+			key, signer, err = importProviderKey(*importPkOpts)
+		} else if importPkOpts.wrapped != nil {
+			key, importedCreatedAt, err = importWrappedKey(importPkOpts.wrapped)
+		} else {
+			key, multiPrimeKey, err = importPrivateKey(*importPkOpts)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("cannot import private key %v", err)
+			return nil, fmt.Errorf("cannot import private key %w", err)
+		}
+		if multiPrimeKey != nil && b.config.sealKey != nil {
+			return nil, fmt.Errorf("cannot seal a multi-prime private key")
+		}
+		if signer != nil && b.config.sealKey != nil {
+			return nil, fmt.Errorf("cannot seal the key of a provider, it is not in memory")
+		}
+		if importPkOpts.certificatePath != "" {
+			if importPkOpts.jks != nil {
+				return nil, fmt.Errorf("a keystore carries its own certificate chain")
+			}
+			err = attachKeyCertificates(key, importPkOpts.certificatePath, importPkOpts.maxInputSize, b.config.reportError)
+			if err != nil {
+				return nil, fmt.Errorf("cannot import certificate %v", err)
+			}
 		}
 		opts = importPkOpts
 	}
 
+	// import the public key of a certificate
+	if b.config.importPubOpts != nil {
+		if b.config.sealKey != nil || b.config.selfTest {
+			return nil, fmt.Errorf("a public key cannot be sealed or self-tested")
+		}
+		importPubOpts := b.config.importPubOpts
+		key, certs, err = importCertificate(*importPubOpts, b.config.reportError)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import certificate %v", err)
+		}
+		if importPubOpts.revocationPolicy != "" {
+			b.config.revocation, err = newRevocationChecker(*importPubOpts, certs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		opts = importPubOpts
+	}
+
 	if key == nil {
-		return nil, fmt.Errorf("generate or import a private key")
+		return nil, fmt.Errorf("%w, generate or import a private key", ErrNoKeyConfigured)
 	}
 
-	// add an id to the certificate according to RFC
-	err = key.Set(jwk.KeyIDKey, opts.KeyId())
+	// explicit builder option > value already on the imported key > thumbprint,
+	// an existing kid is never replaced by an empty one
+	override := b.config.importPkOpts != nil && b.config.importPkOpts.overrideMetadata
+	kid := opts.KeyId()
+	// the kid of a rotated key was persisted along with it
+	if kid == "" || persistedKeyLoaded && key.KeyID() != "" {
+		kid = key.KeyID()
+	}
+	if kid == "" || b.config.thumbprintKid {
+		if kid, err = thumbprintKeyId(key); err != nil {
+			return nil, err
+		}
+	}
+	err = key.Set(jwk.KeyIDKey, kid)
 	if err != nil {
 		return nil, fmt.Errorf("cannot add an id property to the private key %v", err)
 	}
 
-	err = key.Set(jwk.KeyUsageKey, KeyUsageAsSignature)
+	if err = b.config.checkProfileGuardrails(key); err != nil {
+		return nil, err
+	}
+	// the length of a generated key was checked with the settings
+	if b.config.newPkOpts == nil {
+		if err = b.config.checkKeySize(key); err != nil {
+			return nil, err
+		}
+	}
+	if err = b.config.buildAdditionalKeys(key.KeyID()); err != nil {
+		return nil, err
+	}
+
+	// X25519 keys can only agree on encryption keys
+	usage := KeyUsageAsSignature
+	if isX25519PrivateKey(key) {
+		usage = KeyUsageAsEncryption
+	}
+	requestedUsage := key.KeyUsage()
+	if override {
+		requestedUsage = ""
+	}
+	if b.config.newPkOpts != nil && b.config.newPkOpts.usage != "" {
+		requestedUsage = b.config.newPkOpts.usage
+	}
+	if b.config.importPkOpts != nil && b.config.importPkOpts.usage != "" {
+		requestedUsage = b.config.importPkOpts.usage
+	}
+	if usage, err = keyUsage(key, usage, requestedUsage); err != nil {
+		return nil, err
+	}
+	if signer != nil && usage != KeyUsageAsSignature {
+		return nil, fmt.Errorf("the key of a provider can only sign")
+	}
+
+	err = key.Set(jwk.KeyUsageKey, usage)
 	if err != nil {
 		return nil, fmt.Errorf("cannot add an id property to the private key %v", err)
 	}
 
-	// cast to private key
-	if _, ok := key.(jwk.RSAPrivateKey); !ok {
-		return nil, fmt.Errorf("expected jwk.SymmetricKey, got %T", key)
+	if override {
+		if err = key.Remove(jwk.AlgorithmKey); err != nil {
+			return nil, fmt.Errorf("cannot remove the algorithm property of the private key %v", err)
+		}
+	}
+	// a requested algorithm wins over the one carried by the imported key
+	if requestedAlg := b.config.requestedAlgorithm(); requestedAlg != "" {
+		if usage != KeyUsageAsSignature {
+			return nil, fmt.Errorf("a %s key cannot sign with %s", keyDescription(key), requestedAlg)
+		}
+		if err = key.Set(jwk.AlgorithmKey, requestedAlg); err != nil {
+			return nil, fmt.Errorf("cannot add an algorithm property to the private key %v", err)
+		}
+	}
+	if usage == KeyUsageAsSignature {
+		if _, err = signatureAlgorithm(key); err != nil {
+			return nil, err
+		}
 	}
 
-	// generate public key
-	_, err = key.PublicKey()
+	// cast to private key, only a certificate import serves a bare public key
+	// and a key provider signs with its own
+	isPublicKey := isSignaturePublicKey(key)
+	_, isRSA := key.(jwk.RSAPrivateKey)
+	_, isEC := key.(jwk.ECDSAPrivateKey)
+	if signer != nil {
+		if !isPublicKey {
+			return nil, fmt.Errorf("%w, expected an RSA, EC or Ed25519 public key from the provider, got %T", ErrUnsupportedKeyType, key)
+		}
+	} else if !isRSA && !isEC && !isEd25519PrivateKey(key) && !isX25519PrivateKey(key) && !(isPublicKey && b.config.importPubOpts != nil) {
+		return nil, fmt.Errorf("%w, expected an RSA, EC, Ed25519 or X25519 private key, got %T", ErrUnsupportedKeyType, key)
+	}
+
+	// generate public key, a rotation after the removal of the signing key
+	// generates one like it
+	initial, err := key.PublicKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create public key %v", err)
 	}
+	b.config.keys.mu.Lock()
+	b.config.keys.initial = initial
+	b.config.keys.mu.Unlock()
+
+	active := &activeKey{key: key, createdAt: time.Now(), multiPrimeKey: multiPrimeKey, signer: signer}
+	if !importedCreatedAt.IsZero() {
+		active.createdAt = importedCreatedAt
+	}
+	active.notAfter = b.config.keyNotAfter(active.createdAt)
+	b.config.setActive(active)
+
+	// the encryption key is kept apart from the signing key
+	if b.config.encKeyBits != 0 {
+		encKey, err := generateEncryptionKey(b.config.encKeyBits)
+		if err != nil {
+			b.config.setActive(nil)
+			return nil, fmt.Errorf("cannot generate encryption key %v", err)
+		}
+		b.config.keys.encryption = &encryptionKey{key: encKey, createdAt: time.Now()}
+	}
+
+	if b.config.sealKey != nil {
+		sealed, err := b.config.sealActiveKey(active)
+		if err != nil {
+			b.config.setActive(nil)
+			return nil, err
+		}
+		b.config.setActive(sealed)
+	}
+
+	if b.config.selfTest {
+		if err = b.config.runSelfTest(); err != nil {
+			b.config.setActive(nil)
+			return nil, err
+		}
+	}
+	if err = b.config.checkProfileKeySet(); err != nil {
+		b.config.setActive(nil)
+		return nil, err
+	}
+
+	// register the metrics once everything else succeeded
+	if b.config.expvarPrefix != "" {
+		metrics, err := newExpvarMetrics(b.config.expvarPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("cannot register expvar metrics %v", err)
+		}
+		b.config.instruments = append(b.config.instruments, metrics)
+	}
+
+	publishedKeys := b.config.publishedKeyCount()
+	b.config.observe(func(i instrumentation) {
+		i.keysPublished(publishedKeys)
+		i.keyActivated(active.createdAt)
+	})
+
+	if b.config.newPkOpts != nil && !persistedKeyLoaded {
+		b.config.audit(AuditEvent{Action: AuditKeyGenerated, KeyID: key.KeyID(), Trigger: AuditTriggerBuild})
+	} else if persistedKeyLoaded {
+		b.config.audit(AuditEvent{
+			Action:  AuditKeyImported,
+			KeyID:   key.KeyID(),
+			Trigger: AuditTriggerBuild,
+			Source:  b.config.newPkOpts.persistPath,
+		})
+	} else {
+		var source string
+		if b.config.importPkOpts != nil && b.config.importPkOpts.jks != nil {
+			source = b.config.importPkOpts.jks.path
+		} else if b.config.importPkOpts != nil && b.config.importPkOpts.wrapped != nil {
+			source = "wrapped"
+		} else if b.config.importPkOpts != nil && b.config.importPkOpts.pemBytes != nil {
+			source = "bytes"
+		} else if b.config.importPkOpts != nil && b.config.importPkOpts.reader != nil {
+			source = "reader"
+		} else if b.config.importPkOpts != nil && b.config.importPkOpts.rawKey != nil {
+			source = "raw"
+		} else if b.config.importPkOpts != nil && b.config.importPkOpts.jwkKey != nil {
+			source = "jwk"
+		} else if b.config.importPkOpts != nil {
+			source = b.config.importPkOpts.privateKeyPemPath
+		} else {
+			source = b.config.importPubOpts.certificatePath
+		}
+		b.config.audit(AuditEvent{
+			Action:  AuditKeyImported,
+			KeyID:   key.KeyID(),
+			Trigger: AuditTriggerBuild,
+			Source:  source,
+		})
+	}
+	b.config.audit(AuditEvent{Action: AuditKeyPublished, KeyID: key.KeyID(), Trigger: AuditTriggerBuild})
+	for _, additional := range b.config.additionalKeyList() {
+		b.config.audit(AuditEvent{Action: AuditKeyPublished, KeyID: additional.KeyID(), Trigger: AuditTriggerBuild})
+	}
+	if b.config.keys.encryption != nil {
+		encKid := b.config.keys.encryption.key.KeyID()
+		b.config.audit(AuditEvent{Action: AuditKeyGenerated, KeyID: encKid, Trigger: AuditTriggerBuild})
+		if b.config.publishEncKey {
+			b.config.audit(AuditEvent{Action: AuditKeyPublished, KeyID: encKid, Trigger: AuditTriggerBuild})
+		}
+	}
 
-	b.config.key = &key
+	// before any background task serializes the key set
+	b.config.jwksFlight = &jwksFlight{}
+	if b.config.keyAgeAlert != nil {
+		b.config.startKeyAgeAlert()
+	}
+	if b.config.rotationGrace() > 0 {
+		b.config.startRetiredKeyPruning()
+	}
+	if b.config.autoRotation() > 0 {
+		b.config.startAutoRotation()
+	}
+	if (b.config.expiredKeyPruning || b.config.onKeyExpired != nil) && !b.config.active().notAfter.IsZero() {
+		b.config.startKeyExpiryCheck()
+	}
+	if b.config.importPkOpts != nil && b.config.importPkOpts.reloadInterval > 0 {
+		b.config.startKeyReload(*b.config.importPkOpts)
+	}
+	if b.config.importPkOpts != nil {
+		if provider, ok := b.config.importPkOpts.provider.(RenewableKeyProvider); ok {
+			provider.OnRenew(b.config.renewProviderKey)
+		}
+	}
+	// before the publisher, so the first publication knows the status
+	if b.config.revocation != nil {
+		b.config.startRevocationCheck()
+	}
+	if b.config.publisher != nil {
+		b.config.startPublisher()
+	}
 
 	return b.config, nil
 }
 
+// RFC 7638 SHA-256 thumbprint of the public part of key, base64url encoded
+func thumbprintKeyId(key jwk.Key) (string, error) {
+	pubKey, err := key.PublicKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to create public key %v", err)
+	}
+	thumbprint, err := pubKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("cannot compute the thumbprint of the key %v", err)
+	}
+	return EncodeToString(thumbprint), nil
+}
+
+// Hand an error over to the error hook if any
+func (c *Config) reportError(err error) {
+	if c.onError != nil {
+		c.runHook("OnError", func() {
+			c.onError(err)
+		})
+	}
+}
+
 // Generate a private key
 func generatePrivateKey(opts NewKeyOptions) (jwk.Key, error) {
+	if opts.seed != nil {
+		return generateSeededKey(opts)
+	}
+	switch opts.keyType {
+	case "", jwa.RSA:
+	case jwa.EC:
+		return generateECKey(opts.curve)
+	case jwa.OKP:
+		switch opts.curve {
+		case jwa.X25519:
+			return generateX25519Key()
+		case jwa.Ed25519:
+			return generateEd25519Key()
+		default:
+			return nil, fmt.Errorf("%w, unsupported OKP curve %q", ErrUnsupportedKeyType, opts.curve)
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedKeyType, opts.keyType)
+	}
+
 	rawPrivateKey, err := rsa.GenerateKey(rand.Reader, opts.bits)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate new RSA private key: %s\n", err)
@@ -189,68 +793,439 @@ func generatePrivateKey(opts NewKeyOptions) (jwk.Key, error) {
 	return key, nil
 }
 
-// Import a private key with pem format
-func importPrivateKey(opts ImportKeyOptions) (jwk.Key, error) {
-	// import from path
-	keyData, err := ioutil.ReadFile(opts.privateKeyPemPath)
+// Run generate unless ctx ends first, the generation is then abandoned and its
+// result dropped
+func generateWithContext(ctx context.Context, generate func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("key generation cancelled %w", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- generate()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("key generation cancelled %w", ctx.Err())
+	}
+}
+
+// Import a private key with pem format, the raw key is only returned for multi-prime keys
+func importPrivateKey(opts ImportKeyOptions) (jwk.Key, *rsa.PrivateKey, error) {
+	if opts.jks != nil {
+		if opts.privateKeyPemPath != "" || opts.pemBytes != nil || opts.reader != nil || opts.fsys != nil || opts.rawKey != nil || opts.jwkKey != nil {
+			return nil, nil, fmt.Errorf("%w, cannot import from a keystore and another source", ErrConflictingKeySources)
+		}
+		jks := *opts.jks
+		jks.maxSize = opts.maxInputSize
+		key, err := importJKS(jks)
+		return key, nil, err
+	}
+	if opts.rawKey != nil || opts.jwkKey != nil {
+		if opts.privateKeyPemPath != "" || opts.pemBytes != nil || opts.reader != nil || opts.fsys != nil || (opts.rawKey != nil && opts.jwkKey != nil) {
+			return nil, nil, fmt.Errorf("%w, cannot import an in-memory key and another source", ErrConflictingKeySources)
+		}
+		if opts.jwkKey != nil {
+			// Build decorates the key, leave the one of the caller alone
+			key, err := opts.jwkKey.Clone()
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot copy the JWK %v", err)
+			}
+			return key, nil, nil
+		}
+		return importRawKey(opts.rawKey, opts.allowMultiPrime)
+	}
+
+	keyData, err := readKeySource(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the parsed key holds its own copy, do not leave the raw material around
+	defer wipe(keyData)
+
+	key, multiPrimeKey, err := parsePrivateKey(opts, keyData)
+	if err != nil && !errors.Is(err, ErrMultiPrimeUnsupported) {
+		return nil, nil, fmt.Errorf("%w, %v", ErrKeyParse, err)
+	}
+	return key, multiPrimeKey, err
+}
+
+// Parse key data in the format given by opts or else detected
+func parsePrivateKey(opts ImportKeyOptions, keyData []byte) (jwk.Key, *rsa.PrivateKey, error) {
+	format, err := keyFormat(opts.format, keyData)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read private key %v", err)
+		return nil, nil, err
+	}
+	switch format {
+	// Go cannot read X25519 PKCS#8 keys, those are imported as a JWK
+	case FormatJWK:
+		key, err := importJWK(keyData, opts.keyId)
+		return key, nil, err
+	case FormatDER:
+		raw, err := parseDERKey(keyData)
+		if err != nil {
+			return nil, nil, err
+		}
+		return importRawKey(raw, opts.allowMultiPrime)
 	}
 
-	// check if it's a PEM file
-	key, err := jwk.ParseKey(keyData, jwk.WithPEM(true))
+	raw, err := parsePEMKey(keyData)
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse private key %v", err)
+		return nil, nil, err
 	}
+	return importRawKey(raw, opts.allowMultiPrime)
+}
 
-	return key, nil
+// Wrap a key held in memory, multi-prime RSA keys are handled as when parsed
+func importRawKey(raw crypto.PrivateKey, allowMultiPrime bool) (jwk.Key, *rsa.PrivateKey, error) {
+	if rsaKey, ok := raw.(*rsa.PrivateKey); ok && len(rsaKey.Primes) > 2 {
+		if !allowMultiPrime {
+			return nil, nil, ErrMultiPrimeUnsupported
+		}
+		key, err := multiPrimeJWK(rsaKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, rsaKey, nil
+	}
+
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot use raw private key of type %T %v", raw, err)
+	}
+	return key, nil, nil
+}
+
+// Import a JWK, or the key of a JWKS, selected by kid when the set holds several
+func importJWK(keyData []byte, kid string) (jwk.Key, error) {
+	if err := checkJWKJSON(keyData); err != nil {
+		return nil, fmt.Errorf("invalid JWK %v", err)
+	}
+	if key, err := jwk.ParseKey(keyData); err == nil {
+		return key, nil
+	}
+
+	set, err := jwk.Parse(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK %v", err)
+	}
+	if set.Len() == 1 {
+		key, _ := set.Key(0)
+		return key, nil
+	}
+	if key, ok := set.LookupKeyID(kid); ok && kid != "" {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("key set holds %d keys, select one with WithKeyId", set.Len())
 }
 
 // Refer to rfc for more information: https://www.rfc-editor.org/rfc/rfc7518#section-6.3.1
 type JkwsResponse struct {
 	KeyTypeKey        string `json:"kty"`
-	AlgorithmKey      string `json:"alg"`
-	PubKeyExponentKey string `json:"e"`
-	PubKeyModulusKey  string `json:"n"`
-	KeyUsageKey       string `json:"use"`
-	KeyIDKey          string `json:"kid"`
+	AlgorithmKey      string `json:"alg,omitempty"`
+	PubKeyExponentKey string `json:"e,omitempty"`
+	PubKeyModulusKey  string `json:"n,omitempty"`
+	// EC and OKP members, y is EC only
+	CurveKey    string `json:"crv,omitempty"`
+	PubKeyXKey  string `json:"x,omitempty"`
+	PubKeyYKey  string `json:"y,omitempty"`
+	KeyUsageKey string `json:"use"`
+	KeyIDKey    string `json:"kid"`
+	// certificate members, only set for keys imported from a certificate
+	X509CertChainKey          []string `json:"x5c,omitempty"`
+	X509CertThumbprintKey     string   `json:"x5t,omitempty"`
+	X509CertThumbprintS256Key string   `json:"x5t#S256,omitempty"`
+	// lifecycle members, only published with WithPublishKeyLifecycle
+	IssuedAtKey  int64 `json:"iat,omitempty"`
+	NotBeforeKey int64 `json:"nbf,omitempty"`
+	ExpiresKey   int64 `json:"exp,omitempty"`
+	// members added by WithKeyDecorator
+	extra map[string]interface{}
 }
 
-// Jkws middleware exposing the public key properties required in order to decrypt
-// a jwt token
-func Jkws(config Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if config.key == nil {
-			c.Error(fmt.Errorf("private key cannot be nil"))
-			c.AbortWithStatus(500)
-			return
+// Keys the key set is made of, published and verifying alike: the signing key
+// unless it was removed, and the additional keys until the end of their grace
+// period. A signing key which is revoked and dropped, or past its end of
+// validity with WithExpiredKeyPruning, withholds the whole set.
+func (c *Config) servedKeys() (*activeKey, []jwk.Key, error) {
+	active, additionalKeys := c.keySnapshot()
+	if active == nil && len(additionalKeys) == 0 {
+		return nil, nil, ErrNoServableKey
+	}
+	if active != nil {
+		if c.keyDropped() {
+			return nil, nil, fmt.Errorf("%w, the certificate of the key is revoked", ErrNoServableKey)
 		}
+		if c.keyExpired(active, c.keys.now()) {
+			return nil, nil, fmt.Errorf("%w, the key expired", ErrNoServableKey)
+		}
+	}
+	return active, additionalKeys, nil
+}
 
-		// get private key and its properties
-		key := *config.key
+// Keys published by the jkws handler
+func (c *Config) jwksKeys() ([]JkwsResponse, error) {
+	if c.merged != nil {
+		return mergedJWKSKeys(c.merged)
+	}
+	if c.symmetricKey != nil {
+		return nil, ErrSymmetricKey
+	}
+	active, additionalKeys, err := c.servedKeys()
+	if err != nil {
+		return nil, err
+	}
 
-		// get public key
-		pubKey, _ := key.PublicKey()
+	var keys []JkwsResponse
+	// nil once RemoveKey removed the signing key, the other keys are still served
+	if active != nil {
+		res, err := c.activeKeyResponse(active)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, res)
+		if c.publishesAliases() {
+			for _, alias := range c.aliasesOf(res.KeyIDKey) {
+				aliasRes := res
+				aliasRes.KeyIDKey = alias
+				keys = append(keys, aliasRes)
+			}
+		}
+	}
 
-		// get public key exponent
-		E, _ := key.Get("e")
-		// get public key modulus
-		N, _ := key.Get("n")
+	for _, additional := range additionalKeys {
+		additionalAlg, err := signatureAlgorithm(additional)
+		if err != nil {
+			return nil, err
+		}
+		additionalRes, err := newJkwsResponse(additional, additionalAlg.String())
+		if err != nil {
+			return nil, err
+		}
+		// a retired key is published until the end of its grace period
+		if c.publishesLifecycle() {
+			if until := c.retiredKeyUntil(additional.KeyID()); !until.IsZero() {
+				additionalRes.ExpiresKey = until.Unix()
+			}
+		}
+		keys = append(keys, additionalRes)
+	}
+
+	if encryption := c.encryptionKey(); encryption != nil && c.publishEncKey {
+		encRes, err := newJkwsResponse(encryption.key, jwa.RSA_OAEP_256.String())
+		if err != nil {
+			return nil, err
+		}
+		if c.publishesLifecycle() {
+			encRes.IssuedAtKey = encryption.createdAt.Unix()
+			encRes.NotBeforeKey = encryption.createdAt.Unix()
+		}
+		keys = append(keys, encRes)
+	}
+
+	if err := c.decorateKeys(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Entry of the signing key
+func (c *Config) activeKeyResponse(active *activeKey) (JkwsResponse, error) {
+	// an X25519 key serves every ECDH-ES variant, alg is left out unless the
+	// imported key carried one
+	var alg string
+	if active.key.KeyUsage() == KeyUsageAsEncryption {
+		if keyAlg := active.key.Algorithm(); keyAlg != nil {
+			alg = keyAlg.String()
+		}
+	} else {
+		signatureAlg, err := signatureAlgorithm(active.key)
+		if err != nil {
+			return JkwsResponse{}, err
+		}
+		alg = signatureAlg.String()
+	}
+	res, err := newJkwsResponse(active.key, alg)
+	if err != nil {
+		return JkwsResponse{}, err
+	}
+	if c.x5cStripped() {
+		res.X509CertChainKey = nil
+		res.X509CertThumbprintKey = ""
+		res.X509CertThumbprintS256Key = ""
+	}
+
+	// the key is active as soon as it is loaded
+	if c.publishesLifecycle() {
+		res.IssuedAtKey = active.createdAt.Unix()
+		res.NotBeforeKey = active.createdAt.Unix()
+		if !active.notAfter.IsZero() {
+			res.ExpiresKey = active.notAfter.Unix()
+		}
+	}
+	return res, nil
+}
+
+// Public properties of an RSA, EC or OKP key, an error when a member is missing
+func newJkwsResponse(key jwk.Key, alg string) (JkwsResponse, error) {
+	res, err := newJkwsKeyResponse(key, alg)
+	if err != nil {
+		return JkwsResponse{}, err
+	}
+
+	// certificate members, whatever the key type
+	if chain := key.X509CertChain(); chain != nil {
+		for i := 0; i < chain.Len(); i++ {
+			der, ok := chain.Get(i)
+			if !ok {
+				return JkwsResponse{}, fmt.Errorf("cannot read certificate %d of kid %q", i, key.KeyID())
+			}
+			res.X509CertChainKey = append(res.X509CertChainKey, string(der))
+		}
+	}
+	res.X509CertThumbprintKey = key.X509CertThumbprint()
+	res.X509CertThumbprintS256Key = key.X509CertThumbprintS256()
+	return res, nil
+}
+
+// Key type members of the response
+func newJkwsKeyResponse(key jwk.Key, alg string) (JkwsResponse, error) {
+	// get public key
+	pubKey, err := key.PublicKey()
+	if err != nil {
+		return JkwsResponse{}, fmt.Errorf("failed to create public key of kid %q %v", key.KeyID(), err)
+	}
+
+	switch pubKey := pubKey.(type) {
+	case jwk.SymmetricKey:
+		return JkwsResponse{}, ErrSymmetricKey
+	case jwk.ECDSAPublicKey:
+		if len(pubKey.X()) == 0 || len(pubKey.Y()) == 0 {
+			return JkwsResponse{}, fmt.Errorf("EC key of kid %q has no coordinates", key.KeyID())
+		}
+		return JkwsResponse{
+			KeyTypeKey:   pubKey.KeyType().String(),
+			AlgorithmKey: alg,
+			CurveKey:     pubKey.Crv().String(),
+			PubKeyXKey:   EncodeToString(pubKey.X()),
+			PubKeyYKey:   EncodeToString(pubKey.Y()),
+			KeyUsageKey:  key.KeyUsage(),
+			KeyIDKey:     key.KeyID(),
+		}, nil
+	case jwk.OKPPublicKey:
+		if len(pubKey.X()) == 0 {
+			return JkwsResponse{}, fmt.Errorf("OKP key of kid %q has no public value", key.KeyID())
+		}
+		return JkwsResponse{
+			KeyTypeKey:   pubKey.KeyType().String(),
+			AlgorithmKey: alg,
+			CurveKey:     pubKey.Crv().String(),
+			PubKeyXKey:   EncodeToString(pubKey.X()),
+			KeyUsageKey:  key.KeyUsage(),
+			KeyIDKey:     key.KeyID(),
+		}, nil
+	case jwk.RSAPublicKey:
+		// exponent and modulus from the raw public key, so they are encoded
+		// without leading zeros whatever the source of the key carried
+		var raw rsa.PublicKey
+		if err = pubKey.Raw(&raw); err != nil {
+			return JkwsResponse{}, fmt.Errorf("cannot read RSA key of kid %q %v", key.KeyID(), err)
+		}
+		if raw.E <= 0 || raw.N == nil || raw.N.Sign() <= 0 {
+			return JkwsResponse{}, fmt.Errorf("RSA key of kid %q has no exponent or modulus", key.KeyID())
+		}
 
 		// generate jkws response
-		res := JkwsResponse{
+		return JkwsResponse{
 			KeyTypeKey:        pubKey.KeyType().String(),
-			AlgorithmKey:      jwa.RS256.String(),
-			PubKeyExponentKey: EncodeToString(E.([]byte)),
-			PubKeyModulusKey:  EncodeToString(N.([]byte)),
+			AlgorithmKey:      alg,
+			PubKeyExponentKey: EncodeToString(big.NewInt(int64(raw.E)).Bytes()),
+			PubKeyModulusKey:  EncodeToString(raw.N.Bytes()),
 			KeyUsageKey:       key.KeyUsage(),
 			KeyIDKey:          key.KeyID(),
-		}
+		}, nil
+	}
+
+	return JkwsResponse{}, fmt.Errorf("cannot publish a %T", pubKey)
+}
+
+// Jkws handler of the config, the same as Jkws(*c) without the copy at the
+// call site. The handler follows the rotations and removals made through the
+// config or through any of its copies.
+func (c *Config) Jkws() gin.HandlerFunc {
+	return Jkws(*c)
+}
 
-		// expose jkws response
-		c.JSON(200, gin.H{
-			"keys": []JkwsResponse{res},
+// Jkws middleware exposing the public key properties required in order to decrypt
+// a jwt token. It panics on a config holding a symmetric key, whose secret must
+// never be served. config.Jkws() is the same handler.
+func Jkws(config Config) gin.HandlerFunc {
+	if config.symmetricKey != nil {
+		panic(fmt.Sprintf("gin-jwks: %v, the config only verifies tokens", ErrSymmetricKey))
+	}
+	return func(c *gin.Context) {
+		start := time.Now()
+		defer config.observe(func(i instrumentation) {
+			i.requestServed(c.Writer.Status())
 		})
+		defer config.served(c, start)
+		// a preflight request carries no credentials
+		if config.handleCORS(c) {
+			return
+		}
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Allow", jwksAllowedMethods)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if !config.AuthorizeEndpoint(c) {
+			return
+		}
+
+		var body []byte
+		var etag string
+		var err error
+		if kid, ok := c.GetQuery("kid"); ok && config.kidQueryFilter {
+			body, etag, err = config.filteredJWKS(kid)
+		} else {
+			body, etag, err = config.servedJWKS()
+		}
+		if errors.Is(err, ErrNoServableKey) {
+			c.Error(err)
+			serveNoKey(c, &config, err)
+			return
+		}
+		if err != nil {
+			// the error goes to the gin error log, never to the client
+			c.Error(err)
+			config.reportError(err)
+			config.abortWithJSON(c, http.StatusInternalServerError, &OAuthError{
+				Code:        OAuthErrorServerError,
+				Description: "the key set cannot be built",
+			})
+			return
+		}
+
+		// the tag changes with any change of the published keys
+		c.Header("ETag", etag)
+		config.setCacheHeaders(c)
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		// expose jkws response, the very bytes given to the publisher
+		config.writeJWKS(c, http.StatusOK, body)
+	}
+}
+
+// Overwrite a buffer which held key material
+func wipe(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
 	}
 }
 
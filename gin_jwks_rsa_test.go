@@ -0,0 +1,108 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newRSAKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// PKCS#8 PEM encoding of key
+func pemKey(t testing.TB, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// PEM file holding key, removed with the test
+func writeTestKey(t testing.TB, key interface{}) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pemKey(t, key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Config signing with an RSA key under the kid test, closed with the test
+func newTestConfig(t testing.TB, builder *ConfigBuilder) *Config {
+	t.Helper()
+	keys := fstest.MapFS{"key.pem": {Data: pemKey(t, newRSAKey(t))}}
+	config, err := builder.ImportPrivateKey().WithFS(keys, "key.pem").WithKeyId("test").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		config.Close()
+	})
+	return config
+}
+
+// Key set as the Jkws handler of config serves it
+func servedKeySet(t testing.TB, config *Config) jwk.Set {
+	t.Helper()
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("key set answered %d", w.Code)
+	}
+	set, err := jwk.Parse(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("invalid key set: %v", err)
+	}
+	return set
+}
+
+// Allocations per key set request, served from the cached document or
+// serialized again as before the cache
+func BenchmarkJkws(b *testing.B) {
+	config := newTestConfig(b, NewConfigBuilder())
+	r := gin.New()
+	r.GET("/jwks", config.Jkws())
+	req := httptest.NewRequest(http.MethodGet, "/jwks", nil)
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				b.Fatalf("key set answered %d", w.Code)
+			}
+		}
+	})
+	b.Run("rebuilt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := config.jwksDocument(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"sync/atomic"
+	"time"
+)
+
+// Keep publishing the public half of the previous key for grace after a
+// rotation, so the tokens it signed keep verifying. It is never used to sign,
+// and is pruned once the grace period is over.
+func (n *ConfigBuilder) WithRotationGrace(grace time.Duration) *ConfigBuilder {
+	atomic.StoreInt64(&n.config.settings.rotationGrace, int64(grace))
+	return n
+}
+
+// Public half of a key leaving the signing role
+func retiredPublicKey(key jwk.Key) (jwk.Key, error) {
+	pubKey, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public key of the previous key %v", err)
+	}
+	if err = pubKey.Set(jwk.KeyUsageKey, KeyUsageAsSignature); err != nil {
+		return nil, fmt.Errorf("cannot add a use property to the previous key %v", err)
+	}
+	return pubKey, nil
+}
+
+// Publish key until the given time, the caller holds the write lock
+func (r *keyRing) retire(key jwk.Key, until time.Time) error {
+	set := jwk.NewSet()
+	if r.additional != nil {
+		// readers may still hold the current set, work on a copy
+		for i := 0; i < r.additional.Len(); i++ {
+			additional, _ := r.additional.Key(i)
+			if err := set.AddKey(additional); err != nil {
+				return err
+			}
+		}
+	}
+	if err := set.AddKey(key); err != nil {
+		return err
+	}
+	if r.retiredUntil == nil {
+		r.retiredUntil = map[string]time.Time{}
+	}
+	r.additional = set
+	r.retiredUntil[key.KeyID()] = until
+	return nil
+}
+
+// Whether kid is a retired key past its grace period, the caller holds the lock
+func (r *keyRing) expired(kid string, now time.Time) bool {
+	until, ok := r.retiredUntil[kid]
+	return ok && !now.Before(until)
+}
+
+// Stop publishing the retired keys whose grace period is over
+func (c *Config) pruneRetiredKeys(now time.Time) {
+	if c.keys == nil {
+		return
+	}
+	c.keys.mu.RLock()
+	var kids []string
+	for kid := range c.keys.retiredUntil {
+		if c.keys.expired(kid, now) {
+			kids = append(kids, kid)
+		}
+	}
+	c.keys.mu.RUnlock()
+	if len(kids) == 0 {
+		return
+	}
+
+	var pruned []string
+	var notAfter []time.Time
+	c.keys.mu.Lock()
+	for _, kid := range kids {
+		// pruned concurrently or removed
+		if !c.keys.expired(kid, now) {
+			continue
+		}
+		until := c.keys.retiredUntil[kid]
+		set, _, err := withoutKey(c.keys.additional, kid)
+		if err != nil {
+			c.reportError(fmt.Errorf("cannot prune key %q %v", kid, err))
+			continue
+		}
+		c.keys.additional = set
+		delete(c.keys.retiredUntil, kid)
+		pruned = append(pruned, kid)
+		notAfter = append(notAfter, until)
+	}
+	c.keys.mu.Unlock()
+	if len(pruned) == 0 {
+		return
+	}
+
+	for _, kid := range pruned {
+		c.audit(AuditEvent{Action: AuditKeyPruned, KeyID: kid, Trigger: AuditTriggerExpiry})
+	}
+	c.keySetChanged()
+	for i, kid := range pruned {
+		c.keyExpiredHook(kid, notAfter[i])
+	}
+}
+
+// Prune the retired keys in the background too, so the published copies of
+// the key set drop them without waiting for a request
+func (c *Config) startRetiredKeyPruning() {
+	c.settings.pruningStarted.Do(func() {
+		c.background.goWithTicker(keyAgeCheckInterval(c.rotationGrace()), func(time.Time) {
+			c.pruneRetiredKeys(c.keys.now())
+		})
+	})
+}
@@ -0,0 +1,123 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Actions recorded for kid, in order
+func (s *recordingAuditSink) actions(kid string) []AuditAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var actions []AuditAction
+	for _, event := range s.events {
+		if event.KeyID == kid {
+			actions = append(actions, event.Action)
+		}
+	}
+	return actions
+}
+
+// Kids of the key set config serves
+func servedKids(t *testing.T, config *Config) []string {
+	t.Helper()
+	set := servedKeySet(t, config)
+	var kids []string
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		kids = append(kids, key.KeyID())
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+func TestRotationGraceTimeline(t *testing.T) {
+	clock := newFakeClock()
+	sink := &recordingAuditSink{}
+	publisher := newFakePublisher()
+	builder := NewConfigBuilder().
+		WithRotationGrace(time.Hour).
+		WithAuditSink(sink).
+		WithPublisher(publisher)
+	// before Build starts the background tasks reading it
+	builder.config.keys.now = clock.Now
+	config := newTestConfig(t, builder)
+	publisher.next(t)
+	verifier := config.Verifier()
+	claims := map[string]interface{}{"exp": time.Now().Add(24 * time.Hour)}
+	previous := signTestToken(t, config, claims)
+
+	if err := config.Rotate("next"); err != nil {
+		t.Fatal(err)
+	}
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"next", "test"}) {
+		t.Fatalf("rotated key set published with %v", kids)
+	}
+	current := signTestToken(t, config, claims)
+	for _, elapsed := range []time.Duration{0, 59 * time.Minute} {
+		clock.Advance(elapsed)
+		if kids := servedKids(t, config); !reflect.DeepEqual(kids, []string{"next", "test"}) {
+			t.Fatalf("%v into the grace period the key set holds %v", elapsed, kids)
+		}
+		if _, err := verifier.Verify(context.Background(), previous); err != nil {
+			t.Fatalf("%v into the grace period a token of the previous key is refused: %v", elapsed, err)
+		}
+	}
+	if err := config.AliasKid("test", "next"); err == nil {
+		t.Fatal("an alias shadows the retired key")
+	}
+
+	clock.Advance(time.Minute)
+	if kids := servedKids(t, config); !reflect.DeepEqual(kids, []string{"next"}) {
+		t.Fatalf("after the grace period the key set holds %v", kids)
+	}
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"next"}) {
+		t.Fatalf("pruned key set published with %v", kids)
+	}
+	if _, err := verifier.Verify(context.Background(), previous); err == nil {
+		t.Fatal("a token of the previous key verifies after the grace period")
+	}
+	if _, err := verifier.Verify(context.Background(), current); err != nil {
+		t.Fatalf("a token of the current key is refused: %v", err)
+	}
+	if actions := sink.actions("test"); !reflect.DeepEqual(actions[len(actions)-1:], []AuditAction{AuditKeyPruned}) {
+		t.Fatalf("the retired key got audit events %v", actions)
+	}
+}
+
+func TestRotationGraceKeepsEachRetiredKeyItsOwnDeadline(t *testing.T) {
+	clock := newFakeClock()
+	builder := NewConfigBuilder().WithRotationGrace(time.Hour)
+	builder.config.keys.now = clock.Now
+	config := newTestConfig(t, builder)
+
+	if err := config.Rotate("second"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Minute)
+	if err := config.Rotate("third"); err != nil {
+		t.Fatal(err)
+	}
+	if kids := servedKids(t, config); !reflect.DeepEqual(kids, []string{"second", "test", "third"}) {
+		t.Fatalf("after two rotations the key set holds %v", kids)
+	}
+	clock.Advance(30 * time.Minute)
+	if kids := servedKids(t, config); !reflect.DeepEqual(kids, []string{"second", "third"}) {
+		t.Fatalf("after the first grace period the key set holds %v", kids)
+	}
+	if err := config.RemoveKey("second", false); err != nil {
+		t.Fatalf("a retired key cannot be removed early: %v", err)
+	}
+	if kids := servedKids(t, config); !reflect.DeepEqual(kids, []string{"third"}) {
+		t.Fatalf("after removing the retired key the key set holds %v", kids)
+	}
+}
+
+func TestRotationGraceCannotBeNegative(t *testing.T) {
+	if _, err := NewConfigBuilder().WithRotationGrace(-time.Second).NewPrivateKey().Build(); err == nil {
+		t.Fatal("a negative grace period was accepted")
+	}
+}
@@ -0,0 +1,103 @@
+package gin_jwks_rsa_test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	gin_jwks_rsa "github.com/v4lproik/gin-jwks-rsa"
+	"github.com/v4lproik/gin-jwks-rsa/jwkstest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(t *testing.T, r *gin.Engine, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+// Every handler serving keys, over every kind of key, serves public members only
+func TestHandlersServeNoPrivateMaterial(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		builder func() *gin_jwks_rsa.ConfigBuilder
+		build   func(*gin_jwks_rsa.ConfigBuilder) (*gin_jwks_rsa.Config, error)
+	}{
+		{"RSA", gin_jwks_rsa.NewConfigBuilder, func(b *gin_jwks_rsa.ConfigBuilder) (*gin_jwks_rsa.Config, error) {
+			return b.NewPrivateKey().WithKeyLength(2048).WithKeyId("rsa").Build()
+		}},
+		{"EC", gin_jwks_rsa.NewConfigBuilder, func(b *gin_jwks_rsa.ConfigBuilder) (*gin_jwks_rsa.Config, error) {
+			return b.NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P384).WithKeyId("ec").Build()
+		}},
+		{"Ed25519", gin_jwks_rsa.NewConfigBuilder, func(b *gin_jwks_rsa.ConfigBuilder) (*gin_jwks_rsa.Config, error) {
+			return b.NewPrivateKey().WithKeyType(jwa.OKP).WithCurve(jwa.Ed25519).WithKeyId("ed").Build()
+		}},
+		{"X25519", gin_jwks_rsa.NewConfigBuilder, func(b *gin_jwks_rsa.ConfigBuilder) (*gin_jwks_rsa.Config, error) {
+			return b.NewPrivateKey().WithKeyType(jwa.OKP).WithCurve(jwa.X25519).WithKeyUsage("enc").WithKeyId("x").Build()
+		}},
+		{"encryption key and aliases", func() *gin_jwks_rsa.ConfigBuilder {
+			return gin_jwks_rsa.NewConfigBuilder().
+				WithEncryptionKey(2048).
+				WithPublishedEncryptionKey().
+				WithPublishedKidAliases().
+				WithPublishKeyLifecycle()
+		}, func(b *gin_jwks_rsa.ConfigBuilder) (*gin_jwks_rsa.Config, error) {
+			config, err := b.NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P256).WithKeyId("ec").Build()
+			if err != nil {
+				return nil, err
+			}
+			return config, config.AliasKid("previous", "ec")
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.build(tt.builder().WithKidQueryFilter())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer config.Close()
+
+			r := gin.New()
+			if err = gin_jwks_rsa.RegisterJWKS(r, config); err != nil {
+				t.Fatal(err)
+			}
+			r.GET("/copy", gin_jwks_rsa.Jkws(*config))
+			r.GET("/holder", gin_jwks_rsa.NewConfigHolder(config).Jkws())
+
+			for _, target := range []string{gin_jwks_rsa.DefaultJwksPath, "/copy", "/holder", gin_jwks_rsa.DefaultJwksPath + "?kid=ec"} {
+				w := serve(t, r, target)
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s answered %d", target, w.Code)
+				}
+				jwkstest.AssertNoPrivateMaterial(t, w.Body.Bytes())
+				if _, err = jwk.Parse(w.Body.Bytes()); err != nil {
+					t.Fatalf("%s served an invalid key set: %v", target, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthzAndMetadataServeNoPrivateMaterial(t *testing.T) {
+	config, err := gin_jwks_rsa.NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P256).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	r := gin.New()
+	r.GET("/healthz", gin_jwks_rsa.Healthz(*config))
+	err = gin_jwks_rsa.RegisterJWKS(r, config,
+		gin_jwks_rsa.WithOpenIDConfiguration("https://issuer.example.com", gin_jwks_rsa.MetadataOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/healthz", "/.well-known/openid-configuration"} {
+		w := serve(t, r, target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s answered %d", target, w.Code)
+		}
+		jwkstest.AssertNoPrivateMaterial(t, w.Body.Bytes())
+	}
+}
@@ -0,0 +1,172 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	// Largest key, keystore or certificate file read when none is configured
+	DefaultMaxInputSize int64 = 1 << 20
+	// nesting of a JWK or JWKS, the deepest legitimate member is keys[].oth[]
+	maxJWKJSONDepth = 8
+)
+
+// Refuse key files larger than size bytes, DefaultMaxInputSize otherwise
+func (n *ConfigImportKeyBuilder) WithMaxInputSize(size int64) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.maxInputSize = size
+	return n
+}
+
+// Refuse certificate files larger than size bytes, DefaultMaxInputSize otherwise
+func (n *ConfigImportPublicKeyBuilder) WithMaxInputSize(size int64) *ConfigImportPublicKeyBuilder {
+	n.initiateImportPublicOptsIfNil()
+	n.config.importPubOpts.maxInputSize = size
+	return n
+}
+
+func inputSizeLimit(size int64) int64 {
+	if size <= 0 {
+		return DefaultMaxInputSize
+	}
+	return size
+}
+
+var (
+	// ErrKeyFileNotFound is returned when the private key file does not exist
+	ErrKeyFileNotFound = errors.New("private key file not found")
+	// ErrKeyParse is returned when the private key cannot be parsed
+	ErrKeyParse = errors.New("cannot parse private key")
+	// ErrUnsupportedKeyType is returned for a key of a type or curve which
+	// cannot be served
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+)
+
+// Missing private key file, matches ErrKeyFileNotFound and unwraps to the
+// *fs.PathError naming the file
+type keyFileNotFoundError struct {
+	err error
+}
+
+func (e *keyFileNotFoundError) Error() string {
+	return ErrKeyFileNotFound.Error() + ", " + e.err.Error()
+}
+
+func (e *keyFileNotFoundError) Is(target error) bool {
+	return target == ErrKeyFileNotFound
+}
+
+func (e *keyFileNotFoundError) Unwrap() error {
+	return e.err
+}
+
+// Error opening the private key file, ErrKeyFileNotFound when it does not exist
+func keyFileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return &keyFileNotFoundError{err: err}
+	}
+	return fmt.Errorf("cannot read private key %w", err)
+}
+
+// Read a file, stopping as soon as it goes over limit so a huge or endless
+// file is never held in memory. what names the file in errors.
+func readLimitedFile(path string, limit int64, what string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s %v", what, err)
+	}
+	defer f.Close()
+
+	return readLimited(f, limit, what)
+}
+
+// Read r up to limit bytes, a larger input is an error
+func readLimited(r io.Reader, limit int64, what string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		wipe(data)
+		return nil, fmt.Errorf("cannot read %s %v", what, err)
+	}
+	if int64(len(data)) > limit {
+		wipe(data)
+		return nil, fmt.Errorf("%s is larger than %d bytes", what, limit)
+	}
+	return data, nil
+}
+
+// Read the private key from the one source of opts: a path, bytes or a reader
+func readKeySource(opts ImportKeyOptions) ([]byte, error) {
+	sources := 0
+	for _, set := range []bool{opts.privateKeyPemPath != "", opts.pemBytes != nil, opts.reader != nil, opts.fsys != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources == 0 {
+		return nil, fmt.Errorf("%w, set the path, file system, PEM bytes or reader of the private key", ErrNoKeyConfigured)
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("%w, set exactly one of the path, file system, PEM bytes or reader of the private key, got %d", ErrConflictingKeySources, sources)
+	}
+
+	limit := inputSizeLimit(opts.maxInputSize)
+	switch {
+	case opts.pemBytes != nil:
+		if int64(len(opts.pemBytes)) > limit {
+			return nil, fmt.Errorf("private key is larger than %d bytes", limit)
+		}
+		// the caller may reuse its buffer, the copy gets wiped instead
+		return append([]byte{}, opts.pemBytes...), nil
+	case opts.reader != nil:
+		return readLimited(opts.reader, limit, "private key")
+	case opts.fsys != nil:
+		f, err := opts.fsys.Open(opts.fsPath)
+		if err != nil {
+			return nil, keyFileError(err)
+		}
+		defer f.Close()
+		return readLimited(f, limit, "private key")
+	default:
+		f, err := os.Open(opts.privateKeyPemPath)
+		if err != nil {
+			return nil, keyFileError(err)
+		}
+		defer f.Close()
+		return readLimited(f, limit, "private key")
+	}
+}
+
+// Check the shape of a JWK or JWKS before handing it to jwk, the error gives
+// the byte offset or the member at fault, never the content
+func checkJWKJSON(data []byte) error {
+	if depth := jsonDepth(data); depth > maxJWKJSONDepth {
+		return fmt.Errorf("JWK nested deeper than %d", maxJWKJSONDepth)
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("invalid JSON at byte offset %d", syntaxErr.Offset)
+		}
+		return fmt.Errorf("JWK is not a JSON object")
+	}
+	if keys, ok := members["keys"]; ok {
+		var set []json.RawMessage
+		if err := json.Unmarshal(keys, &set); err != nil {
+			return fmt.Errorf(`"keys" is not an array`)
+		}
+		for i, key := range set {
+			var keyMembers map[string]json.RawMessage
+			if err := json.Unmarshal(key, &keyMembers); err != nil {
+				return fmt.Errorf("keys[%d] is not a JSON object", i)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long a successful round trip vouches for the key it checked
+const healthCheckCacheDuration = 30 * time.Second
+
+// Body of the Healthz answers
+type HealthStatus struct {
+	Status string `json:"status"`
+	// kid of the active key, empty for a merged config
+	KeyID  string `json:"kid,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// set while the signing key is older than the WithKeyAgeAlert threshold,
+	// the config stays ready
+	Warning string `json:"warning,omitempty"`
+}
+
+// Healthz readiness handler proving the active key is usable: it signs a
+// throwaway payload and verifies it against the key set as served, the self-test
+// of WithSelfTest. A success is kept for 30 seconds as long as the active key
+// stays the same, a failure is checked again on the next probe. It answers 200
+// with the active kid, or 503 with the reason, e.g. while an asynchronous
+// generation runs or when a provider cannot sign. A signing key older than the
+// WithKeyAgeAlert threshold is reported as a warning of the 200 answer.
+func Healthz(config Config) gin.HandlerFunc {
+	var mu sync.Mutex
+	var checkedKid string
+	var checkedAt time.Time
+
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		kid, err := config.checkHealth(func(kid string) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return kid == checkedKid && time.Since(checkedAt) < healthCheckCacheDuration
+		})
+		if err != nil {
+			c.Error(err)
+			config.abortWithJSON(c, http.StatusServiceUnavailable, &HealthStatus{Status: "unavailable", Reason: err.Error()})
+			return
+		}
+
+		mu.Lock()
+		if kid != checkedKid || time.Since(checkedAt) >= healthCheckCacheDuration {
+			checkedKid, checkedAt = kid, time.Now()
+		}
+		mu.Unlock()
+		config.writeJSON(c, http.StatusOK, &HealthStatus{Status: "ok", KeyID: kid, Warning: config.keyAgeWarning(time.Now())})
+	}
+}
+
+// Run the self-test of the active key unless cached reports it passed, every
+// ready config of a merged one is checked
+func (c *Config) checkHealth(cached func(kid string) bool) (string, error) {
+	if err := c.Ready(); err != nil {
+		return "", err
+	}
+	if c.merged != nil {
+		if cached("") {
+			return "", nil
+		}
+		for _, config := range c.merged {
+			if config.Ready() != nil {
+				continue
+			}
+			if err := config.selfTestKey(config.active()); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+
+	active := c.active()
+	if active == nil {
+		return "", ErrNoServableKey
+	}
+	kid := active.key.KeyID()
+	if cached(kid) {
+		return kid, nil
+	}
+	return kid, c.selfTestKey(active)
+}
@@ -0,0 +1,81 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func probeHealthz(t *testing.T, handler gin.HandlerFunc) (int, HealthStatus) {
+	t.Helper()
+	r := gin.New()
+	r.GET("/healthz", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Fatalf("Cache-Control is %q", cacheControl)
+	}
+	var status HealthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("cannot decode %q %v", w.Body, err)
+	}
+	return w.Code, status
+}
+
+func TestHealthz(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	handler := Healthz(*config)
+
+	code, status := probeHealthz(t, handler)
+	if code != http.StatusOK || status != (HealthStatus{Status: "ok", KeyID: "test"}) {
+		t.Fatalf("healthy config answered %d %+v", code, status)
+	}
+
+	// the cached success belongs to the previous kid, the new one is checked
+	if err := config.Rotate("second"); err != nil {
+		t.Fatal(err)
+	}
+	code, status = probeHealthz(t, handler)
+	if code != http.StatusOK || status.KeyID != "second" {
+		t.Fatalf("rotated config answered %d %+v", code, status)
+	}
+
+	config.setActive(nil)
+	config.keySetChanged()
+	code, status = probeHealthz(t, handler)
+	if code != http.StatusServiceUnavailable || status.Status != "unavailable" || status.Reason != ErrNoServableKey.Error() {
+		t.Fatalf("config without key answered %d %+v", code, status)
+	}
+}
+
+func TestHealthzBeforeBuild(t *testing.T) {
+	code, status := probeHealthz(t, Healthz(Config{}))
+	if code != http.StatusServiceUnavailable || status.Reason == "" {
+		t.Fatalf("config never built answered %d %+v", code, status)
+	}
+}
+
+func TestHealthzWarnsAboutAnOldKey(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithKeyAgeAlert(time.Hour, func(KeyAgeEvent) {}))
+	handler := Healthz(*config)
+	if _, status := probeHealthz(t, handler); status.Warning != "" {
+		t.Fatalf("fresh key reported %q", status.Warning)
+	}
+
+	for age, threshold := range map[time.Duration]string{
+		90 * time.Minute: "1h0m0s",
+		3 * time.Hour:    "2h0m0s",
+	} {
+		aged := *config.active()
+		aged.createdAt = time.Now().Add(-age)
+		config.setActive(&aged)
+		code, status := probeHealthz(t, handler)
+		if code != http.StatusOK || !strings.HasSuffix(status.Warning, "older than "+threshold) {
+			t.Fatalf("key %s old answered %d %+v", age, code, status)
+		}
+	}
+}
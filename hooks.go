@@ -0,0 +1,60 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// ServeStats describes a request answered by the key set handler
+type ServeStats struct {
+	Status   int
+	Duration time.Duration
+	// body bytes written, 0 for a 304
+	Size      int
+	UserAgent string
+}
+
+// Call hook once the key set handler answered a request, e.g. to count the
+// fetches per user agent
+func (n *ConfigBuilder) OnServe(hook func(c *gin.Context, stats ServeStats)) *ConfigBuilder {
+	n.config.onServe = hook
+	return n
+}
+
+// Call hook once the signing key was replaced, by a rotation or a reload of
+// the key file
+func (n *ConfigBuilder) OnRotate(hook func(oldKid, newKid string)) *ConfigBuilder {
+	n.config.onRotate = hook
+	return n
+}
+
+// Run a hook of the caller synchronously. A panicking hook is reported through
+// OnError and leaves the request or the operation in progress alone.
+func (c *Config) runHook(name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil && name != "OnError" {
+			c.reportError(fmt.Errorf("%s hook panicked: %v", name, r))
+		}
+	}()
+	hook()
+}
+
+// Report a request answered by the key set handler
+func (c *Config) served(ctx *gin.Context, start time.Time) {
+	if c.onServe == nil {
+		return
+	}
+	stats := ServeStats{
+		Status:    ctx.Writer.Status(),
+		Duration:  time.Since(start),
+		Size:      ctx.Writer.Size(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+	if stats.Size < 0 {
+		stats.Size = 0
+	}
+	c.runHook("OnServe", func() {
+		c.onServe(ctx, stats)
+	})
+}
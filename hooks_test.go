@@ -0,0 +1,88 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOnServe(t *testing.T) {
+	var stats []ServeStats
+	config := newTestConfig(t, NewConfigBuilder().OnServe(func(_ *gin.Context, s ServeStats) {
+		stats = append(stats, s)
+	}))
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/jwks", nil)
+		req.Header.Set("User-Agent", "fetcher/1.0")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	get(w.Header().Get("ETag"))
+	if len(stats) != 2 {
+		t.Fatalf("hook called %d times for 2 requests", len(stats))
+	}
+	if stats[0].Status != http.StatusOK || stats[0].Size != w.Body.Len() || stats[0].UserAgent != "fetcher/1.0" || stats[0].Duration <= 0 {
+		t.Fatalf("first request reported %+v", stats[0])
+	}
+	if stats[1].Status != http.StatusNotModified || stats[1].Size != 0 {
+		t.Fatalf("revalidation reported %+v", stats[1])
+	}
+}
+
+func TestOnRotate(t *testing.T) {
+	type rotation struct{ oldKid, newKid string }
+	var rotations []rotation
+	config := newTestConfig(t, NewConfigBuilder().OnRotate(func(oldKid, newKid string) {
+		rotations = append(rotations, rotation{oldKid, newKid})
+	}))
+
+	if err := config.Rotate("second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Rotate("third"); err != nil {
+		t.Fatal(err)
+	}
+	if len(rotations) != 2 || rotations[0] != (rotation{"test", "second"}) || rotations[1] != (rotation{"second", "third"}) {
+		t.Fatalf("rotations %v", rotations)
+	}
+
+	// a refused rotation is not reported
+	if err := config.Rotate("third"); err == nil {
+		t.Fatal("rotated to the kid in use")
+	}
+	if len(rotations) != 2 {
+		t.Fatalf("rotations %v", rotations)
+	}
+}
+
+// A panicking hook goes to OnError and leaves the request and the rotation alone
+func TestPanickingHooks(t *testing.T) {
+	var reported []error
+	config := newTestConfig(t, NewConfigBuilder().
+		OnError(func(err error) { reported = append(reported, err) }).
+		OnServe(func(*gin.Context, ServeStats) { panic("serve") }).
+		OnRotate(func(string, string) { panic("rotate") }))
+
+	if w := serveJWKS(Jkws(*config)); w.Code != http.StatusOK {
+		t.Fatalf("key set answered %d", w.Code)
+	}
+	if err := config.Rotate("rotated"); err != nil {
+		t.Fatal(err)
+	}
+	if config.active().key.KeyID() != "rotated" {
+		t.Fatal("key not rotated")
+	}
+	if len(reported) != 2 || !strings.Contains(reported[0].Error(), "OnServe") || !strings.Contains(reported[1].Error(), "OnRotate") {
+		t.Fatalf("reported %v", reported)
+	}
+}
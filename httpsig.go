@@ -0,0 +1,742 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC 9421 headers and context key
+const (
+	SignatureInputHeader = "Signature-Input"
+	SignatureHeader      = "Signature"
+	ContentDigestHeader  = "Content-Digest"
+	// gin context key holding the keyid of a verified HTTP message signature
+	HTTPSignatureKeyIDContextKey = "gin-jwks-http-signature-keyid"
+)
+
+// RFC 9421 algorithm names and the matching JWS algorithms, the signature
+// bytes are the same in both
+var httpSignatureAlgorithms = map[string]jwa.SignatureAlgorithm{
+	"rsa-pss-sha512":    jwa.PS512,
+	"rsa-v1_5-sha256":   jwa.RS256,
+	"ecdsa-p256-sha256": jwa.ES256,
+	"ecdsa-p384-sha384": jwa.ES384,
+	"ed25519":           jwa.EdDSA,
+}
+
+// Covered components when HTTPSigner is given none
+var defaultHTTPSignatureComponents = []string{"@method", "@target-uri", "content-digest"}
+
+// HTTPSigner signs outgoing requests with the key of a config, RFC 9421
+type HTTPSigner struct {
+	config     *Config
+	components []string
+	label      string
+	validity   time.Duration
+}
+
+// HTTPSigner returns a signer covering components, @method, @target-uri and
+// content-digest by default. Header names are given in lower case.
+func (c *Config) HTTPSigner(components ...string) *HTTPSigner {
+	if len(components) == 0 {
+		components = defaultHTTPSignatureComponents
+	}
+	return &HTTPSigner{
+		config:     c,
+		components: append([]string{}, components...),
+		label:      "sig1",
+	}
+}
+
+// Label of the signature in the Signature and Signature-Input dictionaries
+func (s *HTTPSigner) WithLabel(label string) *HTTPSigner {
+	s.label = label
+	return s
+}
+
+// Set the expires parameter validity after created
+func (s *HTTPSigner) WithValidity(validity time.Duration) *HTTPSigner {
+	s.validity = validity
+	return s
+}
+
+// Sign adds the Signature-Input and Signature headers to req, and the
+// Content-Digest header when it is covered and not already set
+func (s *HTTPSigner) Sign(req *http.Request) error {
+	active, err := s.config.signingSnapshot()
+	if err != nil {
+		return err
+	}
+	for _, name := range s.components {
+		if name == "content-digest" && req.Header.Get(ContentDigestHeader) == "" {
+			body, err := readRequestBody(req)
+			if err != nil {
+				return err
+			}
+			req.Header.Set(ContentDigestHeader, contentDigest(body))
+		}
+	}
+
+	alg, err := signatureAlgorithm(active.key)
+	if err != nil {
+		return err
+	}
+	keyID, err := sfString(active.key.KeyID())
+	if err != nil {
+		return fmt.Errorf("cannot use kid as keyid %v", err)
+	}
+
+	var params strings.Builder
+	params.WriteString("(")
+	for i, name := range s.components {
+		if i > 0 {
+			params.WriteString(" ")
+		}
+		quoted, err := sfString(name)
+		if err != nil {
+			return err
+		}
+		params.WriteString(quoted)
+	}
+	created := time.Now().Unix()
+	fmt.Fprintf(&params, ");created=%d", created)
+	if s.validity > 0 {
+		fmt.Fprintf(&params, ";expires=%d", created+int64(s.validity/time.Second))
+	}
+	fmt.Fprintf(&params, ";keyid=%s", keyID)
+	for name, a := range httpSignatureAlgorithms {
+		if a == alg {
+			fmt.Fprintf(&params, ";alg=%q", name)
+		}
+	}
+
+	base, err := signatureBase(req, s.components, params.String())
+	if err != nil {
+		return err
+	}
+	signature, err := active.signBytes(alg, []byte(base))
+	if err != nil {
+		return fmt.Errorf("cannot sign request %v", err)
+	}
+
+	req.Header.Set(SignatureInputHeader, s.label+"="+params.String())
+	req.Header.Set(SignatureHeader, s.label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// HTTPSignatureKeyResolver returns the public key behind the keyid of a signature
+type HTTPSignatureKeyResolver interface {
+	ResolveHTTPSignatureKey(ctx context.Context, keyID string) (jwk.Key, error)
+}
+
+// Function implementing HTTPSignatureKeyResolver
+type HTTPSignatureKeyResolverFunc func(ctx context.Context, keyID string) (jwk.Key, error)
+
+func (f HTTPSignatureKeyResolverFunc) ResolveHTTPSignatureKey(ctx context.Context, keyID string) (jwk.Key, error) {
+	return f(ctx, keyID)
+}
+
+// HTTPSignatureKeyResolver resolves keyid against the keys of the config
+// and their aliases
+func (c *Config) HTTPSignatureKeyResolver() HTTPSignatureKeyResolver {
+	return HTTPSignatureKeyResolverFunc(func(_ context.Context, keyID string) (jwk.Key, error) {
+		set, err := c.verificationKeySet()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := set.LookupKeyID(keyID)
+		if !ok {
+			return nil, fmt.Errorf("unknown keyid %q", keyID)
+		}
+		return key, nil
+	})
+}
+
+// RemoteHTTPSignatureKeyResolver resolves keyid against the JWKS served at
+// jwksURL. The set is fetched again when a keyid is unknown, at most once
+// every minRefresh.
+func RemoteHTTPSignatureKeyResolver(jwksURL string, minRefresh time.Duration) HTTPSignatureKeyResolver {
+	var (
+		mu        sync.Mutex
+		set       jwk.Set
+		fetchedAt time.Time
+	)
+	return HTTPSignatureKeyResolverFunc(func(ctx context.Context, keyID string) (jwk.Key, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if set != nil {
+			if key, ok := set.LookupKeyID(keyID); ok {
+				return key, nil
+			}
+		}
+		if set != nil && time.Since(fetchedAt) < minRefresh {
+			return nil, fmt.Errorf("unknown keyid %q", keyID)
+		}
+
+		fetched, err := jwk.Fetch(ctx, jwksURL)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch %s %v", jwksURL, err)
+		}
+		set, fetchedAt = fetched, time.Now()
+		if key, ok := set.LookupKeyID(keyID); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown keyid %q", keyID)
+	})
+}
+
+type httpSignatureOptions struct {
+	label              string
+	requiredComponents []string
+	skew               time.Duration
+	maxAge             time.Duration
+}
+
+// Option of VerifyHTTPSignature
+type HTTPSignatureOption func(*httpSignatureOptions)
+
+// Verify the signature under label instead of the first one
+func WithSignatureLabel(label string) HTTPSignatureOption {
+	return func(o *httpSignatureOptions) {
+		o.label = label
+	}
+}
+
+// Components every signature must cover, in addition to @method, @target-uri
+// and content-digest for requests with a body
+func WithRequiredComponents(components ...string) HTTPSignatureOption {
+	return func(o *httpSignatureOptions) {
+		o.requiredComponents = append(o.requiredComponents, components...)
+	}
+}
+
+// Tolerate clock differences when checking created and expires
+func WithSignatureClockSkew(skew time.Duration) HTTPSignatureOption {
+	return func(o *httpSignatureOptions) {
+		o.skew = skew
+	}
+}
+
+// Refuse signatures created more than maxAge ago, even when they do not expire
+func WithSignatureMaxAge(maxAge time.Duration) HTTPSignatureOption {
+	return func(o *httpSignatureOptions) {
+		o.maxAge = maxAge
+	}
+}
+
+// VerifyHTTPSignature middleware checking the RFC 9421 signature of inbound
+// requests, the keyid is stored under HTTPSignatureKeyIDContextKey
+func VerifyHTTPSignature(resolver HTTPSignatureKeyResolver, opts ...HTTPSignatureOption) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID, err := VerifyHTTPRequestSignature(c.Request, resolver, opts...)
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(HTTPSignatureKeyIDContextKey, keyID)
+		c.Next()
+	}
+}
+
+// VerifyHTTPRequestSignature checks the RFC 9421 signature of r and returns its keyid
+func VerifyHTTPRequestSignature(r *http.Request, resolver HTTPSignatureKeyResolver, opts ...HTTPSignatureOption) (string, error) {
+	o := httpSignatureOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	inputs, err := parseDictionary(strings.Join(r.Header.Values(SignatureInputHeader), ", "))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s header %v", SignatureInputHeader, err)
+	}
+	signatures, err := parseDictionary(strings.Join(r.Header.Values(SignatureHeader), ", "))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s header %v", SignatureHeader, err)
+	}
+	label := o.label
+	if label == "" && len(inputs) > 0 {
+		label = inputs[0].name
+	}
+	rawInput, ok := dictionaryValue(inputs, label)
+	if !ok {
+		return "", fmt.Errorf("no signature input %q", label)
+	}
+	rawSignature, ok := dictionaryValue(signatures, label)
+	if !ok || len(rawSignature) < 2 || rawSignature[0] != ':' || rawSignature[len(rawSignature)-1] != ':' {
+		return "", fmt.Errorf("no signature %q", label)
+	}
+	signature, err := base64.StdEncoding.DecodeString(rawSignature[1 : len(rawSignature)-1])
+	if err != nil {
+		return "", fmt.Errorf("cannot decode signature %v", err)
+	}
+
+	input, err := parseSignatureInput(rawInput)
+	if err != nil {
+		return "", err
+	}
+
+	required := append([]string{"@method", "@target-uri"}, o.requiredComponents...)
+	if r.ContentLength != 0 {
+		required = append(required, "content-digest")
+	}
+	for _, name := range required {
+		if !input.covers(name) {
+			return "", fmt.Errorf("signature does not cover %q", name)
+		}
+	}
+
+	now := time.Now().Unix()
+	skew := int64(o.skew / time.Second)
+	if input.created == nil {
+		return "", fmt.Errorf("signature has no created parameter")
+	}
+	if *input.created > now+skew {
+		return "", fmt.Errorf("signature created in the future")
+	}
+	if input.expires != nil && *input.expires < now-skew {
+		return "", fmt.Errorf("signature expired")
+	}
+	if o.maxAge > 0 && *input.created < now-skew-int64(o.maxAge/time.Second) {
+		return "", fmt.Errorf("signature older than %s", o.maxAge)
+	}
+
+	if input.covers("content-digest") {
+		body, err := readRequestBody(r)
+		if err != nil {
+			return "", err
+		}
+		if err = checkContentDigest(r.Header.Get(ContentDigestHeader), body); err != nil {
+			return "", err
+		}
+	}
+
+	if input.keyID == "" {
+		return "", fmt.Errorf("signature has no keyid parameter")
+	}
+	key, err := resolver.ResolveHTTPSignatureKey(r.Context(), input.keyID)
+	if err != nil {
+		return "", err
+	}
+	alg, err := httpSignatureAlgorithm(input.alg, key)
+	if err != nil {
+		return "", err
+	}
+
+	base, err := signatureBase(r, input.components, rawInput)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := jws.NewVerifier(alg)
+	if err != nil {
+		return "", fmt.Errorf("cannot create verifier %v", err)
+	}
+	if err = verifier.Verify([]byte(base), signature, key); err != nil {
+		return "", fmt.Errorf("invalid signature %v", err)
+	}
+
+	return input.keyID, nil
+}
+
+// Algorithm to verify with: the alg parameter when present, which must then
+// agree with the key, the alg of the key otherwise
+func httpSignatureAlgorithm(name string, key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	var keyAlg jwa.SignatureAlgorithm
+	if a := key.Algorithm(); a != nil {
+		keyAlg = jwa.SignatureAlgorithm(a.String())
+	}
+	if name == "" {
+		if keyAlg == "" {
+			return "", fmt.Errorf("signature has no alg parameter and the key has no alg")
+		}
+		return keyAlg, nil
+	}
+
+	alg, ok := httpSignatureAlgorithms[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported signature algorithm %q", name)
+	}
+	if keyAlg != "" && keyAlg != alg {
+		return "", fmt.Errorf("signature algorithm %q does not match the key algorithm %s", name, keyAlg)
+	}
+	return alg, nil
+}
+
+// RFC 9421 section 2.5 signature base, params is the serialized inner list of
+// the signature input
+func signatureBase(r *http.Request, components []string, params string) (string, error) {
+	var base strings.Builder
+	seen := map[string]struct{}{}
+	for _, name := range components {
+		if _, ok := seen[name]; ok {
+			return "", fmt.Errorf("component %q covered twice", name)
+		}
+		seen[name] = struct{}{}
+
+		quoted, err := sfString(name)
+		if err != nil {
+			return "", err
+		}
+		value, err := componentValue(r, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&base, "%s: %s\n", quoted, value)
+	}
+	fmt.Fprintf(&base, "\"@signature-params\": %s", params)
+	return base.String(), nil
+}
+
+// Canonical value of a derived component or of a header field
+func componentValue(r *http.Request, name string) (string, error) {
+	if name != strings.ToLower(name) {
+		return "", fmt.Errorf("component %q must be lower case", name)
+	}
+
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@target-uri":
+		return requestScheme(r) + "://" + requestAuthority(r) + r.URL.RequestURI(), nil
+	case "@authority":
+		return requestAuthority(r), nil
+	case "@scheme":
+		return requestScheme(r), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if path := r.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("unsupported derived component %q", name)
+	}
+
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("covered header %q is missing", name)
+	}
+	for i, value := range values {
+		values[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// Scheme of an outgoing request or of a request received by a server
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func requestAuthority(r *http.Request) string {
+	if r.Host != "" {
+		return strings.ToLower(r.Host)
+	}
+	return strings.ToLower(r.URL.Host)
+}
+
+// Read the body and put it back so it can be read again
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read request body %v", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+// RFC 9530 Content-Digest of body
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// Check every sha-256 and sha-512 digest of the header, at least one is required
+func checkContentDigest(header string, body []byte) error {
+	digests, err := parseDictionary(header)
+	if err != nil {
+		return fmt.Errorf("invalid %s header %v", ContentDigestHeader, err)
+	}
+
+	checked := false
+	for _, digest := range digests {
+		var sum []byte
+		switch digest.name {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		value := digest.value
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return fmt.Errorf("invalid %s digest", digest.name)
+		}
+		expected, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil || subtle.ConstantTimeCompare(expected, sum) != 1 {
+			return fmt.Errorf("%s digest does not match the body", digest.name)
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("no supported digest in %s", ContentDigestHeader)
+	}
+	return nil
+}
+
+// Parsed RFC 9421 signature input
+type signatureInput struct {
+	components []string
+	created    *int64
+	expires    *int64
+	keyID      string
+	alg        string
+}
+
+func (s signatureInput) covers(name string) bool {
+	for _, component := range s.components {
+		if component == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the inner list of a Signature-Input member, component parameters such
+// as ;sf or ;key are not supported
+func parseSignatureInput(raw string) (signatureInput, error) {
+	var res signatureInput
+	p := &sfParser{s: raw}
+	if !p.consume('(') {
+		return res, fmt.Errorf("signature input is not an inner list")
+	}
+	for {
+		p.skipSpaces()
+		if p.consume(')') {
+			break
+		}
+		name, err := p.parseString()
+		if err != nil {
+			return res, fmt.Errorf("invalid covered component %v", err)
+		}
+		if p.peek() == ';' {
+			return res, fmt.Errorf("component parameters are not supported")
+		}
+		res.components = append(res.components, name)
+	}
+
+	for p.consume(';') {
+		key := p.parseKey()
+		if !p.consume('=') {
+			return res, fmt.Errorf("parameter %q has no value", key)
+		}
+		switch key {
+		case "created", "expires":
+			n, err := p.parseInteger()
+			if err != nil {
+				return res, fmt.Errorf("invalid %s parameter %v", key, err)
+			}
+			if key == "created" {
+				res.created = &n
+			} else {
+				res.expires = &n
+			}
+		case "keyid", "alg", "nonce", "tag":
+			s, err := p.parseString()
+			if err != nil {
+				return res, fmt.Errorf("invalid %s parameter %v", key, err)
+			}
+			if key == "keyid" {
+				res.keyID = s
+			} else if key == "alg" {
+				res.alg = s
+			}
+		default:
+			return res, fmt.Errorf("unsupported signature parameter %q", key)
+		}
+	}
+	if p.i != len(p.s) {
+		return res, fmt.Errorf("trailing characters in signature input")
+	}
+
+	return res, nil
+}
+
+// Member of a structured field dictionary, value is kept serialized
+type dictionaryMember struct {
+	name  string
+	value string
+}
+
+// Split an RFC 8941 dictionary into its members
+func parseDictionary(header string) ([]dictionaryMember, error) {
+	var members []dictionaryMember
+	p := &sfParser{s: header}
+	for {
+		p.skipSpaces()
+		if p.i == len(p.s) {
+			return members, nil
+		}
+		name := p.parseKey()
+		if name == "" || !p.consume('=') {
+			return nil, fmt.Errorf("invalid dictionary member")
+		}
+		start := p.i
+		inString, depth := false, 0
+		for ; p.i < len(p.s); p.i++ {
+			b := p.s[p.i]
+			switch {
+			case inString && b == '\\':
+				p.i++
+			case b == '"':
+				inString = !inString
+			case inString:
+			case b == '(':
+				depth++
+			case b == ')':
+				depth--
+			}
+			if !inString && depth == 0 && b == ',' {
+				break
+			}
+		}
+		members = append(members, dictionaryMember{name: name, value: strings.TrimSpace(p.s[start:p.i])})
+		p.consume(',')
+	}
+}
+
+func dictionaryValue(members []dictionaryMember, name string) (string, bool) {
+	// the last occurrence wins, RFC 8941 section 4.2.2
+	for i := len(members) - 1; i >= 0; i-- {
+		if members[i].name == name {
+			return members[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Just enough of an RFC 8941 parser for signature inputs
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) peek() byte {
+	if p.i < len(p.s) {
+		return p.s[p.i]
+	}
+	return 0
+}
+
+func (p *sfParser) consume(b byte) bool {
+	if p.peek() == b && p.i < len(p.s) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *sfParser) skipSpaces() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.i++
+	}
+}
+
+func (p *sfParser) parseKey() string {
+	start := p.i
+	for ; p.i < len(p.s); p.i++ {
+		b := p.s[p.i]
+		if !(b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '_' || b == '-' || b == '.' || b == '*') {
+			break
+		}
+	}
+	return p.s[start:p.i]
+}
+
+func (p *sfParser) parseInteger() (int64, error) {
+	start := p.i
+	p.consume('-')
+	for p.peek() >= '0' && p.peek() <= '9' {
+		p.i++
+	}
+	return strconv.ParseInt(p.s[start:p.i], 10, 64)
+}
+
+func (p *sfParser) parseString() (string, error) {
+	if !p.consume('"') {
+		return "", fmt.Errorf("expected a string")
+	}
+	var res strings.Builder
+	for p.i < len(p.s) {
+		b := p.s[p.i]
+		p.i++
+		switch {
+		case b == '\\':
+			if p.i == len(p.s) || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+				return "", fmt.Errorf("invalid escape")
+			}
+			res.WriteByte(p.s[p.i])
+			p.i++
+		case b == '"':
+			return res.String(), nil
+		case b < 0x20 || b > 0x7e:
+			return "", fmt.Errorf("invalid character in string")
+		default:
+			res.WriteByte(b)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// Serialize s as an RFC 8941 string
+func sfString(s string) (string, error) {
+	var res strings.Builder
+	res.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b < 0x20 || b > 0x7e {
+			return "", fmt.Errorf("%q holds characters a structured field string cannot", s)
+		}
+		if b == '"' || b == '\\' {
+			res.WriteByte('\\')
+		}
+		res.WriteByte(b)
+	}
+	res.WriteByte('"')
+	return res.String(), nil
+}
@@ -0,0 +1,244 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 9421 appendix B.1.4 test-key-ed25519, PKCS#8 without the PEM armor
+const rfc9421Ed25519Key = "MC4CAQAwBQYDK2VwBCIEIJ+DYvh6SEqVTm50DFtMDoQikTmiCqirVv9mWG9qfSnF"
+
+// RFC 9421 appendix B.2 test request
+func rfc9421Request() *http.Request {
+	body := `{"hello": "world"}`
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(body))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ContentDigestHeader, "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	req.Header.Set("Content-Length", "18")
+	return req
+}
+
+func rfc9421Ed25519(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	der, err := base64.StdEncoding.DecodeString(rfc9421Ed25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.(ed25519.PrivateKey)
+}
+
+// Appendix B.2.6, ed25519 signatures are deterministic so the signature
+// checks the signature base byte for byte
+func TestSignatureBaseMatchesRFC9421Example(t *testing.T) {
+	const (
+		input     = `("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`
+		signature = "wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw=="
+		expected  = `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@method": POST
+"@path": /foo
+"@authority": example.com
+"content-type": application/json
+"content-length": 18
+"@signature-params": ` + input
+	)
+	parsed, err := parseSignatureInput(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.keyID != "test-key-ed25519" || parsed.created == nil || *parsed.created != 1618884473 {
+		t.Fatalf("signature input parsed as %+v", parsed)
+	}
+	base, err := signatureBase(rfc9421Request(), parsed.components, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != expected {
+		t.Fatalf("signature base\n%s\nwant\n%s", base, expected)
+	}
+	if got := base64.StdEncoding.EncodeToString(ed25519.Sign(rfc9421Ed25519(t), []byte(base))); got != signature {
+		t.Fatalf("signature %s, want %s", got, signature)
+	}
+
+	// the example leaves the query out, which the verifier refuses by requiring @target-uri
+	req := rfc9421Request()
+	req.Header.Set(SignatureInputHeader, "sig-b26="+input)
+	req.Header.Set(SignatureHeader, "sig-b26=:"+signature+":")
+	public, err := jwk.FromRaw(rfc9421Ed25519(t).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := HTTPSignatureKeyResolverFunc(func(ctx context.Context, keyID string) (jwk.Key, error) {
+		return public, nil
+	})
+	if _, err = VerifyHTTPRequestSignature(req, resolver); err == nil || !strings.Contains(err.Error(), "@target-uri") {
+		t.Fatalf("example without @target-uri: %v", err)
+	}
+}
+
+func TestContentDigestMatchesRFCExamples(t *testing.T) {
+	body := []byte(`{"hello": "world"}`)
+	// RFC 9530 section 2
+	if got := contentDigest(body); got != "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:" {
+		t.Fatalf("content digest %s", got)
+	}
+	// RFC 9421 appendix B.2
+	if err := checkContentDigest(rfc9421Request().Header.Get(ContentDigestHeader), body); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkContentDigest(rfc9421Request().Header.Get(ContentDigestHeader), []byte(`{"hello": "there"}`)); err == nil {
+		t.Fatal("digest of another body accepted")
+	}
+}
+
+func TestHTTPSignatureRoundTrip(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	r := gin.New()
+	r.POST("/hook", VerifyHTTPSignature(config.HTTPSignatureKeyResolver()), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(HTTPSignatureKeyIDContextKey))
+	})
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/hook?event=push", strings.NewReader(`{"hello": "world"}`))
+		if err := config.HTTPSigner().WithValidity(time.Minute).Sign(req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	if w := send(newRequest()); w.Code != http.StatusOK || w.Body.String() != "test" {
+		t.Fatalf("signed request answered %d %s", w.Code, w.Body)
+	}
+	for name, tamper := range map[string]func(*http.Request){
+		"body": func(req *http.Request) {
+			req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"hello": "there"}`)).Body
+		},
+		"query":  func(req *http.Request) { req.URL.RawQuery = "event=delete" },
+		"digest": func(req *http.Request) { req.Header.Set(ContentDigestHeader, contentDigest(nil)) },
+		"signature": func(req *http.Request) {
+			req.Header.Set(SignatureHeader, "sig1=:"+base64.StdEncoding.EncodeToString(make([]byte, 256))+":")
+		},
+		"unsigned": func(req *http.Request) { req.Header.Del(SignatureHeader) },
+		"other label": func(req *http.Request) {
+			req.Header.Set(SignatureInputHeader, strings.Replace(req.Header.Get(SignatureInputHeader), "sig1", "sig2", 1))
+		},
+	} {
+		req := newRequest()
+		tamper(req)
+		if w := send(req); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request with another %s answered %d", name, w.Code)
+		}
+	}
+}
+
+// Sign req with the config key under hand written signature parameters
+func signWithParams(t *testing.T, config *Config, req *http.Request, params string) {
+	t.Helper()
+	input, err := parseSignatureInput(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := signatureBase(req, input.components, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jws.NewSigner(jwa.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signature []byte
+	err = config.active().withPrivateKey(func(key jwk.Key) (err error) {
+		signature, err = signer.Sign([]byte(base), key)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(SignatureInputHeader, "sig1="+params)
+	req.Header.Set(SignatureHeader, "sig1=:"+base64.StdEncoding.EncodeToString(signature)+":")
+}
+
+func TestHTTPSignatureTimeParameters(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	now := time.Now().Unix()
+	components := `("@method" "@target-uri")`
+	for name, tt := range map[string]struct {
+		params string
+		opts   []HTTPSignatureOption
+		valid  bool
+	}{
+		"fresh":                 {fmt.Sprintf("%s;created=%d;keyid=\"test\"", components, now), nil, true},
+		"no created":            {fmt.Sprintf("%s;keyid=\"test\"", components), nil, false},
+		"expired":               {fmt.Sprintf("%s;created=%d;expires=%d;keyid=\"test\"", components, now-120, now-60), nil, false},
+		"expired within skew":   {fmt.Sprintf("%s;created=%d;expires=%d;keyid=\"test\"", components, now-120, now-60), []HTTPSignatureOption{WithSignatureClockSkew(2 * time.Minute)}, true},
+		"created in the future": {fmt.Sprintf("%s;created=%d;keyid=\"test\"", components, now+60), nil, false},
+		"future within skew":    {fmt.Sprintf("%s;created=%d;keyid=\"test\"", components, now+60), []HTTPSignatureOption{WithSignatureClockSkew(2 * time.Minute)}, true},
+		"older than max age":    {fmt.Sprintf("%s;created=%d;keyid=\"test\"", components, now-600), []HTTPSignatureOption{WithSignatureMaxAge(5 * time.Minute)}, false},
+		"within max age":        {fmt.Sprintf("%s;created=%d;keyid=\"test\"", components, now-60), []HTTPSignatureOption{WithSignatureMaxAge(5 * time.Minute)}, true},
+		"missing required":      {fmt.Sprintf("%s;created=%d;keyid=\"test\"", components, now), []HTTPSignatureOption{WithRequiredComponents("date")}, false},
+		"alg of another key":    {fmt.Sprintf("%s;created=%d;keyid=\"test\";alg=\"ed25519\"", components, now), nil, false},
+		"unknown keyid":         {fmt.Sprintf("%s;created=%d;keyid=\"other\"", components, now), nil, false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/hook", nil)
+		signWithParams(t, config, req, tt.params)
+		keyID, err := VerifyHTTPRequestSignature(req, config.HTTPSignatureKeyResolver(), tt.opts...)
+		if tt.valid && (err != nil || keyID != "test") {
+			t.Fatalf("%s: refused %v", name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%s: accepted", name)
+		}
+	}
+}
+
+func TestComponentCanonicalization(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://Example.COM/a%20b?x=1", nil)
+	req.Header.Add("X-Multi", " one ")
+	req.Header.Add("X-Multi", "two\t")
+	for name, expected := range map[string]string{
+		"@method":         "GET",
+		"@target-uri":     "http://example.com/a%20b?x=1",
+		"@authority":      "example.com",
+		"@scheme":         "http",
+		"@request-target": "/a%20b?x=1",
+		"@path":           "/a%20b",
+		"@query":          "?x=1",
+		"x-multi":         "one, two",
+	} {
+		got, err := componentValue(req, name)
+		if err != nil || got != expected {
+			t.Fatalf("%s: %q %v, want %q", name, got, err, expected)
+		}
+	}
+	for _, name := range []string{"X-Multi", "@status", "x-missing"} {
+		if _, err := componentValue(req, name); err == nil {
+			t.Fatalf("component %s accepted", name)
+		}
+	}
+	if _, err := parseSignatureInput(`("@query-param";name="Pet");created=1`); err == nil {
+		t.Fatal("component parameters accepted")
+	}
+	if _, err := signatureBase(req, []string{"@method", "@method"}, "()"); err == nil {
+		t.Fatal("component covered twice accepted")
+	}
+}
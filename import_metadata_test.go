@@ -0,0 +1,176 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Private JWK carrying kid, use and alg, along with the members its public
+// key is expected to be published with
+func newAnnotatedJWK(t *testing.T) (jwk.Key, map[string]interface{}) {
+	t.Helper()
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]interface{}{
+		jwk.KeyIDKey:     "external-kid",
+		jwk.KeyUsageKey:  "sig",
+		jwk.AlgorithmKey: jwa.PS384,
+	} {
+		if err = key.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	public, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members map[string]interface{}
+	if err = json.Unmarshal(body, &members); err != nil {
+		t.Fatal(err)
+	}
+	return key, members
+}
+
+// Write the JSON form of v, a JWK or a JWKS, to a file
+func writeJWKFile(t *testing.T, v interface{}) string {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err = os.WriteFile(path, body, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Members of the only key Jkws serves for config
+func servedMetadata(t *testing.T, config *Config) map[string]interface{} {
+	t.Helper()
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil || len(set.Keys) != 1 {
+		t.Fatalf("served %s: %v", w.Body, err)
+	}
+	return set.Keys[0]
+}
+
+func TestImportedMetadataIsPublishedUnchanged(t *testing.T) {
+	key, members := newAnnotatedJWK(t)
+	// the self-test signs with the imported alg
+	config, err := NewConfigBuilder().WithSelfTest().ImportPrivateKey().WithPath(writeJWKFile(t, key)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if served := servedMetadata(t, config); !reflect.DeepEqual(served, members) {
+		t.Fatalf("served %v, imported %v", served, members)
+	}
+}
+
+func TestOverrideMetadata(t *testing.T) {
+	for name, tt := range map[string]struct {
+		build    func(*ConfigImportKeyBuilder) *ConfigImportKeyBuilder
+		kid, alg string
+	}{
+		"override": {
+			build: func(n *ConfigImportKeyBuilder) *ConfigImportKeyBuilder { return n.WithOverrideMetadata() },
+			kid:   "external-kid",
+			alg:   jwa.RS256.String(),
+		},
+		"explicit kid": {
+			build: func(n *ConfigImportKeyBuilder) *ConfigImportKeyBuilder { return n.WithKeyId("local") },
+			kid:   "local",
+			alg:   jwa.PS384.String(),
+		},
+		"override and explicit kid": {
+			build: func(n *ConfigImportKeyBuilder) *ConfigImportKeyBuilder {
+				return n.WithOverrideMetadata().WithKeyId("local")
+			},
+			kid: "local",
+			alg: jwa.RS256.String(),
+		},
+	} {
+		key, members := newAnnotatedJWK(t)
+		config, err := tt.build(NewConfigBuilder().WithSelfTest().ImportPrivateKey().WithPath(writeJWKFile(t, key))).Build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		members["kid"], members["alg"], members["use"] = tt.kid, tt.alg, "sig"
+		if served := servedMetadata(t, config); !reflect.DeepEqual(served, members) {
+			t.Fatalf("%s: served %v, expected %v", name, served, members)
+		}
+	}
+}
+
+func TestImportedEncryptionKeyDoesNotSign(t *testing.T) {
+	key, _ := newAnnotatedJWK(t)
+	if err := key.Set(jwk.KeyUsageKey, "enc"); err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeJWKFile(t, key)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	if use := servedMetadata(t, config)["use"]; use != "enc" {
+		t.Fatalf("served use %v", use)
+	}
+	if _, err = config.signToken(jwt.New()); err == nil {
+		t.Fatal("encryption key signed a token")
+	}
+}
+
+func TestImportKeyOfAJWKS(t *testing.T) {
+	first, _ := newAnnotatedJWK(t)
+	second, _ := newAnnotatedJWK(t)
+	if err := second.Set(jwk.KeyIDKey, "second"); err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	for _, key := range []jwk.Key{first, second} {
+		if err := set.AddKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := writeJWKFile(t, set)
+
+	if _, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).Build(); err == nil {
+		t.Fatal("key of a JWKS imported without selecting it")
+	}
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).WithKeyId("second").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := servedMetadata(t, config)["kid"]; kid != "second" {
+		t.Fatalf("served the key %v", kid)
+	}
+}
@@ -0,0 +1,94 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type introspection struct {
+	authorize    func(*gin.Context) bool
+	verifierOpts []VerifierOption
+}
+
+type IntrospectionOption func(*introspection)
+
+// Only answer the callers authorize accepts, others get a 401 unless authorize
+// aborted with its own status
+func IntrospectionAuthorizer(authorize func(*gin.Context) bool) IntrospectionOption {
+	return func(i *introspection) {
+		i.authorize = authorize
+	}
+}
+
+// Options of the verifier checking the introspected tokens, e.g. the expected issuer
+func IntrospectionVerifierOptions(opts ...VerifierOption) IntrospectionOption {
+	return func(i *introspection) {
+		i.verifierOpts = append(i.verifierOpts, opts...)
+	}
+}
+
+// Introspect RFC 7662 token introspection handler for the tokens signed by a
+// key of the config, for the services which cannot verify them locally. It
+// expects a POST with a token form field and answers {"active": true} along
+// with the claims when the token verifies the same way Verify does, else
+// {"active": false}. token_type_hint is ignored.
+func Introspect(config Config, opts ...IntrospectionOption) gin.HandlerFunc {
+	var i introspection
+	for _, opt := range opts {
+		opt(&i)
+	}
+	verifier := config.Verifier(i.verifierOpts...)
+
+	return func(c *gin.Context) {
+		if i.authorize != nil && !i.authorize(c) {
+			if !c.IsAborted() && !c.Writer.Written() {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+			c.Abort()
+			return
+		}
+		if c.Request.Method != http.MethodPost {
+			c.Header("Allow", http.MethodPost)
+			c.AbortWithStatus(http.StatusMethodNotAllowed)
+			return
+		}
+		token := c.PostForm("token")
+		if token == "" {
+			config.abortWithJSON(c, http.StatusBadRequest, &OAuthError{
+				Code:        OAuthErrorInvalidRequest,
+				Description: "missing token",
+			})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		res, err := introspectToken(c, verifier, token)
+		if err != nil {
+			c.Error(err)
+			config.writeJSON(c, http.StatusOK, map[string]interface{}{"active": false})
+			return
+		}
+		config.writeJSON(c, http.StatusOK, res)
+	}
+}
+
+// Claims of a valid token along with active, dates as NumericDate the way RFC
+// 7662 lists them
+func introspectToken(c *gin.Context, verifier *Verifier, token string) (map[string]interface{}, error) {
+	parsed, err := verifier.Verify(c.Request.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := parsed.AsMap(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range claims {
+		if t, ok := v.(time.Time); ok {
+			claims[k] = t.Unix()
+		}
+	}
+	claims["active"] = true
+	return claims, nil
+}
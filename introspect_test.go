@@ -0,0 +1,80 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntrospect(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	r := gin.New()
+	r.Any("/introspect", Introspect(*config,
+		IntrospectionAuthorizer(func(c *gin.Context) bool {
+			return c.GetHeader("Authorization") == "Bearer resource-server"
+		}),
+		IntrospectionVerifierOptions(WithExpectedIssuer("https://issuer.example.com")),
+	))
+	introspect := func(method, authorization string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	valid := signTestToken(t, config, map[string]interface{}{
+		"iss": "https://issuer.example.com",
+		"sub": "me",
+		"exp": exp,
+	})
+
+	w := introspect(http.MethodPost, "Bearer resource-server", url.Values{"token": {valid}})
+	var res map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("valid token answered %d %s", w.Code, w.Body)
+	}
+	if res["active"] != true || res["sub"] != "me" || res["exp"] != float64(exp.Unix()) {
+		t.Fatalf("valid token introspected as %v", res)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("introspection may be cached: %v", w.Header())
+	}
+
+	otherIssuer := signTestToken(t, config, map[string]interface{}{"iss": "https://other.example.com", "exp": exp})
+	for name, token := range map[string]string{
+		"other issuer": otherIssuer,
+		"tampered":     valid[:len(valid)-4] + "AAAA",
+		"not a token":  "garbage",
+	} {
+		w = introspect(http.MethodPost, "Bearer resource-server", url.Values{"token": {token}})
+		if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"active":false}` {
+			t.Fatalf("%s answered %d %s", name, w.Code, w.Body)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, method, authorization string
+		form                        url.Values
+		code                        int
+	}{
+		{"unauthorized caller", http.MethodPost, "", url.Values{"token": {valid}}, http.StatusUnauthorized},
+		{"GET", http.MethodGet, "Bearer resource-server", nil, http.StatusMethodNotAllowed},
+		{"no token", http.MethodPost, "Bearer resource-server", url.Values{}, http.StatusBadRequest},
+	} {
+		if w = introspect(tt.method, tt.authorization, tt.form); w.Code != tt.code {
+			t.Fatalf("%s answered %d %s", tt.name, w.Code, w.Body)
+		}
+		if strings.Contains(w.Body.String(), `"sub"`) {
+			t.Fatalf("%s answered with claims %s", tt.name, w.Body)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	// ErrJKSWrongStorePassword is returned when the keystore integrity check fails
+	ErrJKSWrongStorePassword = errors.New("wrong keystore password or corrupted keystore")
+	// ErrJKSWrongKeyPassword is returned when the private key entry cannot be decrypted
+	ErrJKSWrongKeyPassword = errors.New("wrong key password")
+	// ErrJKSAliasNotFound is returned when the keystore has no private key under the alias
+	ErrJKSAliasNotFound = errors.New("no private key entry with this alias")
+)
+
+const (
+	jksMagic   = 0xFEEDFEED
+	jceksMagic = 0xCECECECE
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+
+	// keytool writes this after the password in the keystore digest
+	jksWhitener = "Mighty Aphrodite"
+
+	// the bound the JDK itself puts on jdk.jceks.iterationCount, a keystore
+	// asking for more costs minutes of MD5 before the password is even checked
+	maxJCEIterationCount = 5000000
+)
+
+var (
+	// Sun proprietary key protection of JKS stores
+	oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+	// PBEWithMD5AndTripleDES used by JCEKS stores
+	oidJCEKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 19, 1}
+)
+
+type jksEncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type jcePBEParameters struct {
+	Salt           []byte
+	IterationCount int
+}
+
+// Import the private key stored under alias in a JKS or JCEKS keystore, its
+// certificate chain is published as x5c
+func (n *ConfigImportKeyBuilder) WithJKSPath(path, storePassword, keyAlias, keyPassword string) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.jks = &jksOptions{
+		path:          path,
+		storePassword: storePassword,
+		keyAlias:      keyAlias,
+		keyPassword:   keyPassword,
+	}
+	return n
+}
+
+type jksOptions struct {
+	path          string
+	storePassword string
+	keyAlias      string
+	keyPassword   string
+	maxSize       int64
+}
+
+// Read the private key and the certificate chain of the alias
+func importJKS(opts jksOptions) (jwk.Key, error) {
+	data, err := readLimitedFile(opts.path, inputSizeLimit(opts.maxSize), "keystore")
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(data)
+
+	// keytool defaults to PKCS#12 since Java 9
+	if len(data) > 0 && data[0] == 0x30 {
+		return nil, fmt.Errorf("keystore is PKCS#12, not JKS: export the key with " +
+			"`openssl pkcs12 -in store.p12 -nodes -nocerts` and use WithPath instead")
+	}
+	if len(data) < 4+sha1.Size {
+		return nil, fmt.Errorf("keystore is too short")
+	}
+
+	magic := binary.BigEndian.Uint32(data)
+	if magic != jksMagic && magic != jceksMagic {
+		return nil, fmt.Errorf("not a JKS or JCEKS keystore")
+	}
+
+	// the integrity check comes first so a wrong password is not reported as a parsing error
+	content, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	storePassword := jksPassword(opts.storePassword)
+	defer wipe(storePassword)
+	h := sha1.New()
+	h.Write(storePassword)
+	h.Write([]byte(jksWhitener))
+	h.Write(content)
+	if subtle.ConstantTimeCompare(h.Sum(nil), digest) != 1 {
+		return nil, ErrJKSWrongStorePassword
+	}
+
+	encryptedKey, chain, err := findJKSEntry(bytes.NewReader(content[4:]), opts.keyAlias)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := decryptJKSKey(encryptedKey, opts.keyPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(der)
+
+	raw, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, ErrJKSWrongKeyPassword
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert keystore private key %v", err)
+	}
+
+	if len(chain) > 0 {
+		var certs []*x509.Certificate
+		for _, certDER := range chain {
+			parsed, err := x509.ParseCertificate(certDER)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse keystore certificate %v", err)
+			}
+			certs = append(certs, parsed)
+		}
+		if err = attachCertificates(key, certs); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Walk the keystore entries up to the private key entry of alias
+func findJKSEntry(r *bytes.Reader, alias string) ([]byte, [][]byte, error) {
+	var version, count uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, nil, fmt.Errorf("cannot read keystore version %v", err)
+	}
+	if version != 1 && version != 2 {
+		return nil, nil, fmt.Errorf("unsupported keystore version %d", version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, nil, fmt.Errorf("cannot read keystore entry count %v", err)
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var tag uint32
+		if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+			return nil, nil, fmt.Errorf("cannot read keystore entry %v", err)
+		}
+		entryAlias, err := readJKSUTF(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		// creation date
+		if _, err = r.Seek(8, io.SeekCurrent); err != nil {
+			return nil, nil, err
+		}
+
+		switch tag {
+		case jksPrivateKeyTag:
+			encryptedKey, err := readJKSBytes(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			var chainLen uint32
+			if err = binary.Read(r, binary.BigEndian, &chainLen); err != nil {
+				return nil, nil, fmt.Errorf("cannot read certificate chain length %v", err)
+			}
+			var chain [][]byte
+			for j := uint32(0); j < chainLen; j++ {
+				certDER, err := readJKSCertificate(r, version)
+				if err != nil {
+					return nil, nil, err
+				}
+				chain = append(chain, certDER)
+			}
+			// keytool lowercases aliases
+			if strings.EqualFold(entryAlias, alias) {
+				return encryptedKey, chain, nil
+			}
+		case jksTrustedCertTag:
+			if _, err = readJKSCertificate(r, version); err != nil {
+				return nil, nil, err
+			}
+		default:
+			// JCEKS secret keys are serialized Java objects, they cannot be skipped
+			return nil, nil, fmt.Errorf("unsupported keystore entry type %d for alias %q", tag, entryAlias)
+		}
+	}
+
+	return nil, nil, ErrJKSAliasNotFound
+}
+
+func readJKSUTF(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", fmt.Errorf("cannot read keystore alias %v", err)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("cannot read keystore alias %v", err)
+	}
+	return string(buf), nil
+}
+
+func readJKSBytes(r *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("cannot read keystore entry length %v", err)
+	}
+	if int64(length) > int64(r.Len()) {
+		return nil, fmt.Errorf("keystore entry is truncated")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("cannot read keystore entry %v", err)
+	}
+	return buf, nil
+}
+
+func readJKSCertificate(r *bytes.Reader, version uint32) ([]byte, error) {
+	if version == 2 {
+		certType, err := readJKSUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		if certType != "X.509" {
+			return nil, fmt.Errorf("unsupported certificate type %q", certType)
+		}
+	}
+	return readJKSBytes(r)
+}
+
+// Decrypt the PKCS#8 DER of a private key entry
+func decryptJKSKey(encryptedKey []byte, password string) ([]byte, error) {
+	var info jksEncryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(encryptedKey, &info); err != nil {
+		return nil, fmt.Errorf("cannot parse keystore private key entry %v", err)
+	}
+
+	switch {
+	case info.Algorithm.Algorithm.Equal(oidJKSKeyProtector):
+		return decryptJKSKeyProtector(info.EncryptedData, password)
+	case info.Algorithm.Algorithm.Equal(oidJCEKSKeyProtector):
+		var params jcePBEParameters
+		if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("cannot parse keystore key protection parameters %v", err)
+		}
+		return decryptJCEKeyProtector(info.EncryptedData, params, password)
+	default:
+		return nil, fmt.Errorf("unsupported key protection algorithm %v", info.Algorithm.Algorithm)
+	}
+}
+
+// JKS key protector: the key is xored with a SHA-1 keystream seeded by a salt
+// and followed by SHA-1(password || key)
+func decryptJKSKeyProtector(data []byte, password string) ([]byte, error) {
+	if len(data) < 2*sha1.Size {
+		return nil, fmt.Errorf("keystore private key entry is too short")
+	}
+	salt := data[:sha1.Size]
+	encrypted := data[sha1.Size : len(data)-sha1.Size]
+	checksum := data[len(data)-sha1.Size:]
+
+	passwordBytes := jksPassword(password)
+	defer wipe(passwordBytes)
+
+	plain := make([]byte, len(encrypted))
+	digest := salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		h := sha1.New()
+		h.Write(passwordBytes)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			plain[i+j] = encrypted[i+j] ^ digest[j]
+		}
+	}
+
+	h := sha1.New()
+	h.Write(passwordBytes)
+	h.Write(plain)
+	if subtle.ConstantTimeCompare(h.Sum(nil), checksum) != 1 {
+		wipe(plain)
+		return nil, ErrJKSWrongKeyPassword
+	}
+
+	return plain, nil
+}
+
+// JCEKS key protector: PBEWithMD5AndTripleDES
+func decryptJCEKeyProtector(data []byte, params jcePBEParameters, password string) ([]byte, error) {
+	if len(params.Salt) != 8 || params.IterationCount < 1 {
+		return nil, fmt.Errorf("invalid keystore key protection parameters")
+	}
+	if params.IterationCount > maxJCEIterationCount {
+		return nil, fmt.Errorf("keystore key protection iteration count %d is above %d", params.IterationCount, maxJCEIterationCount)
+	}
+	if len(data) == 0 || len(data)%des.BlockSize != 0 {
+		return nil, fmt.Errorf("keystore private key entry is not block aligned")
+	}
+
+	// the password is taken as ASCII
+	passwordBytes := make([]byte, len(password))
+	for i, c := range []byte(password) {
+		passwordBytes[i] = c & 0x7f
+	}
+	defer wipe(passwordBytes)
+
+	// identical salt halves are made different by reversing the first one
+	salt := append([]byte{}, params.Salt...)
+	if bytes.Equal(salt[:4], salt[4:]) {
+		salt[0], salt[3] = salt[3], salt[0]
+		salt[1], salt[2] = salt[2], salt[1]
+	}
+
+	var derived []byte
+	for i := 0; i < 2; i++ {
+		toBeHashed := salt[i*4 : (i+1)*4]
+		for j := 0; j < params.IterationCount; j++ {
+			h := md5.New()
+			h.Write(toBeHashed)
+			h.Write(passwordBytes)
+			toBeHashed = h.Sum(nil)
+		}
+		derived = append(derived, toBeHashed...)
+	}
+	defer wipe(derived)
+
+	block, err := des.NewTripleDESCipher(derived[:24])
+	if err != nil {
+		return nil, fmt.Errorf("cannot create key protection cipher %v", err)
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, derived[24:]).CryptBlocks(plain, data)
+
+	// a wrong password shows as a broken padding
+	padding := int(plain[len(plain)-1])
+	if padding < 1 || padding > des.BlockSize {
+		wipe(plain)
+		return nil, ErrJKSWrongKeyPassword
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			wipe(plain)
+			return nil, ErrJKSWrongKeyPassword
+		}
+	}
+
+	return plain[:len(plain)-padding], nil
+}
+
+// Java chars are UTF-16 big endian
+func jksPassword(password string) []byte {
+	var buf []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		buf = append(buf, byte(c>>8), byte(c))
+	}
+	return buf
+}
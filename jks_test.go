@@ -0,0 +1,105 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"strings"
+	"testing"
+)
+
+// testdata/keystore.jks holds an RSA key under the alias server with a
+// self-signed certificate, after a trusted certificate entry ca. Store
+// password storepass, key password keypass. testdata/keystore.p12 holds the
+// same key, exported by openssl pkcs12 with the store password.
+func TestJKSImport(t *testing.T) {
+	config, err := NewConfigBuilder().ImportPrivateKey().WithJKSPath("testdata/keystore.jks", "storepass", "server", "keypass").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	chain := config.active().key.X509CertChain()
+	if chain == nil || chain.Len() != 1 {
+		t.Fatal("the certificate chain of the entry was not attached")
+	}
+	encoded, _ := chain.Get(0)
+	der, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key rsa.PrivateKey
+	if err = config.active().key.Raw(&key); err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		t.Fatal("the keystore key is not the key of its certificate")
+	}
+	signed, err := config.signToken(jwt.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jwt.Parse(signed, jwt.WithKey(jwa.RS256, &key.PublicKey)); err != nil {
+		t.Fatalf("token signed with the keystore key does not verify: %v", err)
+	}
+
+	// keytool lowercases aliases
+	if _, err = importJKS(jksOptions{path: "testdata/keystore.jks", storePassword: "storepass", keyAlias: "SERVER", keyPassword: "keypass"}); err != nil {
+		t.Fatalf("alias in capitals: %v", err)
+	}
+}
+
+func TestJKSImportErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts jksOptions
+		err  error
+	}{
+		{"wrong store password", jksOptions{storePassword: "wrong", keyAlias: "server", keyPassword: "keypass"}, ErrJKSWrongStorePassword},
+		{"wrong key password", jksOptions{storePassword: "storepass", keyAlias: "server", keyPassword: "wrong"}, ErrJKSWrongKeyPassword},
+		{"missing alias", jksOptions{storePassword: "storepass", keyAlias: "client", keyPassword: "keypass"}, ErrJKSAliasNotFound},
+		// a trusted certificate entry holds no private key
+		{"certificate alias", jksOptions{storePassword: "storepass", keyAlias: "ca", keyPassword: "keypass"}, ErrJKSAliasNotFound},
+	} {
+		tt.opts.path = "testdata/keystore.jks"
+		if _, err := importJKS(tt.opts); !errors.Is(err, tt.err) {
+			t.Fatalf("%s: got %v, expected %v", tt.name, err, tt.err)
+		}
+	}
+
+	_, err := NewConfigBuilder().ImportPrivateKey().WithJKSPath("testdata/keystore.jks", "storepass", "server", "wrong").Build()
+	if !errors.Is(err, ErrJKSWrongKeyPassword) {
+		t.Fatalf("Build with a wrong key password gave %v", err)
+	}
+}
+
+func TestJKSImportRefusesPKCS12(t *testing.T) {
+	_, err := importJKS(jksOptions{path: "testdata/keystore.p12", storePassword: "storepass", keyAlias: "server", keyPassword: "storepass"})
+	if err == nil || !strings.Contains(err.Error(), "PKCS#12") {
+		t.Fatalf("PKCS#12 store gave %v", err)
+	}
+	for _, sentinel := range []error{ErrJKSWrongStorePassword, ErrJKSWrongKeyPassword, ErrJKSAliasNotFound} {
+		if errors.Is(err, sentinel) {
+			t.Fatalf("PKCS#12 store reported as %v", sentinel)
+		}
+	}
+}
+
+func TestJCEKeyProtectorRefusesHugeIterationCounts(t *testing.T) {
+	params := jcePBEParameters{Salt: make([]byte, 8), IterationCount: maxJCEIterationCount + 1}
+	if _, err := decryptJCEKeyProtector(make([]byte, 16), params, "password"); err == nil || !strings.Contains(err.Error(), "iteration count") {
+		t.Fatalf("an iteration count above the cap was run: %v", err)
+	}
+	// within the cap the password check is what fails
+	params.IterationCount = 1
+	if _, err := decryptJCEKeyProtector(make([]byte, 16), params, "password"); err == nil {
+		t.Fatal("garbage decrypted")
+	}
+}
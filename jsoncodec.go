@@ -0,0 +1,78 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+)
+
+// JSONCodec encodes the response bodies of a config, encoding/json by default.
+// sonic.ConfigStd or jsoniter.ConfigCompatibleWithStandardLibrary satisfy it.
+//
+// It encodes the error bodies and the documents such as the discovery or the
+// introspection responses. The key set keeps encoding/json, its bytes make the
+// ETag and the signed key set and must not change with the codec. Tokens are
+// encoded by jwx whatever the codec, and the config file loader keeps
+// encoding/json to refuse unknown fields.
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	MarshalIndent(v interface{}, prefix, indent string) ([]byte, error)
+}
+
+type stdJSONCodec struct{}
+
+func (stdJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (stdJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (stdJSONCodec) MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	return json.MarshalIndent(v, prefix, indent)
+}
+
+// Encode the response bodies with codec instead of encoding/json
+func (n *ConfigBuilder) WithJSONCodec(codec JSONCodec) *ConfigBuilder {
+	n.config.jsonCodec = codec
+	return n
+}
+
+// Indent the JSON bodies, e.g. the discovery documents and the error bodies,
+// ProfileDev turns it on. The key set keeps its canonical form.
+func (n *ConfigBuilder) WithPrettyJSON(pretty bool) *ConfigBuilder {
+	n.config.prettyJSON = pretty
+	n.config.prettyJSONSet = true
+	return n
+}
+
+func (c *Config) codec() JSONCodec {
+	if c == nil || c.jsonCodec == nil {
+		return stdJSONCodec{}
+	}
+	return c.jsonCodec
+}
+
+// Write v as the JSON body of the response with the codec of the config
+func (c *Config) writeJSON(ctx *gin.Context, status int, v interface{}) {
+	var body []byte
+	var err error
+	if c != nil && c.prettyJSON {
+		body, err = c.codec().MarshalIndent(v, "", "  ")
+	} else {
+		body, err = c.codec().Marshal(v)
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatus(500)
+		return
+	}
+	ctx.Data(status, "application/json; charset=utf-8", body)
+}
+
+// Same as writeJSON, aborting the handler chain
+func (c *Config) abortWithJSON(ctx *gin.Context, status int, v interface{}) {
+	ctx.Abort()
+	c.writeJSON(ctx, status, v)
+}
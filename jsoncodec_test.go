@@ -0,0 +1,99 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// Codec whose output differs from encoding/json, everything is indented
+type indentJSONCodec struct{}
+
+func (indentJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "\t")
+}
+
+func (indentJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (indentJSONCodec) MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	return json.MarshalIndent(v, prefix, indent)
+}
+
+func TestJWKSIsByteIdenticalAcrossCodecs(t *testing.T) {
+	const golden = "testdata/jwks.golden.json"
+	for name, codec := range map[string]JSONCodec{"encoding/json": nil, "indent": indentJSONCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			builder := NewConfigBuilder()
+			if codec != nil {
+				builder.WithJSONCodec(codec)
+			}
+			config, err := builder.ImportPrivateKey().WithPath("testdata/private.pem").WithKeyId("golden").Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer config.Close()
+
+			w := serveJWKS(Jkws(*config))
+			if w.Code != http.StatusOK {
+				t.Fatalf("answered %d", w.Code)
+			}
+			if *updateGolden && codec == nil {
+				if err = os.WriteFile(golden, w.Body.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(w.Body.Bytes(), expected) {
+				t.Fatalf("key set\n%s\ndiffers from %s\n%s", w.Body, golden, expected)
+			}
+
+			config.emptyKeySetWhenNoKey = true
+			config.setActive(nil)
+			config.keySetChanged()
+			if w = serveJWKS(Jkws(*config)); w.Body.String() != `{"keys":[]}` {
+				t.Fatalf("empty key set %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestJSONCodecEncodesTheOtherBodies(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithJSONCodec(indentJSONCodec{}))
+	discovery := serveJWKS(OpenIDConfiguration(*config, "https://issuer.example", MetadataOptions{}))
+	if !strings.Contains(discovery.Body.String(), "\n\t") {
+		t.Fatalf("discovery document not encoded by the codec\n%s", discovery.Body)
+	}
+
+	// the key set, its ETag and its signature come from the canonical bytes
+	keys, err := config.jwksKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := json.Marshal(jwksBody{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serveJWKS(Jkws(*config))
+	if !bytes.Equal(w.Body.Bytes(), canonical) || w.Header().Get("ETag") != jwksETag(canonical) {
+		t.Fatalf("key set changed by the codec\n%s", w.Body)
+	}
+	msg, err := jws.Parse(serveJWKS(SignedJkws(*config, config)).Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Payload(), canonical) {
+		t.Fatalf("signed key set changed by the codec\n%s", msg.Payload())
+	}
+}
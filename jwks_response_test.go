@@ -0,0 +1,127 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Public key of raw with member blanked, under the kid broken
+func brokenKey(t *testing.T, raw interface{}, member string) jwk.Key {
+	t.Helper()
+	key, err := jwk.FromRaw(raw)
+	if err == nil && member != "" {
+		err = key.Set(member, []byte{})
+	}
+	if err == nil {
+		err = key.Set(jwk.KeyIDKey, "broken")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Keys missing the members the key set needs
+func brokenKeys(t *testing.T) map[string]jwk.Key {
+	t.Helper()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]jwk.Key{
+		"RSA without modulus": brokenKey(t, &newRSAKey(t).PublicKey, "n"),
+		"EC without point":    brokenKey(t, &newECKey(t).PublicKey, "y"),
+		"OKP without value":   brokenKey(t, edKey.Public(), "x"),
+		"symmetric key":       brokenKey(t, []byte("secret"), ""),
+	}
+}
+
+func TestNewJkwsResponseRefusesIncompleteKeys(t *testing.T) {
+	for name, key := range brokenKeys(t) {
+		if res, err := newJkwsResponse(key, "RS256"); err == nil {
+			t.Fatalf("%s published as %+v", name, res)
+		}
+	}
+}
+
+func TestJkwsAnswers500OnABrokenKey(t *testing.T) {
+	var reported int32
+	config := newTestConfig(t, NewConfigBuilder().OnError(func(error) {
+		atomic.AddInt32(&reported, 1)
+	}))
+	if err := config.keys.additional.AddKey(brokenKey(t, &newRSAKey(t).PublicKey, "n")); err != nil {
+		t.Fatal(err)
+	}
+	config.keySetChanged()
+
+	w := serveJWKS(Jkws(*config))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("broken key set answered %d %s", w.Code, w.Body)
+	}
+	var body OAuthError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != OAuthErrorServerError {
+		t.Fatalf("broken key set answered %s", w.Body)
+	}
+	if strings.Contains(w.Body.String(), "broken") {
+		t.Fatalf("the answer names the key at fault: %s", w.Body)
+	}
+	if atomic.LoadInt32(&reported) == 0 {
+		t.Fatal("the error was not reported")
+	}
+}
+
+func TestRSAMembersMatchOpenSSL(t *testing.T) {
+	// openssl rsa -in testdata/private.pem -noout -modulus
+	out, err := os.ReadFile("testdata/private.modulus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modulus, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(out)), "Modulus="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath("testdata/private.pem").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	members := servedKeyMembers(t, config)
+	if members["e"] != "AQAB" {
+		t.Fatalf("exponent %v", members["e"])
+	}
+	if n, _ := members["n"].(string); n != base64.RawURLEncoding.EncodeToString(modulus) {
+		t.Fatalf("modulus %s differs from openssl", n)
+	}
+}
+
+func TestRSAMembersAreMinimal(t *testing.T) {
+	key := newRSAKey(t)
+	padded, err := jwk.FromRaw(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a source may carry leading zero bytes, e.g. a hand-written JWK
+	if err = padded.Set("n", append([]byte{0, 0}, key.N.Bytes()...)); err != nil {
+		t.Fatal(err)
+	}
+	if err = padded.Set("e", []byte{0, 1, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := newJkwsResponse(padded, "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PubKeyExponentKey != "AQAB" || res.PubKeyModulusKey != base64.RawURLEncoding.EncodeToString(key.N.Bytes()) {
+		t.Fatalf("published e %s and n %s with leading zeros", res.PubKeyExponentKey, res.PubKeyModulusKey)
+	}
+}
@@ -0,0 +1,16 @@
+// Package jwkstest holds test helpers for the programs serving a key set.
+package jwkstest
+
+import (
+	gin_jwks_rsa "github.com/v4lproik/gin-jwks-rsa"
+	"testing"
+)
+
+// AssertNoPrivateMaterial fails the test when body, a JWKS or a JWK, holds a
+// private key member such as d, p or q
+func AssertNoPrivateMaterial(t testing.TB, body []byte) {
+	t.Helper()
+	if err := gin_jwks_rsa.CheckNoPrivateMaterial(body); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// ErrKeyURLHeader is returned for tokens pointing at their own key with jku or x5u
+var ErrKeyURLHeader = errors.New(`token carries a "jku" or "x5u" header`)
+
+// What to do with jku and x5u headers, they are never followed
+type KeyURLHeaderPolicy int
+
+const (
+	// refuse the token
+	KeyURLHeadersReject KeyURLHeaderPolicy = iota
+	// verify the token with the configured keys as if the headers were absent
+	KeyURLHeadersIgnore
+)
+
+// Refuse jku and x5u headers under the reject policy. An embedded jwk header is
+// never a problem: keys only come from the configured sets, whatever the token says.
+func checkKeyURLHeaders(token string, policy KeyURLHeaderPolicy) error {
+	if policy == KeyURLHeadersIgnore {
+		return nil
+	}
+
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		return fmt.Errorf("cannot parse token %v", err)
+	}
+	for _, sig := range msg.Signatures() {
+		for _, headers := range []jws.Headers{sig.ProtectedHeaders(), sig.PublicHeaders()} {
+			if headers == nil {
+				continue
+			}
+			if headers.JWKSetURL() != "" || headers.X509URL() != "" {
+				return ErrKeyURLHeader
+			}
+		}
+	}
+
+	return nil
+}
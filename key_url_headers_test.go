@@ -0,0 +1,109 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Token signed by raw under the kid test, carrying headers
+func tokenWithHeaders(t *testing.T, raw interface{}, headers map[string]interface{}) string {
+	t.Helper()
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := jws.NewHeaders()
+	headers[jws.KeyIDKey] = "test"
+	for name, value := range headers {
+		if err = protected.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token := jwt.New()
+	if err = token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key, jws.WithProtectedHeaders(protected)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+// Tokens which would verify against the key they point at or embed
+func TestVerifierRefusesSelfSignedTokens(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	attacker := newRSAKey(t)
+	attackerKey, err := jwk.FromRaw(attacker.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = attackerKey.Set(jwk.KeyIDKey, "test"); err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	if err = set.AddKey(attackerKey); err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		name    string
+		headers map[string]interface{}
+		policy  KeyURLHeaderPolicy
+		want    error
+	}{
+		{"embedded jwk", map[string]interface{}{jws.JWKKey: attackerKey}, KeyURLHeadersReject, nil},
+		{"embedded jwk, headers ignored", map[string]interface{}{jws.JWKKey: attackerKey}, KeyURLHeadersIgnore, nil},
+		{"jku", map[string]interface{}{jws.JWKSetURLKey: server.URL}, KeyURLHeadersReject, ErrKeyURLHeader},
+		{"x5u", map[string]interface{}{jws.X509URLKey: server.URL}, KeyURLHeadersReject, ErrKeyURLHeader},
+		{"jku ignored", map[string]interface{}{jws.JWKSetURLKey: server.URL}, KeyURLHeadersIgnore, nil},
+		{"x5u ignored", map[string]interface{}{jws.X509URLKey: server.URL}, KeyURLHeadersIgnore, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tokenWithHeaders(t, attacker, tt.headers)
+			_, err := config.Verifier(WithKeyURLHeaderPolicy(tt.policy)).Verify(context.Background(), token)
+			if err == nil {
+				t.Fatal("a token signed by its own key verified")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+	if n := atomic.LoadInt32(&fetches); n != 0 {
+		t.Fatalf("the key URL of a token was fetched %d times", n)
+	}
+}
+
+func TestIgnoredKeyURLHeadersVerifyWithTheConfiguredKey(t *testing.T) {
+	key := newRSAKey(t)
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(writeTestKey(t, key)).WithKeyId("test").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	token := tokenWithHeaders(t, key, map[string]interface{}{jws.JWKSetURLKey: "https://issuer.example.com/jwks"})
+	if _, err = config.Verifier().Verify(context.Background(), token); !errors.Is(err, ErrKeyURLHeader) {
+		t.Fatalf("expected ErrKeyURLHeader, got %v", err)
+	}
+	if _, err = config.Verifier(WithKeyURLHeaderPolicy(KeyURLHeadersIgnore)).Verify(context.Background(), token); err != nil {
+		t.Fatalf("token of the configured key with an ignored jku refused: %v", err)
+	}
+}
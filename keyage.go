@@ -0,0 +1,160 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyAgeEvent is sent when the active signing key gets older than a threshold
+type KeyAgeEvent struct {
+	KeyID string
+	Age   time.Duration
+	// threshold which was crossed, the configured max age or twice that
+	Threshold time.Duration
+	// whether the config rotates its key on its own
+	AutoRotation bool
+}
+
+// Call alert once the signing key gets older than maxAge, and again at twice maxAge
+func (n *ConfigBuilder) WithKeyAgeAlert(maxAge time.Duration, alert func(KeyAgeEvent)) *ConfigBuilder {
+	n.config.keyAgeMax = maxAge
+	n.config.keyAgeAlert = alert
+	return n
+}
+
+// Background work attached to a config, shared by its copies
+type background struct {
+	once sync.Once
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newBackground() *background {
+	return &background{done: make(chan struct{})}
+}
+
+// Run fn in a goroutine stopped by Close
+func (b *background) goWithTicker(interval time.Duration, fn func(now time.Time)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.done:
+				return
+			case now := <-ticker.C:
+				fn(now)
+			}
+		}
+	}()
+}
+
+// Run fn in a goroutine, done is closed by Close
+func (b *background) goRun(fn func(done <-chan struct{})) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn(b.done)
+	}()
+}
+
+// Context cancelled by Close, for the requests made by the background work
+func (b *background) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-b.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Close stops the background work of the config and waits for it to return
+func (c *Config) Close() error {
+	c.background.once.Do(func() {
+		close(c.background.done)
+	})
+	c.background.wg.Wait()
+	return nil
+}
+
+// Check the age of the signing key often enough to notice a crossing quickly
+func keyAgeCheckInterval(maxAge time.Duration) time.Duration {
+	interval := maxAge / 100
+	if interval < time.Second {
+		return time.Second
+	}
+	if interval > time.Minute {
+		return time.Minute
+	}
+	return interval
+}
+
+// Highest key age threshold the signing key crossed at now, zero if none
+func (c *Config) keyAgeThreshold(active *activeKey, now time.Time) time.Duration {
+	if c.keyAgeMax <= 0 || active == nil {
+		return 0
+	}
+	age := now.Sub(active.createdAt)
+	for level := 2; level >= 1; level-- {
+		if threshold := time.Duration(level) * c.keyAgeMax; age >= threshold {
+			return threshold
+		}
+	}
+	return 0
+}
+
+// Warning of the readiness answer while a signing key is past the key age
+// threshold, empty otherwise
+func (c *Config) keyAgeWarning(now time.Time) string {
+	configs := c.merged
+	if configs == nil {
+		configs = []*Config{c}
+	}
+	for _, config := range configs {
+		active := config.active()
+		if threshold := config.keyAgeThreshold(active, now); threshold > 0 {
+			return fmt.Sprintf("signing key %q is older than %s", active.key.KeyID(), threshold)
+		}
+	}
+	return ""
+}
+
+// Watch the age of the signing key and call the alert on each threshold crossing
+func (c *Config) startKeyAgeAlert() {
+	// highest threshold already reported, per kid
+	reported := map[string]int{}
+	check := func(now time.Time) {
+		active := c.active()
+		if active == nil {
+			return
+		}
+		kid := active.key.KeyID()
+		age := now.Sub(active.createdAt)
+		for level := reported[kid] + 1; level <= 2; level++ {
+			threshold := time.Duration(level) * c.keyAgeMax
+			if age < threshold {
+				return
+			}
+			reported[kid] = level
+			event := KeyAgeEvent{
+				KeyID:        kid,
+				Age:          age,
+				Threshold:    threshold,
+				AutoRotation: c.autoRotation() > 0,
+			}
+			c.runHook("KeyAgeAlert", func() {
+				c.keyAgeAlert(event)
+			})
+		}
+	}
+
+	check(time.Now())
+	c.background.goWithTicker(keyAgeCheckInterval(c.keyAgeMax), check)
+}
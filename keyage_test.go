@@ -0,0 +1,68 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyAgeAlert(t *testing.T) {
+	var mu sync.Mutex
+	var events []KeyAgeEvent
+	alert := func(event KeyAgeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	// any key is older than a nanosecond, both thresholds are crossed at Build
+	for name, tt := range map[string]struct {
+		build        func(*ConfigBuilder) (*Config, error)
+		autoRotation bool
+	}{
+		"imported key": {func(b *ConfigBuilder) (*Config, error) {
+			return b.ImportPrivateKey().WithPEMBytes(pemKey(t, newRSAKey(t))).Build()
+		}, false},
+		"auto rotated key": {func(b *ConfigBuilder) (*Config, error) {
+			return b.NewPrivateKey().WithKeyType(jwa.EC).WithAutoRotation(time.Hour, 0).Build()
+		}, true},
+	} {
+		mu.Lock()
+		events = nil
+		mu.Unlock()
+		config, err := tt.build(NewConfigBuilder().WithKeyAgeAlert(time.Nanosecond, alert))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Close()
+
+		mu.Lock()
+		if len(events) != 2 || events[0].Threshold != time.Nanosecond || events[1].Threshold != 2*time.Nanosecond {
+			t.Fatalf("%s: alerted with %+v", name, events)
+		}
+		for _, event := range events {
+			if event.AutoRotation != tt.autoRotation {
+				t.Fatalf("%s: alerted with AutoRotation %v", name, event.AutoRotation)
+			}
+		}
+		mu.Unlock()
+	}
+}
+
+func TestKeyAgeAlertCannotCrashTheServer(t *testing.T) {
+	var reported []error
+	config, err := NewConfigBuilder().
+		WithKeyAgeAlert(time.Nanosecond, func(KeyAgeEvent) { panic("alert failed") }).
+		OnError(func(err error) { reported = append(reported, err) }).
+		ImportPrivateKey().WithPEMBytes(pemKey(t, newRSAKey(t))).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Close()
+	if len(reported) != 2 || !strings.Contains(reported[0].Error(), "panicked") {
+		t.Fatalf("panicking alert reported %v", reported)
+	}
+}
@@ -0,0 +1,102 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"math/big"
+)
+
+// ErrWeakKey is matched by the WeakKeyError of a key below the minimum size
+var ErrWeakKey = errors.New("key below the minimum size")
+
+// WeakKeyError describes a key below the minimum size: an RSA modulus shorter
+// than the minimum, or an EC curve weaker than an RSA key of that size
+type WeakKeyError struct {
+	KeyID string
+	// RSA modulus length, 0 for an EC key
+	Bits  int
+	Curve jwa.EllipticCurveAlgorithm
+	// minimum RSA key size of the policy
+	Minimum int
+}
+
+func (e *WeakKeyError) Error() string {
+	key := fmt.Sprintf("RSA key of %d bits", e.Bits)
+	if e.Curve != "" {
+		key = fmt.Sprintf("EC key on curve %s", e.Curve)
+	}
+	if e.KeyID != "" {
+		key += fmt.Sprintf(" (kid %q)", e.KeyID)
+	}
+	return fmt.Sprintf("%s is below the minimum of %d bits", key, e.Minimum)
+}
+
+func (e *WeakKeyError) Is(target error) bool {
+	return target == ErrWeakKey
+}
+
+// Accept keys below the minimum size, for test suites generating small keys.
+// Build no longer refuses them and a weak imported key is reported through
+// OnError instead.
+func (n *ConfigBuilder) AllowWeakKeys() *ConfigBuilder {
+	n.config.allowWeakKeys = true
+	return n
+}
+
+// Security strength in bits of an RSA key, NIST SP 800-57 part 1 table 2
+func rsaStrength(bits int) int {
+	switch {
+	case bits >= 15360:
+		return 256
+	case bits >= 7680:
+		return 192
+	case bits >= 3072:
+		return 128
+	case bits >= 2048:
+		return 112
+	case bits >= 1024:
+		return 80
+	}
+	return 0
+}
+
+// Security strength in bits of the EC curves, same table
+var ecStrengths = map[jwa.EllipticCurveAlgorithm]int{
+	jwa.P256: 128,
+	jwa.P384: 192,
+	jwa.P521: 256,
+}
+
+// Measure a generated or imported key against the minimum size, the other
+// key types have a fixed strength above any floor
+func (c *Config) checkKeySize(key jwk.Key) error {
+	minBits := c.minimumKeySize()
+	var weak *WeakKeyError
+	switch k := key.(type) {
+	case jwk.RSAPrivateKey:
+		if bits := new(big.Int).SetBytes(k.N()).BitLen(); bits < minBits {
+			weak = &WeakKeyError{Bits: bits}
+		}
+	case jwk.RSAPublicKey:
+		if bits := new(big.Int).SetBytes(k.N()).BitLen(); bits < minBits {
+			weak = &WeakKeyError{Bits: bits}
+		}
+	default:
+		if curve, ok := ecCurve(key); ok && ecStrengths[curve] < rsaStrength(minBits) {
+			weak = &WeakKeyError{Curve: curve}
+		}
+	}
+	if weak == nil {
+		return nil
+	}
+
+	weak.KeyID = key.KeyID()
+	weak.Minimum = minBits
+	if c.allowWeakKeys {
+		c.reportError(weak)
+		return nil
+	}
+	return weak
+}
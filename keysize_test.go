@@ -0,0 +1,109 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"testing"
+)
+
+func newWeakRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestWeakGeneratedKeysAreRefused(t *testing.T) {
+	_, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(1024).Build()
+	if !errors.Is(err, ErrWeakKey) {
+		t.Fatalf("expected ErrWeakKey, got %v", err)
+	}
+
+	config, err := NewConfigBuilder().AllowWeakKeys().NewPrivateKey().WithKeyLength(1024).Build()
+	if err != nil {
+		t.Fatalf("weak key refused with AllowWeakKeys: %v", err)
+	}
+	config.Close()
+}
+
+func TestWeakImportedKeysAreRefused(t *testing.T) {
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// refused before it is measured, a JWK has no P-224 curve
+	if _, err = NewConfigBuilder().ImportPrivateKey().WithRawKey(p224).Build(); err == nil {
+		t.Fatal("P-224 key imported")
+	}
+
+	weak := newWeakRSAKey(t)
+	_, err = NewConfigBuilder().ImportPrivateKey().WithPath(writeTestKey(t, weak)).WithKeyId("weak").Build()
+	var weakErr *WeakKeyError
+	if !errors.Is(err, ErrWeakKey) || !errors.As(err, &weakErr) {
+		t.Fatalf("expected a WeakKeyError, got %v", err)
+	}
+	if weakErr.Bits != 1024 || weakErr.Minimum != DefaultMinimumKeySize || weakErr.KeyID != "weak" {
+		t.Fatalf("weak key error %+v", weakErr)
+	}
+
+	// let through, yet reported
+	var reported []error
+	config, err := NewConfigBuilder().AllowWeakKeys().OnError(func(err error) {
+		reported = append(reported, err)
+	}).ImportPrivateKey().WithRawKey(weak).Build()
+	if err != nil {
+		t.Fatalf("weak key refused with AllowWeakKeys: %v", err)
+	}
+	config.Close()
+	if len(reported) != 1 || !errors.Is(reported[0], ErrWeakKey) {
+		t.Fatalf("weak imported key reported %v", reported)
+	}
+}
+
+func TestWithMinimumKeySize(t *testing.T) {
+	if _, err := NewConfigBuilder().WithMinimumKeySize(3072).NewPrivateKey().WithKeyLength(2048).Build(); !errors.Is(err, ErrWeakKey) {
+		t.Fatalf("2048 bits key generated under a 3072 bits floor: %v", err)
+	}
+	if _, err := NewConfigBuilder().WithMinimumKeySize(3072).ImportPrivateKey().WithRawKey(newRSAKey(t)).Build(); !errors.Is(err, ErrWeakKey) {
+		t.Fatalf("2048 bits key imported under a 3072 bits floor: %v", err)
+	}
+
+	// P-256 is as strong as a 3072 bits RSA key, not as a 7680 bits one
+	config, err := NewConfigBuilder().WithMinimumKeySize(3072).ImportPrivateKey().WithRawKey(newECKey(t)).Build()
+	if err != nil {
+		t.Fatalf("P-256 key refused under a 3072 bits floor: %v", err)
+	}
+	config.Close()
+	_, err = NewConfigBuilder().WithMinimumKeySize(7680).ImportPrivateKey().WithRawKey(newECKey(t)).Build()
+	var weakErr *WeakKeyError
+	if !errors.As(err, &weakErr) || weakErr.Curve != jwa.P256 || weakErr.Minimum != 7680 {
+		t.Fatalf("P-256 key under a 7680 bits floor gave %v", err)
+	}
+}
+
+func TestReplaceKeyRefusesWeakKeys(t *testing.T) {
+	weak, err := jwk.FromRaw(newWeakRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := newTestConfig(t, NewConfigBuilder())
+	if err = config.ReplaceKey(weak, "weak"); !errors.Is(err, ErrWeakKey) {
+		t.Fatalf("expected ErrWeakKey, got %v", err)
+	}
+	if kid := config.active().key.KeyID(); kid != "test" {
+		t.Fatalf("the signing key was replaced by %q", kid)
+	}
+
+	allowed := newTestConfig(t, NewConfigBuilder().AllowWeakKeys())
+	if err = allowed.ReplaceKey(weak, "weak"); err != nil {
+		t.Fatalf("weak key refused with AllowWeakKeys: %v", err)
+	}
+}
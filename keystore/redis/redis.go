@@ -0,0 +1,382 @@
+// Package redis keeps the signing key of a fleet of replicas in Redis. Each
+// replica signs with the key stored there. A rotation writes the new key, then
+// announces it on a pub/sub channel, so the other replicas install it at once
+// instead of on their next poll. It speaks RESP itself, no Redis client
+// library is needed.
+package redis
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultChannel      = "gin-jwks:key-changed"
+	DefaultPollInterval = 30 * time.Second
+	DefaultTimeout      = 5 * time.Second
+	// delay before subscribing again after the subscription dropped
+	resubscribeDelay = time.Second
+	// PEM header holding the kid of the stored key
+	kidHeader = "Kid"
+)
+
+// ErrNoKey is returned by Open when the store holds no key yet, Rotate
+// writes the first one
+var ErrNoKey = errors.New("no key in the redis store")
+
+// Store is a RenewableKeyProvider over the PKCS#8 PEM held by Key. Open loads
+// it and watches for changes: a message on Channel makes the store read Key
+// again, and Key is polled every PollInterval as well, so a replica whose
+// subscription dropped still picks up a rotation. The message is only a hint,
+// its payload is never used.
+type Store struct {
+	Addr     string
+	Password string
+	DB       int
+	// Redis key holding the signing key
+	Key          string
+	Channel      string
+	PollInterval time.Duration
+	// of a connection and of each command
+	Timeout time.Duration
+	// called when a change cannot be read or the subscription drops
+	OnError func(error)
+
+	mu      sync.Mutex
+	signer  crypto.Signer
+	kid     string
+	stored  []byte
+	onRenew []func()
+	sub     *conn
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// New returns a store of the key held by key on the Redis server at addr
+func New(addr, key string) *Store {
+	return &Store{
+		Addr:         addr,
+		Key:          key,
+		Channel:      DefaultChannel,
+		PollInterval: DefaultPollInterval,
+		Timeout:      DefaultTimeout,
+	}
+}
+
+// Open loads the stored key and starts watching for changes until Close. It
+// returns ErrNoKey when the store is empty.
+func (s *Store) Open(ctx context.Context) error {
+	if _, err := s.load(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.signer == nil {
+		return ErrNoKey
+	}
+	if s.done != nil {
+		return fmt.Errorf("redis store already open")
+	}
+	s.done = make(chan struct{})
+	hints := make(chan struct{}, 1)
+	s.stopped.Add(2)
+	go s.subscribe(s.done, hints)
+	go s.watch(s.done, hints)
+	return nil
+}
+
+// Rotate stores key under kid in place of the current key, then tells the
+// other replicas. The key is written before the notification, so a replica
+// reading the store on the message finds it.
+func (s *Store) Rotate(ctx context.Context, key crypto.Signer, kid string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("cannot encode the key %v", err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	if kid != "" {
+		block.Headers = map[string]string{kidHeader: kid}
+	}
+
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if _, err = c.do(s.timeout(), "SET", s.Key, string(pem.EncodeToMemory(block))); err != nil {
+		return fmt.Errorf("cannot store the key %v", err)
+	}
+	if _, err = c.do(s.timeout(), "PUBLISH", s.Channel, kid); err != nil {
+		// the others still get it on their next poll
+		s.reportError(fmt.Errorf("cannot announce the new key %v", err))
+	}
+
+	changed, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	if changed {
+		s.renewed()
+	}
+	return nil
+}
+
+// PublicJWK public key of the stored key, under its kid
+func (s *Store) PublicJWK() (jwk.Key, error) {
+	s.mu.Lock()
+	signer, kid := s.signer, s.kid
+	s.mu.Unlock()
+	if signer == nil {
+		return nil, ErrNoKey
+	}
+
+	key, err := jwk.FromRaw(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public key %v", err)
+	}
+	if kid != "" {
+		if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+			return nil, fmt.Errorf("cannot set the kid of the public key %v", err)
+		}
+	}
+	return key, nil
+}
+
+// Signer of the stored key as last read
+func (s *Store) Signer() crypto.Signer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signer
+}
+
+// OnRenew calls fn once the store read a new key
+func (s *Store) OnRenew(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRenew = append(s.onRenew, fn)
+}
+
+// Close stops watching the store
+func (s *Store) Close() {
+	s.mu.Lock()
+	if s.done == nil {
+		s.mu.Unlock()
+		return
+	}
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	if s.sub != nil {
+		// unblock the subscription read
+		s.sub.close()
+	}
+	s.mu.Unlock()
+	s.stopped.Wait()
+}
+
+// Read the store on each hint and every PollInterval
+func (s *Store) watch(done <-chan struct{}, hints <-chan struct{}) {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(s.pollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-hints:
+		case <-ticker.C:
+		}
+		s.refresh(done)
+	}
+}
+
+// Read the stored key, renewing the signer when it changed
+func (s *Store) refresh(done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	changed, err := s.load(ctx)
+	if err != nil {
+		s.reportError(err)
+		return
+	}
+	if changed {
+		s.renewed()
+	}
+}
+
+// Turn each message on Channel into a hint, subscribing again after a drop.
+// A hint is also sent on each subscription, a change may have been missed
+// while it was down.
+func (s *Store) subscribe(done <-chan struct{}, hints chan<- struct{}) {
+	defer s.stopped.Done()
+	hint := func() {
+		select {
+		case hints <- struct{}{}:
+		default:
+		}
+	}
+
+	for {
+		err := s.listen(done, hint)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		s.reportError(fmt.Errorf("redis subscription dropped, polling until it is back %v", err))
+		select {
+		case <-done:
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// Subscribe and read messages until the connection fails or Close closes it
+func (s *Store) listen(done <-chan struct{}, hint func()) error {
+	c, err := s.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	s.mu.Lock()
+	select {
+	case <-done:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	s.sub = c
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.sub = nil
+		s.mu.Unlock()
+	}()
+
+	if _, err = c.do(s.timeout(), "SUBSCRIBE", s.Channel); err != nil {
+		return fmt.Errorf("cannot subscribe to %q %v", s.Channel, err)
+	}
+	// messages come whenever a key is rotated
+	if err = c.c.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	hint()
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return err
+		}
+		// ["message", channel, payload], the payload is not trusted
+		if items, ok := reply.([]interface{}); ok && len(items) == 3 {
+			if kind, _ := items[0].([]byte); string(kind) == "message" {
+				hint()
+			}
+		}
+	}
+}
+
+// Read the stored key, true when it differs from the one in use
+func (s *Store) load(ctx context.Context) (bool, error) {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+	reply, err := c.do(s.timeout(), "GET", s.Key)
+	if err != nil {
+		return false, fmt.Errorf("cannot read the key %v", err)
+	}
+	if reply == nil {
+		return false, nil
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return false, fmt.Errorf("unexpected reply to GET %T", reply)
+	}
+
+	s.mu.Lock()
+	same := bytes.Equal(data, s.stored)
+	s.mu.Unlock()
+	if same {
+		return false, nil
+	}
+	signer, kid, err := parseKey(data)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// another load installed it meanwhile
+	if bytes.Equal(data, s.stored) {
+		return false, nil
+	}
+	s.signer, s.kid, s.stored = signer, kid, data
+	return true, nil
+}
+
+func (s *Store) renewed() {
+	s.mu.Lock()
+	onRenew := append([]func(){}, s.onRenew...)
+	s.mu.Unlock()
+	for _, fn := range onRenew {
+		fn()
+	}
+}
+
+// Private key and kid of the stored PEM
+func parseKey(data []byte) (crypto.Signer, string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, "", fmt.Errorf("the stored key is not a PKCS#8 PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot parse the stored key %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, "", fmt.Errorf("the stored key of type %T cannot sign", key)
+	}
+	return signer, block.Headers[kidHeader], nil
+}
+
+func (s *Store) reportError(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *Store) pollInterval() time.Duration {
+	if s.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return s.PollInterval
+}
+
+func (s *Store) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return s.Timeout
+}
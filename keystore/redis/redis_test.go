@@ -0,0 +1,230 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	gin_jwks_rsa "github.com/v4lproik/gin-jwks-rsa"
+	"testing"
+	"time"
+)
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Replica signing with the key of a store opened on m
+func newReplica(t *testing.T, m *miniredis.Miniredis, configure func(*Store)) (*Store, *gin_jwks_rsa.Config) {
+	t.Helper()
+	store := New(m.Addr(), "jwks:signing-key")
+	if configure != nil {
+		configure(store)
+	}
+	if err := store.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	config, err := gin_jwks_rsa.NewConfigBuilder().
+		WithRotationGrace(time.Hour).
+		ImportPrivateKey().
+		WithProvider(store).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		config.Close()
+	})
+	return store, config
+}
+
+// Wait until config signs with the key published under kid
+func waitForKid(t *testing.T, config *gin_jwks_rsa.Config, kid string, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for {
+		set, err := config.PublicJWKS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := config.Signer().Sign(map[string]interface{}{"sub": "me"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := set.LookupKeyID(kid); ok && signedBy(t, token) == kid {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the replica did not switch to %q within %s", kid, within)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func signedBy(t *testing.T, token string) string {
+	t.Helper()
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.Signatures()[0].ProtectedHeaders().KeyID()
+}
+
+// Wait until n replicas listen on the channel
+func waitForSubscribers(t *testing.T, m *miniredis.Miniredis, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.PubSubNumSub(DefaultChannel)[DefaultChannel] < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d replicas subscribed, expected %d", m.PubSubNumSub(DefaultChannel)[DefaultChannel], n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRotationReachesTheOtherReplicas(t *testing.T) {
+	m := miniredis.RunT(t)
+	seed := New(m.Addr(), "jwks:signing-key")
+	if err := seed.Open(context.Background()); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("empty store opened with %v", err)
+	}
+	if err := seed.Rotate(context.Background(), newECKey(t), "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// polling alone would take an hour
+	slowPoll := func(s *Store) { s.PollInterval = time.Hour }
+	rotating, first := newReplica(t, m, slowPoll)
+	_, second := newReplica(t, m, slowPoll)
+	waitForKid(t, second, "first", time.Second)
+	waitForSubscribers(t, m, 2)
+
+	start := time.Now()
+	if err := rotating.Rotate(context.Background(), newECKey(t), "next"); err != nil {
+		t.Fatal(err)
+	}
+	waitForKid(t, first, "next", time.Second)
+	waitForKid(t, second, "next", time.Second)
+	t.Logf("replicas converged in %s", time.Since(start))
+
+	// the previous key stays published for the grace period
+	set, err := second.PublicJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set.LookupKeyID("first"); !ok {
+		t.Fatal("the previous key was dropped before the end of its grace period")
+	}
+}
+
+func TestMessagesAreOnlyHints(t *testing.T) {
+	m := miniredis.RunT(t)
+	if err := New(m.Addr(), "jwks:signing-key").Rotate(context.Background(), newECKey(t), "first"); err != nil {
+		t.Fatal(err)
+	}
+	store, config := newReplica(t, m, func(s *Store) { s.PollInterval = time.Hour })
+	renewals := make(chan struct{}, 10)
+	store.OnRenew(func() { renewals <- struct{}{} })
+	waitForSubscribers(t, m, 1)
+
+	// a forged announcement makes the replica read the store, which did not change
+	m.Publish(DefaultChannel, "forged")
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-renewals:
+		t.Fatal("a message without a new key renewed the signer")
+	default:
+	}
+
+	// the key is read from the store, whatever the message says
+	if err := m.Set("jwks:signing-key", string(pemOf(t, "stored"))); err != nil {
+		t.Fatal(err)
+	}
+	m.Publish(DefaultChannel, "forged")
+	waitForKid(t, config, "stored", time.Second)
+}
+
+func TestPollingCatchesMissedRotations(t *testing.T) {
+	m := miniredis.RunT(t)
+	if err := New(m.Addr(), "jwks:signing-key").Rotate(context.Background(), newECKey(t), "first"); err != nil {
+		t.Fatal(err)
+	}
+	// listening on a channel nobody announces on, as if every message was lost
+	_, config := newReplica(t, m, func(s *Store) {
+		s.Channel = "elsewhere"
+		s.PollInterval = 50 * time.Millisecond
+	})
+
+	if err := New(m.Addr(), "jwks:signing-key").Rotate(context.Background(), newECKey(t), "next"); err != nil {
+		t.Fatal(err)
+	}
+	waitForKid(t, config, "next", time.Second)
+}
+
+func TestSubscriptionComesBackAfterADrop(t *testing.T) {
+	m := miniredis.RunT(t)
+	if err := New(m.Addr(), "jwks:signing-key").Rotate(context.Background(), newECKey(t), "first"); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 10)
+	_, config := newReplica(t, m, func(s *Store) {
+		s.PollInterval = time.Hour
+		s.OnError = func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+	})
+	waitForSubscribers(t, m, 1)
+
+	// the server goes away, taking the subscription with it, and comes back
+	m.Close()
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("the dropped subscription was not reported")
+	}
+	waitForSubscribers(t, m, 1)
+
+	if err := New(m.Addr(), "jwks:signing-key").Rotate(context.Background(), newECKey(t), "next"); err != nil {
+		t.Fatal(err)
+	}
+	waitForKid(t, config, "next", time.Second)
+}
+
+func TestOpenRefusesAnInvalidKey(t *testing.T) {
+	m := miniredis.RunT(t)
+	if err := m.Set("jwks:signing-key", "not a key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(m.Addr(), "jwks:signing-key").Open(context.Background()); err == nil {
+		t.Fatal("an invalid stored key was loaded")
+	}
+}
+
+// PEM the store would hold for a new key under kid
+func pemOf(t *testing.T, kid string) []byte {
+	t.Helper()
+	m := miniredis.RunT(t)
+	if err := New(m.Addr(), "key").Rotate(context.Background(), newECKey(t), kid); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := m.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(stored)
+}
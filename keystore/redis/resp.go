@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// longest bulk string read, the key PEM is a few kB at most
+const maxBulkLength = 1 << 20
+
+// Error replied by the server, e.g. WRONGTYPE or NOAUTH
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Connection speaking RESP, the subset the store needs
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// Connect to the store, authenticated and on its database
+func (s *Store) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: s.timeout()}
+	c, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to redis %v", err)
+	}
+	cn := &conn{c: c, r: bufio.NewReader(c)}
+	if s.Password != "" {
+		if _, err = cn.do(s.timeout(), "AUTH", s.Password); err != nil {
+			cn.close()
+			return nil, fmt.Errorf("cannot authenticate to redis %v", err)
+		}
+	}
+	if s.DB != 0 {
+		if _, err = cn.do(s.timeout(), "SELECT", strconv.Itoa(s.DB)); err != nil {
+			cn.close()
+			return nil, fmt.Errorf("cannot select redis database %d %v", s.DB, err)
+		}
+	}
+	return cn, nil
+}
+
+// Send a command and read its reply within timeout
+func (c *conn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if err := c.c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := reply.(Error); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+// Write a command as an array of bulk strings
+func (c *conn) send(args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := c.c.Write(buf)
+	return err
+}
+
+// Read a reply: a string, an Error, an int64, a []byte, nil or a []interface{}
+func (c *conn) read() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > maxBulkLength {
+			return nil, fmt.Errorf("invalid redis bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > maxBulkLength {
+			return nil, fmt.Errorf("invalid redis array length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line[:1])
+}
+
+func (c *conn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("redis reply line too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *conn) close() {
+	c.c.Close()
+}
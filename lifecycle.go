@@ -0,0 +1,95 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"time"
+)
+
+// Planned end of validity of the imported key, published as exp with
+// WithPublishKeyLifecycle. A reloaded key keeps the same one.
+func (n *ConfigImportKeyBuilder) WithNotAfter(t time.Time) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.notAfter = t
+	return n
+}
+
+// Stop serving the signing key once its planned end of validity is over, the
+// key set endpoint answers as if there was no key until it is rotated
+func (n *ConfigBuilder) WithExpiredKeyPruning() *ConfigBuilder {
+	n.config.expiredKeyPruning = true
+	return n
+}
+
+// Call hook once a key reaches its planned end of validity: the signing key
+// past its WithNotAfter or auto rotation date, or a retired key at the end of
+// its grace period
+func (n *ConfigBuilder) OnKeyExpired(hook func(kid string, notAfter time.Time)) *ConfigBuilder {
+	n.config.onKeyExpired = hook
+	return n
+}
+
+// Planned end of validity of a signing key created at createdAt, zero when
+// the key has none
+func (c *Config) keyNotAfter(createdAt time.Time) time.Time {
+	switch {
+	case c.importPkOpts != nil && !c.importPkOpts.notAfter.IsZero():
+		return c.importPkOpts.notAfter
+	case c.autoRotation() > 0:
+		return createdAt.Add(c.autoRotation())
+	}
+	return time.Time{}
+}
+
+// Whether the signing key is withheld because its validity is over
+func (c *Config) keyExpired(active *activeKey, now time.Time) bool {
+	return c.expiredKeyPruning && active != nil && !active.notAfter.IsZero() && !now.Before(active.notAfter)
+}
+
+// End of the grace period of a retired key, zero for the other keys
+func (c *Config) retiredKeyUntil(kid string) time.Time {
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	return c.keys.retiredUntil[kid]
+}
+
+// Report the signing key once it reaches its end of validity, and stop
+// serving it with WithExpiredKeyPruning
+func (c *Config) checkKeyExpiry(now time.Time) {
+	if c.keys == nil {
+		return
+	}
+	active := c.active()
+	if active == nil || active.notAfter.IsZero() || now.Before(active.notAfter) {
+		return
+	}
+	c.keys.mu.Lock()
+	if c.keys.expiryReported == active {
+		c.keys.mu.Unlock()
+		return
+	}
+	c.keys.expiryReported = active
+	c.keys.mu.Unlock()
+
+	kid := active.key.KeyID()
+	if c.expiredKeyPruning {
+		c.reportError(fmt.Errorf("%w, the key %q expired at %s", ErrNoServableKey, kid, active.notAfter.Format(time.RFC3339)))
+		c.audit(AuditEvent{Action: AuditKeyPruned, KeyID: kid, Trigger: AuditTriggerExpiry})
+		c.keySetChanged()
+	}
+	c.keyExpiredHook(kid, active.notAfter)
+}
+
+func (c *Config) keyExpiredHook(kid string, notAfter time.Time) {
+	if c.onKeyExpired == nil {
+		return
+	}
+	c.runHook("OnKeyExpired", func() {
+		c.onKeyExpired(kid, notAfter)
+	})
+}
+
+// Check the signing key in the background too, so the hook fires and the
+// published copies of the key set drop it without waiting for a request
+func (c *Config) startKeyExpiryCheck() {
+	c.background.goWithTicker(keyAgeCheckInterval(time.Hour), c.checkKeyExpiry)
+}
@@ -0,0 +1,124 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTokenLimitExceeded is returned when a token is rejected by its size or
+// shape, before any decoding or signature check
+var ErrTokenLimitExceeded = errors.New("token exceeds parsing limits")
+
+// Bounds enforced on presented tokens before any crypto, a zero field takes
+// its DefaultTokenLimits value
+type TokenLimits struct {
+	// length of the compact serialization
+	MaxTokenLength int
+	// decoded size of the header and of the claims, each
+	MaxSegmentSize int
+	// nesting of JSON objects and arrays in the header and the claims
+	MaxJSONDepth int
+	// entries of the aud claim
+	MaxAudiences int
+}
+
+// Limits applied when none are configured
+var DefaultTokenLimits = TokenLimits{
+	MaxTokenLength: 8 * 1024,
+	MaxSegmentSize: 6 * 1024,
+	MaxJSONDepth:   16,
+	MaxAudiences:   32,
+}
+
+// Limits with each zero field replaced by its default
+func (l TokenLimits) withDefaults() TokenLimits {
+	if l.MaxTokenLength == 0 {
+		l.MaxTokenLength = DefaultTokenLimits.MaxTokenLength
+	}
+	if l.MaxSegmentSize == 0 {
+		l.MaxSegmentSize = DefaultTokenLimits.MaxSegmentSize
+	}
+	if l.MaxJSONDepth == 0 {
+		l.MaxJSONDepth = DefaultTokenLimits.MaxJSONDepth
+	}
+	if l.MaxAudiences == 0 {
+		l.MaxAudiences = DefaultTokenLimits.MaxAudiences
+	}
+	return l
+}
+
+// Check a compact JWS against the limits, the cheap checks come first so an
+// oversized token never gets decoded
+func checkTokenLimits(token string, limits TokenLimits) error {
+	limits = limits.withDefaults()
+	if len(token) > limits.MaxTokenLength {
+		return fmt.Errorf("%w: longer than %d bytes", ErrTokenLimitExceeded, limits.MaxTokenLength)
+	}
+	if strings.Count(token, ".") != 2 {
+		return fmt.Errorf("%w: not a compact JWS", ErrTokenLimitExceeded)
+	}
+
+	segments := strings.SplitN(token, ".", 3)
+	var claims []byte
+	for i, segment := range segments[:2] {
+		if base64.RawURLEncoding.DecodedLen(len(segment)) > limits.MaxSegmentSize {
+			return fmt.Errorf("%w: segment larger than %d bytes", ErrTokenLimitExceeded, limits.MaxSegmentSize)
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(segment)
+		if err != nil {
+			return fmt.Errorf("%w: invalid base64 segment", ErrTokenLimitExceeded)
+		}
+		if jsonDepth(decoded) > limits.MaxJSONDepth {
+			return fmt.Errorf("%w: JSON nested deeper than %d", ErrTokenLimitExceeded, limits.MaxJSONDepth)
+		}
+		if i == 1 {
+			claims = decoded
+		}
+	}
+
+	var aud struct {
+		Aud json.RawMessage `json:"aud"`
+	}
+	if err := json.Unmarshal(claims, &aud); err != nil {
+		return fmt.Errorf("%w: invalid claims", ErrTokenLimitExceeded)
+	}
+	if len(aud.Aud) > 0 && aud.Aud[0] == '[' {
+		var audiences []json.RawMessage
+		if err := json.Unmarshal(aud.Aud, &audiences); err != nil {
+			return fmt.Errorf("%w: invalid aud claim", ErrTokenLimitExceeded)
+		}
+		if len(audiences) > limits.MaxAudiences {
+			return fmt.Errorf("%w: more than %d audiences", ErrTokenLimitExceeded, limits.MaxAudiences)
+		}
+	}
+
+	return nil
+}
+
+// Deepest nesting of objects and arrays, without allocating
+func jsonDepth(data []byte) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, b := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && b == '\\':
+			escaped = true
+		case b == '"':
+			inString = !inString
+		case inString:
+		case b == '{' || b == '[':
+			depth++
+			if depth > deepest {
+				deepest = depth
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return deepest
+}
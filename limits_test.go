@@ -0,0 +1,134 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func compactToken(header, claims string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+func FuzzCheckTokenLimits(f *testing.F) {
+	header := `{"alg":"RS256"}`
+	for _, seed := range []string{
+		compactToken(header, `{"aud":["a","b"]}`),
+		compactToken(header, strings.Repeat("[", 32)+strings.Repeat("]", 32)),
+		compactToken(header, `{"aud":"a","s":"\"{[{["}`),
+		compactToken(header, `{"aud":["a"`+strings.Repeat(`,"a"`, 40)+`]}`),
+		"a.b.c.d",
+		strings.Repeat("a", 9*1024),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, token string) {
+		limits := DefaultTokenLimits
+		if checkTokenLimits(token, limits) != nil {
+			return
+		}
+		if len(token) > limits.MaxTokenLength || strings.Count(token, ".") != 2 {
+			t.Fatalf("a token of %d bytes with %d dots passed the limits", len(token), strings.Count(token, "."))
+		}
+		for _, segment := range strings.SplitN(token, ".", 3)[:2] {
+			decoded, err := base64.RawURLEncoding.DecodeString(segment)
+			if err != nil || len(decoded) > limits.MaxSegmentSize || jsonDepth(decoded) > limits.MaxJSONDepth {
+				t.Fatalf("segment %q passed the limits", segment)
+			}
+		}
+	})
+}
+
+// Tokens taken out of the Authorization header, the WebSocket subprotocols or
+// the query go through the limits before any parsing
+func FuzzExtractedTokenLimits(f *testing.F) {
+	token := compactToken(`{"alg":"RS256"}`, `{"aud":"a"}`)
+	for _, seed := range [][3]string{
+		{"Bearer " + token, "", ""},
+		{"bearer  " + token, "", ""},
+		{"Bearer", "", ""},
+		{"", "bearer, " + token, ""},
+		{"", "chat, BEARER," + token + ",bearer", ""},
+		{"", "bearer", "access_token=" + token},
+		{"", "", "access_token=" + strings.Repeat("a.", 5000)},
+		{"", "", "access_token=%zz&access_token=a.b.c"},
+	} {
+		f.Add(seed[0], seed[1], seed[2])
+	}
+	f.Fuzz(func(t *testing.T, authorization, protocols, query string) {
+		r := &http.Request{Header: http.Header{}, URL: &url.URL{RawQuery: query}}
+		r.Header.Set("Authorization", authorization)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Sec-WebSocket-Protocol", protocols)
+
+		var tokens []string
+		if token, ok := bearerToken(r); ok {
+			if token == "" || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") || !strings.HasSuffix(authorization, token) {
+				t.Fatalf("bearer token %q taken out of %q", token, authorization)
+			}
+			tokens = append(tokens, token)
+		}
+		if token, ok := WebSocketToken(r); ok {
+			if token == "" {
+				t.Fatal("empty WebSocket token")
+			}
+			// a token taken from the query is removed from it
+			if r.URL.Query().Get(AccessTokenQueryParam) == token && !strings.Contains(protocols, token) {
+				t.Fatalf("token %q left in the query %q", token, r.URL.RawQuery)
+			}
+			tokens = append(tokens, token)
+		}
+
+		limits := DefaultTokenLimits
+		for _, token := range tokens {
+			if checkTokenLimits(token, limits) == nil && (len(token) > limits.MaxTokenLength || strings.Count(token, ".") != 2) {
+				t.Fatalf("a token of %d bytes with %d dots passed the limits", len(token), strings.Count(token, "."))
+			}
+		}
+	})
+}
+
+func TestCheckTokenLimits(t *testing.T) {
+	header := `{"alg":"ES256"}`
+	deep := strings.Repeat(`{"a":`, 20) + `1` + strings.Repeat(`}`, 20)
+	audiences := `{"aud":["a"` + strings.Repeat(`,"a"`, 40) + `]}`
+
+	for _, tt := range []struct {
+		name   string
+		token  string
+		limits TokenLimits
+		refuse bool
+	}{
+		{"within the defaults", compactToken(header, `{"aud":["a","b"]}`), DefaultTokenLimits, false},
+		{"too long", strings.Repeat("a", 9*1024), DefaultTokenLimits, true},
+		{"not compact", "a.b", DefaultTokenLimits, true},
+		{"too deep", compactToken(header, deep), DefaultTokenLimits, true},
+		{"too many audiences", compactToken(header, audiences), DefaultTokenLimits, true},
+		{"invalid base64", "a!.b.c", DefaultTokenLimits, true},
+		// zero fields take their default rather than refusing every token
+		{"zero limits", compactToken(header, `{"sub":"me"}`), TokenLimits{}, false},
+		{"zero depth keeps the default", compactToken(header, deep), TokenLimits{MaxTokenLength: 16 * 1024}, true},
+		{"raised depth", compactToken(header, deep), TokenLimits{MaxJSONDepth: 32}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTokenLimits(tt.token, tt.limits)
+			if tt.refuse != (err != nil) {
+				t.Fatalf("expected refused %v, got %v", tt.refuse, err)
+			}
+			if err != nil && !errors.Is(err, ErrTokenLimitExceeded) {
+				t.Fatalf("%v does not match ErrTokenLimitExceeded", err)
+			}
+		})
+	}
+}
+
+func TestJSONDepthIgnoresStrings(t *testing.T) {
+	if depth := jsonDepth([]byte(`{"a":"{[{[\"{"}`)); depth != 1 {
+		t.Fatalf("depth %d, expected 1", depth)
+	}
+}
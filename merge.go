@@ -0,0 +1,63 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MergeConfigs returns a config serving the keys of every config at once, e.g.
+// one key set endpoint for keys managed apart. It follows the rotations and
+// reloads of the configs, and takes the serving settings of the first one,
+// such as caching, CORS or authorization. A kid published by two configs is
+// an error, when merging and when serving. The merged config holds no key of
+// its own, it cannot sign nor rotate.
+func MergeConfigs(configs ...*Config) (*Config, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%w, no config to merge", ErrNoKeyConfigured)
+	}
+	for i, config := range configs {
+		if config == nil {
+			return nil, fmt.Errorf("config %d cannot be nil", i)
+		}
+	}
+	if _, err := mergedJWKSKeys(configs); err != nil && !errors.Is(err, ErrNoServableKey) {
+		return nil, err
+	}
+
+	merged := *configs[0]
+	merged.keys = nil
+	merged.generation = nil
+	merged.publisher = nil
+	merged.background = newBackground()
+	merged.aliases = newKidAliases()
+	merged.merged = append([]*Config{}, configs...)
+	return &merged, nil
+}
+
+// Keys served by each config, a config without a key to serve is skipped
+func mergedJWKSKeys(configs []*Config) ([]JkwsResponse, error) {
+	var keys []JkwsResponse
+	owners := map[string]int{}
+	servable := false
+	for i, config := range configs {
+		configKeys, err := config.jwksKeys()
+		if errors.Is(err, ErrNoServableKey) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot merge the keys of config %d %v", i, err)
+		}
+		servable = true
+		for _, key := range configKeys {
+			if owner, ok := owners[key.KeyIDKey]; ok && owner != i {
+				return nil, fmt.Errorf("kid %q is published by configs %d and %d", key.KeyIDKey, owner, i)
+			}
+			owners[key.KeyIDKey] = i
+		}
+		keys = append(keys, configKeys...)
+	}
+	if !servable {
+		return nil, ErrNoServableKey
+	}
+	return keys, nil
+}
@@ -0,0 +1,99 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func newKidConfig(t *testing.T, kid string) *Config {
+	t.Helper()
+	config, err := NewConfigBuilder().ImportPrivateKey().WithRawKey(newRSAKey(t)).WithKeyId(kid).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.Close() })
+	return config
+}
+
+func TestMergeConfigs(t *testing.T) {
+	first, second := newKidConfig(t, "first"), newKidConfig(t, "second")
+	merged, err := MergeConfigs(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kids := servedKids(t, merged); !reflect.DeepEqual(kids, []string{"first", "second"}) {
+		t.Fatalf("merged config serves %v", kids)
+	}
+	if err = merged.Ready(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the merged key set follows the rotations of its configs
+	if err = second.Rotate("third"); err != nil {
+		t.Fatal(err)
+	}
+	if kids := servedKids(t, merged); !reflect.DeepEqual(kids, []string{"first", "third"}) {
+		t.Fatalf("merged config serves %v after the rotation", kids)
+	}
+
+	// it holds no key of its own
+	if _, err = merged.Signer().Sign(map[string]interface{}{"sub": "me"}); err == nil {
+		t.Fatal("the merged config signed")
+	}
+	if err = merged.Rotate("fourth"); err == nil {
+		t.Fatal("the merged config rotated")
+	}
+}
+
+func TestMergeConfigsRefusesDuplicateKids(t *testing.T) {
+	first, second := newKidConfig(t, "first"), newKidConfig(t, "second")
+	if _, err := MergeConfigs(first, newKidConfig(t, "first")); err == nil {
+		t.Fatal("merged two keys under the same kid")
+	}
+
+	// a rotation to a kid published by the other config fails the key set
+	merged, err := MergeConfigs(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = second.ReplaceKey(newAdditionalKey(t, "first"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveJWKS(Jkws(*merged)); w.Code != http.StatusInternalServerError {
+		t.Fatalf("key set with a duplicate kid answered %d", w.Code)
+	}
+}
+
+func TestMergeConfigsArguments(t *testing.T) {
+	if _, err := MergeConfigs(); !errors.Is(err, ErrNoKeyConfigured) {
+		t.Fatalf("merged nothing with %v", err)
+	}
+	if _, err := MergeConfigs(newKidConfig(t, "first"), nil); err == nil {
+		t.Fatal("merged a nil config")
+	}
+
+	// a config without a key is skipped, the merged config is ready while
+	// one of its configs is
+	first, second := newKidConfig(t, "first"), newKidConfig(t, "second")
+	merged, err := MergeConfigs(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = first.RemoveKey("first", true); err != nil {
+		t.Fatal(err)
+	}
+	if kids := servedKids(t, merged); !reflect.DeepEqual(kids, []string{"second"}) || merged.Ready() != nil {
+		t.Fatalf("merged config serves %v, ready %v", kids, merged.Ready())
+	}
+	if err = second.RemoveKey("second", true); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(merged.Ready(), ErrNoServableKey) {
+		t.Fatalf("merged config without keys ready: %v", merged.Ready())
+	}
+	if w := serveJWKS(Jkws(*merged)); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("merged config without keys answered %d", w.Code)
+	}
+}
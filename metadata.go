@@ -0,0 +1,99 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"strings"
+)
+
+const (
+	DefaultJwksPath                      = "/.well-known/jwks.json"
+	AuthorizationServerMetadataWellKnown = "/.well-known/oauth-authorization-server"
+)
+
+// Endpoints and capabilities advertised by the metadata documents
+type MetadataOptions struct {
+	// path of the jkws handler relative to the issuer, DefaultJwksPath if empty
+	JwksPath string
+	// absolute URLs of the token and introspection endpoints, omitted if empty.
+	// RegisterJWKS sets them to the handlers it mounts
+	TokenEndpoint         string
+	IntrospectionEndpoint string
+	// only advertised along with the token endpoint
+	GrantTypesSupported               []string
+	TokenEndpointAuthMethodsSupported []string
+	// extra members, they cannot override the computed ones
+	Extra map[string]interface{}
+	// where RegisterJWKS mounted the key set, wins over JwksPath
+	jwksURI string
+}
+
+// Members shared by every metadata document so they cannot disagree
+func serverMetadata(issuer string, opts MetadataOptions) (map[string]interface{}, error) {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse issuer %v", err)
+	}
+	if (issuerURL.Scheme != "https" && issuerURL.Scheme != "http") || issuerURL.Host == "" {
+		return nil, fmt.Errorf("issuer must be an absolute http(s) URL")
+	}
+	if issuerURL.RawQuery != "" || issuerURL.Fragment != "" {
+		return nil, fmt.Errorf("issuer cannot have a query or a fragment")
+	}
+
+	jwksPath := opts.JwksPath
+	if jwksPath == "" {
+		jwksPath = DefaultJwksPath
+	}
+
+	metadata := map[string]interface{}{}
+	for k, v := range opts.Extra {
+		metadata[k] = v
+	}
+	metadata["issuer"] = issuer
+	metadata["jwks_uri"] = strings.TrimSuffix(issuer, "/") + jwksPath
+	if opts.jwksURI != "" {
+		metadata["jwks_uri"] = opts.jwksURI
+	}
+	if opts.TokenEndpoint != "" {
+		metadata["token_endpoint"] = opts.TokenEndpoint
+		if len(opts.GrantTypesSupported) > 0 {
+			metadata["grant_types_supported"] = opts.GrantTypesSupported
+		}
+		if len(opts.TokenEndpointAuthMethodsSupported) > 0 {
+			metadata["token_endpoint_auth_methods_supported"] = opts.TokenEndpointAuthMethodsSupported
+		}
+	}
+	if opts.IntrospectionEndpoint != "" {
+		metadata["introspection_endpoint"] = opts.IntrospectionEndpoint
+	}
+
+	return metadata, nil
+}
+
+// AuthorizationServerMetadataPath returns where the RFC 8414 document of issuer
+// lives: the well-known segment goes between the host and the issuer path
+func AuthorizationServerMetadataPath(issuer string) (string, error) {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse issuer %v", err)
+	}
+
+	return AuthorizationServerMetadataWellKnown + strings.TrimSuffix(issuerURL.EscapedPath(), "/"), nil
+}
+
+// AuthorizationServerMetadata serves the RFC 8414 OAuth 2.0 authorization server metadata
+func AuthorizationServerMetadata(issuer string, opts MetadataOptions) gin.HandlerFunc {
+	metadata, err := serverMetadata(issuer, opts)
+
+	return func(c *gin.Context) {
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		c.JSON(200, metadata)
+	}
+}
@@ -0,0 +1,105 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizationServerMetadataFollowsTheRegisteredHandlers(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	issuer := "https://auth.example.com/tenant"
+	exchange := TokenExchangeOptions{
+		VerifySubjectToken: func(context.Context, string) (jwt.Token, error) { return jwt.New(), nil },
+		Policy: func(jwt.Token, TokenExchangeRequest) (TokenExchangeGrant, error) {
+			return TokenExchangeGrant{}, nil
+		},
+	}
+	document := func(t *testing.T, r *gin.Engine, path string) map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &metadata); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s answered %d %s", path, w.Code, w.Body)
+		}
+		return metadata
+	}
+
+	for name, tt := range map[string]struct {
+		opts          []RouteOption
+		token         interface{}
+		introspection interface{}
+		grantTypes    interface{}
+	}{
+		"key set only": {},
+		"introspection": {
+			opts:          []RouteOption{WithIntrospection("/tenant/introspect")},
+			introspection: issuer + "/introspect",
+		},
+		"token exchange": {
+			opts:       []RouteOption{WithTokenExchange("/tenant/token", exchange)},
+			token:      issuer + "/token",
+			grantTypes: []interface{}{GrantTypeTokenExchange},
+		},
+		"both": {
+			opts:          []RouteOption{WithTokenExchange("/tenant/token", exchange), WithIntrospection("/tenant/introspect")},
+			token:         issuer + "/token",
+			introspection: issuer + "/introspect",
+			grantTypes:    []interface{}{GrantTypeTokenExchange},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := gin.New()
+			opts := append([]RouteOption{
+				WithJWKSPath("/tenant/keys"),
+				WithAuthorizationServerMetadata(issuer, MetadataOptions{}),
+				WithOpenIDConfiguration(issuer, MetadataOptions{}),
+			}, tt.opts...)
+			if err := RegisterJWKS(r, config, opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			// RFC 8414 puts the well-known segment before the issuer path
+			metadata := document(t, r, "/.well-known/oauth-authorization-server/tenant")
+			if metadata["issuer"] != issuer || metadata["jwks_uri"] != issuer+"/keys" {
+				t.Fatalf("metadata advertises %v", metadata)
+			}
+			if metadata["token_endpoint"] != tt.token || metadata["introspection_endpoint"] != tt.introspection {
+				t.Fatalf("metadata advertises %v", metadata)
+			}
+			if grantTypes, _ := json.Marshal(metadata["grant_types_supported"]); string(grantTypes) != mustMarshal(t, tt.grantTypes) {
+				t.Fatalf("metadata advertises the grant types %s", grantTypes)
+			}
+
+			// the shared members of the discovery document agree
+			discovery := document(t, r, "/tenant"+OpenIDConfigurationWellKnown)
+			for member, value := range metadata {
+				if got, _ := json.Marshal(discovery[member]); string(got) != mustMarshal(t, value) {
+					t.Fatalf("discovery document advertises %s %s, metadata %v", member, got, value)
+				}
+			}
+
+			for path, registered := range map[string]bool{"/tenant/token": tt.token != nil, "/tenant/introspect": tt.introspection != nil} {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+				if (w.Code != http.StatusNotFound) != registered {
+					t.Fatalf("POST %s answered %d", path, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
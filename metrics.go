@@ -0,0 +1,132 @@
+package gin_jwks_rsa
+
+import (
+	"expvar"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Measurements shared by every metrics backend so their numbers cannot diverge
+type instrumentation interface {
+	requestServed(status int)
+	keysPublished(count int)
+	keyActivated(createdAt time.Time)
+	keyRotated()
+	verification(outcome string)
+}
+
+// Hand a measurement over to every configured backend
+func (c *Config) observe(fn func(instrumentation)) {
+	for _, i := range c.instruments {
+		fn(i)
+	}
+}
+
+// Keys in the key set: the active key, the additional ones and the
+// encryption key when it is published
+func (c *Config) publishedKeyCount() int {
+	active, additional := c.keySnapshot()
+	count := len(additional)
+	if active != nil {
+		count++
+	}
+	if c.publishEncKey && c.encryptionKey() != nil {
+		count++
+	}
+	return count
+}
+
+// expvar.Publish panics on duplicates, serialise the lookups
+var expvarMu sync.Mutex
+
+// Metrics registered by prefix. Published vars cannot be removed, a config
+// built again with the same prefix, e.g. on a reload, takes them over.
+var expvarRegistered = map[string]*expvarMetrics{}
+
+// Publish the metrics with expvar under names starting with prefix
+func (n *ConfigBuilder) WithExpvarMetrics(prefix string) *ConfigBuilder {
+	n.config.expvarPrefix = prefix
+	return n
+}
+
+// Metrics backend exposed through /debug/vars
+type expvarMetrics struct {
+	requests      *expvar.Map
+	keys          *expvar.Int
+	rotations     *expvar.Int
+	verifications *expvar.Map
+	// unix nano timestamp of the active key creation
+	keyCreatedAt int64
+}
+
+// Register the expvar metrics, or return those already registered under
+// prefix. Fails if a name is taken by another package.
+func newExpvarMetrics(prefix string) (*expvarMetrics, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("expvar prefix cannot be empty")
+	}
+
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if m, ok := expvarRegistered[prefix]; ok {
+		return m, nil
+	}
+
+	m := &expvarMetrics{
+		requests:      new(expvar.Map).Init(),
+		keys:          new(expvar.Int),
+		rotations:     new(expvar.Int),
+		verifications: new(expvar.Map).Init(),
+	}
+	vars := map[string]expvar.Var{
+		prefix + "_requests_total":          m.requests,
+		prefix + "_keys":                    m.keys,
+		prefix + "_signing_key_age_seconds": expvar.Func(m.keyAge),
+		prefix + "_rotations_total":         m.rotations,
+		prefix + "_verifications_total":     m.verifications,
+	}
+
+	for name := range vars {
+		if expvar.Get(name) != nil {
+			return nil, fmt.Errorf("expvar %q is already published", name)
+		}
+	}
+	for name, v := range vars {
+		expvar.Publish(name, v)
+	}
+	expvarRegistered[prefix] = m
+
+	return m, nil
+}
+
+func (m *expvarMetrics) keyAge() interface{} {
+	createdAt := atomic.LoadInt64(&m.keyCreatedAt)
+	if createdAt == 0 {
+		return 0
+	}
+
+	return int64(time.Since(time.Unix(0, createdAt)).Seconds())
+}
+
+func (m *expvarMetrics) requestServed(status int) {
+	m.requests.Add(strconv.Itoa(status), 1)
+}
+
+func (m *expvarMetrics) keysPublished(count int) {
+	m.keys.Set(int64(count))
+}
+
+func (m *expvarMetrics) keyActivated(createdAt time.Time) {
+	atomic.StoreInt64(&m.keyCreatedAt, createdAt.UnixNano())
+}
+
+func (m *expvarMetrics) keyRotated() {
+	m.rotations.Add(1)
+}
+
+func (m *expvarMetrics) verification(outcome string) {
+	m.verifications.Add(outcome, 1)
+}
@@ -0,0 +1,64 @@
+package gin_jwks_rsa
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func expvarInt(t *testing.T, name string) int64 {
+	t.Helper()
+	v, ok := expvar.Get(name).(*expvar.Int)
+	if !ok {
+		t.Fatalf("expvar %q is not published", name)
+	}
+	return v.Value()
+}
+
+func TestExpvarMetricsFollowTheKeySet(t *testing.T) {
+	clock := newFakeClock()
+	builder := NewConfigBuilder().WithRotationGrace(time.Hour).WithExpvarMetrics("test_key_set")
+	builder.config.keys.now = clock.Now
+	config := newTestConfig(t, builder)
+	if keys := expvarInt(t, "test_key_set_keys"); keys != 1 {
+		t.Fatalf("built with %d keys published", keys)
+	}
+
+	rotated := expvarInt(t, "test_key_set_rotations_total")
+	if err := config.Rotate("next"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, rotations := expvarInt(t, "test_key_set_keys"), expvarInt(t, "test_key_set_rotations_total")-rotated; keys != 2 || rotations != 1 {
+		t.Fatalf("after a rotation %d keys published and %d rotations counted", keys, rotations)
+	}
+
+	clock.Advance(time.Hour + time.Minute)
+	config.pruneRetiredKeys(clock.Now())
+	if keys := expvarInt(t, "test_key_set_keys"); keys != 1 {
+		t.Fatalf("after the grace period %d keys published", keys)
+	}
+}
+
+func TestExpvarMetricsSurviveARebuild(t *testing.T) {
+	first := newTestConfig(t, NewConfigBuilder().WithExpvarMetrics("test_rebuild"))
+	rotated := expvarInt(t, "test_rebuild_rotations_total")
+	if err := first.Rotate("next"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reloaded config takes over the published vars
+	second := newTestConfig(t, NewConfigBuilder().WithExpvarMetrics("test_rebuild"))
+	if err := second.Rotate("next"); err != nil {
+		t.Fatal(err)
+	}
+	if rotations := expvarInt(t, "test_rebuild_rotations_total") - rotated; rotations != 2 {
+		t.Fatalf("%d rotations counted across the rebuild", rotations)
+	}
+
+	if expvar.Get("test_taken_keys") == nil {
+		expvar.NewInt("test_taken_keys")
+	}
+	if _, err := NewConfigBuilder().WithExpvarMetrics("test_taken").NewPrivateKey().WithKeyLength(2048).Build(); err == nil {
+		t.Fatal("a name published by another package was taken over")
+	}
+}
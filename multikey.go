@@ -0,0 +1,203 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"time"
+)
+
+// Publish key along with the key of the config, e.g. the key used before a
+// rotation so the tokens it signed keep verifying. Only its public part is
+// kept, it needs a kid of its own. Call it once per key.
+func (n *ConfigBuilder) WithAdditionalKey(key jwk.Key) *ConfigBuilder {
+	n.config.pendingKeys = append(n.config.pendingKeys, key)
+	return n
+}
+
+// Turn the keys given to WithAdditionalKey into the published public keys,
+// kids must be unique across them and the active key
+func (c *Config) buildAdditionalKeys(activeKid string) error {
+	set := jwk.NewSet()
+	seen := map[string]bool{activeKid: true}
+	for i, key := range c.pendingKeys {
+		if key == nil {
+			return fmt.Errorf("additional key %d cannot be nil", i)
+		}
+		kid := key.KeyID()
+		if kid == "" {
+			return fmt.Errorf("additional key %d has no kid", i)
+		}
+		if seen[kid] {
+			return fmt.Errorf("duplicate kid %q", kid)
+		}
+		seen[kid] = true
+
+		if usage := key.KeyUsage(); usage != "" && usage != KeyUsageAsSignature {
+			return fmt.Errorf("additional key %q cannot be used for %q", kid, usage)
+		}
+		pubKey, err := key.PublicKey()
+		if err != nil {
+			return fmt.Errorf("failed to create public key of additional key %q %v", kid, err)
+		}
+		_, isRSA := pubKey.(jwk.RSAPublicKey)
+		_, isEC := pubKey.(jwk.ECDSAPublicKey)
+		if !isRSA && !isEC && !isEd25519PublicKey(pubKey) {
+			return fmt.Errorf("additional key %q: expected an RSA, EC or Ed25519 key, got %T", kid, key)
+		}
+		if _, err = signatureAlgorithm(pubKey); err != nil {
+			return fmt.Errorf("additional key %q %v", kid, err)
+		}
+		if err = pubKey.Set(jwk.KeyUsageKey, KeyUsageAsSignature); err != nil {
+			return fmt.Errorf("cannot add a use property to additional key %q %v", kid, err)
+		}
+		if err = set.AddKey(pubKey); err != nil {
+			return fmt.Errorf("cannot add additional key %q to the key set %v", kid, err)
+		}
+	}
+
+	c.keys.mu.Lock()
+	c.keys.additional = set
+	c.keys.mu.Unlock()
+	c.pendingKeys = nil
+	return nil
+}
+
+// Public keys published besides the active one
+func (c *Config) additionalKeyList() []jwk.Key {
+	if c.keys == nil {
+		return nil
+	}
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	return c.keys.additionalKeys(c.keys.now())
+}
+
+// Active key and additional keys read at once, so a concurrent rotation is
+// seen entirely or not at all
+func (c *Config) keySnapshot() (*activeKey, []jwk.Key) {
+	if c == nil || c.keys == nil {
+		return nil, nil
+	}
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	return c.keys.active, c.keys.additionalKeys(c.keys.now())
+}
+
+// Additional keys still published at now, the caller holds the lock
+func (r *keyRing) additionalKeys(now time.Time) []jwk.Key {
+	if r.additional == nil {
+		return nil
+	}
+	keys := make([]jwk.Key, 0, r.additional.Len())
+	for i := 0; i < r.additional.Len(); i++ {
+		key, _ := r.additional.Key(i)
+		if r.expired(key.KeyID(), now) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Additional key published under kid
+func (c *Config) additionalKey(kid string) (jwk.Key, bool) {
+	if c.keys == nil {
+		return nil, false
+	}
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	if c.keys.additional == nil {
+		return nil, false
+	}
+	if c.keys.expired(kid, c.keys.now()) {
+		return nil, false
+	}
+	return c.keys.additional.LookupKeyID(kid)
+}
+
+// Whether kid is the kid of an additional key
+func (c *Config) isAdditionalKid(kid string) bool {
+	_, ok := c.additionalKey(kid)
+	return ok
+}
+
+// RemoveKey stops publishing the key under kid. Removing the signing key
+// leaves the config unable to sign until ReplaceKey or Rotate installs
+// another, the other keys are still served and still verify. Removing the last key served leaves nothing to serve, so it
+// is refused unless force is set, e.g. when the key is compromised. Requests
+// being served see either the previous set or the new one.
+func (c *Config) RemoveKey(kid string, force bool) error {
+	if kid == "" {
+		return fmt.Errorf("kid cannot be empty")
+	}
+	if c.keys == nil {
+		return ErrNoServableKey
+	}
+
+	c.keys.mu.Lock()
+	active := c.keys.active
+	served := c.keys.additionalKeys(c.keys.now())
+	isActive := active != nil && kid == active.key.KeyID()
+	if active != nil {
+		served = append(served, active.key)
+	}
+	if len(served) == 0 {
+		c.keys.mu.Unlock()
+		return ErrNoServableKey
+	}
+	if len(served) == 1 && served[0].KeyID() == kid && !force {
+		c.keys.mu.Unlock()
+		return fmt.Errorf("cannot remove %q, it is the last key served", kid)
+	}
+	if isActive {
+		c.keys.active = nil
+		c.keys.mu.Unlock()
+	} else {
+		set, ok, err := withoutKey(c.keys.additional, kid)
+		if err != nil || !ok {
+			c.keys.mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("cannot remove key %q %v", kid, err)
+			}
+			return fmt.Errorf("unknown kid %q", kid)
+		}
+		c.keys.additional = set
+		delete(c.keys.retiredUntil, kid)
+		c.keys.mu.Unlock()
+	}
+
+	for _, alias := range c.aliasesOf(kid) {
+		if err := c.RemoveKidAlias(alias); err != nil {
+			c.reportError(err)
+		}
+	}
+	c.audit(AuditEvent{
+		Action:  AuditKeyRemoved,
+		KeyID:   kid,
+		Trigger: AuditTriggerAPI,
+	})
+	c.keySetChanged()
+
+	return nil
+}
+
+// Copy of set without the key published under kid, the set itself is left
+// untouched since readers may still hold it
+func withoutKey(set jwk.Set, kid string) (jwk.Set, bool, error) {
+	if set == nil {
+		return nil, false, nil
+	}
+	res := jwk.NewSet()
+	found := false
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if key.KeyID() == kid {
+			found = true
+			continue
+		}
+		if err := res.AddKey(key); err != nil {
+			return nil, false, err
+		}
+	}
+	return res, found, nil
+}
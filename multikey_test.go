@@ -0,0 +1,186 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAdditionalKey(t *testing.T, kid string) jwk.Key {
+	t.Helper()
+	key, err := jwk.FromRaw(newRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != "" {
+		if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return key
+}
+
+func TestAdditionalKeysArePublishedAndVerify(t *testing.T) {
+	next := newAdditionalKey(t, "next")
+	config := newTestConfig(t, NewConfigBuilder().WithAdditionalKey(next))
+
+	set := servedKeySet(t, config)
+	if set.Len() != 2 {
+		t.Fatalf("served key set holds %d keys", set.Len())
+	}
+	published, ok := set.LookupKeyID("next")
+	if !ok {
+		t.Fatal("the additional key is not published")
+	}
+	if _, ok = published.(jwk.RSAPrivateKey); ok {
+		t.Fatal("the private part of the additional key is published")
+	}
+	if _, ok = config.ResolveKid("next"); !ok {
+		t.Fatal("the kid of the additional key does not resolve")
+	}
+
+	// a token signed elsewhere with the additional key verifies here
+	token := jwt.New()
+	if err := token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// jws puts the kid of the key in the header
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, next))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = config.Verifier().Verify(context.Background(), string(signed)); err != nil {
+		t.Fatalf("token of the additional key refused: %v", err)
+	}
+
+	if err = config.AliasKid("next", "test"); err == nil {
+		t.Fatal("an alias shadows the additional key")
+	}
+}
+
+func TestAdditionalKeysNeedUniqueKids(t *testing.T) {
+	for name, builder := range map[string]*ConfigBuilder{
+		"no kid":            NewConfigBuilder().WithAdditionalKey(newAdditionalKey(t, "")),
+		"kid of the active": NewConfigBuilder().WithAdditionalKey(newAdditionalKey(t, "test")),
+		"kid used twice":    NewConfigBuilder().WithAdditionalKey(newAdditionalKey(t, "next")).WithAdditionalKey(newAdditionalKey(t, "next")),
+	} {
+		config, err := builder.ImportPrivateKey().WithPath(writeTestKey(t, newRSAKey(t))).WithKeyId("test").Build()
+		if err == nil {
+			config.Close()
+			t.Fatalf("%s: built", name)
+		}
+	}
+}
+
+func TestRemoveKey(t *testing.T) {
+	next := newAdditionalKey(t, "next")
+	config := newTestConfig(t, NewConfigBuilder().WithAdditionalKey(next))
+	if err := config.AliasKid("previous", "test"); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(jwt.New(), jwt.WithKey(jwa.RS256, next))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = config.RemoveKey("unknown", false); err == nil {
+		t.Fatal("an unknown kid was removed")
+	}
+	if err = config.RemoveKey("next", false); err != nil {
+		t.Fatal(err)
+	}
+	set := servedKeySet(t, config)
+	if _, ok := set.LookupKeyID("next"); ok || set.Len() != 1 {
+		t.Fatalf("served key set holds %d keys after the removal", set.Len())
+	}
+	if _, ok := config.ResolveKid("previous"); !ok {
+		t.Fatal("the alias of the signing key went with another key")
+	}
+	if _, err = config.Verifier().Verify(context.Background(), string(signed)); err == nil {
+		t.Fatal("token of the removed key accepted")
+	}
+
+	err = config.RemoveKey("test", false)
+	if err == nil || !strings.Contains(err.Error(), "last key served") {
+		t.Fatalf("the last key was removed without force: %v", err)
+	}
+	if err = config.RemoveKey("test", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.KidAliases()["previous"]; ok {
+		t.Fatal("the alias of the removed signing key survived")
+	}
+	if w := serveJWKS(Jkws(*config)); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("key set without any key answered %d", w.Code)
+	}
+}
+
+func TestRemoveSigningKeyKeepsTheOtherKeys(t *testing.T) {
+	next := newAdditionalKey(t, "next")
+	config := newTestConfig(t, NewConfigBuilder().WithAdditionalKey(next))
+	signed, err := jwt.Sign(jwt.New(), jwt.WithKey(jwa.RS256, next))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other keys are served, the signing key goes without force
+	if err = config.RemoveKey("test", false); err != nil {
+		t.Fatal(err)
+	}
+	set := servedKeySet(t, config)
+	if _, ok := set.LookupKeyID("next"); !ok || set.Len() != 1 {
+		t.Fatalf("served key set holds %d keys after removing the signing key", set.Len())
+	}
+	if err = config.Ready(); err != nil {
+		t.Fatalf("config serving a key is not ready: %v", err)
+	}
+	if _, err = config.Verifier().Verify(context.Background(), string(signed)); err != nil {
+		t.Fatalf("token of the remaining key refused: %v", err)
+	}
+	if _, err = config.Signer().Sign(map[string]interface{}{"sub": "me"}); err == nil {
+		t.Fatal("signed without a signing key")
+	}
+
+	err = config.RemoveKey("next", false)
+	if err == nil || !strings.Contains(err.Error(), "last key served") {
+		t.Fatalf("the last key was removed without force: %v", err)
+	}
+}
+
+func TestSigningKeyComesBackAfterForcedRemoval(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithRotationGrace(time.Hour))
+	if err := config.RemoveKey("test", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Signer().Sign(map[string]interface{}{"sub": "me"}); err == nil {
+		t.Fatal("signed with the removed key")
+	}
+
+	if err := config.ReplaceKey(newAdditionalKey(t, ""), "replaced"); err != nil {
+		t.Fatalf("no key installed after the removal: %v", err)
+	}
+	// the removed key is compromised, it is not retired into the grace period
+	if kids := servedKids(t, config); len(kids) != 1 || kids[0] != "replaced" {
+		t.Fatalf("served kids after the replacement: %v", kids)
+	}
+	checkSignsAndVerifies(t, config)
+
+	if err := config.RemoveKey("replaced", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Rotate("rotated"); err != nil {
+		t.Fatalf("no key generated after the removal: %v", err)
+	}
+	if config.active().key.KeyType() != jwa.RSA {
+		t.Fatalf("rotation after the removal generated a %s key", config.active().key.KeyType())
+	}
+	if kids := servedKids(t, config); len(kids) != 1 || kids[0] != "rotated" {
+		t.Fatalf("served kids after the rotation: %v", kids)
+	}
+	checkSignsAndVerifies(t, config)
+}
@@ -0,0 +1,54 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// ErrMultiPrimeUnsupported is returned when importing an RSA key with more than two primes
+var ErrMultiPrimeUnsupported = errors.New("multi-prime RSA keys are not supported, see AllowMultiPrime")
+
+// Accept RSA keys with more than two primes. The published public key (n, e)
+// is correct but some consumers still fail on keys produced by legacy
+// multi-prime systems, prefer re-issuing a two-prime key. Forces the self-test.
+func (n *ConfigImportKeyBuilder) AllowMultiPrime() *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.allowMultiPrime = true
+	n.config.selfTest = true
+	return n
+}
+
+// jwk cannot hold more than two primes, the jwk only keeps n, e and d and
+// signatures are computed with the raw key
+func multiPrimeJWK(rsaKey *rsa.PrivateKey) (jwk.Key, error) {
+	key, err := jwk.FromRaw(&rsa.PrivateKey{PublicKey: rsaKey.PublicKey, D: rsaKey.D})
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert multi-prime key %v", err)
+	}
+
+	return key, nil
+}
+
+// Key and protected headers handed to the signer: the jwk itself, or the raw
+// multi-prime key or provider signer along with the kid header jws only sets
+// for a jwk
+func (a *activeKey) signingKey(key jwk.Key) (interface{}, jws.Headers, error) {
+	headers := jws.NewHeaders()
+	var raw interface{}
+	switch {
+	case a.signer != nil:
+		raw = a.signer
+	case a.multiPrimeKey != nil:
+		raw = a.multiPrimeKey
+	default:
+		return key, headers, nil
+	}
+
+	if err := headers.Set(jws.KeyIDKey, key.KeyID()); err != nil {
+		return nil, nil, fmt.Errorf("cannot set kid header %v", err)
+	}
+	return raw, headers, nil
+}
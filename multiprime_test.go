@@ -0,0 +1,66 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newMultiPrimeKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateMultiPrimeKey(rand.Reader, 3, 2048)
+	if err != nil {
+		t.Skipf("cannot generate a multi-prime key: %v", err)
+	}
+	return key
+}
+
+func TestAllowMultiPrime(t *testing.T) {
+	key := newMultiPrimeKey(t)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).WithKeyId("multi").Build(); !errors.Is(err, ErrMultiPrimeUnsupported) {
+		t.Fatalf("multi-prime key imported with %v", err)
+	}
+
+	// the forced self-test signs with the primes and verifies against n and e
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).WithKeyId("multi").AllowMultiPrime().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.selfTest {
+		t.Fatal("self-test not forced")
+	}
+	if config.active().multiPrimeKey == nil {
+		t.Fatal("signing without the primes")
+	}
+
+	// only n and e are published, n being the product of the three primes
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &set); err != nil || len(set.Keys) != 1 {
+		t.Fatalf("served %s: %v", w.Body, err)
+	}
+	members := set.Keys[0]
+	if members["n"] != EncodeToString(key.N.Bytes()) || members["d"] != nil || members["p"] != nil {
+		t.Fatalf("served %v", members)
+	}
+}
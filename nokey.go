@@ -0,0 +1,77 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// ErrNoServableKey is returned when a config has no key to publish, e.g. a
+// holder without config or a config which was never built
+var ErrNoServableKey = errors.New("no servable key")
+
+// Seconds a client should wait before asking again for a key set which had no key
+const noServableKeyRetryAfter = 5
+
+// Serve {"keys": []} with a 200 when there is no key, instead of a 503. Clients
+// cache that empty set like any other, only use it if something relies on it.
+func (n *ConfigBuilder) WithEmptyKeySetWhenNoKey() *ConfigBuilder {
+	n.config.emptyKeySetWhenNoKey = true
+	return n
+}
+
+// Ready reports whether the config has a key to serve, ErrNoServableKey otherwise
+func (c *Config) Ready() error {
+	// a merged config serves as soon as one of its configs does
+	if c != nil && c.merged != nil {
+		for _, config := range c.merged {
+			if config.Ready() == nil {
+				return nil
+			}
+		}
+		return ErrNoServableKey
+	}
+	if err := c.Err(); err != nil {
+		return fmt.Errorf("%w, %v", ErrNoServableKey, err)
+	}
+	// the additional keys are served without a signing key
+	active, additionalKeys := c.keySnapshot()
+	if active == nil {
+		if len(additionalKeys) == 0 {
+			return ErrNoServableKey
+		}
+		return nil
+	}
+	if c.keyDropped() || c.keyExpired(active, c.keys.now()) {
+		return ErrNoServableKey
+	}
+	return nil
+}
+
+// Answer a key set request when there is no key: a 503 nobody caches, so
+// clients come back shortly instead of holding on to an empty set
+func serveNoKey(c *gin.Context, config *Config, err error) {
+	config.reportError(err)
+	if config.emptyKeySetWhenNoKey {
+		body, err := json.Marshal(jwksBody{Keys: []JkwsResponse{}})
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		config.writeJWKS(c, http.StatusOK, body)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Retry-After", strconv.Itoa(noServableKeyRetryAfter))
+	c.AbortWithStatus(http.StatusServiceUnavailable)
+}
+
+// Ready reports whether the current config has a key to serve
+func (h *ConfigHolder) Ready() error {
+	return h.Load().Ready()
+}
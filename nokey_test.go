@@ -0,0 +1,53 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveJWKS(handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET("/jwks", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	return w
+}
+
+func TestJkwsAnswers503WithoutKey(t *testing.T) {
+	holder := NewConfigHolder(nil)
+	for name, handler := range map[string]gin.HandlerFunc{
+		"config never built": Jkws(Config{}),
+		"empty holder":       holder.Jkws(),
+	} {
+		w := serveJWKS(handler)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s answered %d", name, w.Code)
+		}
+		if w.Header().Get("Retry-After") != "5" || w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("%s: headers %v", name, w.Header())
+		}
+	}
+	if err := holder.Ready(); !errors.Is(err, ErrNoServableKey) {
+		t.Fatalf("empty holder ready: %v", err)
+	}
+
+	config := newTestConfig(t, NewConfigBuilder())
+	holder.Swap(config)
+	if err := holder.Ready(); err != nil {
+		t.Fatalf("holder with a config not ready: %v", err)
+	}
+	if w := serveJWKS(holder.Jkws()); w.Code != http.StatusOK {
+		t.Fatalf("holder with a config answered %d", w.Code)
+	}
+}
+
+func TestJkwsServesAnEmptySetWhenAsked(t *testing.T) {
+	config := NewConfigBuilder().WithEmptyKeySetWhenNoKey().config
+	w := serveJWKS(Jkws(*config))
+	if w.Code != http.StatusOK || w.Body.String() != `{"keys":[]}` {
+		t.Fatalf("answered %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,83 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const OpenIDConfigurationWellKnown = "/.well-known/openid-configuration"
+
+// OpenIDConfigurationPath returns where the OpenID Connect discovery document
+// of issuer lives: the well-known segment goes after the issuer path
+func OpenIDConfigurationPath(issuer string) (string, error) {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse issuer %v", err)
+	}
+
+	return strings.TrimSuffix(issuerURL.EscapedPath(), "/") + OpenIDConfigurationWellKnown, nil
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document of issuer,
+// so standard OIDC clients find the key set. The signing algorithms are the
+// ones of the keys of the config at the time of the request. Members such as
+// authorization_endpoint go in opts.Extra.
+func OpenIDConfiguration(config Config, issuer string, opts MetadataOptions) gin.HandlerFunc {
+	metadata, err := serverMetadata(issuer, opts)
+
+	return func(c *gin.Context) {
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		algs, err := config.signingAlgorithms()
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		document := map[string]interface{}{
+			// required by OpenID Connect Discovery 1.0, Extra may narrow them
+			"response_types_supported": []string{"code", "id_token", "code id_token"},
+			"subject_types_supported":  []string{"public"},
+		}
+		for k, v := range metadata {
+			document[k] = v
+		}
+		document["id_token_signing_alg_values_supported"] = algs
+		config.writeJSON(c, http.StatusOK, document)
+	}
+}
+
+// Signature algorithms of the published signing keys, each once
+func (c *Config) signingAlgorithms() ([]string, error) {
+	active, additionalKeys := c.keySnapshot()
+	if active == nil {
+		return nil, ErrNoServableKey
+	}
+	var keys []jwk.Key
+	if active.key.KeyUsage() == KeyUsageAsSignature {
+		keys = append(keys, active.key)
+	}
+	keys = append(keys, additionalKeys...)
+
+	var algs []string
+	seen := map[string]bool{}
+	for _, key := range keys {
+		alg, err := signatureAlgorithm(key)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[alg.String()] {
+			seen[alg.String()] = true
+			algs = append(algs, alg.String())
+		}
+	}
+	return algs, nil
+}
@@ -0,0 +1,376 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const openAPIVersion = "3.1.0"
+
+// Title and version of the generated OpenAPI document
+type OpenAPIInfo struct {
+	Title   string
+	Version string
+}
+
+// Describes the operation of a handler of this package
+type openAPIOperation func() map[string]interface{}
+
+// Handler of this package described by an OpenAPI operation
+type OpenAPIOperation string
+
+const (
+	// Jkws, (*ConfigHolder).Jkws and RegisterJWKS
+	OpenAPIJWKS OpenAPIOperation = "jwks"
+	// SignedJkws
+	OpenAPISignedJWKS OpenAPIOperation = "signed_jwks"
+	// AuthorizationServerMetadata and OpenIDConfiguration
+	OpenAPIMetadata      OpenAPIOperation = "metadata"
+	OpenAPITokenExchange OpenAPIOperation = "token_exchange"
+	// Introspect
+	OpenAPIIntrospection OpenAPIOperation = "introspection"
+	OpenAPIHealthz       OpenAPIOperation = "healthz"
+	// cwt.KeySet
+	OpenAPICOSEKeySet OpenAPIOperation = "cose_key_set"
+)
+
+var openAPIOperations = map[OpenAPIOperation]openAPIOperation{
+	OpenAPIJWKS:          jwksOperation,
+	OpenAPISignedJWKS:    signedJWKSOperation,
+	OpenAPIMetadata:      metadataOperation,
+	OpenAPITokenExchange: tokenExchangeOperation,
+	OpenAPIIntrospection: introspectionOperation,
+	OpenAPIHealthz:       healthzOperation,
+	OpenAPICOSEKeySet:    coseKeySetOperation,
+}
+
+type openAPIRoute struct {
+	method    string
+	path      string
+	operation OpenAPIOperation
+}
+
+// OpenAPIRoutes lists the routes of the OpenAPI document. RegisterJWKS adds the
+// ones it mounts when given WithOpenAPIRoutes, so a renamed path is followed,
+// and Add describes the routes of the other handlers where they are mounted.
+type OpenAPIRoutes struct {
+	mu     sync.Mutex
+	routes []openAPIRoute
+}
+
+func NewOpenAPIRoutes() *OpenAPIRoutes {
+	return &OpenAPIRoutes{}
+}
+
+// Add describes the route mounting a handler of this package, e.g.
+// routes.Add(http.MethodPost, "/introspect", OpenAPIIntrospection) next to
+// r.POST("/introspect", Introspect(*config)). An unknown operation is refused.
+func (o *OpenAPIRoutes) Add(method, path string, operation OpenAPIOperation) error {
+	if _, ok := openAPIOperations[operation]; !ok {
+		return fmt.Errorf("unknown OpenAPI operation %q", operation)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.routes = append(o.routes, openAPIRoute{method: strings.ToUpper(method), path: path, operation: operation})
+	return nil
+}
+
+// OpenAPISpec returns the OpenAPI 3.1 JSON document of the registered routes
+func (o *OpenAPIRoutes) OpenAPISpec(info OpenAPIInfo) ([]byte, error) {
+	o.mu.Lock()
+	routes := make([]openAPIRoute, len(o.routes))
+	copy(routes, o.routes)
+	o.mu.Unlock()
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		operation := openAPIOperations[route.operation]
+		// handlers answer OPTIONS with the allowed methods, whatever they serve
+		if route.method == http.MethodOptions {
+			operation = optionsOperation
+		}
+		path, params := openAPIPath(route.path)
+		op := operation()
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.method)] = op
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no route registered")
+	}
+
+	doc := map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   info.Title,
+			"version": info.Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": openAPISchemas(),
+		},
+	}
+	res, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize OpenAPI document %v", err)
+	}
+	return res, nil
+}
+
+// Gin path to OpenAPI path, :name and *name become {name} path parameters
+func openAPIPath(path string) (string, []interface{}) {
+	var params []interface{}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			name := segment[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func ref(schema string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + schema}
+}
+
+func jsonResponse(description, mediaType string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			mediaType: map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+var internalErrorResponse = map[string]interface{}{"description": "the key set cannot be built"}
+
+func optionsOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "Allowed methods",
+		"responses": map[string]interface{}{
+			"204": map[string]interface{}{"description": "the Allow header lists the methods, or a CORS preflight answer"},
+		},
+	}
+}
+
+func jwksOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "JSON Web Key Set",
+		"responses": map[string]interface{}{
+			// application/jwk-set+json with WithJWKSetContentType
+			"200": map[string]interface{}{
+				"description": "public keys",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": ref("JWKS")},
+					JWKSetContentType:  map[string]interface{}{"schema": ref("JWKS")},
+				},
+			},
+			"304": map[string]interface{}{"description": "the key set named by If-None-Match is current"},
+			"500": jsonResponse("the key set cannot be built", "application/json", ref("OAuthError")),
+			"503": map[string]interface{}{"description": "no key to serve yet"},
+		},
+	}
+}
+
+func signedJWKSOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "JSON Web Key Set signed as a JWS",
+		"responses": map[string]interface{}{
+			// application/jose+json with SignedJWKSJSON
+			"200": map[string]interface{}{
+				"description": "public keys, signed",
+				"content": map[string]interface{}{
+					JOSEContentType:     map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+					JOSEJSONContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "object"}},
+				},
+			},
+			"304": map[string]interface{}{"description": "the key set named by If-None-Match is current"},
+			"500": internalErrorResponse,
+			"503": map[string]interface{}{"description": "no key to serve yet"},
+		},
+	}
+}
+
+func introspectionOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "OAuth 2.0 token introspection, RFC 7662",
+		"requestBody": map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/x-www-form-urlencoded": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type":     "object",
+						"required": []string{"token"},
+						"properties": map[string]interface{}{
+							"token":           map[string]interface{}{"type": "string"},
+							"token_type_hint": map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+		"responses": map[string]interface{}{
+			"200": jsonResponse("state of the token", "application/json", ref("IntrospectionResponse")),
+			"400": jsonResponse("missing token", "application/json", ref("OAuthError")),
+			"401": map[string]interface{}{"description": "the caller is not authorized"},
+			"405": map[string]interface{}{"description": "only POST is allowed"},
+		},
+	}
+}
+
+func healthzOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "Readiness of the signing key",
+		"responses": map[string]interface{}{
+			"200": jsonResponse("the active key signs and verifies", "application/json", ref("HealthStatus")),
+			"503": jsonResponse("the active key is not usable", "application/json", ref("HealthStatus")),
+		},
+	}
+}
+
+func metadataOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "OAuth 2.0 authorization server metadata, RFC 8414",
+		"responses": map[string]interface{}{
+			"200": jsonResponse("metadata document", "application/json", map[string]interface{}{
+				"type":     "object",
+				"required": []string{"issuer", "jwks_uri"},
+				"properties": map[string]interface{}{
+					"issuer":   map[string]interface{}{"type": "string", "format": "uri"},
+					"jwks_uri": map[string]interface{}{"type": "string", "format": "uri"},
+				},
+			}),
+			"500": internalErrorResponse,
+		},
+	}
+}
+
+func tokenExchangeOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "OAuth 2.0 token exchange, RFC 8693",
+		"requestBody": map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/x-www-form-urlencoded": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type":     "object",
+						"required": []string{"grant_type", "subject_token", "subject_token_type"},
+						"properties": map[string]interface{}{
+							"grant_type":           map[string]interface{}{"const": GrantTypeTokenExchange},
+							"subject_token":        map[string]interface{}{"type": "string"},
+							"subject_token_type":   map[string]interface{}{"type": "string"},
+							"requested_token_type": map[string]interface{}{"type": "string"},
+							"audience":             map[string]interface{}{"type": "string"},
+							"resource":             map[string]interface{}{"type": "string"},
+							"scope":                map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+		"responses": map[string]interface{}{
+			"200": jsonResponse("issued token", "application/json", ref("TokenExchangeResponse")),
+			"400": jsonResponse("invalid request", "application/json", ref("OAuthError")),
+			"401": jsonResponse("client authentication failed", "application/json", ref("OAuthError")),
+		},
+	}
+}
+
+func coseKeySetOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"summary": "COSE Key Set, RFC 9052",
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "public keys, CBOR encoded",
+				"content": map[string]interface{}{
+					"application/cose-key-set": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string", "contentEncoding": "binary"},
+					},
+				},
+			},
+			"500": internalErrorResponse,
+		},
+	}
+}
+
+// Schemas shared by the operations, derived from the response types
+func openAPISchemas() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	jwkProperties := map[string]interface{}{}
+	t := reflect.TypeOf(JkwsResponse{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		switch t.Field(i).Type.Kind() {
+		case reflect.Slice:
+			jwkProperties[name] = map[string]interface{}{"type": "array", "items": str}
+		case reflect.Int64:
+			jwkProperties[name] = map[string]interface{}{"type": "integer"}
+		default:
+			jwkProperties[name] = str
+		}
+	}
+
+	return map[string]interface{}{
+		"JWK": map[string]interface{}{
+			"type":       "object",
+			"required":   []string{"kty"},
+			"properties": jwkProperties,
+		},
+		"JWKS": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"keys"},
+			"properties": map[string]interface{}{
+				"keys": map[string]interface{}{"type": "array", "items": ref("JWK")},
+			},
+		},
+		"TokenExchangeResponse": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"access_token", "issued_token_type", "token_type", "expires_in"},
+			"properties": map[string]interface{}{
+				"access_token":      str,
+				"issued_token_type": str,
+				"token_type":        str,
+				"expires_in":        map[string]interface{}{"type": "integer"},
+				"scope":             str,
+			},
+		},
+		"IntrospectionResponse": map[string]interface{}{
+			"type":                 "object",
+			"required":             []string{"active"},
+			"properties":           map[string]interface{}{"active": map[string]interface{}{"type": "boolean"}},
+			"additionalProperties": true,
+		},
+		"HealthStatus": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"status"},
+			"properties": map[string]interface{}{
+				"status": str,
+				"kid":    str,
+				"reason": str,
+			},
+		},
+		"OAuthError": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"error"},
+			"properties": map[string]interface{}{
+				"error":             str,
+				"error_description": str,
+			},
+		},
+	}
+}
@@ -0,0 +1,182 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var openAPIPathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// Check doc against the rules of the OpenAPI 3.1 specification its shape
+// can break: required members, path templates matching their parameters,
+// response codes, and references resolving within the document
+func validateOpenAPI(doc map[string]interface{}) error {
+	if doc["openapi"] != openAPIVersion {
+		return fmt.Errorf("openapi is %v, expected %s", doc["openapi"], openAPIVersion)
+	}
+	info, ok := doc["info"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("info is missing")
+	}
+	for _, member := range []string{"title", "version"} {
+		if s, _ := info[member].(string); s == "" {
+			return fmt.Errorf("info.%s is missing", member)
+		}
+	}
+
+	paths, ok := doc["paths"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("paths is missing")
+	}
+	methods := map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+	for path, item := range paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %q does not start with /", path)
+		}
+		templated := map[string]bool{}
+		for _, match := range openAPIPathParam.FindAllStringSubmatch(path, -1) {
+			templated[match[1]] = true
+		}
+		for method, value := range item.(map[string]interface{}) {
+			if !methods[method] {
+				return fmt.Errorf("%s: unknown method %q", path, method)
+			}
+			op := value.(map[string]interface{})
+			declared := map[string]bool{}
+			params, _ := op["parameters"].([]interface{})
+			for _, p := range params {
+				param := p.(map[string]interface{})
+				name, _ := param["name"].(string)
+				if param["in"] == "path" {
+					if param["required"] != true {
+						return fmt.Errorf("%s %s: path parameter %q is not required", method, path, name)
+					}
+					declared[name] = true
+				}
+			}
+			for name := range templated {
+				if !declared[name] {
+					return fmt.Errorf("%s %s: path parameter %q is not declared", method, path, name)
+				}
+			}
+			for name := range declared {
+				if !templated[name] {
+					return fmt.Errorf("%s %s: parameter %q is not in the path", method, path, name)
+				}
+			}
+
+			responses, _ := op["responses"].(map[string]interface{})
+			if len(responses) == 0 {
+				return fmt.Errorf("%s %s: no response", method, path)
+			}
+			for code, r := range responses {
+				if status, err := strconv.Atoi(code); code != "default" && (err != nil || status < 100 || status > 599) {
+					return fmt.Errorf("%s %s: invalid response code %q", method, path, code)
+				}
+				if description, _ := r.(map[string]interface{})["description"].(string); description == "" {
+					return fmt.Errorf("%s %s: response %s has no description", method, path, code)
+				}
+			}
+		}
+	}
+	return validateOpenAPIRefs(doc, doc)
+}
+
+// Every $ref of value points at a member of doc
+func validateOpenAPIRefs(doc map[string]interface{}, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if target, ok := v["$ref"].(string); ok {
+			var node interface{} = doc
+			for _, segment := range strings.Split(strings.TrimPrefix(target, "#/"), "/") {
+				object, ok := node.(map[string]interface{})
+				if !ok || object[segment] == nil {
+					return fmt.Errorf("unresolved reference %q", target)
+				}
+				node = object[segment]
+			}
+		}
+		for _, member := range v {
+			if err := validateOpenAPIRefs(doc, member); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := validateOpenAPIRefs(doc, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestOpenAPISpecDescribesTheRegisteredRoutes(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	routes := NewOpenAPIRoutes()
+	r := gin.New()
+	group := r.Group("/auth")
+	err := RegisterJWKS(group, config,
+		WithJWKSPath("/keys/:tenant"),
+		WithOpenIDConfiguration("https://issuer.example.com", MetadataOptions{}),
+		WithOpenAPIRoutes(routes),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.POST("/introspect", Introspect(*config))
+	group.GET("/healthz", Healthz(*config))
+	group.GET("/signed-keys", SignedJkws(*config, config))
+	for _, route := range []openAPIRoute{
+		{http.MethodPost, "/auth/introspect", OpenAPIIntrospection},
+		{http.MethodGet, "/auth/healthz", OpenAPIHealthz},
+		{http.MethodGet, "/auth/signed-keys", OpenAPISignedJWKS},
+	} {
+		if err = routes.Add(route.method, route.path, route.operation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spec, err := routes.OpenAPISpec(OpenAPIInfo{Title: "auth", Version: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err = json.Unmarshal(spec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err = validateOpenAPI(doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+
+	// every mounted route of the router is in the document, under its renamed path
+	paths := doc["paths"].(map[string]interface{})
+	for _, route := range r.Routes() {
+		path, _ := openAPIPath(route.Path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok || item[strings.ToLower(route.Method)] == nil {
+			t.Errorf("route %s %s missing from the document", route.Method, route.Path)
+		}
+	}
+	keys := paths["/auth/keys/{tenant}"].(map[string]interface{})["get"].(map[string]interface{})
+	content := keys["responses"].(map[string]interface{})["200"].(map[string]interface{})["content"].(map[string]interface{})
+	if content[JWKSetContentType] == nil {
+		t.Fatalf("key set response does not offer %s", JWKSetContentType)
+	}
+}
+
+func TestOpenAPIRoutesRefusesUnknownOperations(t *testing.T) {
+	routes := NewOpenAPIRoutes()
+	if _, err := routes.OpenAPISpec(OpenAPIInfo{Title: "auth", Version: "1"}); err == nil {
+		t.Fatal("a document without routes was generated")
+	}
+	if err := routes.Add(http.MethodGet, "/rotate", "rotation_admin"); err == nil {
+		t.Fatal("an unknown operation was accepted")
+	}
+}
@@ -0,0 +1,127 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/lestrrat-go/jwx/v2/jwa"
+)
+
+// Option configures the config built by NewConfig
+type Option func(*optionSet)
+
+type optionSet struct {
+	builder *ConfigBuilder
+	// applied to the facet of the key source once every option is known, so
+	// they can come in any order
+	keyOpts []func(newKey *ConfigNewKeyBuilder, importKey *ConfigImportKeyBuilder)
+}
+
+// NewConfig builds a config from options instead of the builder chain. It goes
+// through Build, so both produce the same config and fail the same way.
+func NewConfig(opts ...Option) (*Config, error) {
+	set := &optionSet{builder: NewConfigBuilder()}
+	for _, opt := range opts {
+		opt(set)
+	}
+
+	var newKey *ConfigNewKeyBuilder
+	var importKey *ConfigImportKeyBuilder
+	if set.builder.config.newPkOpts != nil {
+		newKey = set.builder.NewPrivateKey()
+	}
+	if set.builder.config.importPkOpts != nil {
+		importKey = set.builder.ImportPrivateKey()
+	}
+	for _, keyOpt := range set.keyOpts {
+		keyOpt(newKey, importKey)
+	}
+
+	return set.builder.Build()
+}
+
+// Generate an RSA key of bits
+func GenerateRSA(bits int) Option {
+	return func(set *optionSet) {
+		set.builder.NewPrivateKey().WithKeyType(jwa.RSA).WithKeyLength(bits)
+	}
+}
+
+// Generate an EC key on curve
+func GenerateEC(curve jwa.EllipticCurveAlgorithm) Option {
+	return func(set *optionSet) {
+		set.builder.NewPrivateKey().WithKeyType(jwa.EC).WithCurve(curve)
+	}
+}
+
+// Generate an Ed25519 key
+func GenerateEd25519() Option {
+	return func(set *optionSet) {
+		set.builder.NewPrivateKey().WithKeyType(jwa.OKP).WithCurve(jwa.Ed25519)
+	}
+}
+
+// Import the private key at path, PEM or JWK
+func ImportPEMFile(path string) Option {
+	return func(set *optionSet) {
+		set.builder.ImportPrivateKey().WithPath(path)
+	}
+}
+
+// Import the private key from data, PEM or JWK
+func ImportPEMBytes(data []byte) Option {
+	return func(set *optionSet) {
+		set.builder.ImportPrivateKey().WithPEMBytes(data)
+	}
+}
+
+// Sign with the key of p, e.g. a KMS key
+func ImportProvider(p KeyProvider) Option {
+	return func(set *optionSet) {
+		set.builder.ImportPrivateKey().WithProvider(p)
+	}
+}
+
+// Publish the key under id
+func KeyID(id string) Option {
+	return withKeyOption(func(newKey *ConfigNewKeyBuilder) {
+		newKey.WithKeyId(id)
+	}, func(importKey *ConfigImportKeyBuilder) {
+		importKey.WithKeyId(id)
+	})
+}
+
+// Sign with alg and publish it with the key
+func Algorithm(alg jwa.SignatureAlgorithm) Option {
+	return withKeyOption(func(newKey *ConfigNewKeyBuilder) {
+		newKey.WithAlgorithm(alg)
+	}, func(importKey *ConfigImportKeyBuilder) {
+		importKey.WithAlgorithm(alg)
+	})
+}
+
+// Set the use member of the key, sig or enc
+func KeyUsage(usage string) Option {
+	return withKeyOption(func(newKey *ConfigNewKeyBuilder) {
+		newKey.WithKeyUsage(usage)
+	}, func(importKey *ConfigImportKeyBuilder) {
+		importKey.WithKeyUsage(usage)
+	})
+}
+
+// Apply fn to the builder, for the settings without an option of their own
+func Configure(fn func(*ConfigBuilder)) Option {
+	return func(set *optionSet) {
+		fn(set.builder)
+	}
+}
+
+func withKeyOption(newKeyOpt func(*ConfigNewKeyBuilder), importKeyOpt func(*ConfigImportKeyBuilder)) Option {
+	return func(set *optionSet) {
+		set.keyOpts = append(set.keyOpts, func(newKey *ConfigNewKeyBuilder, importKey *ConfigImportKeyBuilder) {
+			if newKey != nil {
+				newKeyOpt(newKey)
+			}
+			if importKey != nil {
+				importKeyOpt(importKey)
+			}
+		})
+	}
+}
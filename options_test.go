@@ -0,0 +1,187 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// Key set document as the Jkws handler of config serves it
+func servedKeySetBody(t *testing.T, config *Config) string {
+	t.Helper()
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("key set answered %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+// The only key of the key set config serves
+func servedKey(t *testing.T, config *Config) jwk.Key {
+	t.Helper()
+	set := servedKeySet(t, config)
+	if set.Len() != 1 {
+		t.Fatalf("%d keys served", set.Len())
+	}
+	key, _ := set.Key(0)
+	return key
+}
+
+func TestNewConfigImportsLikeTheBuilder(t *testing.T) {
+	rsaPath := writeTestKey(t, newRSAKey(t))
+	ecPEM := pemKey(t, newECKey(t))
+
+	for name, tt := range map[string]struct {
+		build func() (*Config, error)
+		opts  []Option
+	}{
+		"PEM file": {
+			build: NewConfigBuilder().ImportPrivateKey().
+				WithPath(rsaPath).WithKeyId("rsa").WithAlgorithm(jwa.RS512).WithKeyUsage(KeyUsageAsSignature).Build,
+			opts: []Option{ImportPEMFile(rsaPath), KeyID("rsa"), Algorithm(jwa.RS512), KeyUsage(KeyUsageAsSignature)},
+		},
+		// the key options may come before the key source
+		"PEM bytes": {
+			build: NewConfigBuilder().ImportPrivateKey().WithPEMBytes(ecPEM).WithKeyId("ec").Build,
+			opts:  []Option{KeyID("ec"), ImportPEMBytes(ecPEM)},
+		},
+	} {
+		built, err := tt.build()
+		if err != nil {
+			t.Fatalf("%s: builder: %v", name, err)
+		}
+		defer built.Close()
+		constructed, err := NewConfig(tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: NewConfig: %v", name, err)
+		}
+		defer constructed.Close()
+
+		if got, want := servedKeySetBody(t, constructed), servedKeySetBody(t, built); got != want {
+			t.Fatalf("%s: NewConfig serves\n%s\nthe builder\n%s", name, got, want)
+		}
+	}
+}
+
+func TestNewConfigGeneratesLikeTheBuilder(t *testing.T) {
+	for name, tt := range map[string]struct {
+		build func() (*Config, error)
+		opts  []Option
+		kty   jwa.KeyType
+		alg   jwa.SignatureAlgorithm
+	}{
+		"RSA": {
+			build: NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.RSA).WithKeyLength(2048).WithKeyId("k").Build,
+			opts:  []Option{GenerateRSA(2048), KeyID("k")},
+			kty:   jwa.RSA,
+			alg:   jwa.RS256,
+		},
+		"RSA with an algorithm": {
+			build: NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.RSA).WithKeyLength(2048).WithKeyId("k").WithAlgorithm(jwa.PS256).Build,
+			opts:  []Option{Algorithm(jwa.PS256), GenerateRSA(2048), KeyID("k")},
+			kty:   jwa.RSA,
+			alg:   jwa.PS256,
+		},
+		"EC": {
+			build: NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P384).WithKeyId("k").Build,
+			opts:  []Option{GenerateEC(jwa.P384), KeyID("k")},
+			kty:   jwa.EC,
+			alg:   jwa.ES384,
+		},
+		"Ed25519": {
+			build: NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.OKP).WithCurve(jwa.Ed25519).WithKeyId("k").Build,
+			opts:  []Option{GenerateEd25519(), KeyID("k")},
+			kty:   jwa.OKP,
+			alg:   jwa.EdDSA,
+		},
+	} {
+		built, err := tt.build()
+		if err != nil {
+			t.Fatalf("%s: builder: %v", name, err)
+		}
+		defer built.Close()
+		constructed, err := NewConfig(tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: NewConfig: %v", name, err)
+		}
+		defer constructed.Close()
+
+		// each generates its own key, everything else is the same
+		for api, config := range map[string]*Config{"builder": built, "NewConfig": constructed} {
+			key := servedKey(t, config)
+			if key.KeyID() != "k" || key.KeyType() != tt.kty || key.Algorithm().String() != tt.alg.String() {
+				t.Fatalf("%s: %s serves kid %q, kty %s, alg %s", name, api, key.KeyID(), key.KeyType(), key.Algorithm())
+			}
+			if rsaKey, ok := key.(jwk.RSAPublicKey); ok {
+				if bits := new(big.Int).SetBytes(rsaKey.N()).BitLen(); bits != 2048 {
+					t.Fatalf("%s: %s generated a %d bits key", name, api, bits)
+				}
+			}
+		}
+	}
+}
+
+func TestNewConfigFailsLikeTheBuilder(t *testing.T) {
+	path := writeTestKey(t, newRSAKey(t))
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	for name, tt := range map[string]struct {
+		build func() (*Config, error)
+		opts  []Option
+		err   error
+	}{
+		"generate and import": {
+			build: NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).ImportPrivateKey().WithPath(path).Build,
+			opts:  []Option{GenerateRSA(2048), ImportPEMFile(path)},
+			err:   ErrConflictingKeySources,
+		},
+		"import and generate": {
+			build: NewConfigBuilder().ImportPrivateKey().WithPath(path).NewPrivateKey().WithKeyLength(2048).Build,
+			opts:  []Option{ImportPEMFile(path), GenerateRSA(2048)},
+			err:   ErrConflictingKeySources,
+		},
+		"no key": {
+			build: NewConfigBuilder().Build,
+			opts:  nil,
+			err:   ErrNoKeyConfigured,
+		},
+		"no key with key options": {
+			build: NewConfigBuilder().Build,
+			opts:  []Option{KeyID("k"), Algorithm(jwa.RS256)},
+			err:   ErrNoKeyConfigured,
+		},
+		"bad PEM": {
+			build: NewConfigBuilder().ImportPrivateKey().WithPEMBytes([]byte("not a key")).Build,
+			opts:  []Option{ImportPEMBytes([]byte("not a key"))},
+			err:   ErrKeyParse,
+		},
+		"missing file": {
+			build: NewConfigBuilder().ImportPrivateKey().WithPath(missing).Build,
+			opts:  []Option{ImportPEMFile(missing)},
+			err:   ErrKeyFileNotFound,
+		},
+		"weak key": {
+			build: NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.RSA).WithKeyLength(1024).Build,
+			opts:  []Option{GenerateRSA(1024)},
+			err:   ErrWeakKey,
+		},
+	} {
+		_, builderErr := tt.build()
+		_, optionsErr := NewConfig(tt.opts...)
+		if !errors.Is(builderErr, tt.err) || !errors.Is(optionsErr, tt.err) {
+			t.Fatalf("%s: expected %v, the builder failed with %v and NewConfig with %v", name, tt.err, builderErr, optionsErr)
+		}
+		if builderErr.Error() != optionsErr.Error() {
+			t.Fatalf("%s: the builder failed with %q and NewConfig with %q", name, builderErr, optionsErr)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// Header parameters this package understands when listed in crit
+var understoodCriticalHeaders = map[string]struct{}{
+	"b64": {},
+}
+
+type payloadOptions struct {
+	unencoded bool
+	detached  bool
+}
+
+// Option of SignPayload
+type PayloadOption func(*payloadOptions)
+
+// Sign the payload as is instead of its base64url form, RFC 7797 (b64: false)
+func WithUnencodedPayload() PayloadOption {
+	return func(o *payloadOptions) {
+		o.unencoded = true
+	}
+}
+
+// Leave the payload out of the serialization, the verifier gets it separately
+func WithDetachedPayload() PayloadOption {
+	return func(o *payloadOptions) {
+		o.detached = true
+	}
+}
+
+// SignPayload returns the compact JWS of payload signed with the config key
+func (c *Config) SignPayload(payload []byte, opts ...PayloadOption) ([]byte, error) {
+	var o payloadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	active, err := c.signingSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	// RFC 7797 section 5.2, the payload would be split apart
+	if o.unencoded && !o.detached && bytes.IndexByte(payload, '.') >= 0 {
+		return nil, fmt.Errorf("an attached unencoded payload cannot contain '.'")
+	}
+
+	alg, err := signatureAlgorithm(active.key)
+	if err != nil {
+		return nil, err
+	}
+	header := map[string]interface{}{
+		"alg": alg.String(),
+		"kid": active.key.KeyID(),
+	}
+	if o.unencoded {
+		header["b64"] = false
+		header["crit"] = []string{"b64"}
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize protected header %v", err)
+	}
+
+	encodedPayload := payload
+	if !o.unencoded {
+		encodedPayload = []byte(base64.RawURLEncoding.EncodeToString(payload))
+	}
+	signingInput := []byte(base64.RawURLEncoding.EncodeToString(headerJSON) + ".")
+	signingInput = append(signingInput, encodedPayload...)
+
+	signature, err := active.signBytes(alg, signingInput)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign payload %v", err)
+	}
+
+	res := signingInput
+	if o.detached {
+		res = res[:bytes.IndexByte(res, '.')+1]
+	}
+	res = append(res, '.')
+	res = append(res, base64.RawURLEncoding.EncodeToString(signature)...)
+
+	return res, nil
+}
+
+// VerifyPayload checks a compact JWS produced by SignPayload and returns its
+// payload. detachedPayload is nil unless the payload was detached. The crit
+// header is honored: unknown critical parameters are refused, and b64 false
+// must be listed in it.
+func (c *Config) VerifyPayload(signed []byte, detachedPayload []byte) ([]byte, error) {
+	active := c.active()
+	if active == nil {
+		return nil, fmt.Errorf("private key cannot be nil")
+	}
+	first, last := bytes.IndexByte(signed, '.'), bytes.LastIndexByte(signed, '.')
+	if first < 0 || first == last {
+		return nil, fmt.Errorf("not a compact JWS")
+	}
+	protected, payloadSegment, signatureSegment := signed[:first], signed[first+1:last], signed[last+1:]
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(string(protected))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode protected header %v", err)
+	}
+	var header struct {
+		Alg  string          `json:"alg"`
+		Kid  string          `json:"kid"`
+		B64  *bool           `json:"b64"`
+		Crit json.RawMessage `json:"crit"`
+	}
+	if err = json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("cannot parse protected header %v", err)
+	}
+	var rawHeader map[string]json.RawMessage
+	if err = json.Unmarshal(headerJSON, &rawHeader); err != nil {
+		return nil, fmt.Errorf("cannot parse protected header %v", err)
+	}
+
+	critical := map[string]struct{}{}
+	if header.Crit != nil {
+		var crit []string
+		if err = json.Unmarshal(header.Crit, &crit); err != nil || len(crit) == 0 {
+			return nil, fmt.Errorf("crit must be a non-empty list of header names")
+		}
+		for _, name := range crit {
+			if _, ok := understoodCriticalHeaders[name]; !ok {
+				return nil, fmt.Errorf("unsupported critical header %q", name)
+			}
+			if _, ok := rawHeader[name]; !ok {
+				return nil, fmt.Errorf("critical header %q is missing", name)
+			}
+			critical[name] = struct{}{}
+		}
+	}
+	b64 := header.B64 == nil || *header.B64
+	if _, ok := critical["b64"]; !b64 && !ok {
+		return nil, fmt.Errorf("b64 false must be listed in crit")
+	}
+
+	// the active key unless the kid names an additional one
+	verificationKey := active.key
+	if header.Kid != "" && header.Kid != verificationKey.KeyID() {
+		resolved, ok := c.ResolveKid(header.Kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", header.Kid)
+		}
+		if resolved != verificationKey.KeyID() {
+			if verificationKey, ok = c.additionalKey(resolved); !ok {
+				return nil, fmt.Errorf("unknown kid %q", header.Kid)
+			}
+		}
+	}
+	alg, err := signatureAlgorithm(verificationKey)
+	if err != nil {
+		return nil, err
+	}
+	if jwa.SignatureAlgorithm(header.Alg) != alg {
+		return nil, fmt.Errorf("unexpected algorithm %q", header.Alg)
+	}
+
+	var payload []byte
+	switch {
+	case len(payloadSegment) > 0 && detachedPayload != nil:
+		return nil, fmt.Errorf("cannot verify a detached payload against an attached one")
+	case detachedPayload != nil:
+		payload = detachedPayload
+		if b64 {
+			payloadSegment = []byte(base64.RawURLEncoding.EncodeToString(detachedPayload))
+		} else {
+			payloadSegment = detachedPayload
+		}
+	case !b64:
+		if bytes.IndexByte(payloadSegment, '.') >= 0 {
+			return nil, fmt.Errorf("an attached unencoded payload cannot contain '.'")
+		}
+		payload = payloadSegment
+	default:
+		if payload, err = base64.RawURLEncoding.DecodeString(string(payloadSegment)); err != nil {
+			return nil, fmt.Errorf("cannot decode payload %v", err)
+		}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(string(signatureSegment))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode signature %v", err)
+	}
+	signingInput := append(append(append([]byte{}, protected...), '.'), payloadSegment...)
+
+	verifier, err := jws.NewVerifier(alg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create verifier %v", err)
+	}
+	pubKey, err := verificationKey.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public key %v", err)
+	}
+	if err = verifier.Verify(signingInput, signature, pubKey); err != nil {
+		return nil, fmt.Errorf("invalid signature %v", err)
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,140 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"strings"
+	"testing"
+)
+
+// RFC 7797 section 4.2, signed with the HMAC key of RFC 7515 appendix A.1
+const (
+	rfc7797Key       = "AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow"
+	rfc7797Payload   = "$.02"
+	rfc7797Detached  = "eyJhbGciOiJIUzI1NiIsImI2NCI6ZmFsc2UsImNyaXQiOlsiYjY0Il19..A5dxf2s96_n5FLueVuW1Z_vh161FwXZC4YLPff6dmDY"
+	rfc7797Protected = `{"alg":"HS256","b64":false,"crit":["b64"]}`
+)
+
+// The reference implementation the payloads are cross-checked against must
+// itself agree with the vector of the RFC
+func TestReferenceImplementationMatchesRFC7797(t *testing.T) {
+	secret, err := base64.RawURLEncoding.DecodeString(rfc7797Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := jws.Verify([]byte(rfc7797Detached), jws.WithKey(jwa.HS256, secret), jws.WithDetachedPayload([]byte(rfc7797Payload)))
+	if err != nil {
+		t.Fatalf("RFC 7797 vector does not verify: %v", err)
+	}
+	if string(payload) != rfc7797Payload {
+		t.Fatalf("payload %q", payload)
+	}
+	protected, _ := base64.RawURLEncoding.DecodeString(strings.Split(rfc7797Detached, ".")[0])
+	if string(protected) != rfc7797Protected {
+		t.Fatalf("protected header %s", protected)
+	}
+}
+
+func TestSignedPayloadsVerifyWithTheReferenceImplementation(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	publicKey, err := config.active().key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(rfc7797Payload)
+
+	for name, tt := range map[string]struct {
+		opts     []PayloadOption
+		detached bool
+	}{
+		"encoded":            {nil, false},
+		"encoded detached":   {[]PayloadOption{WithDetachedPayload()}, true},
+		"unencoded detached": {[]PayloadOption{WithUnencodedPayload(), WithDetachedPayload()}, true},
+	} {
+		signed, err := config.SignPayload(payload, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		verifyOpts := []jws.VerifyOption{jws.WithKey(jwa.RS256, publicKey)}
+		if tt.detached {
+			verifyOpts = append(verifyOpts, jws.WithDetachedPayload(payload))
+		}
+		got, err := jws.Verify(signed, verifyOpts...)
+		if err != nil {
+			t.Fatalf("%s: reference implementation refuses %s: %v", name, signed, err)
+		}
+		if string(got) != rfc7797Payload {
+			t.Fatalf("%s: payload %q", name, got)
+		}
+	}
+}
+
+func TestReferencePayloadsVerify(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	headers := jws.NewHeaders()
+	for k, v := range map[string]interface{}{"b64": false, "crit": []string{"b64"}, jws.KeyIDKey: "test"} {
+		if err := headers.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var signingKey interface{}
+	if err := config.active().withPrivateKey(func(key jwk.Key) error { signingKey = key; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jws.Sign(nil, jws.WithKey(jwa.RS256, signingKey, jws.WithProtectedHeaders(headers)), jws.WithDetachedPayload([]byte(rfc7797Payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := config.VerifyPayload(signed, []byte(rfc7797Payload))
+	if err != nil {
+		t.Fatalf("payload of the reference implementation refused: %v", err)
+	}
+	if string(got) != rfc7797Payload {
+		t.Fatalf("payload %q", got)
+	}
+	if _, err = config.VerifyPayload(signed, []byte("$.03")); err == nil {
+		t.Fatal("another detached payload verified")
+	}
+}
+
+func TestVerifyPayloadHonorsCrit(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	if _, err := config.SignPayload([]byte("a.b"), WithUnencodedPayload()); err == nil {
+		t.Fatal("attached unencoded payload containing '.' was signed")
+	}
+
+	// protected headers re-signed with the config key
+	sign := func(header map[string]interface{}, payload string) []byte {
+		t.Helper()
+		headerJSON, _ := json.Marshal(header)
+		input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + payload
+		signer, _ := jws.NewSigner(jwa.RS256)
+		var signature []byte
+		err := config.active().withPrivateKey(func(key jwk.Key) (err error) {
+			signature, err = signer.Sign([]byte(input), key)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []byte(input + "." + base64.RawURLEncoding.EncodeToString(signature))
+	}
+	for name, signed := range map[string][]byte{
+		"b64 false outside crit":    sign(map[string]interface{}{"alg": "RS256", "b64": false}, ""),
+		"unknown critical header":   sign(map[string]interface{}{"alg": "RS256", "b64": false, "crit": []string{"b64", "exp"}, "exp": 1}, ""),
+		"absent critical header":    sign(map[string]interface{}{"alg": "RS256", "crit": []string{"b64"}}, ""),
+		"empty crit":                sign(map[string]interface{}{"alg": "RS256", "crit": []string{}}, ""),
+		"attached payload with '.'": sign(map[string]interface{}{"alg": "RS256", "b64": false, "crit": []string{"b64"}}, "a.b"),
+	} {
+		detached := []byte(rfc7797Payload)
+		if name == "attached payload with '.'" {
+			detached = nil
+		}
+		if _, err := config.VerifyPayload(signed, detached); err == nil {
+			t.Fatalf("%s verified", name)
+		}
+	}
+}
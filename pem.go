@@ -0,0 +1,89 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Parse the first private key block of PEM data, PKCS#1, PKCS#8 or SEC 1 by
+// its type. Other blocks are skipped, e.g. the certificate of a bundle, and
+// named in the error when no private key follows.
+func parsePEMKey(data []byte) (interface{}, error) {
+	var certificate, publicKey bool
+	for i := 1; ; i++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			switch {
+			case certificate:
+				return nil, fmt.Errorf("file contains a certificate, expected a private key")
+			case publicKey:
+				return nil, fmt.Errorf("public key provided where a private key is required")
+			case i == 1:
+				return nil, fmt.Errorf("no valid PEM block")
+			}
+			return nil, fmt.Errorf("none of the %d PEM blocks is a private key", i-1)
+		}
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, pemBlockError(i, block, "PKCS#1", err)
+			}
+			return key, nil
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, pemBlockError(i, block, "PKCS#8", err)
+			}
+			return key, nil
+		case "EC PRIVATE KEY":
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, pemBlockError(i, block, "SEC 1", err)
+			}
+			return key, nil
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("PEM block %d (%s): encrypted private keys are not supported, decrypt it first", i, block.Type)
+		case "CERTIFICATE":
+			certificate = true
+		case "PUBLIC KEY", "RSA PUBLIC KEY":
+			publicKey = true
+		}
+	}
+}
+
+// Name the encoding the block actually holds when its type is wrong, a PKCS#8
+// key labelled RSA PRIVATE KEY is a common mix-up
+func pemBlockError(i int, block *pem.Block, expected string, err error) error {
+	var actual, actualType string
+	switch {
+	case expected != "PKCS#8" && isPKCS8(block.Bytes):
+		actual, actualType = "PKCS#8", "PRIVATE KEY"
+	case expected != "PKCS#1" && isPKCS1(block.Bytes):
+		actual, actualType = "PKCS#1", "RSA PRIVATE KEY"
+	case expected != "SEC 1" && isSEC1(block.Bytes):
+		actual, actualType = "SEC 1", "EC PRIVATE KEY"
+	}
+	if actual != "" {
+		return fmt.Errorf("PEM block %d (%s) holds a %s key, its type should be %s", i, block.Type, actual, actualType)
+	}
+	return fmt.Errorf("PEM block %d (%s): cannot parse %s key %v", i, block.Type, expected, err)
+}
+
+func isPKCS8(der []byte) bool {
+	_, err := x509.ParsePKCS8PrivateKey(der)
+	return err == nil
+}
+
+func isPKCS1(der []byte) bool {
+	_, err := x509.ParsePKCS1PrivateKey(der)
+	return err == nil
+}
+
+func isSEC1(der []byte) bool {
+	_, err := x509.ParseECPrivateKey(der)
+	return err == nil
+}
@@ -0,0 +1,195 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Keep the generated key at path as a PKCS#8 PEM file readable by the owner
+// only. The next Build loads the key from path instead of generating one, so
+// the tokens signed before a restart keep verifying. A file which cannot be
+// parsed, or holds another type of key, fails the build; it is never replaced.
+// A rotation replaces it with the new key along with its kid.
+func (n *ConfigNewKeyBuilder) WithPersistPath(path string) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.persistPath = path
+	return n
+}
+
+// Load the key persisted at the path of opts, or generate one and write it
+// there. loaded tells which happened.
+func loadOrGeneratePersistedKey(opts NewKeyOptions) (key jwk.Key, loaded bool, err error) {
+	if _, err = os.Stat(opts.persistPath); err == nil {
+		key, err = loadPersistedKey(opts)
+		return key, true, err
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, fmt.Errorf("cannot read persisted private key %v", err)
+	}
+
+	key, err = generatePrivateKey(opts)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := privateKeyPEM(key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer wipe(data)
+
+	// O_EXCL: a file created since the check above is not overwritten
+	f, err := os.OpenFile(opts.persistPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot persist private key %v", err)
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("cannot persist private key %v", err)
+	}
+	if err = f.Close(); err != nil {
+		return nil, false, fmt.Errorf("cannot persist private key %v", err)
+	}
+	return key, false, nil
+}
+
+// PEM header of the kid of a rotated key, it wins over the kid of the options
+const persistedKidHeader = "Kid"
+
+// Read a persisted key, it must be of the type and size opts would generate
+func loadPersistedKey(opts NewKeyOptions) (jwk.Key, error) {
+	data, err := os.ReadFile(opts.persistPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load persisted private key %s %v", opts.persistPath, err)
+	}
+	defer wipe(data)
+	key, _, err := importPrivateKey(ImportKeyOptions{pemBytes: data, format: FormatPEM})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load persisted private key %s %v", opts.persistPath, err)
+	}
+	if block, _ := pem.Decode(data); block != nil && block.Headers[persistedKidHeader] != "" {
+		if err = key.Set(jwk.KeyIDKey, block.Headers[persistedKidHeader]); err != nil {
+			return nil, fmt.Errorf("cannot add an id property to the persisted private key %v", err)
+		}
+	}
+	persisted, err := sameKeyParameters(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load persisted private key %s %v", opts.persistPath, err)
+	}
+
+	wanted := opts
+	if wanted.keyType == "" {
+		wanted.keyType = jwa.RSA
+	}
+	if wanted.keyType == jwa.EC && wanted.curve == "" {
+		wanted.curve = jwa.P256
+	}
+	if persisted.keyType != wanted.keyType || persisted.curve != wanted.curve ||
+		(wanted.keyType == jwa.RSA && wanted.bits != 0 && persisted.bits != wanted.bits) {
+		return nil, fmt.Errorf("persisted private key %s is a %s key, not the requested one", opts.persistPath, keyDescription(key))
+	}
+	return key, nil
+}
+
+// Replace the persisted key with the key installed by a rotation: written to a
+// temporary file readable by the owner only, then renamed over the previous
+// one, so a crash leaves either key whole
+func persistRotatedKey(path string, active *activeKey) error {
+	var data []byte
+	err := active.withPrivateKey(func(key jwk.Key) (err error) {
+		data, err = privateKeyPEM(key, nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("cannot persist private key %v", err)
+	}
+	defer wipe(data)
+	if kid := active.key.KeyID(); kid != "" {
+		block, _ := pem.Decode(data)
+		block.Headers = map[string]string{persistedKidHeader: kid}
+		withKid := pem.EncodeToMemory(block)
+		wipe(block.Bytes)
+		defer wipe(withKid)
+		data = withKid
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("cannot persist private key %v", err)
+	}
+	tmp := f.Name()
+	if err = f.Chmod(0600); err == nil {
+		if _, err = f.Write(data); err == nil {
+			err = f.Sync()
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot persist private key %v", err)
+	}
+	return nil
+}
+
+// PKCS#8 PEM encoding of a private key, raw wins over key for multi-prime keys
+func privateKeyPEM(key jwk.Key, raw interface{}) ([]byte, error) {
+	if raw == nil {
+		if err := key.Raw(&raw); err != nil {
+			return nil, fmt.Errorf("cannot read private key %v", err)
+		}
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode private key %v", err)
+	}
+	defer wipe(der)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ExportPrivatePEM returns the signing key as a PKCS#8 PEM block. It holds
+// the signing identity: never log it, wipe it once written.
+func (c *Config) ExportPrivatePEM() ([]byte, error) {
+	active := c.active()
+	if active == nil {
+		return nil, fmt.Errorf("private key cannot be nil")
+	}
+
+	if active.signer != nil {
+		return nil, fmt.Errorf("cannot export the key of a provider")
+	}
+
+	var data []byte
+	err := active.withPrivateKey(func(key jwk.Key) error {
+		if isSignaturePublicKey(key) {
+			return fmt.Errorf("a config serving a public key only has no private key")
+		}
+		var raw interface{}
+		if active.multiPrimeKey != nil {
+			raw = active.multiPrimeKey
+		}
+		var err error
+		data, err = privateKeyPEM(key, raw)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.audit(AuditEvent{
+		Action:  AuditKeyExported,
+		KeyID:   active.key.KeyID(),
+		Trigger: AuditTriggerAPI,
+		Details: map[string]string{"format": "pem"},
+	})
+	return data, nil
+}
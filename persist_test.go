@@ -0,0 +1,71 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildPersisted(t *testing.T, path string) *Config {
+	t.Helper()
+	config, err := NewConfigBuilder().
+		NewPrivateKey().
+		WithKeyType(jwa.EC).
+		WithCurve(jwa.P256).
+		WithKeyId("initial").
+		WithPersistPath(path).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		config.Close()
+	})
+	return config
+}
+
+func TestRotatedKeyIsPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwk.pem")
+	config := buildPersisted(t, path)
+	if err := config.Rotate(""); err != nil {
+		t.Fatal(err)
+	}
+	token, err := config.Signer().Sign(map[string]interface{}{"sub": "me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("persisted key has mode %o, expected 600", perm)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left next to the persisted key: %d entries", len(entries))
+	}
+
+	// a restart loads the rotated key under its kid, the token still verifies
+	restarted := buildPersisted(t, path)
+	if kid, rotatedKid := restarted.active().key.KeyID(), config.active().key.KeyID(); kid != rotatedKid {
+		t.Fatalf("restart serves kid %q, expected the rotated %q", kid, rotatedKid)
+	}
+	if _, err = restarted.Verifier().Verify(context.Background(), token); err != nil {
+		t.Fatalf("token signed after the rotation does not verify after a restart: %v", err)
+	}
+}
+
+func TestPersistedKeyWithoutRotationKeepsTheConfiguredKid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwk.pem")
+	buildPersisted(t, path)
+	if kid := buildPersisted(t, path).active().key.KeyID(); kid != "initial" {
+		t.Fatalf("reloaded key published as %q, expected initial", kid)
+	}
+}
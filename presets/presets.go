@@ -0,0 +1,121 @@
+// Package presets holds the issuer, key set and claim conventions of common
+// identity providers. Presets are plain data: the verifier treats them like any
+// hand written configuration.
+package presets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where to find a claim in the tokens of a provider, nested claims are dot separated
+type ClaimMapping struct {
+	Subject string
+	// space separated string or array of strings
+	Scopes string
+	Roles  string
+}
+
+// Verification settings of an identity provider
+type Preset struct {
+	Name string
+	// accepted iss values, the first one is the canonical issuer
+	Issuers []string
+	JWKSURL string
+	// accepted aud values, a token must carry one of them
+	Audiences []string
+	// accepted azp values, for providers putting the client there rather than in aud
+	AuthorizedParties []string
+	Claims            ClaimMapping
+}
+
+// Issuer is the canonical issuer of the preset
+func (p Preset) Issuer() string {
+	if len(p.Issuers) == 0 {
+		return ""
+	}
+	return p.Issuers[0]
+}
+
+// Auth0 tenant, domain is the tenant or custom domain e.g. example.eu.auth0.com
+func Auth0(domain, audience string) Preset {
+	base := "https://" + strings.TrimSuffix(domain, "/")
+	return Preset{
+		Name: "auth0",
+		// the trailing slash is part of the Auth0 issuer
+		Issuers:   []string{base + "/"},
+		JWKSURL:   base + "/.well-known/jwks.json",
+		Audiences: []string{audience},
+		Claims: ClaimMapping{
+			Subject: "sub",
+			Scopes:  "scope",
+			Roles:   "permissions",
+		},
+	}
+}
+
+// AzureAD (Entra ID) tenant, v1 and v2 tokens are both accepted: they differ
+// in issuer and in audience, the App ID URI for v1 and the client id for v2
+func AzureAD(tenantID, clientID string) Preset {
+	return Preset{
+		Name: "azuread",
+		Issuers: []string{
+			fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0", tenantID),
+			fmt.Sprintf("https://sts.windows.net/%s/", tenantID),
+		},
+		JWKSURL:   fmt.Sprintf("https://login.microsoftonline.com/%s/discovery/v2.0/keys", tenantID),
+		Audiences: []string{clientID, "api://" + clientID},
+		Claims: ClaimMapping{
+			// sub is pairwise per application, oid identifies the user across them
+			Subject: "oid",
+			Scopes:  "scp",
+			Roles:   "roles",
+		},
+	}
+}
+
+// Keycloak realm, baseURL without the legacy /auth suffix for Keycloak 17+.
+// Access tokens carry the client in azp and aud is usually "account": set
+// AuthorizedParties to the accepted clients.
+func Keycloak(baseURL, realm string) Preset {
+	issuer := fmt.Sprintf("%s/realms/%s", strings.TrimSuffix(baseURL, "/"), realm)
+	return Preset{
+		Name:    "keycloak",
+		Issuers: []string{issuer},
+		JWKSURL: issuer + "/protocol/openid-connect/certs",
+		Claims: ClaimMapping{
+			Subject: "sub",
+			Scopes:  "scope",
+			Roles:   "realm_access.roles",
+		},
+	}
+}
+
+// Google ID tokens, audience is the OAuth client id. Google issues both forms of iss.
+func Google(audience string) Preset {
+	return Preset{
+		Name:      "google",
+		Issuers:   []string{"https://accounts.google.com", "accounts.google.com"},
+		JWKSURL:   "https://www.googleapis.com/oauth2/v3/certs",
+		Audiences: []string{audience},
+		Claims: ClaimMapping{
+			Subject: "sub",
+		},
+	}
+}
+
+// Okta custom authorization server, "default" for the default one
+func Okta(domain, authorizationServerID, audience string) Preset {
+	issuer := fmt.Sprintf("https://%s/oauth2/%s", strings.TrimSuffix(domain, "/"), authorizationServerID)
+	return Preset{
+		Name:      "okta",
+		Issuers:   []string{issuer},
+		JWKSURL:   issuer + "/v1/keys",
+		Audiences: []string{audience},
+		Claims: ClaimMapping{
+			Subject: "sub",
+			Scopes:  "scp",
+			Roles:   "groups",
+		},
+	}
+}
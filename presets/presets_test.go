@@ -0,0 +1,128 @@
+package presets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Recorded token claims of a provider with the preset expected to accept them.
+// The signed token and its key set are verified by the root package tests.
+type fixture struct {
+	Args              []string               `json:"args"`
+	Issuer            string                 `json:"issuer"`
+	JWKSURL           string                 `json:"jwks_url"`
+	AuthorizedParties []string               `json:"authorized_parties"`
+	Claims            map[string]interface{} `json:"claims"`
+	Subject           string                 `json:"subject"`
+}
+
+func presetFor(t *testing.T, name string, args []string) Preset {
+	t.Helper()
+	switch {
+	case name == "auth0":
+		return Auth0(args[0], args[1])
+	case strings.HasPrefix(name, "azuread"):
+		return AzureAD(args[0], args[1])
+	case name == "keycloak":
+		return Keycloak(args[0], args[1])
+	case name == "google":
+		return Google(args[0])
+	case name == "okta":
+		return Okta(args[0], args[1], args[2])
+	}
+	t.Fatalf("no preset for fixture %s", name)
+	return Preset{}
+}
+
+// Value of a dot separated claim path
+func lookup(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+func contains(accepted []string, value interface{}) bool {
+	var values []interface{}
+	switch v := value.(type) {
+	case string:
+		values = []interface{}{v}
+	case []interface{}:
+		values = v
+	}
+	for _, v := range values {
+		for _, a := range accepted {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestPresetsAcceptRecordedTokens(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures: %v", err)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f fixture
+			if err = json.Unmarshal(raw, &f); err != nil {
+				t.Fatal(err)
+			}
+			preset := presetFor(t, name, f.Args)
+			if f.AuthorizedParties != nil {
+				preset.AuthorizedParties = f.AuthorizedParties
+			}
+
+			if preset.Issuer() != f.Issuer {
+				t.Fatalf("issuer %q, want %q", preset.Issuer(), f.Issuer)
+			}
+			if preset.JWKSURL != f.JWKSURL {
+				t.Fatalf("JWKS URL %q, want %q", preset.JWKSURL, f.JWKSURL)
+			}
+			if !contains(preset.Issuers, f.Claims["iss"]) {
+				t.Fatalf("iss %v is not accepted by %v", f.Claims["iss"], preset.Issuers)
+			}
+			if !contains(preset.Audiences, f.Claims["aud"]) && !contains(preset.AuthorizedParties, f.Claims["azp"]) {
+				t.Fatalf("neither aud %v nor azp %v is accepted", f.Claims["aud"], f.Claims["azp"])
+			}
+			if got := lookup(f.Claims, preset.Claims.Subject); got != f.Subject {
+				t.Fatalf("subject %v, want %q", got, f.Subject)
+			}
+			// delegated tokens carry scopes and application tokens roles, the
+			// mapping must find at least one of them when the preset has any
+			if preset.Claims.Scopes != "" || preset.Claims.Roles != "" {
+				if lookup(f.Claims, preset.Claims.Scopes) == nil && lookup(f.Claims, preset.Claims.Roles) == nil {
+					t.Fatalf("neither %q nor %q is in the recorded token", preset.Claims.Scopes, preset.Claims.Roles)
+				}
+			}
+		})
+	}
+}
+
+func TestPresetsRefuseOtherTenants(t *testing.T) {
+	azure := AzureAD("72f988bf-86f1-41af-91ab-2d7cd011db47", "client")
+	if contains(azure.Issuers, "https://sts.windows.net/00000000-0000-0000-0000-000000000000/") {
+		t.Fatal("the issuer of another tenant is accepted")
+	}
+	auth0 := Auth0("example.eu.auth0.com", "api")
+	// Auth0 issuers end with a slash, the bare domain is another issuer
+	if contains(auth0.Issuers, "https://example.eu.auth0.com") {
+		t.Fatal("the issuer without its trailing slash is accepted")
+	}
+}
@@ -0,0 +1,99 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/v4lproik/gin-jwks-rsa/presets"
+	"strings"
+)
+
+// gin context key holding the Principal of the verified token
+const PrincipalContextKey = "gin-jwks-principal"
+
+// Claims read when the verifier has no claim mapping
+var defaultClaimMapping = presets.ClaimMapping{
+	Subject: "sub",
+	Scopes:  "scope",
+}
+
+// Principal is who a verified token stands for, read with the claim mapping
+// of its verifier
+type Principal struct {
+	Subject string
+	Scopes  []string
+	Roles   []string
+}
+
+// Read the subject, scopes and roles of the tokens where mapping says, e.g.
+// oid rather than sub for Azure AD. WithPreset sets the mapping of the
+// provider; sub and scope are read otherwise.
+func WithClaimMapping(mapping presets.ClaimMapping) VerifierOption {
+	return func(v *Verifier) {
+		v.claimMapping = mapping
+	}
+}
+
+// Principal of a token verified by v. A claim missing from the token, or not
+// mapped, leaves its field empty.
+func (v *Verifier) Principal(token jwt.Token) Principal {
+	mapping := v.claimMapping
+	if mapping == (presets.ClaimMapping{}) {
+		mapping = defaultClaimMapping
+	}
+	subject, _ := claimAt(token, mapping.Subject).(string)
+	return Principal{
+		Subject: subject,
+		Scopes:  claimStrings(claimAt(token, mapping.Scopes)),
+		Roles:   claimStrings(claimAt(token, mapping.Roles)),
+	}
+}
+
+// PrincipalFromContext returns the Principal of the token verified by Verify
+// or VerifyRemote
+func PrincipalFromContext(c *gin.Context) (Principal, bool) {
+	v, ok := c.Get(PrincipalContextKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := v.(Principal)
+	return principal, ok
+}
+
+// Value of a dot separated claim path, nil when it is missing
+func claimAt(token jwt.Token, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	names := strings.Split(path, ".")
+	value, ok := token.Get(names[0])
+	if !ok {
+		return nil
+	}
+	for _, name := range names[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// A space separated string or an array of strings
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
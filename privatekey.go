@@ -0,0 +1,40 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// Private key kept outside of the config, only opened while it is needed
+type sealedKey interface {
+	open() (jwk.Key, error)
+}
+
+// Seal the private key of active, the result keeps its public half only
+func (c *Config) sealActiveKey(active *activeKey) (*activeKey, error) {
+	sealed, err := c.sealKey(active.key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot seal private key %v", err)
+	}
+
+	pubKey, err := active.key.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public key %v", err)
+	}
+
+	return &activeKey{key: pubKey, createdAt: active.createdAt, notAfter: active.notAfter, sealed: sealed}, nil
+}
+
+// Run fn with the private key, unsealing it for the duration of the call if needed
+func (a *activeKey) withPrivateKey(fn func(key jwk.Key) error) error {
+	if a.sealed == nil {
+		return fn(a.key)
+	}
+
+	key, err := a.sealed.open()
+	if err != nil {
+		return fmt.Errorf("cannot unseal private key %v", err)
+	}
+
+	return fn(key)
+}
@@ -0,0 +1,111 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// Profile bundles the defaults of an environment
+type Profile string
+
+const (
+	// 2048-bit keys derived from a fixed seed by default, down to 1024 bits
+	// allowed, indented JSON bodies
+	ProfileDev Profile = "dev"
+	// 1024-bit keys by default and no self-test, for fast test suites
+	ProfileTest Profile = "test"
+	// self-test on and the key set checked at Build, keys of at least 2048
+	// bits, no deterministic seed, enforced at Build
+	ProfileProd Profile = "prod"
+)
+
+// Smallest RSA key ProfileProd accepts
+const profileProdMinKeyBits = 2048
+
+// Apply the defaults of an environment, explicit options win over them except
+// for the guardrails of ProfileProd which make Build fail
+func (n *ConfigBuilder) WithProfile(profile Profile) *ConfigBuilder {
+	n.config.profile = profile
+	return n
+}
+
+// Profile returns the profile the config was built with, empty if none
+func (c *Config) Profile() Profile {
+	return c.profile
+}
+
+// Fill in the options left unset with the profile defaults
+func (c *Config) applyProfileDefaults() error {
+	defaultBits := 0
+	switch c.profile {
+	case "":
+		return nil
+	case ProfileDev, ProfileProd:
+		defaultBits = 2048
+	case ProfileTest:
+		defaultBits = 1024
+	default:
+		return fmt.Errorf("unknown profile %q", c.profile)
+	}
+
+	if c.newPkOpts != nil && c.newPkOpts.bits == 0 {
+		c.newPkOpts.bits = defaultBits
+	}
+	switch c.profile {
+	case ProfileDev:
+		// a persisted key is stable already
+		if opts := c.newPkOpts; opts != nil && !opts.seedSet && opts.persistPath == "" {
+			opts.seed = devProfileSeed
+		}
+		if !c.prettyJSONSet {
+			c.prettyJSON = true
+		}
+	case ProfileProd:
+		if c.newPkOpts != nil && c.newPkOpts.seed != nil {
+			return fmt.Errorf("profile %s forbids a deterministic seed", c.profile)
+		}
+		if c.importPubOpts == nil && c.symmetricOpts == nil && !c.selfTestSkipped {
+			c.selfTest = true
+		}
+	}
+	return nil
+}
+
+// Refuse a key breaking the guardrails of the profile
+func (c *Config) checkProfileGuardrails(key jwk.Key) error {
+	if c.profile != ProfileProd {
+		return nil
+	}
+
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return fmt.Errorf("cannot read key %v", err)
+	}
+	var bits int
+	switch raw := raw.(type) {
+	case *rsa.PrivateKey:
+		bits = raw.N.BitLen()
+	case *rsa.PublicKey:
+		bits = raw.N.BitLen()
+	default:
+		return nil
+	}
+	if bits < profileProdMinKeyBits {
+		return fmt.Errorf("profile %s requires RSA keys of at least %d bits, got %d", c.profile, profileProdMinKeyBits, bits)
+	}
+	return nil
+}
+
+// Serialize the key set once at Build under ProfileProd, so a key set the
+// handlers would refuse, e.g. holding private members, fails the startup even
+// without the self-test
+func (c *Config) checkProfileKeySet() error {
+	if c.profile != ProfileProd || c.selfTest {
+		return nil
+	}
+	if _, err := c.jwksDocument(); err != nil {
+		return fmt.Errorf("profile %s cannot serve the key set %w", c.profile, err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Body writeJSON gives for a small object
+func profileJSONBody(config *Config) string {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	config.writeJSON(c, http.StatusOK, map[string]string{"a": "b"})
+	return w.Body.String()
+}
+
+func TestProfileDefaults(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		builder  func() *ConfigNewKeyBuilder
+		bits     int
+		stable   bool
+		pretty   bool
+		selfTest bool
+	}{
+		{
+			name: "dev",
+			builder: func() *ConfigNewKeyBuilder {
+				return NewConfigBuilder().WithProfile(ProfileDev).NewPrivateKey().WithKeyType(jwa.RSA)
+			},
+			bits:   2048,
+			stable: true,
+			pretty: true,
+		},
+		{
+			name: "dev with explicit options",
+			builder: func() *ConfigNewKeyBuilder {
+				return NewConfigBuilder().WithProfile(ProfileDev).WithPrettyJSON(false).
+					NewPrivateKey().WithKeyLength(1024).WithDeterministicSeed(nil)
+			},
+			bits: 1024,
+		},
+		{
+			name: "test",
+			builder: func() *ConfigNewKeyBuilder {
+				return NewConfigBuilder().WithProfile(ProfileTest).NewPrivateKey().WithKeyType(jwa.RSA)
+			},
+			bits: 1024,
+		},
+		{
+			name: "prod",
+			builder: func() *ConfigNewKeyBuilder {
+				return NewConfigBuilder().WithProfile(ProfileProd).NewPrivateKey().WithKeyType(jwa.RSA)
+			},
+			bits:     2048,
+			selfTest: true,
+		},
+		{
+			name: "prod without self-test",
+			builder: func() *ConfigNewKeyBuilder {
+				return NewConfigBuilder().WithProfile(ProfileProd).WithoutSelfTest().NewPrivateKey().WithKeyLength(3072)
+			},
+			bits: 3072,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var kids []string
+			for i := 0; i < 2; i++ {
+				config, err := tc.builder().Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer config.Close()
+				kids = append(kids, config.active().key.KeyID())
+
+				opts, err := sameKeyParameters(config.active().key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if opts.bits != tc.bits {
+					t.Fatalf("generated a %d-bit key, expected %d", opts.bits, tc.bits)
+				}
+				if config.selfTest != tc.selfTest {
+					t.Fatalf("self-test is %v", config.selfTest)
+				}
+				if pretty := strings.Contains(profileJSONBody(config), "\n"); pretty != tc.pretty {
+					t.Fatalf("indented JSON bodies is %v", pretty)
+				}
+				// the key set keeps its canonical form whatever the profile
+				body, err := config.MarshalJWKS()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.Contains(string(body), "\n") {
+					t.Fatal("key set indented")
+				}
+			}
+			if stable := kids[0] == kids[1]; stable != tc.stable {
+				t.Fatalf("the same key on both builds is %v", stable)
+			}
+		})
+	}
+}
+
+func TestProfileGuardrails(t *testing.T) {
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		builder *ConfigBuilder
+		invalid bool
+	}{
+		"prod with a seed": {
+			builder: &NewConfigBuilder().WithProfile(ProfileProd).NewPrivateKey().WithDeterministicSeed([]byte("seed")).ConfigBuilder,
+			invalid: true,
+		},
+		"prod with a weak key allowed": {
+			builder: &NewConfigBuilder().WithProfile(ProfileProd).AllowWeakKeys().ImportPrivateKey().WithRawKey(weakKey).ConfigBuilder,
+		},
+		"unknown profile": {
+			builder: &NewConfigBuilder().WithProfile("staging").NewPrivateKey().WithKeyLength(2048).ConfigBuilder,
+			invalid: true,
+		},
+	} {
+		_, err := tc.builder.Build()
+		if err == nil {
+			t.Fatalf("%s: built", name)
+		}
+		if errors.Is(err, ErrInvalidConfig) != tc.invalid {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestDeterministicSeedKeys(t *testing.T) {
+	for _, tc := range []struct {
+		keyType jwa.KeyType
+		curve   jwa.EllipticCurveAlgorithm
+	}{
+		{keyType: jwa.RSA},
+		{keyType: jwa.EC, curve: jwa.P384},
+		{keyType: jwa.OKP, curve: jwa.Ed25519},
+	} {
+		var kids []string
+		for _, seed := range []string{"one", "one", "two"} {
+			config, err := NewConfigBuilder().NewPrivateKey().WithKeyType(tc.keyType).WithCurve(tc.curve).
+				WithKeyLength(2048).WithDeterministicSeed([]byte(seed)).Build()
+			if err != nil {
+				t.Fatalf("%s: %v", tc.keyType, err)
+			}
+			defer config.Close()
+			checkSignsAndVerifies(t, config)
+			kids = append(kids, config.active().key.KeyID())
+		}
+		if kids[0] != kids[1] || kids[0] == kids[2] {
+			t.Fatalf("%s: kids %v, expected the same key for the same seed only", tc.keyType, kids)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+//go:build memguard
+
+package gin_jwks_rsa
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/awnumar/memguard"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// Keep the private key in an encrypted memguard enclave and only unseal it
+// while it is being used. The config itself only holds the public key.
+//
+// Limitations: jwx and crypto/rsa build their own copies of the key each time
+// it is unsealed, those copies live on the regular heap until they are
+// garbage collected and cannot be wiped by this package.
+func (n *ConfigBuilder) WithProtectedMemory() *ConfigBuilder {
+	n.config.sealKey = sealWithMemguard
+	return n
+}
+
+// Private key sealed in a memguard enclave
+type memguardKey struct {
+	enclave *memguard.Enclave
+	kid     string
+	use     string
+}
+
+// Seal the PKCS#8 DER form of the key, the DER buffer is wiped by memguard
+func sealWithMemguard(key jwk.Key) (sealedKey, error) {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, fmt.Errorf("cannot get raw private key %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal private key %v", err)
+	}
+
+	return &memguardKey{
+		enclave: memguard.NewEnclave(der),
+		kid:     key.KeyID(),
+		use:     key.KeyUsage(),
+	}, nil
+}
+
+func (k *memguardKey) open() (jwk.Key, error) {
+	buf, err := k.enclave.Open()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open enclave %v", err)
+	}
+	defer buf.Destroy()
+
+	raw, err := x509.ParsePKCS8PrivateKey(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key %v", err)
+	}
+
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key %v", err)
+	}
+	if err = key.Set(jwk.KeyIDKey, k.kid); err != nil {
+		return nil, fmt.Errorf("cannot add an id property to the private key %v", err)
+	}
+	if err = key.Set(jwk.KeyUsageKey, k.use); err != nil {
+		return nil, fmt.Errorf("cannot add a usage property to the private key %v", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,55 @@
+//go:build memguard
+
+package gin_jwks_rsa
+
+import (
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"testing"
+)
+
+// The config holds the public key only, the private one is unsealed to sign
+func TestProtectedMemoryKeepsThePrivateKeySealed(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder().WithProtectedMemory())
+	checkSealed := func() {
+		t.Helper()
+		active := config.active()
+		if active.sealed == nil {
+			t.Fatal("private key not sealed")
+		}
+		if _, ok := active.key.(jwk.RSAPrivateKey); ok {
+			t.Fatal("the config holds the private key")
+		}
+		checkSignsAndVerifies(t, config)
+	}
+	checkSealed()
+	if members := servedKeyMembers(t, config); members["kid"] != "test" || members["d"] != nil {
+		t.Fatalf("served %v", members)
+	}
+
+	// a rotated key is sealed as well
+	if err := config.Rotate("rotated"); err != nil {
+		t.Fatal(err)
+	}
+	if kid := config.active().key.KeyID(); kid != "rotated" {
+		t.Fatalf("signing with %q", kid)
+	}
+	checkSealed()
+}
+
+func TestMemguardKeyKeepsItsMetadata(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	sealed, err := sealWithMemguard(config.active().key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := sealed.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.KeyID() != "test" || opened.KeyUsage() != config.active().key.KeyUsage() {
+		t.Fatalf("unsealed kid %q use %q", opened.KeyID(), opened.KeyUsage())
+	}
+	if _, ok := opened.(jwk.RSAPrivateKey); !ok {
+		t.Fatal("unsealed a key without its private part")
+	}
+}
@@ -0,0 +1,140 @@
+package gin_jwks_rsa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// KeyProvider holds a signing key the config never sees, e.g. a KMS or HSM
+// key. The key set publishes PublicJWK and the tokens are signed by Signer,
+// WindowsCertStoreProvider is one.
+type KeyProvider interface {
+	// public key to publish, a kid it carries is kept unless WithKeyId is called
+	PublicJWK() (jwk.Key, error)
+	Signer() crypto.Signer
+}
+
+// ErrProviderRotation is returned by Rotate on the key of a KeyProvider, which
+// would otherwise move the signing key into the process. The provider rotates
+// it, a RenewableKeyProvider then hands the new key over.
+var ErrProviderRotation = errors.New("the key of a provider is rotated through the provider")
+
+// RenewableKeyProvider is a KeyProvider whose key changes, e.g. when its
+// certificate is renewed. Build subscribes the config, which installs the new
+// key the way ReplaceKey does: the key set and the signer change together and
+// the previous key stays published for the rotation grace period.
+type RenewableKeyProvider interface {
+	KeyProvider
+	// call fn once PublicJWK and Signer return the new key. Signer must go on
+	// signing with the key it returned until then.
+	OnRenew(fn func())
+}
+
+// Sign with the key of p instead of importing a private key. Build reads its
+// public key once, the key cannot be exported, sealed or used for encryption.
+func (n *ConfigImportKeyBuilder) WithProvider(p KeyProvider) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.provider = p
+	return n
+}
+
+// In-memory KeyProvider, the private key stays in the process
+type staticKeyProvider struct {
+	signer crypto.Signer
+}
+
+// NewStaticKeyProvider KeyProvider of an in-memory RSA, EC or Ed25519 private
+// key, e.g. to exercise the provider path without a KMS
+func NewStaticKeyProvider(key crypto.Signer) (KeyProvider, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return &staticKeyProvider{signer: key}, nil
+	default:
+		return nil, fmt.Errorf("%w, expected an RSA, EC or Ed25519 private key, got %T", ErrUnsupportedKeyType, key)
+	}
+}
+
+func (p *staticKeyProvider) PublicJWK() (jwk.Key, error) {
+	key, err := jwk.FromRaw(p.signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public key %v", err)
+	}
+	return key, nil
+}
+
+func (p *staticKeyProvider) Signer() crypto.Signer {
+	return p.signer
+}
+
+// Public key and signer of the provider, refusing a signer whose public key is
+// not the published one
+func importProviderKey(opts ImportKeyOptions) (jwk.Key, crypto.Signer, error) {
+	if opts.privateKeyPemPath != "" || opts.pemBytes != nil || opts.reader != nil || opts.fsys != nil ||
+		opts.rawKey != nil || opts.jwkKey != nil || opts.jks != nil || opts.wrapped != nil {
+		return nil, nil, fmt.Errorf("%w, cannot import from a key provider and another source", ErrConflictingKeySources)
+	}
+
+	key, err := opts.provider.PublicJWK()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read the public key of the provider %v", err)
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("the provider returned no public key")
+	}
+	// Build decorates the key, leave the one of the provider alone
+	if key, err = key.Clone(); err != nil {
+		return nil, nil, fmt.Errorf("cannot copy the public key of the provider %v", err)
+	}
+	signer := opts.provider.Signer()
+	if signer == nil {
+		return nil, nil, fmt.Errorf("the provider returned no signer")
+	}
+
+	signerKey, err := jwk.FromRaw(signer.Public())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w, cannot read the public key of the signer %v", ErrUnsupportedKeyType, err)
+	}
+	published, err := thumbprintKeyId(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	signed, err := thumbprintKeyId(signerKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if published != signed {
+		return nil, nil, fmt.Errorf("the signer of the provider does not match its public key")
+	}
+
+	return key, signer, nil
+}
+
+// Install the new key of the provider, the previous signer stays in use until
+// the key set publishes the new one
+func (c *Config) renewProviderKey() {
+	select {
+	case <-c.background.done:
+		return
+	default:
+	}
+	c.keys.rotating.Lock()
+	defer c.keys.rotating.Unlock()
+	current := c.active()
+	if current == nil {
+		return
+	}
+
+	opts := *c.importPkOpts
+	key, signer, err := importProviderKey(opts)
+	if err == nil {
+		err = c.replaceKey(key, signer, opts.keyId, current, AuditKeyRotated, AuditTriggerProviderRenewal)
+	}
+	if err != nil {
+		c.reportError(fmt.Errorf("cannot install the renewed key of the provider %v", err))
+	}
+}
@@ -0,0 +1,175 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Provider whose key is swapped by renew, the way a certificate renewal does
+type renewingProvider struct {
+	mu      sync.Mutex
+	signer  crypto.Signer
+	onRenew []func()
+}
+
+func (p *renewingProvider) PublicJWK() (jwk.Key, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return jwk.FromRaw(p.signer.Public())
+}
+
+func (p *renewingProvider) Signer() crypto.Signer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.signer
+}
+
+func (p *renewingProvider) OnRenew(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onRenew = append(p.onRenew, fn)
+}
+
+func (p *renewingProvider) renew(t *testing.T) {
+	p.mu.Lock()
+	p.signer = newECKey(t)
+	onRenew := p.onRenew
+	p.mu.Unlock()
+	for _, fn := range onRenew {
+		fn()
+	}
+}
+
+func TestStaticKeyProviderSignsAndPublishes(t *testing.T) {
+	provider, err := NewStaticKeyProvider(newECKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigBuilder().ImportPrivateKey().WithProvider(provider).WithKeyId("kms").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	token, err := config.Signer().Sign(map[string]interface{}{"sub": "me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = config.Verifier().Verify(context.Background(), token); err != nil {
+		t.Fatalf("token of the provider does not verify: %v", err)
+	}
+	if members := servedKeyMembers(t, config); members["kid"] != "kms" || members["d"] != nil {
+		t.Fatalf("served %v", members)
+	}
+	if _, err = config.ExportPrivatePEM(); err == nil {
+		t.Fatal("the key of a provider was exported")
+	}
+}
+
+func TestProviderMismatchingSignerIsRefused(t *testing.T) {
+	provider := &mismatchedProvider{published: newECKey(t), signer: newECKey(t)}
+	if _, err := NewConfigBuilder().ImportPrivateKey().WithProvider(provider).Build(); err == nil {
+		t.Fatal("a signer not matching the published key was accepted")
+	}
+}
+
+type mismatchedProvider struct {
+	published, signer *ecdsa.PrivateKey
+}
+
+func (p *mismatchedProvider) PublicJWK() (jwk.Key, error) {
+	return jwk.FromRaw(p.published.Public())
+}
+
+func (p *mismatchedProvider) Signer() crypto.Signer {
+	return p.signer
+}
+
+func TestProviderKeysOnlySign(t *testing.T) {
+	key := newECKey(t)
+	provider, err := NewStaticKeyProvider(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, builder := range map[string]*ConfigImportKeyBuilder{
+		"with a path":    NewConfigBuilder().ImportPrivateKey().WithProvider(provider).WithPath(writeTestKey(t, key)),
+		"with a raw key": NewConfigBuilder().ImportPrivateKey().WithProvider(provider).WithRawKey(key),
+		"for encryption": NewConfigBuilder().ImportPrivateKey().WithProvider(provider).WithKeyUsage("enc"),
+	} {
+		if config, err := builder.Build(); err == nil {
+			config.Close()
+			t.Fatalf("provider %s accepted", name)
+		}
+	}
+}
+
+func TestProviderRenewalPublishesTheNewKey(t *testing.T) {
+	provider := &renewingProvider{signer: newECKey(t)}
+	config, err := NewConfigBuilder().
+		WithRotationGrace(time.Hour).
+		ImportPrivateKey().
+		WithProvider(provider).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	before, err := config.Signer().Sign(map[string]interface{}{"sub": "me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousKid := config.active().key.KeyID()
+	provider.renew(t)
+	if config.active().key.KeyID() == previousKid {
+		t.Fatal("the renewed key was not installed")
+	}
+
+	after, err := config.Signer().Sign(map[string]interface{}{"sub": "me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the new token is signed by the key the set publishes, the previous one
+	// still verifies during the grace period
+	for _, token := range []string{before, after} {
+		if _, err = config.Verifier().Verify(context.Background(), token); err != nil {
+			t.Fatalf("token does not verify after the renewal: %v", err)
+		}
+	}
+	set, err := config.PublicJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 2 {
+		t.Fatalf("expected the renewed and the previous key, got %d keys", set.Len())
+	}
+}
+
+func TestProviderKeyIsNotRotatedInMemory(t *testing.T) {
+	provider, err := NewStaticKeyProvider(newECKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfigBuilder().ImportPrivateKey().WithProvider(provider).WithKeyId("kms").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	if err = config.Rotate("next"); !errors.Is(err, ErrProviderRotation) {
+		t.Fatalf("rotation of the provider key answered %v", err)
+	}
+	active := config.active()
+	if active.key.KeyID() != "kms" || active.signer == nil {
+		t.Fatalf("signing key is %q after a refused rotation", active.key.KeyID())
+	}
+	if _, err = config.ExportPrivatePEM(); err == nil {
+		t.Fatal("the key of the provider was replaced by a key in memory")
+	}
+}
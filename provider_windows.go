@@ -0,0 +1,352 @@
+//go:build windows
+
+package gin_jwks_rsa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/cert"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"golang.org/x/sys/windows"
+	"io"
+	"math/big"
+	"time"
+	"unsafe"
+)
+
+const (
+	certSystemStoreCurrentUser  = 0x00010000
+	certSystemStoreLocalMachine = 0x00020000
+	certStoreReadonlyFlag       = 0x00008000
+	certStoreProvSystemW        = 10
+
+	cryptAcquireOnlyNCryptKeyFlag = 0x00040000
+	certNCryptKeySpec             = 0xFFFFFFFF
+
+	bcryptPadPKCS1 = 0x00000002
+	bcryptPadPSS   = 0x00000008
+)
+
+var (
+	ncrypt             = windows.NewLazySystemDLL("ncrypt.dll")
+	procNCryptSignHash = ncrypt.NewProc("NCryptSignHash")
+	procNCryptFreeObj  = ncrypt.NewProc("NCryptFreeObject")
+)
+
+type bcryptPKCS1PaddingInfo struct {
+	algID *uint16
+}
+
+type bcryptPSSPaddingInfo struct {
+	algID *uint16
+	salt  uint32
+}
+
+// Where to find the certificate in the Windows certificate store
+type WindowsCertStoreOptions struct {
+	// store name, "My" if empty
+	Store string
+	// LocalMachine store instead of the CurrentUser one
+	LocalMachine bool
+	// hex encoded SHA-1 thumbprint of the certificate
+	Thumbprint string
+	// subject, either the full distinguished name or the common name;
+	// the valid certificate with the latest NotBefore wins
+	Subject string
+	// how often to look for a renewed certificate, never if zero
+	RescanInterval time.Duration
+	// called when a re-scan picked up a different certificate, or failed
+	OnRescan func(thumbprint string, err error)
+}
+
+var _ RenewableKeyProvider = (*WindowsCertStoreProvider)(nil)
+
+// WindowsCertStoreProvider exposes a certificate of the Windows store whose
+// private key never leaves CNG, signatures are computed with NCryptSignHash.
+// A renewed certificate found by a re-scan is installed in the configs built
+// with it, the way ReplaceKey does.
+type WindowsCertStoreProvider struct {
+	opts WindowsCertStoreOptions
+	// keys of the current and previous certificates, freed by Close
+	keys       storeKeys
+	background *background
+}
+
+// Certificate found in the store along with its CNG key handle
+type windowsCertKey struct {
+	cert       *x509.Certificate
+	thumbprint string
+	key        windows.Handle
+	freeKey    bool
+}
+
+// Find the certificate in the store and start watching for renewals
+func NewWindowsCertStoreProvider(opts WindowsCertStoreOptions) (*WindowsCertStoreProvider, error) {
+	if opts.Thumbprint == "" && opts.Subject == "" {
+		return nil, fmt.Errorf("a thumbprint or a subject is required")
+	}
+	if opts.Store == "" {
+		opts.Store = "My"
+	}
+	opts.Thumbprint = normalizeThumbprint(opts.Thumbprint)
+
+	p := &WindowsCertStoreProvider{opts: opts, background: newBackground()}
+	current, err := p.scan()
+	if err != nil {
+		return nil, err
+	}
+	p.keys.current = current
+
+	if opts.RescanInterval > 0 {
+		p.background.goWithTicker(opts.RescanInterval, func(time.Time) {
+			p.rescan()
+		})
+	}
+
+	return p, nil
+}
+
+// Look the certificate up again and swap it if it changed
+func (p *WindowsCertStoreProvider) rescan() {
+	p.keys.rescan(func() (storeKey, error) {
+		found, err := p.scan()
+		if err != nil {
+			return nil, err
+		}
+		return found, nil
+	}, p.opts.OnRescan)
+}
+
+// OnRenew calls fn after a re-scan picked up a renewed certificate
+func (p *WindowsCertStoreProvider) OnRenew(fn func()) {
+	p.keys.subscribe(fn)
+}
+
+// Walk the store for the configured certificate and acquire its CNG key
+func (p *WindowsCertStoreProvider) scan() (*windowsCertKey, error) {
+	location := uint32(certSystemStoreCurrentUser)
+	if p.opts.LocalMachine {
+		location = certSystemStoreLocalMachine
+	}
+	storeName, err := windows.UTF16PtrFromString(p.opts.Store)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store name %v", err)
+	}
+	store, err := windows.CertOpenStore(certStoreProvSystemW, 0, 0, location|certStoreReadonlyFlag, uintptr(unsafe.Pointer(storeName)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open certificate store %q %v", p.opts.Store, err)
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	var best *windows.CertContext
+	var bestCert *x509.Certificate
+	now := time.Now()
+	var ctx *windows.CertContext
+	for {
+		ctx, err = windows.CertEnumCertificatesInStore(store, ctx)
+		if err != nil {
+			break
+		}
+
+		// the parsed certificate refers to its DER, which the next call frees
+		der := append([]byte(nil), unsafe.Slice(ctx.EncodedCert, ctx.Length)...)
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		if !certificateMatches(p.opts.Thumbprint, p.opts.Subject, parsed) {
+			continue
+		}
+		if preferCertificate(bestCert, parsed, p.opts.Thumbprint != "", now) {
+			if best != nil {
+				windows.CertFreeCertificateContext(best)
+			}
+			best = windows.CertDuplicateCertificateContext(ctx)
+			bestCert = parsed
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no matching certificate in store %q", p.opts.Store)
+	}
+	defer windows.CertFreeCertificateContext(best)
+
+	var key windows.Handle
+	var keySpec uint32
+	var freeKey bool
+	err = windows.CryptAcquireCertificatePrivateKey(best, cryptAcquireOnlyNCryptKeyFlag, nil, &key, &keySpec, &freeKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot acquire the private key of the certificate %v", err)
+	}
+	if keySpec != certNCryptKeySpec {
+		return nil, fmt.Errorf("the private key of the certificate is not a CNG key")
+	}
+
+	return &windowsCertKey{
+		cert:       bestCert,
+		thumbprint: certificateThumbprint(bestCert),
+		key:        key,
+		freeKey:    freeKey,
+	}, nil
+}
+
+// PublicJWK returns the public key of the current certificate with its x5c,
+// x5t and x5t#S256 members, the kid is the certificate SHA-1 thumbprint
+func (p *WindowsCertStoreProvider) PublicJWK() (jwk.Key, error) {
+	current := p.keys.get().(*windowsCertKey)
+
+	key, err := jwk.FromRaw(current.cert.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public key %v", err)
+	}
+
+	var chain cert.Chain
+	if err = chain.AddString(base64.StdEncoding.EncodeToString(current.cert.Raw)); err != nil {
+		return nil, fmt.Errorf("cannot encode certificate %v", err)
+	}
+	sha1Thumbprint := sha1.Sum(current.cert.Raw)
+	sha256Thumbprint := sha256.Sum256(current.cert.Raw)
+	members := map[string]interface{}{
+		jwk.KeyIDKey:                  current.thumbprint,
+		jwk.KeyUsageKey:               KeyUsageAsSignature,
+		jwk.X509CertChainKey:          &chain,
+		jwk.X509CertThumbprintKey:     EncodeToString(sha1Thumbprint[:]),
+		jwk.X509CertThumbprintS256Key: EncodeToString(sha256Thumbprint[:]),
+	}
+	for k, v := range members {
+		if err = key.Set(k, v); err != nil {
+			return nil, fmt.Errorf("cannot set %q on the public key %v", k, err)
+		}
+	}
+
+	return key, nil
+}
+
+// Signer returns a crypto.Signer backed by NCryptSignHash with the key of the
+// current certificate. It keeps signing with that key after a renewal, until
+// Close, so a config signs with the key its key set publishes.
+func (p *WindowsCertStoreProvider) Signer() crypto.Signer {
+	return p.keys.get()
+}
+
+// Public returns the public key of the current certificate
+func (p *WindowsCertStoreProvider) Public() crypto.PublicKey {
+	return p.keys.get().Public()
+}
+
+// Sign the digest with the CNG key of the current certificate, a config signs
+// through Signer instead
+func (p *WindowsCertStoreProvider) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return p.keys.get().Sign(rand, digest, opts)
+}
+
+func (k *windowsCertKey) certificate() *x509.Certificate {
+	return k.cert
+}
+
+// Public returns the public key of the certificate
+func (k *windowsCertKey) Public() crypto.PublicKey {
+	return k.cert.PublicKey
+}
+
+// Sign the digest with the CNG key of the certificate
+func (k *windowsCertKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch k.cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return k.signRSA(digest, opts)
+	case *ecdsa.PublicKey:
+		return k.signECDSA(digest)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k.cert.PublicKey)
+	}
+}
+
+// Close stops the re-scan and releases the CNG key
+func (p *WindowsCertStoreProvider) Close() error {
+	p.background.once.Do(func() {
+		close(p.background.done)
+	})
+	p.background.wg.Wait()
+
+	p.keys.free()
+	return nil
+}
+
+func (k *windowsCertKey) signRSA(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var algID string
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		algID = "SHA256"
+	case crypto.SHA384:
+		algID = "SHA384"
+	case crypto.SHA512:
+		algID = "SHA512"
+	default:
+		return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+	algIDPtr, err := windows.UTF16PtrFromString(algID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		saltLength := pss.SaltLength
+		if saltLength == rsa.PSSSaltLengthEqualsHash || saltLength == rsa.PSSSaltLengthAuto {
+			saltLength = opts.HashFunc().Size()
+		}
+		info := bcryptPSSPaddingInfo{algID: algIDPtr, salt: uint32(saltLength)}
+		return k.signHash(unsafe.Pointer(&info), digest, bcryptPadPSS)
+	}
+
+	info := bcryptPKCS1PaddingInfo{algID: algIDPtr}
+	return k.signHash(unsafe.Pointer(&info), digest, bcryptPadPKCS1)
+}
+
+// CNG returns r||s, crypto.Signer callers expect an ASN.1 signature
+func (k *windowsCertKey) signECDSA(digest []byte) ([]byte, error) {
+	raw, err := k.signHash(nil, digest, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	half := len(raw) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+}
+
+func (k *windowsCertKey) signHash(paddingInfo unsafe.Pointer, digest []byte, flags uint32) ([]byte, error) {
+	var size uint32
+	r, _, _ := procNCryptSignHash.Call(uintptr(k.key), uintptr(paddingInfo),
+		uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+		0, 0, uintptr(unsafe.Pointer(&size)), uintptr(flags))
+	if r != 0 {
+		return nil, fmt.Errorf("NCryptSignHash failed with status 0x%x", r)
+	}
+
+	signature := make([]byte, size)
+	r, _, _ = procNCryptSignHash.Call(uintptr(k.key), uintptr(paddingInfo),
+		uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+		uintptr(unsafe.Pointer(&signature[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), uintptr(flags))
+	if r != 0 {
+		return nil, fmt.Errorf("NCryptSignHash failed with status 0x%x", r)
+	}
+
+	return signature[:size], nil
+}
+
+func (k *windowsCertKey) free() {
+	if k != nil && k.freeKey {
+		procNCryptFreeObj.Call(uintptr(k.key))
+		k.freeKey = false
+	}
+}
@@ -0,0 +1,244 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"sort"
+	"time"
+)
+
+const (
+	// first retry delay after a failed publication, doubled up to a minute
+	publishRetryDelay = 2 * time.Second
+	maxPublishRetry   = time.Minute
+)
+
+// Publisher copies the served key set somewhere else, e.g. a CDN-backed bucket.
+// body is the exact document served by the Jkws handler and etag its entity tag.
+type Publisher interface {
+	Publish(ctx context.Context, body []byte, etag string) error
+}
+
+// Publish the key set with p after Build and after each change of the published
+// keys. Publication runs in the background, failures are retried with a backoff
+// and reported through the error hook, serving never waits for it.
+func (n *ConfigBuilder) WithPublisher(p Publisher) *ConfigBuilder {
+	n.config.publisher = &keySetPublisher{publisher: p, changed: make(chan struct{}, 1)}
+	return n
+}
+
+type keySetPublisher struct {
+	publisher Publisher
+	// pending change, a burst of changes is published once
+	changed chan struct{}
+}
+
+// Serialized key set, as served by the Jkws handler
+func (c *Config) jwksDocument() ([]byte, error) {
+	keys, err := c.jwksKeys()
+	if err != nil {
+		return nil, err
+	}
+	return c.marshalJWKS(keys)
+}
+
+// Serialize keys, refusing a document with a private key member whatever put
+// it there, e.g. a decorator. The keys are sorted by kid and their members come
+// in a fixed order, so the same keys always give the same bytes and a cache
+// diffing the document only sees actual changes. The bytes make the ETag and
+// the signed key set, they are encoded by encoding/json whatever the codec.
+func (c *Config) marshalJWKS(keys []JkwsResponse) ([]byte, error) {
+	sorted := append([]JkwsResponse{}, keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].KeyIDKey < sorted[j].KeyIDKey
+	})
+	body, err := json.Marshal(jwksBody{Keys: sorted})
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckNoPrivateMaterial(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Narrow the key set endpoint to the key named by the kid query parameter,
+// e.g. /.well-known/jwks.json?kid=my-id. An unknown kid gets an empty set,
+// requests without the parameter get the whole set. Not part of RFC 7517.
+func (n *ConfigBuilder) WithKidQueryFilter() *ConfigBuilder {
+	n.config.kidQueryFilter = true
+	return n
+}
+
+// Key set document holding only the keys published under kid
+func (c *Config) filteredJWKS(kid string) ([]byte, string, error) {
+	keys, err := c.jwksKeys()
+	if err != nil {
+		return nil, "", err
+	}
+	matching := []JkwsResponse{}
+	for _, key := range keys {
+		if key.KeyIDKey == kid {
+			matching = append(matching, key)
+		}
+	}
+	body, err := c.marshalJWKS(matching)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, jwksETag(body), nil
+}
+
+// MarshalJWKS returns the key set document exactly as the Jkws handler
+// serves it, e.g. to write it to disk during a deploy
+func (c *Config) MarshalJWKS() ([]byte, error) {
+	body, _, err := c.servedJWKS()
+	if err != nil {
+		return nil, err
+	}
+	// the served bytes are shared by the requests, the caller gets its own
+	return append([]byte{}, body...), nil
+}
+
+// PublicJWKS returns the key set served by the Jkws handler, parsed from the
+// very document it serves
+func (c *Config) PublicJWKS() (jwk.Set, error) {
+	body, _, err := c.servedJWKS()
+	if err != nil {
+		return nil, err
+	}
+	set, err := jwk.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the served key set %v", err)
+	}
+	return set, nil
+}
+
+// Key set document, RFC 7517 section 5
+type jwksBody struct {
+	Keys []JkwsResponse `json:"keys"`
+}
+
+// Serialized key set and its entity tag, built once per change of the
+// published keys rather than on every request
+func (c *Config) servedJWKS() ([]byte, string, error) {
+	if c.keys == nil {
+		body, err := c.jwksDocument()
+		if err != nil {
+			return nil, "", err
+		}
+		return body, jwksETag(body), nil
+	}
+
+	now := c.keys.now()
+	c.pruneRetiredKeys(now)
+	c.checkKeyExpiry(now)
+	c.keys.mu.RLock()
+	body, etag, generation, active := c.keys.document, c.keys.etag, c.keys.generation, c.keys.active
+	c.keys.mu.RUnlock()
+	// the document may have been cached before the key expired, and
+	// checkKeyExpiry drops it only once, maybe after this request
+	if c.keyExpired(active, now) {
+		return nil, "", fmt.Errorf("%w, the key expired", ErrNoServableKey)
+	}
+	if body != nil {
+		return body, etag, nil
+	}
+
+	// concurrent misses wait for a single serialization
+	body, err := c.jwksFlight.do(generation, c.jwksDocument)
+	if err != nil {
+		return nil, "", err
+	}
+	etag = jwksETag(body)
+
+	// a change while serializing makes this document stale, do not keep it
+	c.keys.mu.Lock()
+	if c.keys.generation == generation {
+		c.keys.document = body
+		c.keys.etag = etag
+	}
+	c.keys.mu.Unlock()
+	return body, etag, nil
+}
+
+// Strong entity tag of a serialized key set
+func jwksETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + EncodeToString(sum[:]) + `"`
+}
+
+// Drop the serialized key set, update the key count and tell the publisher
+// the published keys changed
+func (c *Config) keySetChanged() {
+	if c.keys != nil {
+		c.keys.mu.Lock()
+		c.keys.document = nil
+		c.keys.etag = ""
+		c.keys.generation++
+		c.keys.mu.Unlock()
+	}
+	if len(c.instruments) > 0 {
+		count := c.publishedKeyCount()
+		c.observe(func(i instrumentation) {
+			i.keysPublished(count)
+		})
+	}
+	if c.publisher == nil {
+		return
+	}
+	select {
+	case c.publisher.changed <- struct{}{}:
+	default:
+		// a publication is already pending, it will read the latest keys
+	}
+}
+
+// Publish the key set now and on each change until Close
+func (c *Config) startPublisher() {
+	c.keySetChanged()
+	c.background.goRun(func(done <-chan struct{}) {
+		ctx, cancel := c.background.context()
+		defer cancel()
+
+		var retry time.Duration
+		var retryC <-chan time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case <-c.publisher.changed:
+				// a new change restarts the backoff
+				retry = 0
+			case <-retryC:
+			}
+			retryC = nil
+
+			if err := c.publishKeySet(ctx); err != nil {
+				c.reportError(err)
+				if retry == 0 {
+					retry = publishRetryDelay
+				} else if retry *= 2; retry > maxPublishRetry {
+					retry = maxPublishRetry
+				}
+				retryC = time.After(retry)
+				continue
+			}
+			retry = 0
+		}
+	})
+}
+
+func (c *Config) publishKeySet(ctx context.Context) error {
+	body, etag, err := c.servedJWKS()
+	if err != nil {
+		return fmt.Errorf("cannot serialize the key set to publish %v", err)
+	}
+	if err = c.publisher.publisher.Publish(ctx, body, etag); err != nil {
+		return fmt.Errorf("cannot publish the key set %v", err)
+	}
+	return nil
+}
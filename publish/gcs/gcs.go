@@ -0,0 +1,152 @@
+// Package gcs publishes the key set to a Google Cloud Storage bucket through the
+// XML API. Authentication is left to the http.Client, e.g. one returned by
+// golang.org/x/oauth2/google.DefaultClient, the storage SDK is not needed.
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// the key set handler default, set ContentType to JWKSetContentType
+	// along with WithJWKSetContentType
+	DefaultContentType  = "application/json"
+	JWKSetContentType   = "application/jwk-set+json"
+	DefaultCacheControl = "public, max-age=300"
+	DefaultEndpoint     = "https://storage.googleapis.com"
+	// object metadata holding the entity tag of the published key set
+	etagMetadataHeader = "X-Goog-Meta-Jwks-Etag"
+)
+
+// Publisher writes the key set to Object in Bucket. The write is conditional on
+// the generation of the object it replaces, so two instances publishing at once
+// cannot overwrite each other with an older document: the loser fails and its
+// retry finds the key set already there.
+type Publisher struct {
+	Bucket string
+	Object string
+	// authorized client, with the devstorage.read_write scope at least
+	Client       *http.Client
+	Endpoint     string
+	ContentType  string
+	CacheControl string
+}
+
+// New returns a publisher writing to bucket/object with client
+func New(bucket, object string, client *http.Client) *Publisher {
+	return &Publisher{
+		Bucket:       bucket,
+		Object:       object,
+		Client:       client,
+		Endpoint:     DefaultEndpoint,
+		ContentType:  DefaultContentType,
+		CacheControl: DefaultCacheControl,
+	}
+}
+
+// Publish writes body unless the object already holds the key set tagged etag
+func (p *Publisher) Publish(ctx context.Context, body []byte, etag string) error {
+	current, err := p.head(ctx)
+	if err != nil {
+		return err
+	}
+	if current.generation != "" && current.jwksETag == etag {
+		return nil
+	}
+
+	req, err := p.newRequest(ctx, http.MethodPut, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", p.ContentType)
+	req.Header.Set("Cache-Control", p.CacheControl)
+	req.Header.Set(etagMetadataHeader, etag)
+	// generation 0 only matches a missing object
+	generation := current.generation
+	if generation == "" {
+		generation = "0"
+	}
+	req.Header.Set("X-Goog-If-Generation-Match", generation)
+
+	res, err := p.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot put gcs object %v", err)
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusPreconditionFailed:
+		return fmt.Errorf("gcs object changed while publishing")
+	case res.StatusCode/100 != 2:
+		return fmt.Errorf("cannot put gcs object: %s %s", res.Status, errorBody(res.Body))
+	}
+	return nil
+}
+
+type objectState struct {
+	// empty when the object does not exist
+	generation string
+	jwksETag   string
+}
+
+func (p *Publisher) head(ctx context.Context) (objectState, error) {
+	req, err := p.newRequest(ctx, http.MethodHead, nil)
+	if err != nil {
+		return objectState{}, err
+	}
+	res, err := p.client().Do(req)
+	if err != nil {
+		return objectState{}, fmt.Errorf("cannot read gcs object metadata %v", err)
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return objectState{}, nil
+	case res.StatusCode/100 != 2:
+		return objectState{}, fmt.Errorf("cannot read gcs object metadata: %s", res.Status)
+	}
+	return objectState{
+		generation: res.Header.Get("X-Goog-Generation"),
+		jwksETag:   res.Header.Get(etagMetadataHeader),
+	}, nil
+}
+
+func (p *Publisher) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
+	}
+	return p.Client
+}
+
+func (p *Publisher) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	target := strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(p.Bucket) + "/" + escapeObject(p.Object)
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create gcs request %v", err)
+	}
+	return req, nil
+}
+
+// Escape each segment of an object name, keeping the slashes
+func escapeObject(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Start of an error response, enough to hold the GCS error code
+func errorBody(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 512))
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,248 @@
+// Package s3 publishes the key set to an S3 (or S3 compatible) bucket. It signs
+// its requests itself, the AWS SDK is not needed.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// the key set handler default, set ContentType to JWKSetContentType
+	// along with WithJWKSetContentType
+	DefaultContentType  = "application/json"
+	JWKSetContentType   = "application/jwk-set+json"
+	DefaultCacheControl = "public, max-age=300"
+	// object metadata holding the entity tag of the published key set
+	etagMetadataHeader = "X-Amz-Meta-Jwks-Etag"
+)
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// set for temporary credentials only
+	SessionToken string
+}
+
+// Publisher writes the key set to Key in Bucket. The write is conditional on
+// the object it replaces, so two instances publishing at once cannot overwrite
+// each other with an older document: the loser fails and its retry finds the
+// key set already there.
+type Publisher struct {
+	Bucket      string
+	Key         string
+	Region      string
+	Credentials Credentials
+	// e.g. http://localhost:9000 for MinIO, path-style requests are used.
+	// Empty for AWS, virtual-hosted requests are used.
+	Endpoint     string
+	ContentType  string
+	CacheControl string
+	Client       *http.Client
+}
+
+// New returns a publisher writing to bucket/key on AWS
+func New(bucket, key, region string, creds Credentials) *Publisher {
+	return &Publisher{
+		Bucket:       bucket,
+		Key:          key,
+		Region:       region,
+		Credentials:  creds,
+		ContentType:  DefaultContentType,
+		CacheControl: DefaultCacheControl,
+		Client:       http.DefaultClient,
+	}
+}
+
+// Publish writes body unless the object already holds the key set tagged etag
+func (p *Publisher) Publish(ctx context.Context, body []byte, etag string) error {
+	current, err := p.head(ctx)
+	if err != nil {
+		return err
+	}
+	if current.exists && current.jwksETag == etag {
+		return nil
+	}
+
+	req, err := p.newRequest(ctx, http.MethodPut, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", p.ContentType)
+	req.Header.Set("Cache-Control", p.CacheControl)
+	req.Header.Set(etagMetadataHeader, etag)
+	if current.exists {
+		req.Header.Set("If-Match", current.objectETag)
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+	p.sign(req, body, time.Now())
+
+	res, err := p.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot put s3 object %v", err)
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusPreconditionFailed || res.StatusCode == http.StatusConflict:
+		return fmt.Errorf("s3 object changed while publishing")
+	case res.StatusCode/100 != 2:
+		return fmt.Errorf("cannot put s3 object: %s %s", res.Status, errorBody(res.Body))
+	}
+	return nil
+}
+
+type objectState struct {
+	exists     bool
+	objectETag string
+	jwksETag   string
+}
+
+func (p *Publisher) head(ctx context.Context) (objectState, error) {
+	req, err := p.newRequest(ctx, http.MethodHead, nil)
+	if err != nil {
+		return objectState{}, err
+	}
+	p.sign(req, nil, time.Now())
+
+	res, err := p.client().Do(req)
+	if err != nil {
+		return objectState{}, fmt.Errorf("cannot read s3 object metadata %v", err)
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return objectState{}, nil
+	case res.StatusCode/100 != 2:
+		return objectState{}, fmt.Errorf("cannot read s3 object metadata: %s", res.Status)
+	}
+	return objectState{
+		exists:     true,
+		objectETag: res.Header.Get("ETag"),
+		jwksETag:   res.Header.Get(etagMetadataHeader),
+	}, nil
+}
+
+func (p *Publisher) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
+	}
+	return p.Client
+}
+
+func (p *Publisher) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	// the escaped path is the one signed, RawPath keeps net/url from escaping it differently
+	u := &url.URL{
+		Scheme:  "https",
+		Host:    p.Bucket + ".s3." + p.Region + ".amazonaws.com",
+		Path:    "/" + p.Key,
+		RawPath: "/" + uriEncode(p.Key, true),
+	}
+	if p.Endpoint != "" {
+		endpoint, err := url.Parse(p.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 endpoint %v", err)
+		}
+		u.Scheme, u.Host = endpoint.Scheme, endpoint.Host
+		u.Path = "/" + p.Bucket + "/" + p.Key
+		u.RawPath = "/" + uriEncode(p.Bucket, false) + "/" + uriEncode(p.Key, true)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create s3 request %v", err)
+	}
+	return req, nil
+}
+
+// Sign req with AWS signature version 4
+func (p *Publisher) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if p.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.Credentials.SessionToken)
+	}
+
+	// every header set so far is signed, along with host
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + p.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+p.Credentials.SecretAccessKey), day)
+	key = hmacSHA256(key, p.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.Credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// URI encoding of SigV4, only the unreserved characters are left as is
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || ch == '/' && keepSlash {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Start of an error response, enough to hold the S3 error code
+func errorBody(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 512))
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,324 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Key decorator taking its time over each key set and counting them, failing
+// while fail is set. The decorator runs once for each document built.
+type slowDecorator struct {
+	delay time.Duration
+	calls int32
+	fail  int32
+}
+
+func (s *slowDecorator) decorate(_ string, entry map[string]interface{}) {
+	atomic.AddInt32(&s.calls, 1)
+	time.Sleep(s.delay)
+	if atomic.LoadInt32(&s.fail) == 1 {
+		// cannot be serialized, fails the key set
+		entry["unserializable"] = make(chan int)
+	}
+}
+
+func TestServedJWKSIsSerializedOncePerChange(t *testing.T) {
+	decorator := &slowDecorator{delay: 50 * time.Millisecond}
+	config := newTestConfig(t, NewConfigBuilder().WithKeyDecorator(decorator.decorate))
+
+	const requests = 32
+	bodies := make([][]byte, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, _, err := config.servedJWKS()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			bodies[i] = body
+		}(i)
+	}
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&decorator.calls); calls != 1 {
+		t.Fatalf("expected one serialization for %d concurrent misses, got %d", requests, calls)
+	}
+	for _, body := range bodies[1:] {
+		if !bytes.Equal(body, bodies[0]) {
+			t.Fatal("concurrent requests got different documents")
+		}
+	}
+	if _, _, err := config.servedJWKS(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&decorator.calls); calls != 1 {
+		t.Fatalf("the cached document was serialized again, %d serializations", calls)
+	}
+
+	// a change invalidates the document, the next miss rebuilds it once more
+	config.keySetChanged()
+	if _, _, err := config.servedJWKS(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&decorator.calls); calls != 2 {
+		t.Fatalf("expected a rebuild after the change, got %d serializations", calls)
+	}
+}
+
+func TestServedJWKSDoesNotCacheErrors(t *testing.T) {
+	decorator := &slowDecorator{fail: 1}
+	config := newTestConfig(t, NewConfigBuilder().WithKeyDecorator(decorator.decorate))
+
+	if _, _, err := config.servedJWKS(); err == nil {
+		t.Fatal("the serialization failure was not reported")
+	}
+	atomic.StoreInt32(&decorator.fail, 0)
+	body, etag, err := config.servedJWKS()
+	if err != nil {
+		t.Fatalf("the failure was cached: %v", err)
+	}
+	if len(body) == 0 || etag != jwksETag(body) {
+		t.Fatal("no document after a failed build")
+	}
+}
+
+// Publisher recording the documents it is given, failing the first fail calls
+type fakePublisher struct {
+	published chan []byte
+	fail      int32
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{published: make(chan []byte, 8)}
+}
+
+func (p *fakePublisher) Publish(_ context.Context, body []byte, etag string) error {
+	if atomic.AddInt32(&p.fail, -1) >= 0 {
+		return errors.New("bucket unavailable")
+	}
+	if etag != jwksETag(body) {
+		return errors.New("etag does not match the body")
+	}
+	p.published <- body
+	return nil
+}
+
+// Wait for the next publication and return the sorted kids it holds
+func (p *fakePublisher) next(t *testing.T) []string {
+	t.Helper()
+	select {
+	case body := <-p.published:
+		var document struct {
+			Keys []struct {
+				Kid string `json:"kid"`
+			} `json:"keys"`
+		}
+		if err := json.Unmarshal(body, &document); err != nil {
+			t.Fatal(err)
+		}
+		var kids []string
+		for _, key := range document.Keys {
+			kids = append(kids, key.Kid)
+		}
+		sort.Strings(kids)
+		return kids
+	case <-time.After(5 * time.Second):
+		t.Fatal("the key set was not published")
+		return nil
+	}
+}
+
+func TestPublisherFiresOnEveryChange(t *testing.T) {
+	publisher := newFakePublisher()
+	config := newTestConfig(t, NewConfigBuilder().WithPublisher(publisher).WithPublishedKidAliases())
+
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"test"}) {
+		t.Fatalf("built key set published with %v", kids)
+	}
+	if err := config.AliasKid("alias", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"alias", "test"}) {
+		t.Fatalf("key set with an added alias published with %v", kids)
+	}
+	if err := config.RemoveKidAlias("alias"); err != nil {
+		t.Fatal(err)
+	}
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"test"}) {
+		t.Fatalf("key set without the alias published with %v", kids)
+	}
+	if err := config.Rotate("rotated"); err != nil {
+		t.Fatal(err)
+	}
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"rotated"}) {
+		t.Fatalf("rotated key set published with %v", kids)
+	}
+}
+
+func TestPublisherFiresOnKeyRemoval(t *testing.T) {
+	publisher := newFakePublisher()
+	config := newTestConfig(t, NewConfigBuilder().WithPublisher(publisher).WithAdditionalKey(newAdditionalKey(t, "next")))
+
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"next", "test"}) {
+		t.Fatalf("built key set published with %v", kids)
+	}
+	if err := config.RemoveKey("next", false); err != nil {
+		t.Fatal(err)
+	}
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"test"}) {
+		t.Fatalf("key set without the removed key published with %v", kids)
+	}
+}
+
+func TestPublisherRetriesAndReportsFailures(t *testing.T) {
+	publisher := newFakePublisher()
+	publisher.fail = 1
+	errs := make(chan error, 4)
+	newTestConfig(t, NewConfigBuilder().WithPublisher(publisher).OnError(func(err error) { errs <- err }))
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("nil error reported")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the failed publication was not reported")
+	}
+	// published again after publishRetryDelay
+	if kids := publisher.next(t); !reflect.DeepEqual(kids, []string{"test"}) {
+		t.Fatalf("retried key set published with %v", kids)
+	}
+}
+
+func TestKeySetIsCanonical(t *testing.T) {
+	signing := fstest.MapFS{"key.pem": {Data: pemKey(t, newRSAKey(t))}}
+	additional := []jwk.Key{newAdditionalKey(t, "zulu"), newAdditionalKey(t, "alpha"), newAdditionalKey(t, "mike")}
+	build := func(keys ...jwk.Key) *Config {
+		builder := NewConfigBuilder()
+		for _, key := range keys {
+			builder.WithAdditionalKey(key)
+		}
+		config, err := builder.ImportPrivateKey().WithFS(signing, "key.pem").WithKeyId("test").Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			config.Close()
+		})
+		return config
+	}
+
+	config := build(additional...)
+	body := serveJWKS(Jkws(*config)).Body.Bytes()
+	if kids := servedKids(t, config); !reflect.DeepEqual(kids, []string{"alpha", "mike", "test", "zulu"}) {
+		t.Fatalf("key set holds %v", kids)
+	}
+	var document struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, key := range document.Keys {
+		order = append(order, key.Kid)
+	}
+	if !sort.StringsAreSorted(order) {
+		t.Fatalf("keys are served in the order %v", order)
+	}
+
+	// the same keys added in another order give the same bytes
+	reordered := build(additional[2], additional[0], additional[1])
+	if other := serveJWKS(Jkws(*reordered)).Body.Bytes(); !bytes.Equal(body, other) {
+		t.Fatalf("the same keys serialized differently\n%s\n%s", body, other)
+	}
+
+	keys, err := config.jwksKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := make([]JkwsResponse, len(keys))
+	for i, key := range keys {
+		reversed[len(keys)-1-i] = key
+	}
+	fromReversed, err := config.marshalJWKS(reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, fromReversed) {
+		t.Fatalf("reversed keys serialized differently\n%s\n%s", body, fromReversed)
+	}
+	if reversed[0].KeyIDKey != keys[len(keys)-1].KeyIDKey {
+		t.Fatal("marshalJWKS reordered the keys of its caller")
+	}
+}
+
+func TestServedJWKSWithholdsAnExpiredKey(t *testing.T) {
+	clock := newFakeClock()
+	notAfter := clock.Now().Add(time.Hour)
+	type expiry struct {
+		kid      string
+		notAfter time.Time
+	}
+	var expired []expiry
+	builder := NewConfigBuilder().
+		WithExpiredKeyPruning().
+		WithPublishKeyLifecycle().
+		OnKeyExpired(func(kid string, notAfter time.Time) {
+			expired = append(expired, expiry{kid, notAfter})
+		})
+	builder.config.keys.now = clock.Now
+	config, err := builder.ImportPrivateKey().WithRawKey(newECKey(t)).WithKeyId("expiring").WithNotAfter(notAfter).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	body, _, err := config.servedJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Exp int64  `json:"exp"`
+		} `json:"keys"`
+	}
+	if err = json.Unmarshal(body, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Keys) != 1 || document.Keys[0].Exp != notAfter.Unix() {
+		t.Fatalf("key set %s, expected exp %d", body, notAfter.Unix())
+	}
+	if len(expired) != 0 {
+		t.Fatalf("OnKeyExpired fired before the end of validity: %v", expired)
+	}
+
+	// the cached document is withheld on every request past the end of
+	// validity, the hook fires once whatever checks the key
+	clock.Advance(2 * time.Hour)
+	for i := 0; i < 2; i++ {
+		if _, _, err = config.servedJWKS(); !errors.Is(err, ErrNoServableKey) {
+			t.Fatalf("the cached key set was served past the end of validity of its key: %v", err)
+		}
+	}
+	config.checkKeyExpiry(clock.Now())
+	if len(expired) != 1 || expired[0].kid != "expiring" || !expired[0].notAfter.Equal(notAfter) {
+		t.Fatalf("OnKeyExpired fired with %v, expected once for expiring at %s", expired, notAfter)
+	}
+}
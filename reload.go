@@ -0,0 +1,148 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"os"
+	"time"
+)
+
+// KeyReloadEvent reports a check of the imported key file which found it changed
+type KeyReloadEvent struct {
+	Path string
+	// kid served once the event is over, the previous one when the reload failed
+	KeyID string
+	// why the file was not loaded, the previous key then stays in place
+	Err error
+}
+
+// Check the key file every interval and load it in place of the key when its
+// content changed. A file which cannot be read or holds an invalid key is
+// reported and the previous key is kept. Another key never takes the kid of
+// the previous one or the WithKeyId one, it is published under its thumbprint
+// and the previous key is retired as on a rotation. The checks stop with Close.
+func (n *ConfigImportKeyBuilder) WithReloadInterval(interval time.Duration) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.reloadInterval = interval
+	return n
+}
+
+// Call hook after each reload of the key file, successful or not
+func (n *ConfigImportKeyBuilder) WithReloadHook(hook func(KeyReloadEvent)) *ConfigImportKeyBuilder {
+	n.initiateImportOptsIfNil()
+	n.config.importPkOpts.reloadHook = hook
+	return n
+}
+
+func checkReloadOptions(opts ImportKeyOptions) error {
+	if opts.reloadInterval < 0 {
+		return fmt.Errorf("reload interval cannot be negative")
+	}
+	if opts.reloadInterval == 0 {
+		if opts.reloadHook != nil {
+			return fmt.Errorf("a reload hook needs a reload interval")
+		}
+		return nil
+	}
+	if opts.privateKeyPemPath == "" || opts.jks != nil || opts.wrapped != nil {
+		return fmt.Errorf("only a key imported from a path can be reloaded")
+	}
+	return nil
+}
+
+// State of the key file as of the last check
+type keyFileState struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// Watch the key file and swap the key when its content changes
+func (c *Config) startKeyReload(opts ImportKeyOptions) {
+	path := opts.privateKeyPemPath
+	// the file Build read, a change made meanwhile is only seen on the next one
+	last, data, err := readKeyFileState(path, opts.maxInputSize)
+	wipe(data)
+	if err != nil {
+		c.reportError(err)
+	}
+
+	c.background.goWithTicker(opts.reloadInterval, func(time.Time) {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().Equal(last.modTime) && info.Size() == last.size {
+			return
+		}
+
+		state, data, err := readKeyFileState(path, opts.maxInputSize)
+		if err == nil {
+			// a touched file with the same key is not a reload
+			if state.sum == last.sum {
+				last = state
+				wipe(data)
+				return
+			}
+			last = state
+			err = c.reloadKey(opts, data)
+			wipe(data)
+		}
+
+		event := KeyReloadEvent{Path: path, Err: err}
+		if active := c.active(); active != nil {
+			event.KeyID = active.key.KeyID()
+		}
+		if err != nil {
+			c.reportError(fmt.Errorf("cannot reload private key %v", err))
+		}
+		if opts.reloadHook != nil {
+			c.runHook("ReloadHook", func() {
+				opts.reloadHook(event)
+			})
+		}
+	})
+}
+
+func readKeyFileState(path string, maxInputSize int64) (keyFileState, []byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return keyFileState{}, nil, fmt.Errorf("cannot read private key %v", err)
+	}
+	data, err := readLimitedFile(path, inputSizeLimit(maxInputSize), "private key")
+	if err != nil {
+		return keyFileState{}, nil, err
+	}
+	return keyFileState{modTime: info.ModTime(), size: info.Size(), sum: sha256.Sum256(data)}, data, nil
+}
+
+// Import data the way Build imported the file and make it the signing key.
+// The rotating lock is held throughout, as for Rotate, so a reload and a
+// rotation cannot both install and persist their key.
+func (c *Config) reloadKey(opts ImportKeyOptions, data []byte) error {
+	c.keys.rotating.Lock()
+	defer c.keys.rotating.Unlock()
+	// nil once the signing key was removed, the file then brings it back
+	current := c.active()
+
+	opts.privateKeyPemPath = ""
+	opts.pemBytes = data
+	key, multiPrimeKey, err := importPrivateKey(opts)
+	if err != nil {
+		return err
+	}
+	if multiPrimeKey != nil {
+		return fmt.Errorf("a multi-prime private key cannot be reloaded")
+	}
+	if opts.certificatePath != "" {
+		if err = attachKeyCertificates(key, opts.certificatePath, opts.maxInputSize, c.reportError); err != nil {
+			return fmt.Errorf("cannot import certificate %v", err)
+		}
+	}
+	if opts.overrideMetadata {
+		for _, name := range []string{jwk.AlgorithmKey, jwk.KeyUsageKey} {
+			if err = key.Remove(name); err != nil {
+				return fmt.Errorf("cannot remove the %s property of the private key %v", name, err)
+			}
+		}
+	}
+	return c.replaceKey(key, nil, opts.keyId, current, AuditKeyReloaded, AuditTriggerFileChange)
+}
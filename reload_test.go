@@ -0,0 +1,144 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write key over the file at path, with a modification time the reload notices
+func replaceKeyFile(t *testing.T, path string, key interface{}) {
+	t.Helper()
+	if err := os.WriteFile(path, pemKey(t, key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadHookCannotCrashTheServer(t *testing.T) {
+	path := writeTestKey(t, newECKey(t))
+	errs := make(chan error, 10)
+	config, err := NewConfigBuilder().
+		OnError(func(err error) { errs <- err }).
+		ImportPrivateKey().
+		WithPath(path).
+		WithReloadInterval(10 * time.Millisecond).
+		WithReloadHook(func(KeyReloadEvent) { panic("hook failed") }).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	previous := config.active().key.KeyID()
+
+	replaceKeyFile(t, path, newECKey(t))
+	select {
+	case err = <-errs:
+		if !strings.Contains(err.Error(), "panicked") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the panicking hook was not reported")
+	}
+	if config.active().key.KeyID() == previous {
+		t.Fatal("the key was not reloaded")
+	}
+}
+
+func TestReloadWaitsForARotation(t *testing.T) {
+	path := writeTestKey(t, newECKey(t))
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	next := pemKey(t, newECKey(t))
+
+	// a rotation in progress
+	config.keys.rotating.Lock()
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- config.reloadKey(*config.importPkOpts, next)
+	}()
+	select {
+	case err = <-reloaded:
+		t.Fatalf("the reload did not wait for the rotation: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	config.keys.rotating.Unlock()
+	if err = <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadUnderAFixedKidRetiresThePreviousKey(t *testing.T) {
+	first := newECKey(t)
+	config, err := NewConfigBuilder().
+		WithRotationGrace(time.Hour).
+		ImportPrivateKey().
+		WithPath(writeTestKey(t, first)).
+		WithKeyId("my-id").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	reload := func(data []byte) {
+		t.Helper()
+		if err := config.reloadKey(*config.importPkOpts, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	thumbprint := func(raw interface{}) string {
+		t.Helper()
+		key, err := jwk.FromRaw(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kid, err := thumbprintKeyId(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kid
+	}
+
+	// the same key encoded another way keeps its kid
+	sec1, err := x509.MarshalECPrivateKey(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reload(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+	if kids := servedKids(t, config); !reflect.DeepEqual(kids, []string{"my-id"}) {
+		t.Fatalf("served kids %v after reloading the same key", kids)
+	}
+
+	second := newECKey(t)
+	reload(pemKey(t, second))
+	next := thumbprint(second)
+	if kid := config.active().key.KeyID(); kid != next {
+		t.Fatalf("another key reloaded under kid %q, expected its thumbprint %q", kid, next)
+	}
+	set := servedKeySet(t, config)
+	retired, ok := set.LookupKeyID("my-id")
+	if !ok || set.Len() != 2 {
+		t.Fatalf("served %d keys, the previous key is not retired", set.Len())
+	}
+	if kid, _ := thumbprintKeyId(retired); kid != thumbprint(first) {
+		t.Fatal("kid my-id serves another key than the one it named")
+	}
+
+	// the first key coming back does not take the fixed kid again
+	reload(pemKey(t, first))
+	if kid := config.active().key.KeyID(); kid != thumbprint(first) {
+		t.Fatalf("key reloaded again under kid %q", kid)
+	}
+}
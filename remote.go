@@ -0,0 +1,151 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/v4lproik/gin-jwks-rsa/presets"
+	"net/http"
+	"time"
+)
+
+// Refresh interval of a remote key set when none is configured
+const DefaultRemoteRefreshInterval = 15 * time.Minute
+
+type RemoteOption func(*remoteOptions)
+
+type remoteOptions struct {
+	ctx             context.Context
+	refreshInterval time.Duration
+	client          *http.Client
+	verifierOpts    []VerifierOption
+	onError         func(error)
+}
+
+// Fetch the remote key set every interval, DefaultRemoteRefreshInterval if not
+// set. It is fetched sooner when the response asks for it with Cache-Control.
+func WithRefreshInterval(interval time.Duration) RemoteOption {
+	return func(o *remoteOptions) {
+		o.refreshInterval = interval
+	}
+}
+
+// Fetch the remote key set with client, http.DefaultClient otherwise
+func WithRemoteHTTPClient(client *http.Client) RemoteOption {
+	return func(o *remoteOptions) {
+		o.client = client
+	}
+}
+
+// Stop refreshing the remote key set once ctx ends, e.g. at shutdown or at the
+// end of a test. It is refreshed for the life of the program otherwise.
+func WithRemoteContext(ctx context.Context) RemoteOption {
+	return func(o *remoteOptions) {
+		o.ctx = ctx
+	}
+}
+
+// Check the claims of the tokens with opts, as Config.Verifier does
+func WithRemoteVerifierOptions(opts ...VerifierOption) RemoteOption {
+	return func(o *remoteOptions) {
+		o.verifierOpts = append(o.verifierOpts, opts...)
+	}
+}
+
+// Report the failed refreshes, the last fetched key set keeps being used
+func WithRemoteErrorHook(hook func(error)) RemoteOption {
+	return func(o *remoteOptions) {
+		o.onError = hook
+	}
+}
+
+// Accept the tokens of an identity provider: its issuers, audiences and
+// authorized parties, and read their Principal with its claim mapping. The key
+// set URL of the preset is used by VerifyPreset.
+func WithPreset(preset presets.Preset) RemoteOption {
+	return func(o *remoteOptions) {
+		o.verifierOpts = append(o.verifierOpts, WithClaimMapping(preset.Claims))
+		if len(preset.Issuers) > 0 {
+			o.verifierOpts = append(o.verifierOpts, WithExpectedIssuer(preset.Issuers...))
+		}
+		if len(preset.Audiences) > 0 {
+			o.verifierOpts = append(o.verifierOpts, WithExpectedAudience(preset.Audiences...))
+		}
+		if len(preset.AuthorizedParties) > 0 {
+			o.verifierOpts = append(o.verifierOpts, WithAuthorizedParties(preset.AuthorizedParties...))
+		}
+	}
+}
+
+// ErrSink of jwk.Cache
+type errSinkFunc func(error)
+
+func (f errSinkFunc) Error(err error) {
+	f(err)
+}
+
+// NewRemoteVerifier returns a token verifier using the key set published at
+// jwksURL by another issuer. The set is fetched on first use and refreshed in
+// the background; while the remote cannot be reached the last fetched set is
+// used.
+func NewRemoteVerifier(jwksURL string, opts ...RemoteOption) (*Verifier, error) {
+	o := remoteOptions{
+		ctx:             context.Background(),
+		refreshInterval: DefaultRemoteRefreshInterval,
+		client:          http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.refreshInterval <= 0 {
+		return nil, fmt.Errorf("refresh interval must be positive")
+	}
+
+	var cacheOpts []jwk.CacheOption
+	if o.onError != nil {
+		cacheOpts = append(cacheOpts, jwk.WithErrSink(errSinkFunc(o.onError)))
+	}
+	cache := jwk.NewCache(o.ctx, cacheOpts...)
+	err := cache.Register(jwksURL,
+		jwk.WithHTTPClient(o.client),
+		jwk.WithRefreshInterval(o.refreshInterval),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register key set %s %v", jwksURL, err)
+	}
+
+	v := &Verifier{
+		keySet: func(ctx context.Context) (jwk.Set, error) {
+			set, err := cache.Get(ctx, jwksURL)
+			if err != nil {
+				return nil, fmt.Errorf("cannot fetch key set %s %v", jwksURL, err)
+			}
+			return set, nil
+		},
+		limits: DefaultTokenLimits,
+	}
+	for _, opt := range o.verifierOpts {
+		opt(v)
+	}
+	return v, nil
+}
+
+// VerifyRemote middleware accepting requests with a Bearer token signed by a
+// key of the set published at jwksURL, see Verify and NewRemoteVerifier. A
+// jwksURL which cannot be used makes every request fail with a 500.
+func VerifyRemote(jwksURL string, opts ...RemoteOption) gin.HandlerFunc {
+	verifier, err := NewRemoteVerifier(jwksURL, opts...)
+	if err != nil {
+		return func(c *gin.Context) {
+			c.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+	}
+	return verifyBearer(verifier, nil)
+}
+
+// VerifyPreset middleware accepting the tokens of an identity provider
+func VerifyPreset(preset presets.Preset, opts ...RemoteOption) gin.HandlerFunc {
+	return VerifyRemote(preset.JWKSURL, append([]RemoteOption{WithPreset(preset)}, opts...)...)
+}
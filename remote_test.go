@@ -0,0 +1,162 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/v4lproik/gin-jwks-rsa/presets"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Token of a provider with the key set verifying it, in the shapes the
+// provider issues them, and the Principal its preset reads
+type presetFixture struct {
+	Args              []string `json:"args"`
+	AuthorizedParties []string `json:"authorized_parties"`
+	Claims            struct {
+		IssuedAt int64 `json:"iat"`
+	} `json:"claims"`
+	Subject string          `json:"subject"`
+	Scopes  []string        `json:"scopes"`
+	Roles   []string        `json:"roles"`
+	Token   string          `json:"token"`
+	JWKS    json.RawMessage `json:"jwks"`
+}
+
+func fixturePreset(t *testing.T, name string, args []string) presets.Preset {
+	t.Helper()
+	switch {
+	case name == "auth0":
+		return presets.Auth0(args[0], args[1])
+	case strings.HasPrefix(name, "azuread"):
+		return presets.AzureAD(args[0], args[1])
+	case name == "keycloak":
+		return presets.Keycloak(args[0], args[1])
+	case name == "google":
+		return presets.Google(args[0])
+	case name == "okta":
+		return presets.Okta(args[0], args[1], args[2])
+	}
+	t.Fatalf("no preset for fixture %s", name)
+	return presets.Preset{}
+}
+
+func TestPresetsVerifyRecordedTokens(t *testing.T) {
+	paths, err := filepath.Glob("presets/testdata/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures: %v", err)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f presetFixture
+			if err = json.Unmarshal(raw, &f); err != nil {
+				t.Fatal(err)
+			}
+			preset := fixturePreset(t, name, f.Args)
+			if f.AuthorizedParties != nil {
+				preset.AuthorizedParties = f.AuthorizedParties
+			}
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(f.JWKS)
+			}))
+			defer provider.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			verifier, err := NewRemoteVerifier(provider.URL, WithPreset(preset), WithRemoteContext(ctx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the recorded token has long expired
+			if _, err = verifier.Verify(context.Background(), f.Token); err == nil {
+				t.Fatal("expired token verified")
+			}
+			verifier.clock = jwt.ClockFunc(func() time.Time {
+				return time.Unix(f.Claims.IssuedAt, 0).Add(time.Minute)
+			})
+			token, err := verifier.Verify(context.Background(), f.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			principal := verifier.Principal(token)
+			if principal.Subject != f.Subject {
+				t.Fatalf("subject %q, want %q", principal.Subject, f.Subject)
+			}
+			if strings.Join(principal.Scopes, " ") != strings.Join(f.Scopes, " ") {
+				t.Fatalf("scopes %q, want %q", principal.Scopes, f.Scopes)
+			}
+			if strings.Join(principal.Roles, " ") != strings.Join(f.Roles, " ") {
+				t.Fatalf("roles %q, want %q", principal.Roles, f.Roles)
+			}
+
+			// the same token is refused by the preset of another tenant
+			other := fixturePreset(t, name, f.Args)
+			other.Issuers = []string{"https://issuer.example.com"}
+			otherVerifier, err := NewRemoteVerifier(provider.URL, WithPreset(other), WithRemoteContext(ctx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			otherVerifier.clock = verifier.clock
+			if _, err = otherVerifier.Verify(context.Background(), f.Token); err == nil {
+				t.Fatal("token of another issuer verified")
+			}
+		})
+	}
+}
+
+func TestVerifyStoresThePrincipal(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	token := signTestToken(t, config, map[string]interface{}{
+		"sub":          "me",
+		"oid":          "object-id",
+		"scp":          []string{"orders.read", "orders.write"},
+		"realm_access": map[string]interface{}{"roles": []string{"customer"}},
+		"exp":          time.Now().Add(time.Hour),
+	})
+
+	for name, tt := range map[string]struct {
+		opts []VerifierOption
+		want Principal
+	}{
+		"default mapping": {
+			want: Principal{Subject: "me"},
+		},
+		"preset mapping": {
+			opts: []VerifierOption{WithClaimMapping(presets.ClaimMapping{Subject: "oid", Scopes: "scp", Roles: "realm_access.roles"})},
+			want: Principal{Subject: "object-id", Scopes: []string{"orders.read", "orders.write"}, Roles: []string{"customer"}},
+		},
+	} {
+		var got Principal
+		found := false
+		r := gin.New()
+		r.GET("/me", Verify(*config, tt.opts...), func(c *gin.Context) {
+			got, found = PrincipalFromContext(c)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !found {
+			t.Fatalf("%s: answered %d, principal found %v", name, w.Code, found)
+		}
+		if got.Subject != tt.want.Subject ||
+			strings.Join(got.Scopes, " ") != strings.Join(tt.want.Scopes, " ") ||
+			strings.Join(got.Roles, " ") != strings.Join(tt.want.Roles, " ") {
+			t.Fatalf("%s: principal %+v, want %+v", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers the jti of the tokens already accepted so a captured
+// proof cannot be used twice within its freshness window. VerifyDPoPProof and
+// VerifyClientAssertion consult it.
+//
+// Seen atomically records jti until exp and reports whether it was already
+// recorded. Callers must treat an error as a rejection (fail closed).
+// A distributed implementation maps naturally to Redis:
+// SET <jti> 1 NX PXAT <exp in ms>, where a nil reply means the jti was seen.
+type ReplayCache interface {
+	Seen(ctx context.Context, jti string, exp time.Time) (bool, error)
+}
+
+const replayCacheShards = 16
+
+// MemoryReplayCache is a sharded in-memory ReplayCache bounded in size
+type MemoryReplayCache struct {
+	shards      [replayCacheShards]replayCacheShard
+	maxPerShard int
+	now         func() time.Time
+}
+
+type replayCacheShard struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// Create an in-memory replay cache holding at most maxEntries live jti
+func NewMemoryReplayCache(maxEntries int) *MemoryReplayCache {
+	maxPerShard := maxEntries / replayCacheShards
+	if maxPerShard < 1 {
+		maxPerShard = 1
+	}
+
+	c := &MemoryReplayCache{maxPerShard: maxPerShard, now: time.Now}
+	for i := range c.shards {
+		c.shards[i].entries = map[string]time.Time{}
+	}
+
+	return c
+}
+
+func (c *MemoryReplayCache) Seen(ctx context.Context, jti string, exp time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if jti == "" {
+		return false, fmt.Errorf("jti cannot be empty")
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(jti))
+	shard := &c.shards[h.Sum32()%replayCacheShards]
+	now := c.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if seenExp, ok := shard.entries[jti]; ok && now.Before(seenExp) {
+		return true, nil
+	}
+
+	// make room by dropping the expired entries, never the live ones
+	if len(shard.entries) >= c.maxPerShard {
+		for k, e := range shard.entries {
+			if !now.Before(e) {
+				delete(shard.entries, k)
+			}
+		}
+		if len(shard.entries) >= c.maxPerShard {
+			return false, fmt.Errorf("replay cache is full")
+		}
+	}
+	shard.entries[jti] = exp
+
+	return false, nil
+}
@@ -0,0 +1,163 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+)
+
+// gin context key holding the validated request object
+const RequestObjectContextKey = "gin-jwks-request-object"
+
+// OAuth parameters carried by an RFC 9101 request object
+type RequestObjectClaims struct {
+	ClientID            string
+	ResponseType        string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// every claim of the request object, including the ones above
+	Claims map[string]interface{}
+}
+
+// VerifyRequestObject validates a signed RFC 9101 request object against the
+// client key set: alg cannot be none, iss must be the client id, aud must
+// include expectedAudience (our issuer) and exp is required
+func VerifyRequestObject(token string, clientJWKS jwk.Set, expectedClientID, expectedAudience string) (RequestObjectClaims, error) {
+	if expectedClientID == "" || expectedAudience == "" {
+		return RequestObjectClaims{}, fmt.Errorf("expected client id and audience cannot be empty")
+	}
+
+	if err := checkTokenLimits(token, DefaultTokenLimits); err != nil {
+		return RequestObjectClaims{}, err
+	}
+	if err := checkKeyURLHeaders(token, KeyURLHeadersReject); err != nil {
+		return RequestObjectClaims{}, err
+	}
+
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		return RequestObjectClaims{}, fmt.Errorf("cannot parse request object %v", err)
+	}
+	for _, sig := range msg.Signatures() {
+		if sig.ProtectedHeaders().Algorithm() == jwa.NoSignature {
+			return RequestObjectClaims{}, fmt.Errorf("request object cannot be unsigned")
+		}
+	}
+
+	parsed, err := jwt.Parse([]byte(token),
+		jwt.WithKeySet(clientJWKS, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(expectedClientID),
+		jwt.WithAudience(expectedAudience),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+	)
+	if err != nil {
+		return RequestObjectClaims{}, fmt.Errorf("invalid request object %v", err)
+	}
+
+	claims, err := parsed.AsMap(context.Background())
+	if err != nil {
+		return RequestObjectClaims{}, fmt.Errorf("cannot read request object claims %v", err)
+	}
+	str := func(name string) string {
+		v, _ := claims[name].(string)
+		return v
+	}
+
+	res := RequestObjectClaims{
+		ClientID:            str("client_id"),
+		ResponseType:        str("response_type"),
+		RedirectURI:         str("redirect_uri"),
+		Scope:               str("scope"),
+		State:               str("state"),
+		Nonce:               str("nonce"),
+		CodeChallenge:       str("code_challenge"),
+		CodeChallengeMethod: str("code_challenge_method"),
+		Claims:              claims,
+	}
+	if res.ClientID != "" && res.ClientID != expectedClientID {
+		return RequestObjectClaims{}, fmt.Errorf("request object client_id %q does not match %q", res.ClientID, expectedClientID)
+	}
+	res.ClientID = expectedClientID
+
+	return res, nil
+}
+
+// Everything needed to validate the request objects of the clients
+type RequestObjectOptions struct {
+	// our issuer, the request objects must be addressed to it
+	Issuer   string
+	Resolver ClientKeyResolver
+	// key sets fetched from the JWKSURL of the clients, RequireRequestObject
+	// creates one if not set
+	KeySetCache *ClientKeySetCache
+}
+
+// RequireRequestObject middleware validating the request object passed in the
+// request query parameter, the client keys are looked up from the client_id
+// query parameter. The claims are stored under RequestObjectContextKey. The
+// error bodies are encoded with the codec of config and never hold the
+// validation error, which goes to c.Error.
+func RequireRequestObject(config Config, opts RequestObjectOptions) gin.HandlerFunc {
+	if opts.KeySetCache == nil {
+		opts.KeySetCache = NewClientKeySetCache(0)
+	}
+	return func(c *gin.Context) {
+		clientID := c.Query("client_id")
+		requestObject := c.Query("request")
+		if clientID == "" || requestObject == "" {
+			config.abortWithJSON(c, http.StatusBadRequest, &OAuthError{
+				Code:        OAuthErrorInvalidRequest,
+				Description: "missing client_id or request",
+			})
+			return
+		}
+
+		keys, err := opts.Resolver.ResolveClientKeys(c.Request.Context(), clientID)
+		if err == nil && keys.Set == nil && keys.JWKSURL != "" {
+			keys.Set, err = opts.KeySetCache.fetch(c.Request.Context(), keys.JWKSURL)
+		}
+		if err != nil || keys.Set == nil {
+			if err != nil {
+				c.Error(err)
+			}
+			config.abortWithJSON(c, http.StatusBadRequest, &OAuthError{
+				Code:        OAuthErrorInvalidRequestObject,
+				Description: "cannot resolve client keys",
+			})
+			return
+		}
+
+		claims, err := VerifyRequestObject(requestObject, keys.Set, clientID, opts.Issuer)
+		if err != nil {
+			c.Error(err)
+			config.abortWithJSON(c, http.StatusBadRequest, &OAuthError{
+				Code:        OAuthErrorInvalidRequestObject,
+				Description: "invalid request object",
+			})
+			return
+		}
+
+		c.Set(RequestObjectContextKey, claims)
+		c.Next()
+	}
+}
+
+// RequestObjectFromContext returns the request object validated by RequireRequestObject
+func RequestObjectFromContext(c *gin.Context) (RequestObjectClaims, bool) {
+	v, ok := c.Get(RequestObjectContextKey)
+	if !ok {
+		return RequestObjectClaims{}, false
+	}
+	claims, ok := v.(RequestObjectClaims)
+	return claims, ok
+}
@@ -0,0 +1,126 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRequestObjectIssuer = "https://auth.example.com"
+
+// Request object of clientID naming claimedClientID in its client_id claim
+func requestObject(t *testing.T, key *ecdsa.PrivateKey, clientID, claimedClientID string) string {
+	t.Helper()
+	private, err := jwk.FromRaw(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jwk.AssignKeyID(private); err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewBuilder().
+		Issuer(clientID).
+		Audience([]string{testRequestObjectIssuer}).
+		Expiration(time.Now().Add(time.Minute)).
+		Claim("client_id", claimedClientID).
+		Claim("response_type", "code").
+		Claim("state", "xyz").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256, private))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+// Codec counting the bodies it encodes
+type countingCodec struct {
+	stdJSONCodec
+	calls *int32
+}
+
+func (c countingCodec) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt32(c.calls, 1)
+	return c.stdJSONCodec.Marshal(v)
+}
+
+func TestRequireRequestObject(t *testing.T) {
+	key := newECKey(t)
+	var fetches, encoded int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(clientPublicSet(t, key))
+	}))
+	defer server.Close()
+
+	config := newTestConfig(t, NewConfigBuilder().WithJSONCodec(countingCodec{calls: &encoded}))
+	var logged []error
+	r := gin.New()
+	r.GET("/authorize", func(c *gin.Context) {
+		c.Next()
+		for _, err := range c.Errors {
+			logged = append(logged, err.Err)
+		}
+	}, RequireRequestObject(*config, RequestObjectOptions{
+		Issuer:   testRequestObjectIssuer,
+		Resolver: staticClientKeys{"alice": {JWKSURL: server.URL}},
+	}), func(c *gin.Context) {
+		claims, _ := RequestObjectFromContext(c)
+		c.String(http.StatusOK, claims.ClientID+" "+claims.State)
+	})
+	serve := func(clientID, request string) *httptest.ResponseRecorder {
+		query := url.Values{"client_id": {clientID}, "request": {request}}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil))
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := serve("alice", requestObject(t, key, "alice", "alice")); w.Code != http.StatusOK || w.Body.String() != "alice xyz" {
+			t.Fatalf("valid request object answered %d %s", w.Code, w.Body)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("client key set fetched %d times, expected once", n)
+	}
+
+	// signed by alice for alice, but naming another client
+	logged = nil
+	w := serve("alice", requestObject(t, key, "alice", "mallory"))
+	var body OAuthError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("mismatched client_id answered %d %s", w.Code, w.Body)
+	}
+	if body.Code != OAuthErrorInvalidRequestObject || body.Description != "invalid request object" {
+		t.Fatalf("mismatched client_id answered %s", w.Body)
+	}
+	if strings.Contains(w.Body.String(), "mallory") {
+		t.Fatalf("response body %s carries the validation error", w.Body)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0].Error(), "mallory") {
+		t.Fatalf("the validation error is not kept for the logs: %v", logged)
+	}
+	if atomic.LoadInt32(&encoded) == 0 {
+		t.Fatal("the error body was not encoded with the codec of the config")
+	}
+
+	if w = serve("bob", requestObject(t, key, "bob", "bob")); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown client answered %d %s", w.Code, w.Body)
+	}
+	if len(logged) != 2 || logged[1].Error() != "unknown client" {
+		t.Fatalf("the resolver error is not kept for the logs: %v", logged)
+	}
+}
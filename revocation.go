@@ -0,0 +1,320 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// largest CRL downloaded, OCSP responses use DefaultMaxInputSize
+	maxCRLSize int64 = 16 << 20
+	// bound of the revocation check run by Build
+	revocationBuildTimeout = 30 * time.Second
+	// first retry delay after a failed check, doubled up to the recheck interval
+	revocationRetryDelay = 2 * time.Second
+)
+
+// ErrCertificateRevoked is reported through the error hook when the
+// certificate of the served key is found revoked
+var ErrCertificateRevoked = errors.New("certificate revoked")
+
+// What to do with the served key once its certificate is revoked
+type RevocationPolicy string
+
+const (
+	// only report the revocation
+	RevocationWarn RevocationPolicy = "warn"
+	// keep serving the key without its x5c, x5t and x5t#S256 members
+	RevocationStripX5C RevocationPolicy = "strip-x5c"
+	// stop serving the key, the key set answers 503 until it is replaced
+	RevocationDropKey RevocationPolicy = "drop-key"
+)
+
+type RevocationStatus int
+
+const (
+	// no check concluded yet
+	RevocationUnknown RevocationStatus = iota
+	RevocationGood
+	RevocationRevoked
+)
+
+func (s RevocationStatus) String() string {
+	switch s {
+	case RevocationGood:
+		return "good"
+	case RevocationRevoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// Check the leaf certificate against its OCSP responders, then its CRL
+// distribution points, at Build and every interval. The chain file must hold
+// the issuer right after the leaf. A check which cannot conclude is reported
+// and retried, the last known status is kept.
+func (n *ConfigImportPublicKeyBuilder) WithRevocationCheck(policy RevocationPolicy, interval time.Duration) *ConfigImportPublicKeyBuilder {
+	n.initiateImportPublicOptsIfNil()
+	n.config.importPubOpts.revocationPolicy = policy
+	n.config.importPubOpts.revocationInterval = interval
+	return n
+}
+
+// Use client for the outgoing requests of the config, http.DefaultClient otherwise
+func (n *ConfigBuilder) WithHTTPClient(client *http.Client) *ConfigBuilder {
+	n.config.httpClient = client
+	return n
+}
+
+func (c *Config) client() *http.Client {
+	if c.httpClient == nil {
+		return http.DefaultClient
+	}
+	return c.httpClient
+}
+
+// RevocationStatus returns the last concluded status of the certificate of the
+// served key, RevocationUnknown when revocation is not checked
+func (c *Config) RevocationStatus() RevocationStatus {
+	if c.revocation == nil {
+		return RevocationUnknown
+	}
+	c.revocation.mu.RLock()
+	defer c.revocation.mu.RUnlock()
+	return c.revocation.status
+}
+
+type revocationChecker struct {
+	policy   RevocationPolicy
+	interval time.Duration
+	leaf     *x509.Certificate
+	issuer   *x509.Certificate
+
+	mu     sync.RWMutex
+	status RevocationStatus
+}
+
+func newRevocationChecker(opts ImportPublicKeyOptions, certs []*x509.Certificate) (*revocationChecker, error) {
+	switch opts.revocationPolicy {
+	case RevocationWarn, RevocationStripX5C, RevocationDropKey:
+	default:
+		return nil, fmt.Errorf("unknown revocation policy %q", opts.revocationPolicy)
+	}
+	if opts.revocationInterval <= 0 {
+		return nil, fmt.Errorf("revocation recheck interval must be positive")
+	}
+	if len(certs) < 2 {
+		return nil, fmt.Errorf("revocation checking needs the issuer certificate after the leaf")
+	}
+	if err := certs[0].CheckSignatureFrom(certs[1]); err != nil {
+		return nil, fmt.Errorf("second certificate is not the issuer of the leaf %v", err)
+	}
+	if len(certs[0].OCSPServer) == 0 && len(certs[0].CRLDistributionPoints) == 0 {
+		return nil, fmt.Errorf("certificate has no OCSP responder nor CRL distribution point")
+	}
+
+	return &revocationChecker{
+		policy:   opts.revocationPolicy,
+		interval: opts.revocationInterval,
+		leaf:     certs[0],
+		issuer:   certs[1],
+	}, nil
+}
+
+// Whether the served key is withheld because of its revoked certificate
+func (c *Config) keyDropped() bool {
+	return c.revocation != nil && c.revocation.policy == RevocationDropKey &&
+		c.RevocationStatus() == RevocationRevoked
+}
+
+// Whether the certificate members of the served key are withheld
+func (c *Config) x5cStripped() bool {
+	return c.revocation != nil && c.revocation.policy == RevocationStripX5C &&
+		c.RevocationStatus() == RevocationRevoked
+}
+
+// Check once now, then every interval until Close
+func (c *Config) startRevocationCheck() {
+	ctx, cancel := context.WithTimeout(context.Background(), revocationBuildTimeout)
+	err := c.checkRevocation(ctx)
+	cancel()
+	if err != nil {
+		c.reportError(err)
+	}
+
+	c.background.goRun(func(done <-chan struct{}) {
+		ctx, cancel := c.background.context()
+		defer cancel()
+
+		var retry time.Duration
+		if err != nil {
+			retry = revocationRetryDelay
+		}
+		for {
+			delay := c.revocation.interval
+			if retry > 0 && retry < delay {
+				delay = retry
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+
+			if err := c.checkRevocation(ctx); err != nil {
+				c.reportError(err)
+				if retry == 0 {
+					retry = revocationRetryDelay
+				} else {
+					retry *= 2
+				}
+				continue
+			}
+			retry = 0
+		}
+	})
+}
+
+// Query the revocation status and apply the policy on a change
+func (c *Config) checkRevocation(ctx context.Context) error {
+	r := c.revocation
+	status, err := r.query(ctx, c.client())
+	if err != nil {
+		return fmt.Errorf("cannot check the revocation of certificate %s, keeping the last status %v", r.leaf.SerialNumber, err)
+	}
+
+	r.mu.Lock()
+	previous := r.status
+	r.status = status
+	r.mu.Unlock()
+	if status == previous {
+		return nil
+	}
+
+	if status == RevocationRevoked {
+		c.reportError(fmt.Errorf("%w: serial %s, applying the %s policy", ErrCertificateRevoked, r.leaf.SerialNumber, r.policy))
+	}
+	if r.policy != RevocationWarn && (status == RevocationRevoked || previous == RevocationRevoked) {
+		c.keySetChanged()
+	}
+	return nil
+}
+
+// OCSP first, the CRLs when no responder gives a definitive answer
+func (r *revocationChecker) query(ctx context.Context, client *http.Client) (RevocationStatus, error) {
+	var errs []error
+	for _, server := range r.leaf.OCSPServer {
+		status, err := r.queryOCSP(ctx, client, server)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if status != RevocationUnknown {
+			return status, nil
+		}
+	}
+	for _, url := range r.leaf.CRLDistributionPoints {
+		status, err := r.queryCRL(ctx, client, url)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return status, nil
+	}
+
+	if len(errs) == 0 {
+		return RevocationUnknown, fmt.Errorf("no definitive answer")
+	}
+	return RevocationUnknown, fmt.Errorf("%v", errs)
+}
+
+func (r *revocationChecker) queryOCSP(ctx context.Context, client *http.Client, server string) (RevocationStatus, error) {
+	ocspReq, err := ocsp.CreateRequest(r.leaf, r.issuer, nil)
+	if err != nil {
+		return RevocationUnknown, fmt.Errorf("cannot create OCSP request %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(ocspReq))
+	if err != nil {
+		return RevocationUnknown, fmt.Errorf("cannot create OCSP request %v", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	body, err := fetch(client, req, DefaultMaxInputSize)
+	if err != nil {
+		return RevocationUnknown, fmt.Errorf("OCSP responder %s %v", server, err)
+	}
+	res, err := ocsp.ParseResponseForCert(body, r.leaf, r.issuer)
+	if err != nil {
+		return RevocationUnknown, fmt.Errorf("invalid response from OCSP responder %s %v", server, err)
+	}
+	if !res.NextUpdate.IsZero() && time.Now().After(res.NextUpdate) {
+		return RevocationUnknown, fmt.Errorf("stale response from OCSP responder %s", server)
+	}
+
+	switch res.Status {
+	case ocsp.Good:
+		return RevocationGood, nil
+	case ocsp.Revoked:
+		return RevocationRevoked, nil
+	default:
+		return RevocationUnknown, nil
+	}
+}
+
+func (r *revocationChecker) queryCRL(ctx context.Context, client *http.Client, url string) (RevocationStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return RevocationUnknown, fmt.Errorf("cannot create CRL request %v", err)
+	}
+	body, err := fetch(client, req, maxCRLSize)
+	if err != nil {
+		return RevocationUnknown, fmt.Errorf("CRL %s %v", url, err)
+	}
+	crl, err := x509.ParseCRL(body)
+	if err != nil {
+		return RevocationUnknown, fmt.Errorf("cannot parse CRL %s %v", url, err)
+	}
+	if err = r.issuer.CheckCRLSignature(crl); err != nil {
+		return RevocationUnknown, fmt.Errorf("CRL %s is not signed by the issuer %v", url, err)
+	}
+	if crl.HasExpired(time.Now()) {
+		return RevocationUnknown, fmt.Errorf("stale CRL %s", url)
+	}
+
+	for _, revoked := range crl.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(r.leaf.SerialNumber) == 0 {
+			return RevocationRevoked, nil
+		}
+	}
+	return RevocationGood, nil
+}
+
+// Send req and read a 200 body of at most limit bytes
+func fetch(client *http.Client, req *http.Request, limit int64) ([]byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("answered %s", res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("response is larger than %d bytes", limit)
+	}
+	return body, nil
+}
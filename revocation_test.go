@@ -0,0 +1,226 @@
+package gin_jwks_rsa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Issuing CA, its OCSP responder and CRL endpoint, answering with the
+// status of every certificate it issued
+type stubCA struct {
+	key    crypto.Signer
+	cert   *x509.Certificate
+	server *httptest.Server
+	// ocsp.Good or ocsp.Revoked
+	status int32
+	// answer with responses that expired an hour ago
+	stale int32
+}
+
+func newStubCA(t *testing.T) *stubCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &stubCA{key: key, cert: cert}
+	ca.server = httptest.NewServer(ca)
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *stubCA) updates() (time.Time, time.Time) {
+	now := time.Now()
+	if atomic.LoadInt32(&ca.stale) == 1 {
+		now = now.Add(-2 * time.Hour)
+	}
+	return now.Add(-time.Minute), now.Add(time.Hour)
+}
+
+func (ca *stubCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	thisUpdate, nextUpdate := ca.updates()
+	if r.URL.Path == "/crl" {
+		list := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: thisUpdate, NextUpdate: nextUpdate}
+		if atomic.LoadInt32(&ca.status) == ocsp.Revoked {
+			list.RevokedCertificateEntries = []x509.RevocationListEntry{{SerialNumber: big.NewInt(42), RevocationTime: thisUpdate}}
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, list, ca.cert, ca.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(der)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	req, err := ocsp.ParseRequest(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res := ocsp.Response{
+		Status:       int(atomic.LoadInt32(&ca.status)),
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   nextUpdate,
+		RevokedAt:    thisUpdate,
+	}
+	der, err := ocsp.CreateResponse(ca.cert, ca.cert, res, ca.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(der)
+}
+
+// Chain file holding an RSA leaf issued by ca, checked over OCSP or over its CRL
+func (ca *stubCA) issue(t *testing.T, useCRL bool) string {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "signing key"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if useCRL {
+		template.CRLDistributionPoints = []string{ca.server.URL + "/crl"}
+	} else {
+		template.OCSPServer = []string{ca.server.URL + "/ocsp"}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, newRSAKey(t).Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	path := filepath.Join(t.TempDir(), "chain.pem")
+	if err = os.WriteFile(path, chain, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRevocationPolicies(t *testing.T) {
+	for _, tt := range []struct {
+		policy  RevocationPolicy
+		useCRL  bool
+		code    int
+		withX5C bool
+	}{
+		{RevocationWarn, false, http.StatusOK, true},
+		{RevocationStripX5C, false, http.StatusOK, false},
+		{RevocationDropKey, false, http.StatusServiceUnavailable, false},
+		{RevocationDropKey, true, http.StatusServiceUnavailable, false},
+	} {
+		name := string(tt.policy)
+		if tt.useCRL {
+			name += " over CRL"
+		}
+		t.Run(name, func(t *testing.T) {
+			ca := newStubCA(t)
+			chain := ca.issue(t, tt.useCRL)
+			build := func() (*Config, []error) {
+				var errs []error
+				config, err := NewConfigBuilder().
+					OnError(func(err error) { errs = append(errs, err) }).
+					ImportPublicKey().
+					WithCertificatePath(chain).
+					WithRevocationCheck(tt.policy, time.Hour).
+					Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { config.Close() })
+				return config, errs
+			}
+
+			config, errs := build()
+			if status := config.RevocationStatus(); status != RevocationGood || len(errs) != 0 {
+				t.Fatalf("good certificate: status %s, errors %v", status, errs)
+			}
+			if w := serveJWKS(Jkws(*config)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"x5c"`) {
+				t.Fatalf("good certificate answered %d %s", w.Code, w.Body)
+			}
+
+			atomic.StoreInt32(&ca.status, ocsp.Revoked)
+			config, errs = build()
+			if status := config.RevocationStatus(); status != RevocationRevoked {
+				t.Fatalf("revoked certificate: status %s", status)
+			}
+			if len(errs) != 1 || !errors.Is(errs[0], ErrCertificateRevoked) {
+				t.Fatalf("revocation reported as %v", errs)
+			}
+			w := serveJWKS(Jkws(*config))
+			if w.Code != tt.code || strings.Contains(w.Body.String(), `"x5c"`) != tt.withX5C {
+				t.Fatalf("revoked certificate answered %d %s", w.Code, w.Body)
+			}
+			// a dropped key does not verify tokens either
+			if _, err := config.VerificationKeys(); (err == nil) != (tt.code == http.StatusOK) {
+				t.Fatalf("revoked certificate verification keys: %v", err)
+			}
+		})
+	}
+}
+
+func TestRevocationKeepsUnknownOnStaleAnswers(t *testing.T) {
+	ca := newStubCA(t)
+	atomic.StoreInt32(&ca.stale, 1)
+	var errs []error
+	config, err := NewConfigBuilder().
+		OnError(func(err error) { errs = append(errs, err) }).
+		ImportPublicKey().
+		WithCertificatePath(ca.issue(t, false)).
+		WithRevocationCheck(RevocationDropKey, time.Hour).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	if status := config.RevocationStatus(); status != RevocationUnknown {
+		t.Fatalf("stale answer concluded %s", status)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "stale") {
+		t.Fatalf("stale answer reported as %v", errs)
+	}
+	// a check which cannot conclude does not withhold the key
+	if w := serveJWKS(Jkws(*config)); w.Code != http.StatusOK {
+		t.Fatalf("answered %d", w.Code)
+	}
+}
@@ -0,0 +1,383 @@
+package gin_jwks_rsa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"sync"
+	"time"
+)
+
+// Keys of a config, shared by its copies so the handlers serve a rotated key
+type keyRing struct {
+	mu sync.RWMutex
+	// held for a whole rotation, key generation included
+	rotating sync.Mutex
+	active   *activeKey
+	// public half of the key Build installed, a rotation after the removal
+	// of the signing key generates one like it
+	initial jwk.Key
+	// public keys published besides the active one
+	additional jwk.Set
+	// end of the grace period of the retired keys among additional, by kid
+	retiredUntil map[string]time.Time
+	// time.Now and time.AfterFunc outside of tests
+	now       func() time.Time
+	afterFunc func(time.Duration, func()) refreshTimer
+	// serialized key set and its entity tag, nil until served once after a
+	// change, generation counts the changes
+	document   []byte
+	etag       string
+	generation uint64
+	// last signing key reported past its end of validity
+	expiryReported *activeKey
+	// key of WithEncryptionKey, and the ones it replaced which still decrypt
+	// until the end of their grace period
+	encryption        *encryptionKey
+	retiredEncryption []*encryptionKey
+}
+
+// Active key along with what belongs to it. It is never modified once set,
+// rotation installs a new one, so a snapshot can be used without locking.
+type activeKey struct {
+	// public half only when the private key is sealed
+	key       jwk.Key
+	createdAt time.Time
+	sealed    sealedKey
+	// raw key of a multi-prime import, jwk cannot hold it
+	multiPrimeKey *rsa.PrivateKey
+	// signer of a key provider, key is its public key
+	signer crypto.Signer
+	// planned end of validity, none if zero
+	notAfter time.Time
+}
+
+func newKeyRing() *keyRing {
+	return &keyRing{
+		now: time.Now,
+		afterFunc: func(d time.Duration, f func()) refreshTimer {
+			return time.AfterFunc(d, f)
+		},
+	}
+}
+
+// Snapshot of the active key, nil when there is none
+func (c *Config) active() *activeKey {
+	if c == nil || c.keys == nil {
+		return nil
+	}
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	return c.keys.active
+}
+
+// Key the next signing key takes after: current, or else the key Build
+// installed once the signing key was removed, nil before Build
+func (c *Config) replacedKey(current *activeKey) jwk.Key {
+	if current != nil {
+		return current.key
+	}
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	return c.keys.initial
+}
+
+func (c *Config) setActive(active *activeKey) {
+	c.keys.mu.Lock()
+	defer c.keys.mu.Unlock()
+	c.keys.active = active
+}
+
+// Rotate replaces the signing key with a newly generated one of the same type
+// and size, published under keyId, or under its thumbprint when keyId is
+// empty. Handlers serve the new key as soon as Rotate returns, the previous
+// key is no longer published unless a rotation grace period is set. Once the
+// signing key was removed, the new key is generated like the one Build
+// installed. The key of a KeyProvider is refused with ErrProviderRotation.
+func (c *Config) Rotate(keyId string) error {
+	if c.keys == nil {
+		return ErrNoServableKey
+	}
+	c.keys.rotating.Lock()
+	defer c.keys.rotating.Unlock()
+	return c.rotate(keyId, AuditTriggerAPI)
+}
+
+// Generate and install the next key, the caller holds the rotating lock
+func (c *Config) rotate(keyId string, trigger string) error {
+	current := c.active()
+	if c.importPkOpts != nil && c.importPkOpts.provider != nil || current != nil && current.signer != nil {
+		return ErrProviderRotation
+	}
+	model := c.replacedKey(current)
+	if model == nil {
+		return ErrNoServableKey
+	}
+	opts, err := sameKeyParameters(model)
+	if err != nil {
+		return fmt.Errorf("cannot rotate the key %v", err)
+	}
+	key, err := generatePrivateKey(opts)
+	if err != nil {
+		return fmt.Errorf("cannot generate new private key %v", err)
+	}
+	return c.replaceKey(key, nil, keyId, current, AuditKeyRotated, trigger)
+}
+
+// ReplaceKey makes key the signing key, published under keyId, or under the
+// kid it carries or else its thumbprint when keyId is empty. An invalid key
+// is refused and the previous one stays in place. It also installs a signing
+// key once the previous one was removed, e.g. after a compromise.
+func (c *Config) ReplaceKey(key jwk.Key, keyId string) error {
+	if c.keys == nil {
+		return ErrNoServableKey
+	}
+	c.keys.rotating.Lock()
+	defer c.keys.rotating.Unlock()
+	current := c.active()
+	return c.replaceKey(key, nil, keyId, current, AuditKeyRotated, AuditTriggerAPI)
+}
+
+// Install key in place of current, signing through signer when key is the
+// public key of a provider. A reloaded key may keep the kid of the key it
+// replaces, the key then goes on being published under the same name. current
+// is nil once the signing key was removed, key then has nothing to inherit and
+// nothing to retire.
+func (c *Config) replaceKey(key jwk.Key, signer crypto.Signer, keyId string, current *activeKey, action AuditAction, trigger string) error {
+	if c.importPubOpts != nil || c.symmetricKey != nil {
+		return fmt.Errorf("a config serving a public key only cannot rotate")
+	}
+	model := c.replacedKey(current)
+	if model == nil {
+		return ErrNoServableKey
+	}
+	if model.KeyUsage() != KeyUsageAsSignature {
+		return fmt.Errorf("only signing keys can be rotated")
+	}
+	if key == nil {
+		return fmt.Errorf("private key cannot be nil")
+	}
+	next, err := c.checkReplacementKey(key, signer, keyId, current, action == AuditKeyReloaded)
+	if err != nil {
+		return fmt.Errorf("cannot rotate the key %w", err)
+	}
+	var previousKid string
+	if current != nil {
+		previousKid = current.key.KeyID()
+	}
+	kidChanged := next.key.KeyID() != previousKid
+
+	var retired jwk.Key
+	if current != nil && c.rotationGrace() > 0 && kidChanged {
+		if retired, err = retiredPublicKey(current.key); err != nil {
+			return fmt.Errorf("cannot rotate the key %v", err)
+		}
+	}
+
+	// the next start loads the key in use, a key which cannot be persisted
+	// is not installed
+	if c.newPkOpts != nil && c.newPkOpts.persistPath != "" {
+		if err = persistRotatedKey(c.newPkOpts.persistPath, next); err != nil {
+			return fmt.Errorf("cannot rotate the key %v", err)
+		}
+	}
+
+	c.keys.mu.Lock()
+	if c.keys.active != current {
+		c.keys.mu.Unlock()
+		return fmt.Errorf("cannot rotate the key, it was rotated concurrently")
+	}
+	if retired != nil {
+		if err = c.keys.retire(retired, c.keys.now().Add(c.rotationGrace())); err != nil {
+			c.keys.mu.Unlock()
+			return fmt.Errorf("cannot rotate the key %v", err)
+		}
+	}
+	c.keys.active = next
+	c.keys.mu.Unlock()
+
+	// aliases named the previous key, which is gone
+	if current != nil && kidChanged {
+		for _, alias := range c.aliasesOf(previousKid) {
+			if err = c.RemoveKidAlias(alias); err != nil {
+				c.reportError(err)
+			}
+		}
+	}
+
+	c.observe(func(i instrumentation) {
+		i.keyActivated(next.createdAt)
+		i.keyRotated()
+	})
+	event := AuditEvent{Action: action, KeyID: next.key.KeyID(), Trigger: trigger}
+	if previousKid != "" {
+		event.Details = map[string]string{"previous_kid": previousKid}
+	}
+	c.audit(event)
+	c.keySetChanged()
+	if c.onRotate != nil {
+		c.runHook("OnRotate", func() {
+			c.onRotate(previousKid, next.key.KeyID())
+		})
+	}
+
+	return nil
+}
+
+// Validate the replacement of current the way Build validates a key, and
+// seal and self-test it as the config requires
+func (c *Config) checkReplacementKey(key jwk.Key, signer crypto.Signer, keyId string, current *activeKey, reload bool) (*activeKey, error) {
+	// the key may be shared by the caller, work on a copy
+	key, err := key.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("cannot copy the key %v", err)
+	}
+	if keyId != "" {
+		if err = key.Set(jwk.KeyIDKey, keyId); err != nil {
+			return nil, fmt.Errorf("cannot add an id property to the private key %v", err)
+		}
+	}
+	kid := key.KeyID()
+	if kid == "" || c.thumbprintKid {
+		if kid, err = thumbprintKeyId(key); err != nil {
+			return nil, err
+		}
+		if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+			return nil, fmt.Errorf("cannot add an id property to the private key %v", err)
+		}
+	}
+	if reload && current != nil && (keyId != "" || kid == current.key.KeyID()) {
+		// a reloaded file holding another key takes neither the kid of the
+		// previous key nor the fixed one, which caches may hold for an older
+		// key: it is published under its thumbprint and the previous key
+		// retired as on a rotation. The same key keeps its kid.
+		var previous, next string
+		if previous, err = thumbprintKeyId(current.key); err != nil {
+			return nil, err
+		}
+		if next, err = thumbprintKeyId(key); err != nil {
+			return nil, err
+		}
+		kid = current.key.KeyID()
+		if next != previous {
+			kid = next
+		}
+		if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+			return nil, fmt.Errorf("cannot add an id property to the private key %v", err)
+		}
+	}
+	if (current != nil && kid == current.key.KeyID() && !reload) || c.isAdditionalKid(kid) {
+		return nil, fmt.Errorf("kid %q is already published", kid)
+	}
+	if _, ok := c.KidAliases()[kid]; ok {
+		return nil, fmt.Errorf("kid %q is an alias", kid)
+	}
+
+	if signer != nil {
+		_, isRSA := key.(jwk.RSAPublicKey)
+		_, isEC := key.(jwk.ECDSAPublicKey)
+		if !isRSA && !isEC && !isEd25519PublicKey(key) {
+			return nil, fmt.Errorf("expected an RSA, EC or Ed25519 public key from the provider, got %T", key)
+		}
+	} else {
+		_, isRSA := key.(jwk.RSAPrivateKey)
+		_, isEC := key.(jwk.ECDSAPrivateKey)
+		if !isRSA && !isEC && !isEd25519PrivateKey(key) {
+			return nil, fmt.Errorf("expected an RSA, EC or Ed25519 private key, got %T", key)
+		}
+	}
+	if usage := key.KeyUsage(); usage != "" && usage != KeyUsageAsSignature {
+		return nil, fmt.Errorf("a signing key cannot be used for %q", usage)
+	}
+	if err = key.Set(jwk.KeyUsageKey, KeyUsageAsSignature); err != nil {
+		return nil, fmt.Errorf("cannot add a use property to the private key %v", err)
+	}
+	// the algorithm of the config wins, as it does at Build
+	if alg := c.requestedAlgorithm(); alg != "" {
+		if err = key.Set(jwk.AlgorithmKey, alg); err != nil {
+			return nil, fmt.Errorf("cannot add an algorithm property to the private key %v", err)
+		}
+	}
+	if current != nil {
+		if err = inheritAlgorithm(key, current.key); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = signatureAlgorithm(key); err != nil {
+		return nil, err
+	}
+	if _, err = key.PublicKey(); err != nil {
+		return nil, fmt.Errorf("failed to create public key %v", err)
+	}
+	if err = c.checkProfileGuardrails(key); err != nil {
+		return nil, err
+	}
+	if err = c.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	next := &activeKey{key: key, createdAt: time.Now(), signer: signer}
+	next.notAfter = c.keyNotAfter(next.createdAt)
+	if c.sealKey != nil {
+		if next, err = c.sealActiveKey(next); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.selfTest {
+		// run against a copy serving the new key, the config still serves the previous one
+		candidate := *c
+		candidate.keys = &keyRing{active: next, additional: c.keys.additional, now: c.keys.now}
+		if err = candidate.runSelfTest(); err != nil {
+			return nil, err
+		}
+	}
+
+	return next, nil
+}
+
+// Give key the alg of the RSA key it replaces when it carries none, a PS256
+// key is not replaced by an RS256 one. The alg of the other keys follows from
+// their curve.
+func inheritAlgorithm(key, previous jwk.Key) error {
+	if _, ok := key.Get(jwk.AlgorithmKey); ok {
+		return nil
+	}
+	if key.KeyType() != jwa.RSA || previous.KeyType() != jwa.RSA {
+		return nil
+	}
+	alg, ok := previous.Get(jwk.AlgorithmKey)
+	if !ok {
+		return nil
+	}
+	if err := key.Set(jwk.AlgorithmKey, alg); err != nil {
+		return fmt.Errorf("cannot add an algorithm property to the private key %v", err)
+	}
+	return nil
+}
+
+// Parameters generating a key like key
+func sameKeyParameters(key jwk.Key) (NewKeyOptions, error) {
+	if isEd25519PrivateKey(key) || isEd25519PublicKey(key) {
+		return NewKeyOptions{keyType: jwa.OKP, curve: jwa.Ed25519}, nil
+	}
+
+	var raw interface{}
+	pubKey, err := key.PublicKey()
+	if err == nil {
+		err = pubKey.Raw(&raw)
+	}
+	if err != nil {
+		return NewKeyOptions{}, fmt.Errorf("cannot read key %v", err)
+	}
+	switch raw := raw.(type) {
+	case *rsa.PublicKey:
+		return NewKeyOptions{keyType: jwa.RSA, bits: raw.N.BitLen()}, nil
+	case *ecdsa.PublicKey:
+		return NewKeyOptions{keyType: jwa.EC, curve: jwa.EllipticCurveAlgorithm(raw.Curve.Params().Name)}, nil
+	}
+	return NewKeyOptions{}, fmt.Errorf("cannot generate a %s key", keyDescription(key))
+}
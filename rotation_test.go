@@ -0,0 +1,407 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotateReplacesTheServedKey(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	if err := config.AliasKid("alias", "test"); err != nil {
+		t.Fatal(err)
+	}
+	before := servedKeySet(t, config)
+
+	if err := config.Rotate("next"); err != nil {
+		t.Fatal(err)
+	}
+	set := servedKeySet(t, config)
+	if _, ok := set.LookupKeyID("next"); !ok || set.Len() != 1 {
+		t.Fatalf("served key set holds %d keys after the rotation", set.Len())
+	}
+	if _, ok := config.ResolveKid("alias"); ok {
+		t.Fatal("the alias of the retired key survived the rotation")
+	}
+
+	signed, err := config.signToken(jwt.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jwt.Parse(signed, jwt.WithKeySet(set)); err != nil {
+		t.Fatalf("token signed after the rotation refused: %v", err)
+	}
+	if _, err = jwt.Parse(signed, jwt.WithKeySet(before)); err == nil {
+		t.Fatal("token signed after the rotation verifies with the retired key")
+	}
+}
+
+func TestReplaceKeyKeepsThePreviousKeyOnFailure(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	if err := config.AliasKid("alias", "test"); err != nil {
+		t.Fatal(err)
+	}
+	public, err := jwk.FromRaw(newRSAKey(t).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := jwk.FromRaw(newRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, replace := range map[string]func() error{
+		"public key":   func() error { return config.ReplaceKey(public, "next") },
+		"nil key":      func() error { return config.ReplaceKey(nil, "next") },
+		"kid in use":   func() error { return config.ReplaceKey(private, "test") },
+		"kid as alias": func() error { return config.ReplaceKey(private, "alias") },
+	} {
+		if err = replace(); err == nil {
+			t.Fatalf("%s installed", name)
+		}
+		if kid := config.active().key.KeyID(); kid != "test" {
+			t.Fatalf("%s: active key is %q", name, kid)
+		}
+	}
+
+	ec, err := jwk.FromRaw(newECKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.ReplaceKey(ec, "ec"); err != nil {
+		t.Fatal(err)
+	}
+	if alg, _ := signatureAlgorithm(config.active().key); alg != jwa.ES256 {
+		t.Fatalf("replacement EC key signs with %s", alg)
+	}
+}
+
+func TestRotateKeepsTheAlgorithm(t *testing.T) {
+	config, err := NewConfigBuilder().
+		NewPrivateKey().
+		WithKeyLength(2048).
+		WithAlgorithm(jwa.PS256).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	if err = config.Rotate(""); err != nil {
+		t.Fatal(err)
+	}
+	alg, err := signatureAlgorithm(config.active().key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alg != jwa.PS256 {
+		t.Fatalf("rotated key signs with %s, expected PS256", alg)
+	}
+
+	token, err := config.Signer().Sign(map[string]interface{}{"sub": "me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headerAlg := msg.Signatures()[0].ProtectedHeaders().Algorithm(); headerAlg != jwa.PS256 {
+		t.Fatalf("token signed with %s after the rotation, expected PS256", headerAlg)
+	}
+	set, err := config.PublicJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, _ := set.Key(0)
+	if published.Algorithm().String() != jwa.PS256.String() {
+		t.Fatalf("rotated key published with alg %s, expected PS256", published.Algorithm())
+	}
+}
+
+// Run with -race: handlers and signers read the keys while they are rotated.
+// Every token is checked afterwards against all the keys the config had, so
+// the kid, alg and signature of a token must come from the same key.
+func TestRotateWhileServing(t *testing.T) {
+	config, err := NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P256).WithKeyId("0").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	r := gin.New()
+	r.GET("/jwks", Jkws(*config))
+
+	keys := jwk.NewSet()
+	addActive := func() {
+		public, err := config.active().key.PublicKey()
+		if err == nil {
+			err = public.Set(jwk.AlgorithmKey, jwa.ES256)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = keys.AddKey(public); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addActive()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var tokens [][]byte
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+				if set, err := jwk.Parse(w.Body.Bytes()); w.Code != http.StatusOK || err != nil || set.Len() != 1 {
+					t.Errorf("key set answered %d %s during a rotation", w.Code, w.Body)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				signed, err := config.signToken(jwt.New())
+				if err != nil {
+					t.Errorf("cannot sign during a rotation: %v", err)
+					return
+				}
+				mu.Lock()
+				tokens = append(tokens, signed)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 1; i <= 20; i++ {
+		if err := config.Rotate(string(rune('a' + i))); err != nil {
+			t.Error(err)
+			break
+		}
+		addActive()
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	for _, signed := range tokens {
+		if _, err := jwt.Parse(signed, jwt.WithKeySet(keys)); err != nil {
+			t.Fatalf("token signed during a rotation does not verify: %v", err)
+		}
+	}
+}
+
+// Run with -race: with a grace period every rotation adds a kid, so a reader
+// seeing the new active key without the retired one saw it half done.
+func TestKeySnapshotSeesRotationsWhole(t *testing.T) {
+	config, err := NewConfigBuilder().WithRotationGrace(time.Hour).NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P256).WithKeyId("k00").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	// the kids served must be k00 to kNN without a gap
+	checkWhole := func(kids map[string]bool) bool {
+		for i := 0; i < len(kids); i++ {
+			if !kids[fmt.Sprintf("k%02d", i)] {
+				return false
+			}
+		}
+		return true
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				keys, err := config.jwksKeys()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				kids := map[string]bool{}
+				for _, key := range keys {
+					kids[key.KeyIDKey] = true
+				}
+				if !checkWhole(kids) {
+					t.Errorf("key set read during a rotation holds %v", kids)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				set, err := config.verificationKeySet()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				kids := map[string]bool{}
+				for i := 0; i < set.Len(); i++ {
+					key, _ := set.Key(i)
+					kids[key.KeyID()] = true
+				}
+				if !checkWhole(kids) {
+					t.Errorf("verification keys read during a rotation hold %v", kids)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i < 20; i++ {
+		if err := config.Rotate(fmt.Sprintf("k%02d", i)); err != nil {
+			t.Error(err)
+			break
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+}
+
+// Run with -race: copies of the config, taken before and during the
+// rotations, never see the signing key and the served set of different
+// rotations
+func TestRotateThroughCopies(t *testing.T) {
+	config, err := NewConfigBuilder().
+		WithRotationGrace(time.Minute).
+		NewPrivateKey().
+		WithKeyType(jwa.EC).
+		WithCurve(jwa.P256).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	served := *config
+	r := gin.New()
+	r.GET("/pointer", config.Jkws())
+	r.GET("/copy", Jkws(served))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, path := range []string{"/pointer", "/copy"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				signing := *config
+				token, err := signing.Signer().Sign(map[string]interface{}{"sub": "me"})
+				if err != nil {
+					t.Errorf("cannot sign with a copy during a rotation: %v", err)
+					return
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				set, err := jwk.Parse(w.Body.Bytes())
+				if err != nil {
+					t.Errorf("%s served an invalid key set during a rotation: %v", path, err)
+					return
+				}
+				kids := map[string]bool{}
+				for i := 0; i < set.Len(); i++ {
+					key, _ := set.Key(i)
+					if kids[key.KeyID()] {
+						t.Errorf("%s served kid %q twice", path, key.KeyID())
+						return
+					}
+					kids[key.KeyID()] = true
+				}
+				msg, err := jws.Parse([]byte(token))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				kid := msg.Signatures()[0].ProtectedHeaders().KeyID()
+				key, ok := set.LookupKeyID(kid)
+				if !ok {
+					t.Errorf("%s does not serve kid %q of a token signed before the request", path, kid)
+					return
+				}
+				if _, err = jws.Verify([]byte(token), jws.WithKey(jwa.ES256, key)); err != nil {
+					t.Errorf("token does not verify against the key %s serves: %v", path, err)
+					return
+				}
+			}
+		}(path)
+	}
+
+	rotating := *config
+	for i := 0; i < 20; i++ {
+		if err := rotating.Rotate(""); err != nil {
+			t.Error(err)
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestReplaceKeyAppliesTheConfiguredAlgorithm(t *testing.T) {
+	config, err := NewConfigBuilder().
+		ImportPrivateKey().
+		WithRawKey(newRSAKey(t)).
+		WithAlgorithm(jwa.PS384).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	key, err := jwk.FromRaw(newRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = key.Set(jwk.AlgorithmKey, jwa.RS256); err != nil {
+		t.Fatal(err)
+	}
+	if err = config.ReplaceKey(key, "next"); err != nil {
+		t.Fatal(err)
+	}
+	if alg, _ := signatureAlgorithm(config.active().key); alg != jwa.PS384 {
+		t.Fatalf("replacement key signs with %s, expected the configured PS384", alg)
+	}
+}
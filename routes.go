@@ -0,0 +1,182 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type RouteOption func(*routeOptions)
+
+type routeOptions struct {
+	jwksPath      string
+	oidcIssuer    string
+	metadataOpts  MetadataOptions
+	asIssuer      string
+	asOpts        MetadataOptions
+	introspection *introspectionRoute
+	tokenExchange *tokenExchangeRoute
+	openAPI       *OpenAPIRoutes
+}
+
+type introspectionRoute struct {
+	path string
+	opts []IntrospectionOption
+}
+
+type tokenExchangeRoute struct {
+	path         string
+	opts         TokenExchangeOptions
+	authenticate []gin.HandlerFunc
+}
+
+// Path of the key set, DefaultJwksPath if not set
+func WithJWKSPath(path string) RouteOption {
+	return func(o *routeOptions) {
+		o.jwksPath = path
+	}
+}
+
+// Serve the OpenID Connect discovery document of issuer as well, pointing at
+// the key set path unless opts names another one
+func WithOpenIDConfiguration(issuer string, opts MetadataOptions) RouteOption {
+	return func(o *routeOptions) {
+		o.oidcIssuer = issuer
+		o.metadataOpts = opts
+	}
+}
+
+// Serve the RFC 8414 authorization server metadata of issuer as well, its
+// endpoints being the ones mounted along with the key set. The document lives
+// under the host root, r should be the engine when the issuer has a path.
+func WithAuthorizationServerMetadata(issuer string, opts MetadataOptions) RouteOption {
+	return func(o *routeOptions) {
+		o.asIssuer = issuer
+		o.asOpts = opts
+	}
+}
+
+// Mount Introspect at path for POST, advertised as the introspection_endpoint
+func WithIntrospection(path string, opts ...IntrospectionOption) RouteOption {
+	return func(o *routeOptions) {
+		o.introspection = &introspectionRoute{path: path, opts: opts}
+	}
+}
+
+// Mount TokenExchange at path for POST behind authenticate, e.g.
+// RequireClientAssertion, advertised as the token_endpoint
+func WithTokenExchange(path string, opts TokenExchangeOptions, authenticate ...gin.HandlerFunc) RouteOption {
+	return func(o *routeOptions) {
+		o.tokenExchange = &tokenExchangeRoute{path: path, opts: opts, authenticate: authenticate}
+	}
+}
+
+// Describe the mounted routes in routes, under their full path
+func WithOpenAPIRoutes(routes *OpenAPIRoutes) RouteOption {
+	return func(o *routeOptions) {
+		o.openAPI = routes
+	}
+}
+
+// Full path of relativePath mounted on r, gin joins the paths the same way
+func routePath(r gin.IRouter, relativePath string) string {
+	group, ok := r.(interface{ BasePath() string })
+	if !ok || relativePath == "" {
+		return relativePath
+	}
+	base := strings.TrimSuffix(group.BasePath(), "/")
+	return base + "/" + strings.TrimPrefix(relativePath, "/")
+}
+
+// Metadata of issuer pointing at the handlers mounted on r, they win over the
+// endpoints of opts which are kept for the handlers mounted elsewhere
+func (o routeOptions) registeredMetadata(r gin.IRouter, issuer string, opts MetadataOptions) MetadataOptions {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		// serverMetadata reports it
+		return opts
+	}
+	origin := issuerURL.Scheme + "://" + issuerURL.Host
+	if opts.JwksPath == "" {
+		opts.jwksURI = origin + routePath(r, o.jwksPath)
+	}
+	if o.tokenExchange != nil {
+		opts.TokenEndpoint = origin + routePath(r, o.tokenExchange.path)
+		if len(opts.GrantTypesSupported) == 0 {
+			opts.GrantTypesSupported = []string{GrantTypeTokenExchange}
+		}
+	}
+	if o.introspection != nil {
+		opts.IntrospectionEndpoint = origin + routePath(r, o.introspection.path)
+	}
+	return opts
+}
+
+// RegisterJWKS mounts the key set handler of config on r, an engine or a
+// group, for GET, HEAD and OPTIONS, along with the token exchange,
+// introspection and metadata handlers asked for
+func RegisterJWKS(r gin.IRouter, config *Config, opts ...RouteOption) error {
+	o := routeOptions{jwksPath: DefaultJwksPath}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if config.symmetricKey != nil {
+		return fmt.Errorf("%w, the config only verifies tokens", ErrSymmetricKey)
+	}
+	handler := Jkws(*config)
+	r.GET(o.jwksPath, handler)
+	r.HEAD(o.jwksPath, handler)
+	r.OPTIONS(o.jwksPath, handler)
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		if err := o.describe(r, method, o.jwksPath, OpenAPIJWKS); err != nil {
+			return err
+		}
+	}
+
+	if o.tokenExchange != nil {
+		handlers := append(append([]gin.HandlerFunc{}, o.tokenExchange.authenticate...), TokenExchange(*config, o.tokenExchange.opts))
+		r.POST(o.tokenExchange.path, handlers...)
+		if err := o.describe(r, http.MethodPost, o.tokenExchange.path, OpenAPITokenExchange); err != nil {
+			return err
+		}
+	}
+	if o.introspection != nil {
+		r.POST(o.introspection.path, Introspect(*config, o.introspection.opts...))
+		if err := o.describe(r, http.MethodPost, o.introspection.path, OpenAPIIntrospection); err != nil {
+			return err
+		}
+	}
+
+	if o.oidcIssuer != "" {
+		path, err := OpenIDConfigurationPath(o.oidcIssuer)
+		if err != nil {
+			return err
+		}
+		r.GET(path, config.EndpointAuthorization(), OpenIDConfiguration(*config, o.oidcIssuer, o.registeredMetadata(r, o.oidcIssuer, o.metadataOpts)))
+		if err = o.describe(r, http.MethodGet, path, OpenAPIMetadata); err != nil {
+			return err
+		}
+	}
+	if o.asIssuer != "" {
+		path, err := AuthorizationServerMetadataPath(o.asIssuer)
+		if err != nil {
+			return err
+		}
+		r.GET(path, config.EndpointAuthorization(), AuthorizationServerMetadata(o.asIssuer, o.registeredMetadata(r, o.asIssuer, o.asOpts)))
+		if err = o.describe(r, http.MethodGet, path, OpenAPIMetadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the route to the OpenAPI routes, if any
+func (o routeOptions) describe(r gin.IRouter, method, relativePath string, operation OpenAPIOperation) error {
+	if o.openAPI == nil {
+		return nil
+	}
+	return o.openAPI.Add(method, routePath(r, relativePath), operation)
+}
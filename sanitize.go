@@ -0,0 +1,60 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrPrivateMaterial is returned instead of a key set holding a private key member
+var ErrPrivateMaterial = errors.New("private key material in the key set")
+
+// JWK members of private keys, RFC 7518 section 6
+var privateJWKMembers = []string{"d", "p", "q", "dp", "dq", "qi", "oth", "k"}
+
+func isPrivateJWKMember(name string) bool {
+	for _, member := range privateJWKMembers {
+		if name == member {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckNoPrivateMaterial returns ErrPrivateMaterial when the JWKS or JWK body
+// holds a private key member. The key set handlers run it on every document
+// they are about to serve, tests can run it on what they got.
+func CheckNoPrivateMaterial(body []byte) error {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(body, &document); err != nil {
+		return fmt.Errorf("cannot read the key set %v", err)
+	}
+	keys, ok := document["keys"]
+	if !ok {
+		return checkKeyMembers(document)
+	}
+
+	var set []map[string]json.RawMessage
+	if err := json.Unmarshal(keys, &set); err != nil {
+		return fmt.Errorf(`"keys" is not an array of objects %v`, err)
+	}
+	for _, key := range set {
+		if err := checkKeyMembers(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name the member and the kid, never the value
+func checkKeyMembers(key map[string]json.RawMessage) error {
+	for _, member := range privateJWKMembers {
+		if _, ok := key[member]; !ok {
+			continue
+		}
+		var kid string
+		_ = json.Unmarshal(key["kid"], &kid)
+		return fmt.Errorf("%w, member %q of kid %q", ErrPrivateMaterial, member, kid)
+	}
+	return nil
+}
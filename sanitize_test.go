@@ -0,0 +1,64 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckNoPrivateMaterial(t *testing.T) {
+	for _, tt := range []struct {
+		name, body string
+		private    bool
+	}{
+		{"public key set", `{"keys":[{"kty":"RSA","kid":"a","n":"AQAB","e":"AQAB"}]}`, false},
+		{"public key", `{"kty":"EC","kid":"a","crv":"P-256","x":"AA","y":"AA"}`, false},
+		{"empty key set", `{"keys":[]}`, false},
+		{"private exponent", `{"keys":[{"kty":"RSA","kid":"a"},{"kty":"RSA","kid":"b","d":"c2VjcmV0"}]}`, true},
+		{"private key", `{"kty":"OKP","kid":"a","crv":"Ed25519","x":"AA","d":"c2VjcmV0"}`, true},
+		{"symmetric secret", `{"keys":[{"kty":"oct","kid":"a","k":"c2VjcmV0"}]}`, true},
+		{"other primes", `{"keys":[{"kty":"RSA","kid":"a","oth":[]}]}`, true},
+	} {
+		err := CheckNoPrivateMaterial([]byte(tt.body))
+		if errors.Is(err, ErrPrivateMaterial) != tt.private {
+			t.Fatalf("%s: got %v", tt.name, err)
+		}
+		if tt.private && strings.Contains(err.Error(), "c2VjcmV0") {
+			t.Fatalf("%s: the error echoes the private value %v", tt.name, err)
+		}
+	}
+
+	for _, body := range []string{"", "[]", `{"keys":{}}`} {
+		if err := CheckNoPrivateMaterial([]byte(body)); err == nil || errors.Is(err, ErrPrivateMaterial) {
+			t.Fatalf("%q gave %v", body, err)
+		}
+	}
+}
+
+// The last check before serving catches a private member whatever put it
+// there, the decorator itself refuses to add one
+func TestLeakingKeySetIsNotServed(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+
+	keys, err := config.jwksKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys[0].extra = map[string]interface{}{"d": "c2VjcmV0"}
+	body, err := config.marshalJWKS(keys)
+	if !errors.Is(err, ErrPrivateMaterial) || body != nil {
+		t.Fatalf("leaking key set serialized %s %v", body, err)
+	}
+	if strings.Contains(err.Error(), "c2VjcmV0") {
+		t.Fatalf("the error echoes the private value %v", err)
+	}
+
+	config = newTestConfig(t, NewConfigBuilder().WithKeyDecorator(func(_ string, entry map[string]interface{}) {
+		entry["d"] = "c2VjcmV0"
+	}))
+	w := serveJWKS(Jkws(*config))
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "c2VjcmV0") {
+		t.Fatalf("leaking key set answered %d %s", w.Code, w.Body)
+	}
+}
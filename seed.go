@@ -0,0 +1,184 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"io"
+	"math/big"
+)
+
+// Seed of the keys ProfileDev generates when none is given, the same key on
+// every start
+var devProfileSeed = []byte("gin-jwks dev profile")
+
+// Derive the key from seed, the same seed always gives the same key, e.g. a
+// stable kid across the restarts of a development server. Anyone knowing the
+// seed holds the private key, ProfileProd refuses it. A nil seed asks for a
+// random key whatever the profile.
+func (n *ConfigNewKeyBuilder) WithDeterministicSeed(seed []byte) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.seed = seed
+	n.config.newPkOpts.seedSet = true
+	return n
+}
+
+// SHA-256 of the seed and a counter, block after block
+type seedStream struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func (s *seedStream) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.buf) == 0 {
+			h := sha256.New()
+			h.Write(s.seed)
+			var counter [8]byte
+			binary.BigEndian.PutUint64(counter[:], s.counter)
+			h.Write(counter[:])
+			s.counter++
+			s.buf = h.Sum(nil)
+		}
+		copied := copy(p[n:], s.buf)
+		s.buf = s.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// Generate the key opts describes from opts.seed. The standard library mixes
+// its own randomness into RSA and EC key generation, the keys are derived here.
+func generateSeededKey(opts NewKeyOptions) (jwk.Key, error) {
+	stream := &seedStream{seed: opts.seed}
+	var raw interface{}
+	switch opts.keyType {
+	case "", jwa.RSA:
+		rsaKey, err := seededRSAKey(stream, opts.bits)
+		if err != nil {
+			return nil, err
+		}
+		raw = rsaKey
+	case jwa.EC:
+		curve, err := ellipticCurve(opts.curve)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = seededECKey(stream, curve); err != nil {
+			return nil, err
+		}
+	case jwa.OKP:
+		if opts.curve != jwa.Ed25519 {
+			return nil, fmt.Errorf("%w, a deterministic seed cannot generate an OKP key on %q", ErrUnsupportedKeyType, opts.curve)
+		}
+		edSeed := make([]byte, ed25519.SeedSize)
+		if _, err := io.ReadFull(stream, edSeed); err != nil {
+			return nil, err
+		}
+		raw = ed25519.NewKeyFromSeed(edSeed)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedKeyType, opts.keyType)
+	}
+
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key: %v", err)
+	}
+	return key, nil
+}
+
+// Two-prime RSA key of bits with e = 65537
+func seededRSAKey(stream io.Reader, bits int) (*rsa.PrivateKey, error) {
+	if bits < 64 {
+		return nil, fmt.Errorf("RSA key length %d is too small", bits)
+	}
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	for {
+		p, err := seededPrime(stream, bits-bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := seededPrime(stream, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != bits {
+			continue
+		}
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if d == nil {
+			continue
+		}
+
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		key.Precompute()
+		if err = key.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to derive RSA private key: %v", err)
+		}
+		return key, nil
+	}
+}
+
+// Prime of exactly bits, its two top bits set as crypto/rand.Prime does so the
+// product of two of them has the full length
+func seededPrime(stream io.Reader, bits int) (*big.Int, error) {
+	buf := make([]byte, (bits+7)/8)
+	topBits := uint(bits % 8)
+	if topBits == 0 {
+		topBits = 8
+	}
+	for {
+		if _, err := io.ReadFull(stream, buf); err != nil {
+			return nil, err
+		}
+		buf[0] &= uint8(int(1<<topBits) - 1)
+		if topBits >= 2 {
+			buf[0] |= 3 << (topBits - 2)
+		} else {
+			buf[0] |= 1
+			buf[1] |= 0x80
+		}
+		buf[len(buf)-1] |= 1
+
+		p := new(big.Int).SetBytes(buf)
+		if p.ProbablyPrime(20) {
+			return p, nil
+		}
+	}
+}
+
+// EC private key whose scalar is read from stream, in [1, N-1]
+func seededECKey(stream io.Reader, curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	params := curve.Params()
+	// 64 more bits than the order keep the modulo bias negligible
+	buf := make([]byte, (params.BitSize+7)/8+8)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return nil, err
+	}
+	d := new(big.Int).SetBytes(buf)
+	d.Mod(d, new(big.Int).Sub(params.N, big.NewInt(1)))
+	d.Add(d, big.NewInt(1))
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d.FillBytes(make([]byte, (params.BitSize+7)/8)))
+	return key, nil
+}
@@ -0,0 +1,86 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"math/big"
+	"time"
+)
+
+// Publish a certificate issued by the generated key to itself, for consumers
+// requiring x5c. It is valid for validity from Build, subject is its common name.
+func (n *ConfigNewKeyBuilder) WithSelfSignedCert(subject string, validity time.Duration) *ConfigNewKeyBuilder {
+	n.initiateNewOptsIfNil()
+	n.config.newPkOpts.selfSignedSubject = subject
+	n.config.newPkOpts.selfSignedValidity = validity
+	return n
+}
+
+// Issue a self-signed certificate over key and attach it as x5c
+func attachSelfSignedCertificate(key jwk.Key, subject string, validity time.Duration) error {
+	if validity <= 0 {
+		return fmt.Errorf("self-signed certificate validity must be positive")
+	}
+	var priv, pub interface{}
+	if err := key.Raw(&priv); err != nil {
+		return fmt.Errorf("cannot read private key %v", err)
+	}
+	pubKey, err := key.PublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to create public key %v", err)
+	}
+	if err = pubKey.Raw(&pub); err != nil {
+		return fmt.Errorf("cannot read public key %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("cannot generate certificate serial number %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: subject},
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		return fmt.Errorf("cannot create self-signed certificate %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("cannot parse self-signed certificate %v", err)
+	}
+
+	return attachCertificates(key, []*x509.Certificate{certificate})
+}
+
+// CertificateExpiry returns the end of validity of the certificate published
+// with the signing key, false when the key has none
+func (c *Config) CertificateExpiry() (time.Time, bool) {
+	active := c.active()
+	if active == nil {
+		return time.Time{}, false
+	}
+	chain := active.key.X509CertChain()
+	if chain == nil || chain.Len() == 0 {
+		return time.Time{}, false
+	}
+	encoded, _ := chain.Get(0)
+	der, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return time.Time{}, false
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return leaf.NotAfter, true
+}
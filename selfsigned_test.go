@@ -0,0 +1,81 @@
+package gin_jwks_rsa
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCert(t *testing.T) {
+	for name, builder := range map[string]*ConfigNewKeyBuilder{
+		"RSA": NewConfigBuilder().NewPrivateKey().WithKeyLength(2048),
+		"EC":  NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P256),
+	} {
+		before := time.Now().Truncate(time.Second)
+		config, err := builder.WithKeyId("cert").WithSelfSignedCert("auth.example.com", 24*time.Hour).Build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer config.Close()
+
+		members := servedKeyMembers(t, config)
+		chain, _ := members["x5c"].([]interface{})
+		if len(chain) != 1 {
+			t.Fatalf("%s: served x5c %v", name, members["x5c"])
+		}
+		der, err := base64.StdEncoding.DecodeString(chain[0].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certificate.Subject.CommonName != "auth.example.com" {
+			t.Fatalf("%s: subject %s", name, certificate.Subject)
+		}
+		// issued by the key to itself
+		if err = certificate.CheckSignature(certificate.SignatureAlgorithm, certificate.RawTBSCertificate, certificate.Signature); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		publicKey, err := config.active().key.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raw interface{}
+		if err = publicKey.Raw(&raw); err != nil {
+			t.Fatal(err)
+		}
+		if !certificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(raw) {
+			t.Fatalf("%s: the certificate holds another key", name)
+		}
+		thumbprint := sha256.Sum256(der)
+		if members["x5t#S256"] != base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+			t.Fatalf("%s: x5t#S256 %v", name, members["x5t#S256"])
+		}
+
+		expiry, ok := config.CertificateExpiry()
+		if !ok || expiry.Before(before.Add(24*time.Hour)) || expiry.After(time.Now().Add(24*time.Hour)) {
+			t.Fatalf("%s: certificate expires %v", name, expiry)
+		}
+		checkSignsAndVerifies(t, config)
+	}
+}
+
+func TestSelfSignedCertRefusesNoValidity(t *testing.T) {
+	if _, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).WithSelfSignedCert("auth.example.com", 0).Build(); err == nil {
+		t.Fatal("built a certificate valid for no time")
+	}
+	// without it no certificate is published
+	config := newTestConfig(t, NewConfigBuilder())
+	if _, ok := config.CertificateExpiry(); ok {
+		t.Fatal("certificate expiry of a key without certificate")
+	}
+	if members := servedKeyMembers(t, config); members["x5c"] != nil {
+		t.Fatalf("served x5c %v", members["x5c"])
+	}
+}
@@ -0,0 +1,118 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+const selfTestPayload = "gin-jwks self-test"
+
+// Sign and verify a throwaway payload against the served key set at Build time.
+// Leave it off for providers charging per signature.
+func (n *ConfigBuilder) WithSelfTest() *ConfigBuilder {
+	n.config.selfTest = true
+	return n
+}
+
+// Keep ProfileProd from turning the self-test on, e.g. for a provider charging
+// per signature. WithSelfTest and AllowMultiPrime still turn it on.
+func (n *ConfigBuilder) WithoutSelfTest() *ConfigBuilder {
+	n.config.selfTestSkipped = true
+	return n
+}
+
+// Round trip between the private key and the key set exactly as served by the handler
+func (c *Config) runSelfTest() error {
+	return c.selfTestKey(c.active())
+}
+
+// Self-test of the active key snapshot, which may no longer be the active one
+func (c *Config) selfTestKey(active *activeKey) error {
+	if active == nil {
+		return ErrNoServableKey
+	}
+	key := active.key
+	if key.KeyUsage() == KeyUsageAsEncryption {
+		return c.runEncryptionSelfTest(active)
+	}
+
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return fmt.Errorf("self-test failed to pick the signature algorithm %v", err)
+	}
+
+	var signed []byte
+	err = active.withPrivateKey(func(privateKey jwk.Key) (err error) {
+		if err = active.checkSigningKey(privateKey); err != nil {
+			return err
+		}
+		signingKey, headers, err := active.signingKey(privateKey)
+		if err != nil {
+			return err
+		}
+		signed, err = jws.Sign([]byte(selfTestPayload), jws.WithKey(alg, signingKey, jws.WithProtectedHeaders(headers)))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("self-test failed to sign the payload %v", err)
+	}
+
+	pubKey, err := c.servedKey(key.KeyID())
+	if err != nil {
+		return err
+	}
+
+	payload, err := jws.Verify(signed, jws.WithKey(alg, pubKey))
+	if err != nil {
+		return fmt.Errorf("self-test failed to verify the signature with the served key %v", err)
+	}
+	if string(payload) != selfTestPayload {
+		return fmt.Errorf("self-test failed to recover the signed payload")
+	}
+
+	return nil
+}
+
+// Encrypt to the served key and decrypt with the private key
+func (c *Config) runEncryptionSelfTest(active *activeKey) error {
+	pubKey, err := c.servedKey(active.key.KeyID())
+	if err != nil {
+		return err
+	}
+
+	alg := keyEncryptionAlgorithms(pubKey)[0]
+	encrypted, err := jwe.Encrypt([]byte(selfTestPayload), jwe.WithKey(alg, pubKey))
+	if err != nil {
+		return fmt.Errorf("self-test failed to encrypt the payload with the served key %v", err)
+	}
+	payload, err := c.Decrypt(encrypted)
+	if err != nil {
+		return fmt.Errorf("self-test failed to decrypt the payload %v", err)
+	}
+	if string(payload) != selfTestPayload {
+		return fmt.Errorf("self-test failed to recover the encrypted payload")
+	}
+
+	return nil
+}
+
+// Public key as a consumer of the handler sees it
+func (c *Config) servedKey(kid string) (jwk.Key, error) {
+	body, err := c.jwksDocument()
+	if err != nil {
+		return nil, fmt.Errorf("self-test failed to build the served key set %v", err)
+	}
+
+	set, err := jwk.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("self-test failed to parse the served key set %v", err)
+	}
+	pubKey, ok := set.LookupKeyID(kid)
+	if !ok {
+		return nil, fmt.Errorf("self-test failed to find kid %q in the served key set", kid)
+	}
+
+	return pubKey, nil
+}
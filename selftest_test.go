@@ -0,0 +1,43 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"strings"
+	"testing"
+)
+
+func newSelfTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSelfTestPassesOnAGeneratedKey(t *testing.T) {
+	if _, err := NewConfigBuilder().WithSelfTest().NewPrivateKey().WithKeyLength(2048).WithKeyId("test").Build(); err != nil {
+		t.Fatalf("self-test of a sound key failed: %v", err)
+	}
+}
+
+func TestSelfTestFailsOnABrokenKey(t *testing.T) {
+	// the private exponent of another key, which no PEM parser lets through
+	broken := *newSelfTestRSAKey(t)
+	broken.D = newSelfTestRSAKey(t).D
+	broken.Precomputed = rsa.PrecomputedValues{}
+	key, err := jwk.FromRaw(&broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = key.Set(jwk.KeyIDKey, "broken"); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{keys: &keyRing{active: &activeKey{key: key}}, aliases: newKidAliases()}
+	if err = config.runSelfTest(); err == nil || !strings.Contains(err.Error(), "self-test failed to sign") {
+		t.Fatalf("self-test of the broken key gave %v", err)
+	}
+}
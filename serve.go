@@ -0,0 +1,162 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultReadinessPath   = "/readyz"
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+type ServeOption func(*serveOptions)
+
+type serveOptions struct {
+	certFile        string
+	keyFile         string
+	getCertificate  func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	shutdownTimeout time.Duration
+	readinessPath   string
+	metadataIssuer  string
+	metadataOpts    MetadataOptions
+	onListening     func(net.Addr)
+}
+
+// Serve over TLS with the certificate and key files
+func WithTLSFiles(certFile, keyFile string) ServeOption {
+	return func(o *serveOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// Serve over TLS with the certificate returned by getCertificate, e.g. a reloading one
+func WithGetCertificate(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) ServeOption {
+	return func(o *serveOptions) {
+		o.getCertificate = getCertificate
+	}
+}
+
+// Time given to the requests in flight once the context is cancelled, 10s if not set
+func WithShutdownTimeout(timeout time.Duration) ServeOption {
+	return func(o *serveOptions) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+// Path of the readiness probe, DefaultReadinessPath if not set
+func WithReadinessPath(path string) ServeOption {
+	return func(o *serveOptions) {
+		o.readinessPath = path
+	}
+}
+
+// Serve the RFC 8414 metadata of issuer as well
+func WithServedMetadata(issuer string, opts MetadataOptions) ServeOption {
+	return func(o *serveOptions) {
+		o.metadataIssuer = issuer
+		o.metadataOpts = opts
+	}
+}
+
+// Call fn with the address listened on, e.g. to learn the port picked for ":0"
+func OnListening(fn func(addr net.Addr)) ServeOption {
+	return func(o *serveOptions) {
+		o.onListening = fn
+	}
+}
+
+// ServeJWKS serves the key set on addr, for programs which do not use gin
+// themselves, until ctx is cancelled. The requests in flight are then given
+// the shutdown timeout to complete. The readiness probe answers 503 as long
+// as Ready does not return nil.
+func (c *Config) ServeJWKS(ctx context.Context, addr string, opts ...ServeOption) error {
+	o := serveOptions{
+		shutdownTimeout: defaultShutdownTimeout,
+		readinessPath:   DefaultReadinessPath,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	handler, err := c.serveHandler(o)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+	useTLS := o.certFile != "" || o.getCertificate != nil
+	if useTLS {
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: o.getCertificate,
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s %v", addr, err)
+	}
+	if o.onListening != nil {
+		o.onListening(listener.Addr())
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if useTLS {
+			served <- server.ServeTLS(listener, o.certFile, o.keyFile)
+		} else {
+			served <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err = <-served:
+		return fmt.Errorf("cannot serve the key set %v", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("cannot shut down the key set server %v", err)
+	}
+	if err = <-served; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("cannot serve the key set %v", err)
+	}
+	return nil
+}
+
+// Routes of ServeJWKS
+func (c *Config) serveHandler(o serveOptions) (http.Handler, error) {
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+	var routeOpts []RouteOption
+	if o.metadataIssuer != "" {
+		routeOpts = append(routeOpts, WithAuthorizationServerMetadata(o.metadataIssuer, o.metadataOpts))
+	}
+	if err := RegisterJWKS(engine, c, routeOpts...); err != nil {
+		return nil, err
+	}
+	engine.GET(o.readinessPath, func(ctx *gin.Context) {
+		if err := c.Ready(); err != nil {
+			ctx.Header("Cache-Control", "no-store")
+			ctx.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		ctx.Status(http.StatusOK)
+	})
+	return engine, nil
+}
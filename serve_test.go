@@ -0,0 +1,88 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeJWKSOnAPickedPort(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listening := make(chan net.Addr, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- config.ServeJWKS(ctx, "127.0.0.1:0",
+			OnListening(func(addr net.Addr) { listening <- addr }),
+			WithServedMetadata("https://issuer.example.com", MetadataOptions{}),
+			WithShutdownTimeout(time.Second))
+	}()
+	var base string
+	select {
+	case addr := <-listening:
+		base = "http://" + addr.String()
+	case err := <-served:
+		t.Fatalf("ServeJWKS returned %v", err)
+	}
+
+	get := func(path string) []byte {
+		t.Helper()
+		res, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s answered %d", path, res.StatusCode)
+		}
+		return body
+	}
+	set, err := jwk.Parse(get(DefaultJwksPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set.LookupKeyID("test"); !ok || set.Len() != 1 {
+		t.Fatalf("served key set holds %d keys", set.Len())
+	}
+	get(DefaultReadinessPath)
+	var metadata map[string]interface{}
+	if err = json.Unmarshal(get("/.well-known/oauth-authorization-server"), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata["jwks_uri"] != "https://issuer.example.com"+DefaultJwksPath {
+		t.Fatalf("metadata advertises %v", metadata["jwks_uri"])
+	}
+
+	cancel()
+	select {
+	case err = <-served:
+		if err != nil {
+			t.Fatalf("shutdown returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not shut down")
+	}
+}
+
+func TestServeJWKSReturnsListenErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	config := newTestConfig(t, NewConfigBuilder())
+	if err = config.ServeJWKS(context.Background(), listener.Addr().String()); err == nil {
+		t.Fatal("listening on a port in use succeeded")
+	}
+}
@@ -0,0 +1,112 @@
+// Package session keeps the verified token of a request in a gin session, so
+// a browser app only presents its token once. It plugs into the Verify and
+// VerifyRemote middlewares, handlers read the claims with ClaimsFromContext as
+// for a Bearer token. It works with gin-contrib/sessions without depending on
+// it: wrap sessions.Default to get a Store.
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	gin_jwks_rsa "github.com/v4lproik/gin-jwks-rsa"
+	"time"
+)
+
+// session key used when WithSessionStore is given an empty one
+const DefaultSessionKey = "gin-jwks-token"
+
+// Session is the part of gin-contrib/sessions.Session used here
+type Session interface {
+	Get(key interface{}) interface{}
+	Set(key interface{}, val interface{})
+	Delete(key interface{})
+	Save() error
+}
+
+// Store returns the session of a request, e.g.
+// func(c *gin.Context) session.Session { return sessions.Default(c) }
+type Store func(c *gin.Context) Session
+
+// Session entry, stored as JSON so cookie stores need no gob registration
+type entry struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type tokenSession struct {
+	store Store
+	key   string
+	now   func() time.Time
+}
+
+// WithSessionStore makes the verifier write each verified token to the session
+// under sessionKey, DefaultSessionKey if empty. Tokens without exp are not
+// kept, the session would not know when to end.
+func WithSessionStore(store Store, sessionKey string) gin_jwks_rsa.VerifierOption {
+	if sessionKey == "" {
+		sessionKey = DefaultSessionKey
+	}
+	return gin_jwks_rsa.WithTokenSession(&tokenSession{store: store, key: sessionKey, now: time.Now})
+}
+
+// RequireSessionOrToken middleware accepting a Bearer token or a session
+// holding an unexpired token, opts must hold WithSessionStore. A Bearer token
+// wins over the session and replaces its token. The kept token is verified
+// again on each request, it ends with its exp or when its key leaves the key
+// set.
+func RequireSessionOrToken(config gin_jwks_rsa.Config, opts ...gin_jwks_rsa.VerifierOption) gin.HandlerFunc {
+	opts = append(opts[:len(opts):len(opts)], gin_jwks_rsa.WithTokenSessionLookup())
+	return gin_jwks_rsa.Verify(config, opts...)
+}
+
+// ClearSession removes the token from the session, for logout. It must run
+// behind a verifier given WithSessionStore.
+func ClearSession(c *gin.Context) error {
+	return gin_jwks_rsa.ClearTokenSession(c)
+}
+
+func (s *tokenSession) Load(c *gin.Context) (string, bool) {
+	data, ok := s.store(c).Get(s.key).(string)
+	if !ok {
+		return "", false
+	}
+	var e entry
+	if err := json.Unmarshal([]byte(data), &e); err != nil || e.Token == "" {
+		return "", false
+	}
+	// spares the verification of a token known to be expired, it fails anyway
+	if !s.now().Before(time.Unix(e.ExpiresAt, 0)) {
+		if err := s.Clear(c); err != nil {
+			c.Error(err)
+		}
+		return "", false
+	}
+	return e.Token, true
+}
+
+func (s *tokenSession) Save(c *gin.Context, token string, parsed jwt.Token) error {
+	if parsed.Expiration().IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(entry{Token: token, ExpiresAt: parsed.Expiration().Unix()})
+	if err != nil {
+		return fmt.Errorf("cannot serialize session token %v", err)
+	}
+	session := s.store(c)
+	session.Set(s.key, string(data))
+	if err = session.Save(); err != nil {
+		return fmt.Errorf("cannot save session %v", err)
+	}
+	return nil
+}
+
+func (s *tokenSession) Clear(c *gin.Context) error {
+	session := s.store(c)
+	session.Delete(s.key)
+	if err := session.Save(); err != nil {
+		return fmt.Errorf("cannot save session %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	gin_jwks_rsa "github.com/v4lproik/gin-jwks-rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Session of a single client, kept across its requests
+type memorySession struct {
+	values map[interface{}]interface{}
+	saves  int
+}
+
+func (s *memorySession) Get(key interface{}) interface{}      { return s.values[key] }
+func (s *memorySession) Set(key interface{}, val interface{}) { s.values[key] = val }
+func (s *memorySession) Delete(key interface{})               { delete(s.values, key) }
+func (s *memorySession) Save() error {
+	s.saves++
+	return nil
+}
+
+type sessionTest struct {
+	t       *testing.T
+	router  *gin.Engine
+	session *memorySession
+	signer  *gin_jwks_rsa.Signer
+}
+
+func newSessionTest(t *testing.T) *sessionTest {
+	gin.SetMode(gin.TestMode)
+	config, err := gin_jwks_rsa.NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).WithKeyId("test").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.Close() })
+
+	st := &sessionTest{
+		t:       t,
+		router:  gin.New(),
+		session: &memorySession{values: map[interface{}]interface{}{}},
+		signer:  config.Signer(),
+	}
+	store := WithSessionStore(func(*gin.Context) Session { return st.session }, "")
+	me := func(c *gin.Context) {
+		token, ok := gin_jwks_rsa.ClaimsFromContext(c)
+		if !ok {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, token.Subject())
+	}
+	st.router.GET("/me", RequireSessionOrToken(*config, store), me)
+	st.router.GET("/token-only", gin_jwks_rsa.Verify(*config, store), me)
+	st.router.POST("/logout", RequireSessionOrToken(*config, store), func(c *gin.Context) {
+		if err := ClearSession(c); err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if _, ok := gin_jwks_rsa.ClaimsFromContext(c); ok {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	return st
+}
+
+func (st *sessionTest) token(claims map[string]interface{}) string {
+	token, err := st.signer.Sign(claims)
+	if err != nil {
+		st.t.Fatal(err)
+	}
+	return token
+}
+
+func (st *sessionTest) request(method, target, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	st.router.ServeHTTP(w, r)
+	return w
+}
+
+func (st *sessionTest) expect(w *httptest.ResponseRecorder, status int, body string) {
+	st.t.Helper()
+	if w.Code != status || (body != "" && w.Body.String() != body) {
+		st.t.Fatalf("got %d %q, expected %d %q", w.Code, w.Body.String(), status, body)
+	}
+}
+
+func TestSessionHit(t *testing.T) {
+	st := newSessionTest(t)
+	st.expect(st.request(http.MethodGet, "/me", ""), http.StatusUnauthorized, "")
+
+	token := st.token(map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour)})
+	st.expect(st.request(http.MethodGet, "/me", token), http.StatusOK, "alice")
+	// the token is not presented again
+	st.expect(st.request(http.MethodGet, "/me", ""), http.StatusOK, "alice")
+
+	// without the lookup the verifier keeps the token but still asks for one
+	st.expect(st.request(http.MethodGet, "/token-only", ""), http.StatusUnauthorized, "")
+}
+
+func TestSessionTokenFallback(t *testing.T) {
+	st := newSessionTest(t)
+	alice := st.token(map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour)})
+	st.expect(st.request(http.MethodGet, "/me", alice), http.StatusOK, "alice")
+
+	// a Bearer token wins over the session and replaces its token
+	bob := st.token(map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour)})
+	st.expect(st.request(http.MethodGet, "/me", bob), http.StatusOK, "bob")
+	st.expect(st.request(http.MethodGet, "/me", ""), http.StatusOK, "bob")
+
+	// an invalid Bearer token is refused even with a session
+	st.expect(st.request(http.MethodGet, "/me", alice+"x"), http.StatusUnauthorized, "")
+
+	// tokens without exp are verified but not kept
+	st = newSessionTest(t)
+	st.expect(st.request(http.MethodGet, "/me", st.token(map[string]interface{}{"sub": "carol"})), http.StatusOK, "carol")
+	st.expect(st.request(http.MethodGet, "/me", ""), http.StatusUnauthorized, "")
+}
+
+func TestClearSession(t *testing.T) {
+	st := newSessionTest(t)
+	token := st.token(map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour)})
+	st.expect(st.request(http.MethodGet, "/me", token), http.StatusOK, "alice")
+
+	st.expect(st.request(http.MethodPost, "/logout", ""), http.StatusNoContent, "")
+	if _, ok := st.session.values[DefaultSessionKey]; ok {
+		t.Fatal("token still in the session")
+	}
+	st.expect(st.request(http.MethodGet, "/me", ""), http.StatusUnauthorized, "")
+
+	// without a session store on the route there is nothing to clear
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		if err := ClearSession(c); err != gin_jwks_rsa.ErrNoTokenSession {
+			t.Errorf("cleared %v", err)
+		}
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestExpiredSession(t *testing.T) {
+	st := newSessionTest(t)
+	for name, exp := range map[string]time.Time{
+		// known expired from the entry alone
+		"entry": time.Now().Add(-time.Minute),
+		// the entry claims more than the token holds, the token is verified again
+		"token": time.Now().Add(time.Hour),
+	} {
+		token := st.token(map[string]interface{}{"sub": "alice", "exp": time.Now().Add(-time.Minute)})
+		data, err := json.Marshal(entry{Token: token, ExpiresAt: exp.Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.session.values[DefaultSessionKey] = string(data)
+
+		st.expect(st.request(http.MethodGet, "/me", ""), http.StatusUnauthorized, "")
+		if _, ok := st.session.values[DefaultSessionKey]; ok {
+			t.Fatalf("%s: expired token left in the session", name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package gin_jwks_rsa
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings a running config can change, e.g. from WatchConfigFile. Jkws and
+// the other handlers take the config by value, the copies share them.
+type runtimeSettings struct {
+	// nanoseconds, first so the 64-bit atomic operations are aligned
+	cacheMaxAge   int64
+	rotationGrace int64
+	autoRotation  int64
+	// 1 when set
+	publishLifecycle uint32
+	publishAliases   uint32
+	// background work started once a built config needs it
+	autoRotationStarted sync.Once
+	pruningStarted      sync.Once
+	// wakes the scheduled rotations up when their interval changes
+	autoRotationChanged chan struct{}
+}
+
+func newRuntimeSettings() *runtimeSettings {
+	return &runtimeSettings{
+		cacheMaxAge:         int64(DefaultCacheMaxAge),
+		autoRotationChanged: make(chan struct{}, 1),
+	}
+}
+
+func (c *Config) cacheMaxAge() time.Duration {
+	if c.settings == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&c.settings.cacheMaxAge))
+}
+
+// Change the max-age of the key set responses, the next answer uses it
+func (c *Config) setCacheMaxAge(maxAge time.Duration) {
+	atomic.StoreInt64(&c.settings.cacheMaxAge, int64(maxAge))
+}
+
+func (c *Config) rotationGrace() time.Duration {
+	if c.settings == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&c.settings.rotationGrace))
+}
+
+// Change the grace period of the next rotations, the keys already retired
+// keep theirs
+func (c *Config) setRotationGrace(grace time.Duration) {
+	atomic.StoreInt64(&c.settings.rotationGrace, int64(grace))
+	if grace > 0 {
+		c.startRetiredKeyPruning()
+	}
+}
+
+// Interval of the scheduled rotations, none if zero
+func (c *Config) autoRotation() time.Duration {
+	if c.settings == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&c.settings.autoRotation))
+}
+
+// Change the interval of the scheduled rotations, counted from now, zero
+// stops them
+func (c *Config) setAutoRotation(every time.Duration) {
+	atomic.StoreInt64(&c.settings.autoRotation, int64(every))
+	if every > 0 {
+		c.startAutoRotation()
+	}
+	select {
+	case c.settings.autoRotationChanged <- struct{}{}:
+	default:
+		// a change is already pending, the scheduler will read the latest interval
+	}
+}
+
+func (c *Config) publishesLifecycle() bool {
+	return c.settings != nil && atomic.LoadUint32(&c.settings.publishLifecycle) == 1
+}
+
+// Publish the key lifecycle timestamps or stop publishing them
+func (c *Config) setPublishLifecycle(publish bool) {
+	if storeFlag(&c.settings.publishLifecycle, publish) {
+		c.keySetChanged()
+	}
+}
+
+func (c *Config) publishesAliases() bool {
+	return c.settings != nil && atomic.LoadUint32(&c.settings.publishAliases) == 1
+}
+
+// Publish the aliases of the signing key or stop publishing them
+func (c *Config) setPublishAliases(publish bool) {
+	if storeFlag(&c.settings.publishAliases, publish) {
+		c.keySetChanged()
+	}
+}
+
+// Store value in flag, and report whether it changed
+func storeFlag(flag *uint32, value bool) bool {
+	var v uint32
+	if value {
+		v = 1
+	}
+	return atomic.SwapUint32(flag, v) != v
+}
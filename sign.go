@@ -0,0 +1,144 @@
+package gin_jwks_rsa
+
+import (
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Snapshot of the signing key, refused once the key set withholds it: what it
+// signs then verifies with none of the served keys
+func (c *Config) signingSnapshot() (*activeKey, error) {
+	active := c.active()
+	if active == nil {
+		return nil, fmt.Errorf("private key cannot be nil")
+	}
+	if c.keyDropped() {
+		return nil, fmt.Errorf("%w, the certificate of the key is revoked", ErrNoServableKey)
+	}
+	if c.keyExpired(active, c.keys.now()) {
+		return nil, fmt.Errorf("%w, the key expired", ErrNoServableKey)
+	}
+	return active, nil
+}
+
+// Sign a token with the private key, the kid header is the one published in the key set
+func (c *Config) signToken(token jwt.Token) ([]byte, error) {
+	active, err := c.signingSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	alg, err := signatureAlgorithm(active.key)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed []byte
+	err = active.withPrivateKey(func(key jwk.Key) (err error) {
+		if err = active.checkSigningKey(key); err != nil {
+			return err
+		}
+		signingKey, headers, err := active.signingKey(key)
+		if err != nil {
+			return err
+		}
+		signed, err = jwt.Sign(token, jwt.WithKey(alg, signingKey, jws.WithProtectedHeaders(headers)))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign token %v", err)
+	}
+
+	return signed, nil
+}
+
+// SignBytes signs data as is with the config key and returns the signature
+// with its JWS algorithm and the kid. It serves formats reusing the JWS
+// signature encodings, such as COSE or HTTP message signatures.
+func (c *Config) SignBytes(data []byte) ([]byte, jwa.SignatureAlgorithm, string, error) {
+	active, err := c.signingSnapshot()
+	if err != nil {
+		return nil, "", "", err
+	}
+	alg, err := signatureAlgorithm(active.key)
+	if err != nil {
+		return nil, "", "", err
+	}
+	signature, err := active.signBytes(alg, data)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return signature, alg, active.key.KeyID(), nil
+}
+
+// Sign data with alg, callers get alg from the same snapshot so a rotation
+// cannot mix the algorithm or kid of one key with the signature of another
+func (a *activeKey) signBytes(alg jwa.SignatureAlgorithm, data []byte) ([]byte, error) {
+	signer, err := jws.NewSigner(alg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create signer %v", err)
+	}
+
+	var signature []byte
+	err = a.withPrivateKey(func(key jwk.Key) error {
+		if err := a.checkSigningKey(key); err != nil {
+			return err
+		}
+		signingKey, _, err := a.signingKey(key)
+		if err != nil {
+			return err
+		}
+		signature, err = signer.Sign(data, signingKey)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign %v", err)
+	}
+
+	return signature, nil
+}
+
+// VerificationKeys returns the public keys verifying what the config signs:
+// the signing key with its alg, under its kid and each of its aliases
+func (c *Config) VerificationKeys() (jwk.Set, error) {
+	return c.verificationKeySet()
+}
+
+// Refuse to sign with a public key or a key published for encryption, the
+// public key of a provider signs through its signer
+func (a *activeKey) checkSigningKey(key jwk.Key) error {
+	if isSignaturePublicKey(key) && a.signer == nil {
+		return fmt.Errorf("a config serving a public key only cannot sign")
+	}
+	if key.KeyUsage() == KeyUsageAsEncryption {
+		return fmt.Errorf("a %s key cannot be used for signing", keyDescription(key))
+	}
+	return nil
+}
+
+// Signature algorithm of the key: the one of its curve for an EC key, EdDSA
+// for an Ed25519 key, RS256 unless it carries another RSA one
+func signatureAlgorithm(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	if curve, ok := ecCurve(key); ok {
+		return ecSignatureAlgorithm(key, curve)
+	}
+	if isEd25519PrivateKey(key) || isEd25519PublicKey(key) {
+		return ed25519SignatureAlgorithm(key)
+	}
+
+	var alg jwa.SignatureAlgorithm
+	if keyAlg := key.Algorithm(); keyAlg != nil {
+		alg = jwa.SignatureAlgorithm(keyAlg.String())
+	}
+
+	switch alg {
+	case "":
+		return jwa.RS256, nil
+	case jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("algorithm %q cannot be used with an RSA key", alg)
+	}
+}
@@ -0,0 +1,184 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// media type of a compact JWS, RFC 7515 section 9.2
+	JOSEContentType = "application/jose"
+	// media type of a JSON JWS
+	JOSEJSONContentType = "application/jose+json"
+	// cty header of a signed key set
+	signedJWKSContentType = "jwk-set+json"
+)
+
+type signedJWKS struct {
+	json bool
+}
+
+type SignedJWKSOption func(*signedJWKS)
+
+// Use the JSON serialization of RFC 7515 rather than the compact one
+func SignedJWKSJSON() SignedJWKSOption {
+	return func(s *signedJWKS) {
+		s.json = true
+	}
+}
+
+// SignedJWKS the key set served by Jkws, signed by the key of signer as a JWS
+// whose cty header is jwk-set+json. signer may be c itself or a config
+// holding a dedicated metadata signing key.
+func (c *Config) SignedJWKS(signer *Config, opts ...SignedJWKSOption) ([]byte, error) {
+	var s signedJWKS
+	for _, opt := range opts {
+		opt(&s)
+	}
+	body, _, err := c.servedJWKS()
+	if err != nil {
+		return nil, err
+	}
+	signed, _, err := signer.signKeySet(body, s.json)
+	return signed, err
+}
+
+// Sign the serialized key set with the active key, returned along with it so
+// a handler can tell when the signature is stale
+func (c *Config) signKeySet(body []byte, json bool) ([]byte, *activeKey, error) {
+	if c.symmetricKey != nil {
+		return nil, nil, fmt.Errorf("cannot sign the key set, %v", ErrSymmetricKey)
+	}
+	active, err := c.signingSnapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	alg, err := signatureAlgorithm(active.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var signed []byte
+	err = active.withPrivateKey(func(key jwk.Key) error {
+		if err := active.checkSigningKey(key); err != nil {
+			return err
+		}
+		signingKey, headers, err := active.signingKey(key)
+		if err != nil {
+			return err
+		}
+		if err = headers.Set(jws.ContentTypeKey, signedJWKSContentType); err != nil {
+			return fmt.Errorf("cannot set cty header %v", err)
+		}
+		options := []jws.SignOption{jws.WithKey(alg, signingKey, jws.WithProtectedHeaders(headers))}
+		if json {
+			options = append(options, jws.WithJSON())
+		}
+		signed, err = jws.Sign(body, options...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot sign the key set %v", err)
+	}
+
+	return signed, active, nil
+}
+
+// SignedJkws serves the key set of config signed by the key of signer, see
+// SignedJWKS. It answers the way Jkws does, the signature being computed
+// again only when the key set or the key of signer changed. Jkws keeps serving
+// the unsigned key set. Like Jkws, it panics on a config holding a symmetric
+// key, where RegisterJWKS returns ErrSymmetricKey.
+func SignedJkws(config Config, signer *Config, opts ...SignedJWKSOption) gin.HandlerFunc {
+	if config.symmetricKey != nil {
+		panic(fmt.Sprintf("gin-jwks: %v, the config only verifies tokens", ErrSymmetricKey))
+	}
+	var s signedJWKS
+	for _, opt := range opts {
+		opt(&s)
+	}
+	contentType := JOSEContentType
+	if s.json {
+		contentType = JOSEJSONContentType
+	}
+
+	var mu sync.Mutex
+	var cachedETag string
+	var cachedKey *activeKey
+	var cachedBody []byte
+	signedBody := func(body []byte, etag string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cachedBody != nil && cachedETag == etag && cachedKey == signer.active() {
+			return cachedBody, nil
+		}
+		signed, active, err := signer.signKeySet(body, s.json)
+		if err != nil {
+			return nil, err
+		}
+		cachedETag, cachedKey, cachedBody = etag, active, signed
+		return signed, nil
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		defer config.observe(func(i instrumentation) {
+			i.requestServed(c.Writer.Status())
+		})
+		defer config.served(c, start)
+		if config.handleCORS(c) {
+			return
+		}
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Allow", jwksAllowedMethods)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if !config.AuthorizeEndpoint(c) {
+			return
+		}
+
+		body, etag, err := config.servedJWKS()
+		if errors.Is(err, ErrNoServableKey) {
+			c.Error(err)
+			serveNoKey(c, &config, err)
+			return
+		}
+		if err == nil {
+			body, err = signedBody(body, etag)
+		}
+		if err != nil {
+			c.Error(err)
+			config.reportError(err)
+			config.abortWithJSON(c, http.StatusInternalServerError, &OAuthError{
+				Code:        OAuthErrorServerError,
+				Description: "the key set cannot be built",
+			})
+			return
+		}
+
+		// an EC signature differs each time, tag the signed bytes
+		etag = jwksETag(body)
+		c.Header("ETag", etag)
+		config.setCacheHeaders(c)
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.Header("Content-Length", strconv.Itoa(len(body)))
+		if c.Request.Method == http.MethodHead {
+			c.Header("Content-Type", contentType)
+			c.Status(http.StatusOK)
+			return
+		}
+		c.Data(http.StatusOK, contentType, body)
+	}
+}
@@ -0,0 +1,147 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// EC config signing metadata, its signatures differ each time
+func newSignerConfig(t *testing.T) *Config {
+	t.Helper()
+	signer, err := NewConfigBuilder().NewPrivateKey().WithKeyType(jwa.EC).WithCurve(jwa.P256).WithKeyId("signer").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { signer.Close() })
+	return signer
+}
+
+// Payload of a signed key set, checked against the keys of signer and its cty
+func verifySignedJWKS(t *testing.T, signed []byte, signer *Config) []byte {
+	t.Helper()
+	keys, err := signer.VerificationKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := jws.Verify(signed, jws.WithKeySet(keys))
+	if err != nil {
+		t.Fatalf("signed key set does not verify: %v", err)
+	}
+	msg, err := jws.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cty := msg.Signatures()[0].ProtectedHeaders().ContentType(); cty != signedJWKSContentType {
+		t.Fatalf("cty header %q", cty)
+	}
+	return payload
+}
+
+func TestSignedJWKSVerifiesAgainstTheSigner(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	body, err := config.MarshalJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, signer := range map[string]*Config{"itself": config, "metadata key": newSignerConfig(t)} {
+		for _, opts := range [][]SignedJWKSOption{nil, {SignedJWKSJSON()}} {
+			signed, err := config.SignedJWKS(signer, opts...)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if payload := verifySignedJWKS(t, signed, signer); !bytes.Equal(payload, body) {
+				t.Fatalf("%s: signed %s, expected the served key set", name, payload)
+			}
+		}
+	}
+
+	// the key set of config does not verify a key set signed by another key
+	signed, err := config.SignedJWKS(newSignerConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := config.VerificationKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jws.Verify(signed, jws.WithKeySet(keys)); err == nil {
+		t.Fatal("verified against the wrong key")
+	}
+}
+
+func TestSignedJkwsContentType(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	for contentType, opts := range map[string][]SignedJWKSOption{
+		JOSEContentType:     nil,
+		JOSEJSONContentType: {SignedJWKSJSON()},
+	} {
+		w := serveJWKS(SignedJkws(*config, config, opts...))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentType {
+			t.Fatalf("got %d %s, expected %s", w.Code, w.Header().Get("Content-Type"), contentType)
+		}
+		verifySignedJWKS(t, w.Body.Bytes(), config)
+		if isJSON := strings.HasPrefix(w.Body.String(), "{"); isJSON != (contentType == JOSEJSONContentType) {
+			t.Fatalf("%s body %s", contentType, w.Body)
+		}
+	}
+}
+
+func TestSignedJkwsSignsAgainAfterRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := newTestConfig(t, NewConfigBuilder())
+	signer := newSignerConfig(t)
+	r := gin.New()
+	r.GET("/jwks", SignedJkws(*config, signer))
+	get := func(etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/jwks", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("")
+	verifySignedJWKS(t, first.Body.Bytes(), signer)
+	// an EC signature differs each time, the same bytes come from the cache
+	if second := get(""); !bytes.Equal(second.Body.Bytes(), first.Body.Bytes()) {
+		t.Fatal("the key set was signed again without a change")
+	}
+	if w := get(first.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Fatalf("cached signed key set answered %d to its ETag", w.Code)
+	}
+
+	// a rotation of the served keys
+	if err := config.Rotate("rotated"); err != nil {
+		t.Fatal(err)
+	}
+	rotated := get(first.Header().Get("ETag"))
+	if rotated.Code != http.StatusOK {
+		t.Fatalf("got %d after the rotation", rotated.Code)
+	}
+	if payload := verifySignedJWKS(t, rotated.Body.Bytes(), signer); !strings.Contains(string(payload), `"rotated"`) {
+		t.Fatalf("signed key set without the rotated key %s", payload)
+	}
+
+	// a rotation of the signing key
+	if err := signer.Rotate("signer-2"); err != nil {
+		t.Fatal(err)
+	}
+	resigned := get("")
+	msg, err := jws.Parse(resigned.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := msg.Signatures()[0].ProtectedHeaders().KeyID(); kid != "signer-2" {
+		t.Fatalf("signed by %q after the signer rotation", kid)
+	}
+	verifySignedJWKS(t, resigned.Body.Bytes(), signer)
+}
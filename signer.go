@@ -0,0 +1,74 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// gin context key holding the signer of the request
+const SignerContextKey = "gin-jwks-signer"
+
+// ErrNoSigner is returned by SignerFromContext on a route without WithSigner
+var ErrNoSigner = errors.New("no signer on this route, add the WithSigner middleware")
+
+// Signer signs tokens with the key of a config. The kid header and the
+// algorithm are the ones the key set publishes, and it follows rotations:
+// each token is signed by the key active at the time.
+type Signer struct {
+	config *Config
+}
+
+// Signer returns a token signer using the signing key of the config
+func (c *Config) Signer() *Signer {
+	return &Signer{config: c}
+}
+
+// Sign a token carrying claims, e.g. {"sub": "me", "exp": time.Now().Add(time.Hour)}
+func (s *Signer) Sign(claims map[string]interface{}) (string, error) {
+	token := jwt.New()
+	for name, value := range claims {
+		if err := token.Set(name, value); err != nil {
+			return "", fmt.Errorf("cannot set claim %q %v", name, err)
+		}
+	}
+	return s.SignToken(token)
+}
+
+// SignToken signs a token built with jwx
+func (s *Signer) SignToken(token jwt.Token) (string, error) {
+	if token == nil {
+		return "", fmt.Errorf("token cannot be nil")
+	}
+	signed, err := s.config.signToken(token)
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// WithSigner middleware making the signer of config available to the handlers
+// through SignerFromContext. The signer follows rotations, handlers never hold
+// a stale key.
+func WithSigner(config Config) gin.HandlerFunc {
+	signer := config.Signer()
+	return func(c *gin.Context) {
+		c.Set(SignerContextKey, signer)
+		c.Next()
+	}
+}
+
+// SignerFromContext returns the signer set by WithSigner, ErrNoSigner when
+// the middleware is not installed
+func SignerFromContext(c *gin.Context) (*Signer, error) {
+	v, ok := c.Get(SignerContextKey)
+	if !ok {
+		return nil, ErrNoSigner
+	}
+	signer, ok := v.(*Signer)
+	if !ok {
+		return nil, ErrNoSigner
+	}
+	return signer, nil
+}
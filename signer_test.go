@@ -0,0 +1,75 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignerFromContext(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	sign := func(c *gin.Context) {
+		signer, err := SignerFromContext(c)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		token, err := signer.Sign(map[string]interface{}{"sub": "me"})
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.String(http.StatusOK, token)
+	}
+	r := gin.New()
+	r.GET("/signed", WithSigner(*config), sign)
+	r.GET("/unsigned", sign)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/unsigned")
+	if w.Code != http.StatusInternalServerError || w.Body.String() != ErrNoSigner.Error() {
+		t.Fatalf("route without WithSigner answered %d %q", w.Code, w.Body)
+	}
+
+	for _, kid := range []string{"test", "next"} {
+		if kid != "test" {
+			// the middleware holds a copy of the config, the rotation reaches it
+			if err := config.Rotate(kid); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w = get("/signed")
+		if w.Code != http.StatusOK {
+			t.Fatalf("signing route answered %d %q", w.Code, w.Body)
+		}
+		msg, err := jws.Parse(w.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Signatures()[0].ProtectedHeaders().KeyID(); got != kid {
+			t.Fatalf("token signed by kid %q, expected %q", got, kid)
+		}
+		token, err := jwt.Parse(w.Body.Bytes(), jwt.WithKeySet(servedKeySet(t, config)))
+		if err != nil {
+			t.Fatalf("token refused by the served key set: %v", err)
+		}
+		if token.Subject() != "me" {
+			t.Fatalf("token subject %q", token.Subject())
+		}
+	}
+
+	// a value of another type under the key is not a signer
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(SignerContextKey, "signer")
+	if _, err := SignerFromContext(c); !errors.Is(err, ErrNoSigner) {
+		t.Fatalf("foreign context value gave %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// ErrSymmetricKey is returned when a config holding a shared secret is asked
+// for its key set, the secret is never published
+var ErrSymmetricKey = errors.New("a symmetric key cannot be published")
+
+// Import symmetric key facet of the config builder, for verification only
+type ConfigImportSymmetricKeyBuilder struct {
+	ConfigBuilder
+}
+
+// Shared secret of the HS256, HS384 or HS512 tokens a config verifies
+type ImportSymmetricKeyOptions struct {
+	secret []byte
+	keyId  string
+	alg    jwa.SignatureAlgorithm
+}
+
+// Verify the HMAC tokens of a service sharing a secret. Such a config has no
+// key set: Jkws refuses it and it cannot sign, only Verify and the other
+// verification helpers use it.
+func (n *ConfigBuilder) ImportSymmetricKey() *ConfigImportSymmetricKeyBuilder {
+	return &ConfigImportSymmetricKeyBuilder{*n}
+}
+
+// Initiate the import symmetric key opts obj if nil
+func (n *ConfigImportSymmetricKeyBuilder) initiateImportSymmetricOptsIfNil() {
+	if n.config.symmetricOpts == nil {
+		n.config.symmetricOpts = &ImportSymmetricKeyOptions{}
+	}
+}
+
+// Shared secret, at least as long as the hash of the algorithm (RFC 7518
+// section 3.2). It is copied, wipe it once Build returned.
+func (n *ConfigImportSymmetricKeyBuilder) WithSecret(secret []byte) *ConfigImportSymmetricKeyBuilder {
+	n.initiateImportSymmetricOptsIfNil()
+	n.config.symmetricOpts.secret = append([]byte{}, secret...)
+	return n
+}
+
+// kid the tokens name the secret with, tokens without kid are accepted if not set
+func (n *ConfigImportSymmetricKeyBuilder) WithKeyId(keyId string) *ConfigImportSymmetricKeyBuilder {
+	n.initiateImportSymmetricOptsIfNil()
+	n.config.symmetricOpts.keyId = keyId
+	return n
+}
+
+// HS256 (the default), HS384 or HS512, tokens with another alg are refused
+func (n *ConfigImportSymmetricKeyBuilder) WithAlgorithm(alg jwa.SignatureAlgorithm) *ConfigImportSymmetricKeyBuilder {
+	n.initiateImportSymmetricOptsIfNil()
+	n.config.symmetricOpts.alg = alg
+	return n
+}
+
+// Check the algorithm and the length of the secret
+func checkSymmetricKeyOptions(opts ImportSymmetricKeyOptions) error {
+	var minLength int
+	switch opts.alg {
+	case "", jwa.HS256:
+		minLength = 32
+	case jwa.HS384:
+		minLength = 48
+	case jwa.HS512:
+		minLength = 64
+	default:
+		return fmt.Errorf("algorithm %q cannot be used with a symmetric key", opts.alg)
+	}
+	if len(opts.secret) == 0 {
+		return fmt.Errorf("symmetric key secret cannot be empty, see WithSecret")
+	}
+	if len(opts.secret) < minLength {
+		return fmt.Errorf("symmetric key secret of %d bytes is shorter than the %d bytes of the hash", len(opts.secret), minLength)
+	}
+	return nil
+}
+
+// Build a verification-only config around the secret, nothing goes to the key ring
+func (b *ConfigBuilder) buildSymmetric() (*Config, error) {
+	if b.config.sealKey != nil || b.config.encKeyBits != 0 || b.config.selfTest {
+		return nil, fmt.Errorf("a symmetric key cannot be sealed, self-tested or come with an encryption key")
+	}
+	opts := b.config.symmetricOpts
+	alg := opts.alg
+	if alg == "" {
+		alg = jwa.HS256
+	}
+
+	key, err := jwk.FromRaw(opts.secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create symmetric key %v", err)
+	}
+	members := map[string]interface{}{
+		jwk.AlgorithmKey: alg,
+		jwk.KeyUsageKey:  KeyUsageAsSignature,
+	}
+	if opts.keyId != "" {
+		members[jwk.KeyIDKey] = opts.keyId
+	}
+	for k, v := range members {
+		if err = key.Set(k, v); err != nil {
+			return nil, fmt.Errorf("cannot set %q on the symmetric key %v", k, err)
+		}
+	}
+	b.config.symmetricKey = key
+
+	return b.config, nil
+}
+
+// Key set of a verification-only config: its secret alone
+func (c *Config) symmetricKeySet() (jwk.Set, error) {
+	set := jwk.NewSet()
+	if err := set.AddKey(c.symmetricKey); err != nil {
+		return nil, fmt.Errorf("cannot add symmetric key to the key set %v", err)
+	}
+	return set, nil
+}
@@ -0,0 +1,109 @@
+package gin_jwks_rsa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"testing"
+	"time"
+)
+
+func hmacToken(t *testing.T, secret []byte, alg jwa.SignatureAlgorithm, kid string) string {
+	t.Helper()
+	key, err := jwk.FromRaw(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != "" {
+		if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := jwt.NewBuilder().Expiration(time.Now().Add(time.Hour)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(alg, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestSymmetricKeyVerifies(t *testing.T) {
+	secret := bytes.Repeat([]byte("s"), 64)
+	other := bytes.Repeat([]byte("o"), 64)
+	for _, tt := range []struct {
+		name    string
+		builder *ConfigImportSymmetricKeyBuilder
+		token   string
+		valid   bool
+	}{
+		{"HS256 without kid", NewConfigBuilder().ImportSymmetricKey().WithSecret(secret), hmacToken(t, secret, jwa.HS256, ""), true},
+		{"HS512", NewConfigBuilder().ImportSymmetricKey().WithSecret(secret).WithAlgorithm(jwa.HS512), hmacToken(t, secret, jwa.HS512, ""), true},
+		{"kid named", NewConfigBuilder().ImportSymmetricKey().WithSecret(secret).WithKeyId("shared"), hmacToken(t, secret, jwa.HS256, "shared"), true},
+		{"kid missing", NewConfigBuilder().ImportSymmetricKey().WithSecret(secret).WithKeyId("shared"), hmacToken(t, secret, jwa.HS256, ""), false},
+		{"other secret", NewConfigBuilder().ImportSymmetricKey().WithSecret(secret), hmacToken(t, other, jwa.HS256, ""), false},
+		{"other algorithm", NewConfigBuilder().ImportSymmetricKey().WithSecret(secret), hmacToken(t, secret, jwa.HS384, ""), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer config.Close()
+			_, err = config.Verifier().Verify(context.Background(), tt.token)
+			if (err == nil) != tt.valid {
+				t.Fatalf("got %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestSymmetricKeyIsNeverPublished(t *testing.T) {
+	config, err := NewConfigBuilder().ImportSymmetricKey().WithSecret(bytes.Repeat([]byte("s"), 32)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+
+	if _, err = config.jwksKeys(); !errors.Is(err, ErrSymmetricKey) {
+		t.Fatalf("key set rendering gave %v", err)
+	}
+	if err = RegisterJWKS(gin.New(), config); !errors.Is(err, ErrSymmetricKey) {
+		t.Fatalf("RegisterJWKS gave %v", err)
+	}
+	for name, handler := range map[string]func(){
+		"Jkws":       func() { Jkws(*config) },
+		"SignedJkws": func() { SignedJkws(*config, config) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s accepted a symmetric key", name)
+				}
+			}()
+			handler()
+		}()
+	}
+}
+
+func TestSymmetricKeyOptions(t *testing.T) {
+	for name, builder := range map[string]*ConfigImportSymmetricKeyBuilder{
+		"no secret":          NewConfigBuilder().ImportSymmetricKey().WithKeyId("shared"),
+		"short HS256 secret": NewConfigBuilder().ImportSymmetricKey().WithSecret(bytes.Repeat([]byte("s"), 31)),
+		"short HS512 secret": NewConfigBuilder().ImportSymmetricKey().WithSecret(bytes.Repeat([]byte("s"), 63)).WithAlgorithm(jwa.HS512),
+		"asymmetric alg":     NewConfigBuilder().ImportSymmetricKey().WithSecret(bytes.Repeat([]byte("s"), 32)).WithAlgorithm(jwa.RS256),
+		"along another key":  NewConfigBuilder().NewPrivateKey().WithKeyId("rsa").ImportSymmetricKey().WithSecret(bytes.Repeat([]byte("s"), 32)),
+		"with a self-test":   NewConfigBuilder().WithSelfTest().ImportSymmetricKey().WithSecret(bytes.Repeat([]byte("s"), 32)),
+	} {
+		if config, err := builder.Build(); err == nil {
+			config.Close()
+			t.Fatalf("%s: symmetric key accepted", name)
+		}
+	}
+}
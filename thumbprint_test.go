@@ -0,0 +1,81 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"math/big"
+	"testing"
+)
+
+// RFC 7638 section 3.1
+const (
+	rfc7638N          = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	rfc7638Thumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+)
+
+func TestThumbprintKeyIdMatchesRFC7638(t *testing.T) {
+	key, err := jwk.ParseKey([]byte(`{"kty":"RSA","n":"` + rfc7638N + `","e":"AQAB","alg":"RS256","kid":"2011-04-29"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, err := thumbprintKeyId(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != rfc7638Thumbprint {
+		t.Fatalf("thumbprint %s, want %s", kid, rfc7638Thumbprint)
+	}
+}
+
+// Thumbprint of an RSA key computed from the required members in
+// lexicographic order, as RFC 7638 spells it out
+func rsaThumbprint(t *testing.T, config *Config) string {
+	t.Helper()
+	members := servedKeyMembers(t, config)
+	n, ok := members["n"].(string)
+	if !ok {
+		t.Fatalf("served %v", members)
+	}
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(65537).Bytes())
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, e, n)))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestKidDefaultsToTheThumbprint(t *testing.T) {
+	path := writeTestKey(t, newRSAKey(t))
+	config, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	thumbprint := rsaThumbprint(t, config)
+	if kid := config.active().key.KeyID(); kid != thumbprint {
+		t.Fatalf("kid %s, want the thumbprint %s", kid, thumbprint)
+	}
+
+	given, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).WithKeyId("given").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer given.Close()
+	if kid := given.active().key.KeyID(); kid != "given" {
+		t.Fatalf("kid %s, want the given one", kid)
+	}
+
+	forced, err := NewConfigBuilder().WithThumbprintKeyId().ImportPrivateKey().WithPath(path).WithKeyId("given").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forced.Close()
+	if kid := forced.active().key.KeyID(); kid != thumbprint {
+		t.Fatalf("kid %s, want the thumbprint despite WithKeyId", kid)
+	}
+	if err = forced.Rotate("ignored"); err != nil {
+		t.Fatal(err)
+	}
+	if kid := forced.active().key.KeyID(); kid != rsaThumbprint(t, forced) || kid == thumbprint {
+		t.Fatalf("rotated key published under %s", kid)
+	}
+}
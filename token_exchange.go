@@ -0,0 +1,206 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"time"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+
+	OAuthErrorUnsupportedGrantType = "unsupported_grant_type"
+	OAuthErrorInvalidTarget        = "invalid_target"
+
+	defaultExchangedTokenLifetime = 5 * time.Minute
+)
+
+// Parameters of an RFC 8693 token exchange request
+type TokenExchangeRequest struct {
+	// authenticated client performing the exchange
+	ClientID           string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Audience           []string
+	Resource           []string
+	Scope              string
+}
+
+// What the policy grants for an exchange
+type TokenExchangeGrant struct {
+	// claims of the issued token, iss, iat and exp are set by the handler
+	// and sub defaults to the subject token one. scope is a space separated string
+	Claims map[string]interface{}
+	// impersonation issues a token indistinguishable from the subject one,
+	// delegation (the default) adds an act claim identifying the client
+	Impersonation bool
+	// lifetime of the issued token, 5 minutes if zero
+	ExpiresIn time.Duration
+}
+
+// TokenExchangePolicy narrows the subject token into the issued one, returning an
+// *OAuthError lets it pick the error code (e.g. invalid_target or invalid_scope).
+// Any other error is only logged, the client gets a fixed description.
+type TokenExchangePolicy func(subject jwt.Token, req TokenExchangeRequest) (TokenExchangeGrant, error)
+
+type TokenExchangeOptions struct {
+	// iss of the issued tokens
+	Issuer string
+	// verifies the subject_token, locally or against its remote issuer
+	VerifySubjectToken func(ctx context.Context, token string) (jwt.Token, error)
+	Policy             TokenExchangePolicy
+}
+
+// Successful token exchange response
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// TokenExchange token endpoint handler for the RFC 8693 token exchange grant,
+// the issued token is signed with the config key. It expects the client to be
+// authenticated beforehand, e.g. by RequireClientAssertion.
+func TokenExchange(config Config, opts TokenExchangeOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := config.exchangeToken(c, opts)
+		if err != nil {
+			c.Error(err)
+			if oauthErr, ok := err.(*OAuthError); ok {
+				config.abortWithJSON(c, oauthErr.StatusCode(), oauthErr)
+				return
+			}
+			c.AbortWithStatus(500)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		config.writeJSON(c, http.StatusOK, res)
+	}
+}
+
+func (c *Config) exchangeToken(ctx *gin.Context, opts TokenExchangeOptions) (*TokenExchangeResponse, error) {
+	if opts.VerifySubjectToken == nil || opts.Policy == nil {
+		return nil, fmt.Errorf("token exchange needs a subject token verifier and a policy")
+	}
+
+	if grantType := ctx.PostForm("grant_type"); grantType != GrantTypeTokenExchange {
+		return nil, &OAuthError{Code: OAuthErrorUnsupportedGrantType, Description: "unsupported grant_type"}
+	}
+	if ctx.PostForm("actor_token") != "" || ctx.PostForm("actor_token_type") != "" {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "actor_token is not supported"}
+	}
+	subjectToken := ctx.PostForm("subject_token")
+	subjectTokenType := ctx.PostForm("subject_token_type")
+	if subjectToken == "" || subjectTokenType == "" {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "missing subject_token or subject_token_type"}
+	}
+	if subjectTokenType != TokenTypeAccessToken && subjectTokenType != TokenTypeJWT {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "unsupported subject_token_type"}
+	}
+	requestedTokenType := ctx.PostForm("requested_token_type")
+	if requestedTokenType != "" && requestedTokenType != TokenTypeAccessToken {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "unsupported requested_token_type"}
+	}
+
+	clientID := ctx.GetString(ClientIDContextKey)
+	if clientID == "" {
+		return nil, &OAuthError{Code: OAuthErrorInvalidClient, Description: "client is not authenticated"}
+	}
+
+	subject, err := opts.VerifySubjectToken(ctx.Request.Context(), subjectToken)
+	if err != nil {
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "invalid subject_token", cause: err}
+	}
+
+	req := TokenExchangeRequest{
+		ClientID:           clientID,
+		SubjectTokenType:   subjectTokenType,
+		RequestedTokenType: requestedTokenType,
+		Audience:           ctx.PostFormArray("audience"),
+		Resource:           ctx.PostFormArray("resource"),
+		Scope:              ctx.PostForm("scope"),
+	}
+	grant, err := opts.Policy(subject, req)
+	if err != nil {
+		if _, ok := err.(*OAuthError); ok {
+			return nil, err
+		}
+		return nil, &OAuthError{Code: OAuthErrorInvalidRequest, Description: "token exchange refused", cause: err}
+	}
+
+	token, err := exchangedToken(subject, grant, clientID, opts.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := c.signToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &TokenExchangeResponse{
+		AccessToken:     string(signed),
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(token.Expiration().Sub(token.IssuedAt()).Seconds()),
+	}
+	if scope, ok := token.Get("scope"); ok {
+		res.Scope, _ = scope.(string)
+	}
+
+	return res, nil
+}
+
+// Build the issued token out of the policy grant
+func exchangedToken(subject jwt.Token, grant TokenExchangeGrant, clientID, issuer string) (jwt.Token, error) {
+	token := jwt.New()
+	for k, v := range grant.Claims {
+		if err := token.Set(k, v); err != nil {
+			return nil, fmt.Errorf("cannot set claim %q %v", k, err)
+		}
+	}
+
+	if token.Subject() == "" {
+		if err := token.Set(jwt.SubjectKey, subject.Subject()); err != nil {
+			return nil, fmt.Errorf("cannot set claim %q %v", jwt.SubjectKey, err)
+		}
+	}
+
+	// the current actor goes on top of the chain of the subject token actors
+	if !grant.Impersonation {
+		act := map[string]interface{}{"sub": clientID}
+		if prior, ok := subject.Get("act"); ok {
+			act["act"] = prior
+		}
+		if err := token.Set("act", act); err != nil {
+			return nil, fmt.Errorf("cannot set claim %q %v", "act", err)
+		}
+	}
+
+	expiresIn := grant.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = defaultExchangedTokenLifetime
+	}
+	now := time.Now().Truncate(time.Second)
+	claims := map[string]interface{}{
+		jwt.IssuedAtKey:   now,
+		jwt.ExpirationKey: now.Add(expiresIn),
+	}
+	if issuer != "" {
+		claims[jwt.IssuerKey] = issuer
+	}
+	for k, v := range claims {
+		if err := token.Set(k, v); err != nil {
+			return nil, fmt.Errorf("cannot set claim %q %v", k, err)
+		}
+	}
+
+	return token, nil
+}
@@ -0,0 +1,70 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTokenExchangeErrorsDoNotEchoTheCause(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	subject := jwt.New()
+	for name, tt := range map[string]struct {
+		opts        TokenExchangeOptions
+		description string
+	}{
+		"subject token": {TokenExchangeOptions{
+			VerifySubjectToken: func(context.Context, string) (jwt.Token, error) {
+				return nil, errors.New(`"exp" not satisfied`)
+			},
+			Policy: func(jwt.Token, TokenExchangeRequest) (TokenExchangeGrant, error) {
+				return TokenExchangeGrant{}, nil
+			},
+		}, "invalid subject_token"},
+		"policy": {TokenExchangeOptions{
+			VerifySubjectToken: func(context.Context, string) (jwt.Token, error) {
+				return subject, nil
+			},
+			Policy: func(jwt.Token, TokenExchangeRequest) (TokenExchangeGrant, error) {
+				return TokenExchangeGrant{}, errors.New("role lookup failed on db-3")
+			},
+		}, "token exchange refused"},
+	} {
+		var logged []error
+		r := gin.New()
+		r.POST("/token", func(c *gin.Context) {
+			c.Set(ClientIDContextKey, "alice")
+			c.Next()
+			for _, err := range c.Errors {
+				logged = append(logged, err.Err)
+			}
+		}, TokenExchange(*config, tt.opts))
+		form := url.Values{
+			"grant_type":         {GrantTypeTokenExchange},
+			"subject_token":      {"token"},
+			"subject_token_type": {TokenTypeAccessToken},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body OAuthError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: answered %d %s", name, w.Code, w.Body)
+		}
+		if body.Code != OAuthErrorInvalidRequest || body.Description != tt.description {
+			t.Fatalf("%s: answered %s", name, w.Body)
+		}
+		if len(logged) != 1 || errors.Unwrap(logged[0]) == nil {
+			t.Fatalf("%s: the cause is not kept for the logs: %v", name, logged)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// gin context key holding the TokenSession of the verifier of the request
+const tokenSessionContextKey = "gin-jwks-token-session"
+
+// ErrNoTokenSession is returned by ClearTokenSession on a route whose verifier
+// keeps no session
+var ErrNoTokenSession = errors.New("no token session on this route")
+
+// TokenSession keeps the verified token of a request for the next requests of
+// the same client, see the session subpackage for gin sessions
+type TokenSession interface {
+	// Token kept for the request, false when there is none
+	Load(c *gin.Context) (string, bool)
+	// Keep token, verified as parsed, for the next requests
+	Save(c *gin.Context, token string, parsed jwt.Token) error
+	// Forget the kept token
+	Clear(c *gin.Context) error
+}
+
+// Let Verify and VerifyRemote write each verified token to session. Requests
+// still need a token unless WithTokenSessionLookup is also given.
+func WithTokenSession(session TokenSession) VerifierOption {
+	return func(v *Verifier) {
+		v.session = session
+	}
+}
+
+// Accept requests without a token whose TokenSession holds one. The kept token
+// is verified again on each request, so it ends when it expires or when its
+// key leaves the key set. A kept token failing verification is cleared.
+func WithTokenSessionLookup() VerifierOption {
+	return func(v *Verifier) {
+		v.sessionLookup = true
+	}
+}
+
+// ClearTokenSession forgets the token kept by the TokenSession of the verifier
+// of the route, for logout. The rest of the request no longer sees the claims.
+func ClearTokenSession(c *gin.Context) error {
+	v, ok := c.Get(tokenSessionContextKey)
+	if !ok {
+		return ErrNoTokenSession
+	}
+	c.Set(ClaimsContextKey, nil)
+	c.Set(RawTokenContextKey, nil)
+	c.Set(PrincipalContextKey, nil)
+	return v.(TokenSession).Clear(c)
+}
@@ -0,0 +1,315 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GrantTypeClientCredentials = "client_credentials"
+
+	// first retry delay after a failed background refresh, doubled up to a minute
+	tokenRefreshRetryDelay = 2 * time.Second
+	maxTokenRefreshRetry   = time.Minute
+)
+
+// How a TokenSource authenticates to the token endpoint: client_secret_basic,
+// or private_key_jwt when Assertion is set
+type Credentials struct {
+	ClientID     string
+	ClientSecret string
+	// config signing the RFC 7523 client assertion
+	Assertion *Config
+}
+
+// TokenSource fetches client_credentials tokens from a token endpoint and
+// keeps them fresh. It is safe for concurrent use.
+type TokenSource struct {
+	endpoint string
+	creds    Credentials
+	scopes   []string
+	client   *http.Client
+	onError  func(error)
+	// time.Now and time.AfterFunc outside of tests
+	now       func() time.Time
+	afterFunc func(time.Duration, func()) refreshTimer
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	// refresh in progress, concurrent callers wait for it instead of starting another
+	inflight *tokenCall
+	timer    refreshTimer
+	retry    time.Duration
+	closed   bool
+}
+
+// Scheduled refresh, a *time.Timer outside of tests
+type refreshTimer interface {
+	Stop() bool
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewTokenSource returns a token source for the client_credentials grant of endpoint
+func NewTokenSource(endpoint string, creds Credentials, scopes ...string) *TokenSource {
+	return &TokenSource{
+		endpoint: endpoint,
+		creds:    creds,
+		scopes:   scopes,
+		client:   http.DefaultClient,
+		now:      time.Now,
+		afterFunc: func(d time.Duration, f func()) refreshTimer {
+			return time.AfterFunc(d, f)
+		},
+	}
+}
+
+// Use client to call the token endpoint
+func (s *TokenSource) WithHTTPClient(client *http.Client) *TokenSource {
+	s.client = client
+	return s
+}
+
+// Call fn when a background refresh fails, the last token is served until it expires
+func (s *TokenSource) OnError(fn func(error)) *TokenSource {
+	s.onError = fn
+	return s
+}
+
+// Token returns the cached token, fetching one when there is none or it expired
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	if s.token != "" && s.now().Before(s.expiresAt) {
+		token := s.token
+		s.mu.Unlock()
+		return token, nil
+	}
+	call := s.refreshLocked()
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Close stops the background refresh
+func (s *TokenSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	return nil
+}
+
+// Start a refresh unless one is in progress, s.mu must be held
+func (s *TokenSource) refreshLocked() *tokenCall {
+	if s.inflight != nil {
+		return s.inflight
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	s.inflight = call
+
+	go func() {
+		token, expiresIn, err := s.fetch(context.Background())
+
+		s.mu.Lock()
+		s.inflight = nil
+		if err == nil {
+			s.token = token
+			s.expiresAt = s.now().Add(expiresIn)
+			s.retry = 0
+			s.scheduleLocked(refreshDelay(expiresIn))
+			call.token = token
+		} else {
+			call.err = err
+			s.scheduleRetryLocked()
+		}
+		s.mu.Unlock()
+		close(call.done)
+
+		if err != nil && s.onError != nil {
+			s.onError(err)
+		}
+	}()
+
+	return call
+}
+
+func (s *TokenSource) scheduleLocked(delay time.Duration) {
+	if s.closed {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = s.afterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.closed {
+			s.refreshLocked()
+		}
+	})
+}
+
+// Retry with an exponential backoff, never past the expiry of the current token
+// so callers do not all wait on a synchronous fetch
+func (s *TokenSource) scheduleRetryLocked() {
+	if s.retry == 0 {
+		s.retry = tokenRefreshRetryDelay
+	} else if s.retry *= 2; s.retry > maxTokenRefreshRetry {
+		s.retry = maxTokenRefreshRetry
+	}
+	delay := s.retry
+	if remaining := s.expiresAt.Sub(s.now()); remaining > 0 && remaining < delay {
+		delay = remaining
+	}
+	if s.token == "" || delay <= 0 {
+		// nothing to keep fresh, the next Token call fetches
+		return
+	}
+	s.scheduleLocked(delay)
+}
+
+// Refresh at 80% of the lifetime, minus up to 10% of jitter so a fleet of
+// clients started together does not hit the endpoint at once
+func refreshDelay(expiresIn time.Duration) time.Duration {
+	delay := expiresIn * 8 / 10
+	if jitter := int64(expiresIn / 10); jitter > 0 {
+		delay -= time.Duration(mathrand.Int63n(jitter))
+	}
+	return delay
+}
+
+// Token endpoint response, RFC 6749 section 5.1
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (s *TokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {GrantTypeClientCredentials}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	if s.creds.Assertion != nil {
+		assertion, err := s.clientAssertion()
+		if err != nil {
+			return "", 0, err
+		}
+		form.Set("client_id", s.creds.ClientID)
+		form.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+		form.Set("client_assertion", assertion)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot create token request %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.creds.Assertion == nil {
+		req.SetBasicAuth(url.QueryEscape(s.creds.ClientID), url.QueryEscape(s.creds.ClientSecret))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot call token endpoint %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var oauthErr OAuthError
+		if json.NewDecoder(res.Body).Decode(&oauthErr) == nil && oauthErr.Code != "" {
+			return "", 0, &oauthErr
+		}
+		return "", 0, fmt.Errorf("token endpoint returned %s", res.Status)
+	}
+	var body tokenResponse
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("cannot decode token response %v", err)
+	}
+	if body.AccessToken == "" || body.ExpiresIn <= 0 {
+		return "", 0, fmt.Errorf("token response has no access_token or expires_in")
+	}
+
+	return body.AccessToken, time.Duration(body.ExpiresIn) * time.Second, nil
+}
+
+// RFC 7523 client assertion for the token endpoint
+func (s *TokenSource) clientAssertion() (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("cannot generate jti %v", err)
+	}
+
+	now := time.Now()
+	token := jwt.New()
+	for name, value := range map[string]interface{}{
+		jwt.IssuerKey:     s.creds.ClientID,
+		jwt.SubjectKey:    s.creds.ClientID,
+		jwt.AudienceKey:   s.endpoint,
+		jwt.JwtIDKey:      hex.EncodeToString(jti),
+		jwt.IssuedAtKey:   now,
+		jwt.ExpirationKey: now.Add(time.Minute),
+	} {
+		if err := token.Set(name, value); err != nil {
+			return "", fmt.Errorf("cannot set %s claim %v", name, err)
+		}
+	}
+
+	signed, err := s.creds.Assertion.signToken(token)
+	if err != nil {
+		return "", fmt.Errorf("cannot sign client assertion %v", err)
+	}
+	return string(signed), nil
+}
+
+// Transport returns a round tripper adding the token of s as a Bearer
+// Authorization header, base is http.DefaultTransport when nil
+func (s *TokenSource) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tokenTransport{source: s, base: base}
+}
+
+type tokenTransport struct {
+	source *TokenSource
+	base   http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	// a RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
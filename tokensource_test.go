@@ -0,0 +1,264 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Clock whose timers only fire when the test advances it
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	f     func()
+	done  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) refreshTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := !t.done
+	t.done = true
+	return stopped
+}
+
+// Move the clock forward and run the timers that are due, in order
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if !t.done && !t.at.After(c.now) {
+			t.done = true
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// Token endpoint issuing token-1, token-2... valid for expiresIn seconds,
+// answering 503 while failing is set
+type flakyTokenEndpoint struct {
+	calls     int32
+	failing   int32
+	expiresIn int
+}
+
+func (e *flakyTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" || r.FormValue("grant_type") != GrantTypeClientCredentials {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_client"}`)
+		return
+	}
+	n := atomic.AddInt32(&e.calls, 1)
+	if atomic.LoadInt32(&e.failing) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, e.expiresIn)
+}
+
+func newFakeTokenSource(t *testing.T, endpoint *flakyTokenEndpoint) (*TokenSource, *fakeClock, chan error) {
+	t.Helper()
+	server := httptest.NewServer(endpoint)
+	t.Cleanup(server.Close)
+	clock := newFakeClock()
+	errs := make(chan error, 16)
+	source := NewTokenSource(server.URL, Credentials{ClientID: "client", ClientSecret: "secret"}, "read").
+		OnError(func(err error) { errs <- err })
+	source.now = clock.Now
+	source.afterFunc = clock.AfterFunc
+	t.Cleanup(func() { source.Close() })
+	return source, clock, errs
+}
+
+// Wait for the background refresh to hand over want
+func waitForToken(t *testing.T, source *TokenSource, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		token, err := source.Token(context.Background())
+		if err == nil && token == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token %q %v, want %q", token, err, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTokenSourceFetchesOnceForConcurrentCallers(t *testing.T) {
+	endpoint := &flakyTokenEndpoint{expiresIn: 100}
+	source, _, _ := newFakeTokenSource(t, endpoint)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := source.Token(context.Background()); err != nil || token != "token-1" {
+				t.Errorf("token %q %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls := atomic.LoadInt32(&endpoint.calls); calls != 1 {
+		t.Fatalf("%d calls to the token endpoint", calls)
+	}
+}
+
+func TestTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	endpoint := &flakyTokenEndpoint{expiresIn: 100}
+	source, clock, _ := newFakeTokenSource(t, endpoint)
+	waitForToken(t, source, "token-1")
+
+	// the refresh is due between 70% and 80% of the lifetime
+	clock.Advance(69 * time.Second)
+	waitForToken(t, source, "token-1")
+	if calls := atomic.LoadInt32(&endpoint.calls); calls != 1 {
+		t.Fatalf("refreshed after %d calls before 70%% of the lifetime", calls)
+	}
+	clock.Advance(11 * time.Second)
+	waitForToken(t, source, "token-2")
+
+	// and again for the new token
+	clock.Advance(80 * time.Second)
+	waitForToken(t, source, "token-3")
+}
+
+func TestTokenSourceServesTheLastTokenWhileTheEndpointFails(t *testing.T) {
+	endpoint := &flakyTokenEndpoint{expiresIn: 100}
+	source, clock, errs := newFakeTokenSource(t, endpoint)
+	waitForToken(t, source, "token-1")
+
+	atomic.StoreInt32(&endpoint.failing, 1)
+	clock.Advance(80 * time.Second)
+	if err := <-errs; err == nil {
+		t.Fatal("no error reported")
+	}
+	waitForToken(t, source, "token-1")
+
+	// retried after 2s, then 4s
+	clock.Advance(2 * time.Second)
+	<-errs
+	clock.Advance(4 * time.Second)
+	<-errs
+	waitForToken(t, source, "token-1")
+
+	atomic.StoreInt32(&endpoint.failing, 0)
+	clock.Advance(8 * time.Second)
+	// one success and three failures before this refresh
+	waitForToken(t, source, "token-5")
+}
+
+func TestTokenSourceStopsServingAnExpiredToken(t *testing.T) {
+	endpoint := &flakyTokenEndpoint{expiresIn: 100}
+	source, clock, errs := newFakeTokenSource(t, endpoint)
+	waitForToken(t, source, "token-1")
+
+	atomic.StoreInt32(&endpoint.failing, 1)
+	clock.Advance(80 * time.Second)
+	<-errs
+	clock.Advance(20 * time.Second)
+	if _, err := source.Token(context.Background()); err == nil {
+		t.Fatal("an expired token was served")
+	}
+}
+
+func TestTokenSourceTransportAddsTheBearerToken(t *testing.T) {
+	endpoint := &flakyTokenEndpoint{expiresIn: 100}
+	source, _, _ := newFakeTokenSource(t, endpoint)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer api.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, api.URL, nil)
+	res, err := (&http.Client{Transport: source.Transport(nil)}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body [64]byte
+	n, _ := res.Body.Read(body[:])
+	if got := string(body[:n]); got != "Bearer token-1" {
+		t.Fatalf("Authorization %q", got)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Fatal("the request of the caller was modified")
+	}
+}
+
+func TestTokenSourceAuthenticatesWithAClientAssertion(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	var assertion jwt.Token
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok || r.FormValue("client_assertion_type") != ClientAssertionTypeJWTBearer {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var err error
+		if assertion, err = jwt.Parse([]byte(r.FormValue("client_assertion")), jwt.WithKeySet(servedKeySet(t, config))); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":60}`)
+	}))
+	defer server.Close()
+
+	source := NewTokenSource(server.URL, Credentials{ClientID: "client", Assertion: config})
+	defer source.Close()
+	if _, err := source.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Issuer() != "client" || assertion.Subject() != "client" || len(assertion.Audience()) != 1 || assertion.Audience()[0] != server.URL {
+		t.Fatalf("client assertion claims %v %v %v", assertion.Issuer(), assertion.Subject(), assertion.Audience())
+	}
+}
+
+func TestTokenSourceReportsOAuthErrors(t *testing.T) {
+	endpoint := &flakyTokenEndpoint{expiresIn: 100}
+	source, _, _ := newFakeTokenSource(t, endpoint)
+	source.creds.ClientSecret = "wrong"
+	_, err := source.Token(context.Background())
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_client" {
+		t.Fatalf("error %v", err)
+	}
+}
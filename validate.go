@@ -0,0 +1,122 @@
+package gin_jwks_rsa
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"io/fs"
+)
+
+var (
+	// ErrInvalidConfig is wrapped by the errors of Build caused by the settings
+	// themselves rather than by reading or generating the key
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrConflictingKeySources is returned when several key sources are set
+	ErrConflictingKeySources = errors.New("conflicting key sources")
+	// ErrNoKeyConfigured is returned when no key is generated nor imported
+	ErrNoKeyConfigured = errors.New("no key configured")
+)
+
+// Error of the settings, matches ErrInvalidConfig as well as its cause
+type invalidConfigError struct {
+	err error
+}
+
+func (e *invalidConfigError) Error() string {
+	return ErrInvalidConfig.Error() + ", " + e.err.Error()
+}
+
+func (e *invalidConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+func (e *invalidConfigError) Unwrap() error {
+	return e.err
+}
+
+// Smallest RSA key Build accepts unless WithMinimumKeySize says otherwise
+const DefaultMinimumKeySize = 2048
+
+// Refuse RSA keys smaller than bits, generated or imported, and EC keys on a
+// weaker curve. DefaultMinimumKeySize if not set, 1024 with ProfileDev and
+// ProfileTest. AllowWeakKeys lifts it.
+func (n *ConfigBuilder) WithMinimumKeySize(bits int) *ConfigBuilder {
+	n.config.minKeyBits = bits
+	return n
+}
+
+// Check the settings of the builder before any key is generated or read
+func (c *Config) validate() error {
+	if err := c.validateSettings(); err != nil {
+		return &invalidConfigError{err: err}
+	}
+	return nil
+}
+
+func (c *Config) validateSettings() error {
+	if c.newPkOpts != nil && c.importPkOpts != nil {
+		return fmt.Errorf("%w, cannot import and generate a new private key", ErrConflictingKeySources)
+	}
+	if c.importPubOpts != nil && (c.newPkOpts != nil || c.importPkOpts != nil) {
+		return fmt.Errorf("%w, cannot import a public key along with a private key", ErrConflictingKeySources)
+	}
+	if c.symmetricOpts != nil && (c.newPkOpts != nil || c.importPkOpts != nil || c.importPubOpts != nil) {
+		return fmt.Errorf("%w, cannot import a symmetric key along with another key", ErrConflictingKeySources)
+	}
+	if c.newPkOpts == nil && c.importPkOpts == nil && c.importPubOpts == nil && c.symmetricOpts == nil {
+		return fmt.Errorf("%w, generate or import a private key", ErrNoKeyConfigured)
+	}
+	if err := c.applyProfileDefaults(); err != nil {
+		return err
+	}
+
+	if opts := c.newPkOpts; opts != nil && (opts.keyType == "" || opts.keyType == jwa.RSA) {
+		if opts.bits == 0 {
+			return fmt.Errorf("key length must be set, see WithKeyLength")
+		}
+		if minBits := c.minimumKeySize(); opts.bits < minBits && !c.allowWeakKeys {
+			return &WeakKeyError{Bits: opts.bits, Minimum: minBits, KeyID: opts.keyId}
+		}
+	}
+	if c.autoRotation() < 0 {
+		return fmt.Errorf("auto rotation interval cannot be negative")
+	}
+	if opts := c.importPkOpts; opts != nil {
+		if opts.pathGiven && opts.privateKeyPemPath == "" {
+			return fmt.Errorf("private key path cannot be empty")
+		}
+		if opts.fsys != nil && !fs.ValidPath(opts.fsPath) {
+			return fmt.Errorf("invalid private key path %q in the file system", opts.fsPath)
+		}
+		if err := checkReloadOptions(*opts); err != nil {
+			return err
+		}
+	}
+
+	if c.keyAgeAlert != nil && c.keyAgeMax <= 0 {
+		return fmt.Errorf("key age alert threshold must be positive")
+	}
+	if c.symmetricOpts != nil {
+		if err := checkSymmetricKeyOptions(*c.symmetricOpts); err != nil {
+			return err
+		}
+	}
+	if c.rotationGrace() < 0 {
+		return fmt.Errorf("rotation grace period cannot be negative")
+	}
+	if c.endpointGuardErr != nil {
+		return c.endpointGuardErr
+	}
+	return nil
+}
+
+// Floor of the RSA keys
+func (c *Config) minimumKeySize() int {
+	if c.minKeyBits > 0 {
+		return c.minKeyBits
+	}
+	if c.profile == ProfileDev || c.profile == ProfileTest {
+		return 1024
+	}
+	return DefaultMinimumKeySize
+}
@@ -0,0 +1,448 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/v4lproik/gin-jwks-rsa/presets"
+	"sync"
+	"time"
+)
+
+const AuthorizedPartyKey = "azp"
+
+// Labels of the verifications_total metric
+const (
+	VerificationOutcomeValid       = "valid"
+	VerificationOutcomeInvalid     = "invalid"
+	VerificationOutcomeAzpMissing  = "azp_missing"
+	VerificationOutcomeAzpMismatch = "azp_mismatch"
+	// rejected before any decoding by the parsing limits
+	VerificationOutcomeLimitExceeded = "limit_exceeded"
+	VerificationOutcomeKeyURLHeader  = "key_url_header"
+)
+
+var (
+	// ErrAzpMissing is returned when a token with several audiences has no azp claim
+	ErrAzpMissing = errors.New(`"azp" claim is required when "aud" holds several audiences`)
+	// ErrAzpMismatch is returned when the azp claim is not one of the authorized parties
+	ErrAzpMismatch = errors.New(`"azp" claim is not an authorized party`)
+	// ErrAlgorithmMismatch is returned when the alg header of a token is not the
+	// algorithm of the key named by its kid, e.g. none or HS256 with an RSA key
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match its key")
+)
+
+// AuthorizedPartyValidator checks the azp claim the OpenID Connect way: it is
+// mandatory when aud holds several audiences and optional otherwise, and when
+// present it must be one of parties. Use it along with jwt.WithAudience so
+// both claims are checked in the same validation pass.
+func AuthorizedPartyValidator(parties ...string) jwt.Validator {
+	authorized := map[string]struct{}{}
+	for _, party := range parties {
+		authorized[party] = struct{}{}
+	}
+
+	return jwt.ValidatorFunc(func(_ context.Context, token jwt.Token) jwt.ValidationError {
+		azp, ok := token.Get(AuthorizedPartyKey)
+		if !ok {
+			if len(token.Audience()) > 1 {
+				return jwt.NewValidationError(ErrAzpMissing)
+			}
+			return nil
+		}
+
+		party, _ := azp.(string)
+		if _, ok := authorized[party]; !ok {
+			return jwt.NewValidationError(ErrAzpMismatch)
+		}
+		return nil
+	})
+}
+
+// Metric label of a verification error
+func verificationOutcome(err error) string {
+	switch {
+	case err == nil:
+		return VerificationOutcomeValid
+	case errors.Is(err, ErrAzpMissing):
+		return VerificationOutcomeAzpMissing
+	case errors.Is(err, ErrAzpMismatch):
+		return VerificationOutcomeAzpMismatch
+	case errors.Is(err, ErrTokenLimitExceeded):
+		return VerificationOutcomeLimitExceeded
+	case errors.Is(err, ErrKeyURLHeader):
+		return VerificationOutcomeKeyURLHeader
+	default:
+		return VerificationOutcomeInvalid
+	}
+}
+
+// Verifier checks tokens signed by the key of a config, or by a remote key
+// set, without any HTTP context. It is safe for concurrent use.
+type Verifier struct {
+	// nil for a remote key set
+	config            *Config
+	keySet            func(ctx context.Context) (jwk.Set, error)
+	issuers           []string
+	audiences         []string
+	authorizedParties []string
+	checkAzp          bool
+	requiredClaims    []string
+	skew              time.Duration
+	limits            TokenLimits
+	keyURLHeaders     KeyURLHeaderPolicy
+	webSocketTokens   bool
+	session           TokenSession
+	sessionLookup     bool
+	claimMapping      presets.ClaimMapping
+	// time.Now when nil, tests verify recorded tokens at their time
+	clock jwt.Clock
+}
+
+// Option of Config.Verifier
+type VerifierOption func(*Verifier)
+
+// Accept tokens issued by one of issuers
+func WithExpectedIssuer(issuers ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.issuers = append(v.issuers, issuers...)
+	}
+}
+
+// Accept tokens whose aud holds one of audiences
+func WithExpectedAudience(audiences ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.audiences = append(v.audiences, audiences...)
+	}
+}
+
+// Check the azp claim against parties, see AuthorizedPartyValidator
+func WithAuthorizedParties(parties ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.authorizedParties = append(v.authorizedParties, parties...)
+		v.checkAzp = true
+	}
+}
+
+// Reject tokens missing one of the claims
+func WithRequiredClaims(names ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.requiredClaims = append(v.requiredClaims, names...)
+	}
+}
+
+// Tolerate clock differences when checking exp, nbf and iat
+func WithClockSkew(skew time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		v.skew = skew
+	}
+}
+
+// Bound the size and shape of the tokens, DefaultTokenLimits otherwise and
+// for each field left zero
+func WithTokenLimits(limits TokenLimits) VerifierOption {
+	return func(v *Verifier) {
+		v.limits = limits
+	}
+}
+
+// Ignore jku and x5u headers instead of refusing the token, for interop with
+// issuers which always set them. They are never followed.
+func WithKeyURLHeaderPolicy(policy KeyURLHeaderPolicy) VerifierOption {
+	return func(v *Verifier) {
+		v.keyURLHeaders = policy
+	}
+}
+
+// Let Verify and VerifyRemote read the token of a WebSocket upgrade request
+// without an Authorization header with WebSocketToken. A missing or invalid
+// token is answered with a 401 before the upgrade handler runs.
+func WithWebSocketTokenLookup() VerifierOption {
+	return func(v *Verifier) {
+		v.webSocketTokens = true
+	}
+}
+
+// Verifier returns a token verifier using the signing key of the config
+func (c *Config) Verifier(opts ...VerifierOption) *Verifier {
+	v := &Verifier{
+		config: c,
+		keySet: func(context.Context) (jwk.Set, error) {
+			return c.verificationKeySet()
+		},
+		limits: DefaultTokenLimits,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Keys a token can be signed with: the signing key under its kid and its aliases
+func (c *Config) verificationKeySet() (jwk.Set, error) {
+	if c.symmetricKey != nil {
+		return c.symmetricKeySet()
+	}
+	// a key withheld from the key set does not verify either
+	active, additionalKeys, err := c.servedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	set := jwk.NewSet()
+	// nil once RemoveKey removed the signing key, the other keys still verify
+	if active != nil {
+		if err = c.addActiveVerificationKey(set, active); err != nil {
+			return nil, err
+		}
+	}
+
+	// tokens signed by the additional keys verify as well
+	for _, additional := range additionalKeys {
+		additionalAlg, err := signatureAlgorithm(additional)
+		if err != nil {
+			return nil, err
+		}
+		verificationKey, err := additional.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create public key %v", err)
+		}
+		if err = verificationKey.Set(jwk.AlgorithmKey, additionalAlg); err != nil {
+			return nil, fmt.Errorf("cannot set the algorithm of the public key %v", err)
+		}
+		if err = set.AddKey(verificationKey); err != nil {
+			return nil, fmt.Errorf("cannot add additional key to the key set %v", err)
+		}
+	}
+
+	return set, nil
+}
+
+// Add the public key of the signing key to set, under its kid and its aliases
+func (c *Config) addActiveVerificationKey(set jwk.Set, active *activeKey) error {
+	if active.key.KeyUsage() == KeyUsageAsEncryption {
+		return fmt.Errorf("a %s key cannot verify signatures", keyDescription(active.key))
+	}
+
+	alg, err := signatureAlgorithm(active.key)
+	if err != nil {
+		return err
+	}
+	pubKey, err := active.key.PublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to create public key %v", err)
+	}
+	if err = pubKey.Set(jwk.AlgorithmKey, alg); err != nil {
+		return fmt.Errorf("cannot set the algorithm of the public key %v", err)
+	}
+	if err = set.AddKey(pubKey); err != nil {
+		return fmt.Errorf("cannot add public key to the key set %v", err)
+	}
+	for _, alias := range c.aliasesOf(pubKey.KeyID()) {
+		aliasKey, err := pubKey.PublicKey()
+		if err != nil {
+			return fmt.Errorf("failed to create public key %v", err)
+		}
+		if err = aliasKey.Set(jwk.KeyIDKey, alias); err != nil {
+			return fmt.Errorf("cannot add an id property to the public key %v", err)
+		}
+		if err = set.AddKey(aliasKey); err != nil {
+			return fmt.Errorf("cannot add public key to the key set %v", err)
+		}
+	}
+	return nil
+}
+
+// Verify checks the signature and the claims of a token
+func (v *Verifier) Verify(ctx context.Context, token string) (jwt.Token, error) {
+	parsed, err := v.verify(ctx, token)
+	if v.config != nil {
+		v.config.observe(func(i instrumentation) {
+			i.verification(verificationOutcome(err))
+		})
+	}
+	return parsed, err
+}
+
+func (v *Verifier) verify(ctx context.Context, token string) (jwt.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkTokenLimits(token, v.limits); err != nil {
+		return nil, err
+	}
+	if err := checkKeyURLHeaders(token, v.keyURLHeaders); err != nil {
+		return nil, err
+	}
+
+	set, err := v.keySet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTokenAlgorithm(token, set); err != nil {
+		return nil, err
+	}
+
+	// only the configured keys, an embedded jwk header is never looked at.
+	// Keys of a remote set may not carry alg, checkTokenAlgorithm vetted the
+	// header; a shared secret without kid verifies the tokens without one.
+	keySetOpts := []interface{}{jws.WithInferAlgorithmFromKey(true)}
+	if v.config != nil && v.config.symmetricKey != nil && v.config.symmetricKey.KeyID() == "" {
+		keySetOpts = append(keySetOpts, jws.WithUseDefault(true))
+	}
+	opts := []jwt.ParseOption{
+		jwt.WithKeySet(set, keySetOpts...),
+		jwt.WithValidate(true),
+		jwt.WithContext(ctx),
+		jwt.WithAcceptableSkew(v.skew),
+	}
+	if v.clock != nil {
+		opts = append(opts, jwt.WithClock(v.clock))
+	}
+	for _, name := range v.requiredClaims {
+		opts = append(opts, jwt.WithRequiredClaim(name))
+	}
+	if len(v.issuers) > 0 {
+		opts = append(opts, jwt.WithValidator(oneOfValidator(jwt.IssuerKey, v.issuers)))
+	}
+	if len(v.audiences) > 0 {
+		opts = append(opts, jwt.WithValidator(oneOfValidator(jwt.AudienceKey, v.audiences)))
+	}
+	if v.checkAzp {
+		opts = append(opts, jwt.WithValidator(AuthorizedPartyValidator(v.authorizedParties...)))
+	}
+
+	parsed, err := jwt.Parse([]byte(token), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token %w", err)
+	}
+
+	return parsed, nil
+}
+
+// Refuse a token whose alg header differs from the alg of the key its kid
+// names, or is not an algorithm of its key type when the key has no alg. The
+// signature would not verify anyway, this says why and never lets the token
+// pick the algorithm.
+func checkTokenAlgorithm(token string, set jwk.Set) error {
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		return fmt.Errorf("cannot parse token %v", err)
+	}
+	for _, sig := range msg.Signatures() {
+		headers := sig.ProtectedHeaders()
+		alg := headers.Algorithm()
+		if alg == "" || alg == jwa.NoSignature {
+			return ErrAlgorithmMismatch
+		}
+		key, ok := set.LookupKeyID(headers.KeyID())
+		if !ok {
+			return fmt.Errorf("no key with kid %q", headers.KeyID())
+		}
+		if keyAlg := key.Algorithm(); keyAlg != nil && keyAlg.String() != "" {
+			if keyAlg.String() != alg.String() {
+				return ErrAlgorithmMismatch
+			}
+			continue
+		}
+		// no alg on the key, the header must name one of its key type
+		if !keyAlgorithmAccepts(key, alg) {
+			return ErrAlgorithmMismatch
+		}
+	}
+	return nil
+}
+
+func keyAlgorithmAccepts(key jwk.Key, alg jwa.SignatureAlgorithm) bool {
+	algs, err := jws.AlgorithmsForKey(key)
+	if err != nil {
+		return false
+	}
+	for _, candidate := range algs {
+		if candidate == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcome of the verification of one token of a batch
+type Result struct {
+	Token jwt.Token
+	Err   error
+}
+
+// VerifyBatch verifies tokens on up to parallelism goroutines. Results are in
+// the order of tokens, each with its own error. The returned error is only set
+// when ctx ends before every token was verified.
+func (v *Verifier) VerifyBatch(ctx context.Context, tokens []string, parallelism int) ([]Result, error) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > len(tokens) {
+		parallelism = len(tokens)
+	}
+
+	results := make([]Result, len(tokens))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				token, err := v.Verify(ctx, tokens[i])
+				results[i] = Result{Token: token, Err: err}
+			}
+		}()
+	}
+
+	var err error
+	for i := range tokens {
+		if err = ctx.Err(); err != nil {
+			for j := i; j < len(tokens); j++ {
+				results[j] = Result{Err: err}
+			}
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results, err
+}
+
+// Validator accepting a string or string list claim holding one of values
+func oneOfValidator(name string, values []string) jwt.Validator {
+	accepted := map[string]struct{}{}
+	for _, value := range values {
+		accepted[value] = struct{}{}
+	}
+
+	return jwt.ValidatorFunc(func(_ context.Context, token jwt.Token) jwt.ValidationError {
+		var got []string
+		switch name {
+		case jwt.AudienceKey:
+			got = token.Audience()
+		case jwt.IssuerKey:
+			got = []string{token.Issuer()}
+		default:
+			if v, ok := token.Get(name); ok {
+				s, _ := v.(string)
+				got = []string{s}
+			}
+		}
+
+		for _, value := range got {
+			if _, ok := accepted[value]; ok {
+				return nil
+			}
+		}
+		return jwt.NewValidationError(fmt.Errorf("%q claim does not hold an accepted value", name))
+	})
+}
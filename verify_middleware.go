@@ -0,0 +1,103 @@
+package gin_jwks_rsa
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// gin context key holding the verified token of the request
+	ClaimsContextKey = "gin-jwks-claims"
+	// gin context key holding the verified token as received, to forward it
+	RawTokenContextKey = "gin-jwks-raw-token"
+)
+
+// Verify middleware accepting requests with a Bearer token signed by a key of
+// the config, the counterpart of Jkws. The token must name the key with its
+// kid and use its algorithm; exp and nbf are checked. Other requests get a 401
+// with an RFC 6750 error body. The token is stored under ClaimsContextKey, as
+// received under RawTokenContextKey and its Principal under
+// PrincipalContextKey. WithTokenSession keeps it across requests.
+func Verify(config Config, opts ...VerifierOption) gin.HandlerFunc {
+	return verifyBearer(config.Verifier(opts...), &config)
+}
+
+// Middleware verifying the Bearer token of a request with verifier, config
+// encodes the error body and may be nil
+func verifyBearer(verifier *Verifier, config *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.Request)
+		if !ok && verifier.webSocketTokens {
+			token, ok = WebSocketToken(c.Request)
+		}
+		if verifier.session != nil {
+			c.Set(tokenSessionContextKey, verifier.session)
+		}
+		fromSession := false
+		if !ok && verifier.session != nil && verifier.sessionLookup {
+			token, ok = verifier.session.Load(c)
+			fromSession = ok
+		}
+		if !ok {
+			rejectToken(c, config, "missing bearer token")
+			return
+		}
+		parsed, err := verifier.Verify(c.Request.Context(), token)
+		if err != nil {
+			c.Error(err)
+			if fromSession {
+				if err = verifier.session.Clear(c); err != nil {
+					c.Error(err)
+				}
+			}
+			rejectToken(c, config, "the token is invalid or expired")
+			return
+		}
+		if verifier.session != nil && !fromSession {
+			if err = verifier.session.Save(c, token, parsed); err != nil {
+				c.Error(err)
+				config.abortWithJSON(c, http.StatusInternalServerError, &OAuthError{
+					Code:        OAuthErrorServerError,
+					Description: "the session cannot be saved",
+				})
+				return
+			}
+		}
+
+		c.Set(ClaimsContextKey, parsed)
+		c.Set(RawTokenContextKey, token)
+		c.Set(PrincipalContextKey, verifier.Principal(parsed))
+		c.Next()
+	}
+}
+
+// ClaimsFromContext returns the token verified by Verify or VerifyRemote
+func ClaimsFromContext(c *gin.Context) (jwt.Token, bool) {
+	v, ok := c.Get(ClaimsContextKey)
+	if !ok {
+		return nil, false
+	}
+	token, ok := v.(jwt.Token)
+	return token, ok
+}
+
+// Token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return auth[len(prefix):], true
+}
+
+// Answer 401 the RFC 6750 way, description never holds the verification error
+func rejectToken(c *gin.Context, config *Config, description string) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	config.abortWithJSON(c, http.StatusUnauthorized, &OAuthError{
+		Code:        OAuthErrorInvalidToken,
+		Description: description,
+	})
+}
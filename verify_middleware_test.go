@@ -0,0 +1,199 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Token signed with alg by key, under kid
+func tokenWithAlgorithm(t *testing.T, alg jwa.SignatureAlgorithm, key interface{}, kid string) string {
+	t.Helper()
+	headers := jws.NewHeaders()
+	if err := headers.Set(jws.KeyIDKey, kid); err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.New()
+	if err := token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(alg, key, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestCheckTokenAlgorithm(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	set := servedKeySet(t, config)
+	var private, public interface{}
+	if err := config.active().key.Raw(&private); err != nil {
+		t.Fatal(err)
+	}
+	served, _ := set.LookupKeyID("test")
+	if err := served.Raw(&public); err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the public key an attacker would use as an HMAC secret
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	encode := func(v string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	unsigned := encode(`{"alg":"none","kid":"test"}`) + "." + encode(`{"sub":"me"}`) + "."
+
+	if err = checkTokenAlgorithm(tokenWithAlgorithm(t, jwa.RS256, private, "test"), set); err != nil {
+		t.Fatalf("token with the key algorithm refused: %v", err)
+	}
+	for name, tt := range map[string]struct {
+		token    string
+		mismatch bool
+	}{
+		"none":                      {unsigned, true},
+		"HS256 with the public key": {tokenWithAlgorithm(t, jwa.HS256, publicPEM, "test"), true},
+		"other RSA algorithm":       {tokenWithAlgorithm(t, jwa.RS512, private, "test"), true},
+		"unknown kid":               {tokenWithAlgorithm(t, jwa.RS256, private, "other"), false},
+		"not a token":               {"not.a.token", false},
+	} {
+		err := checkTokenAlgorithm(tt.token, set)
+		if err == nil {
+			t.Fatalf("%s accepted", name)
+		}
+		if errors.Is(err, ErrAlgorithmMismatch) != tt.mismatch {
+			t.Fatalf("%s refused with %v", name, err)
+		}
+	}
+}
+
+func TestVerifyMiddleware(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	r := gin.New()
+	r.GET("/me", Verify(*config), func(c *gin.Context) {
+		token, ok := ClaimsFromContext(c)
+		if !ok {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, token.Subject())
+	})
+	serve := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	token := signTestToken(t, config, map[string]interface{}{"sub": "me", "exp": time.Now().Add(time.Hour)})
+	if w := serve("bearer " + token); w.Code != http.StatusOK || w.Body.String() != "me" {
+		t.Fatalf("valid token answered %d %s", w.Code, w.Body)
+	}
+
+	expired := signTestToken(t, config, map[string]interface{}{"sub": "me", "exp": time.Now().Add(-time.Hour)})
+	for name, auth := range map[string]string{
+		"no header":     "",
+		"empty bearer":  "Bearer ",
+		"basic":         "Basic bWU6c2VjcmV0",
+		"expired":       "Bearer " + expired,
+		"tampered":      "Bearer " + token[:len(token)-4] + "AAAA",
+		"other key kid": "Bearer " + tokenWithAlgorithm(t, jwa.RS256, newRSAKey(t), "test"),
+	} {
+		w := serve(auth)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+			t.Fatalf("%s answered %d %v", name, w.Code, w.Header())
+		}
+		var body OAuthError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != OAuthErrorInvalidToken {
+			t.Fatalf("%s answered %s", name, w.Body)
+		}
+		if strings.Contains(body.Description, `"exp"`) {
+			t.Fatalf("%s answered with the verification error: %s", name, body.Description)
+		}
+	}
+}
+
+func TestWebSocketTokenLookup(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	jwks := gin.New()
+	jwks.GET("/jwks", Jkws(*config))
+	issuer := httptest.NewServer(jwks)
+	defer issuer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	token := signTestToken(t, config, map[string]interface{}{"sub": "me", "exp": time.Now().Add(time.Hour)})
+	for name, verify := range map[string]gin.HandlerFunc{
+		"local":  Verify(*config, WithWebSocketTokenLookup()),
+		"remote": VerifyRemote(issuer.URL+"/jwks", WithRemoteContext(ctx), WithRemoteVerifierOptions(WithWebSocketTokenLookup())),
+	} {
+		upgraded := false
+		r := gin.New()
+		r.GET("/ws", verify, func(c *gin.Context) {
+			upgraded = true
+			c.String(http.StatusOK, c.GetHeader("Sec-WebSocket-Protocol")+" "+c.Request.URL.RawQuery)
+		})
+		serve := func(target, protocols string) *httptest.ResponseRecorder {
+			upgraded = false
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			if protocols != "" {
+				req.Header.Set("Sec-WebSocket-Protocol", protocols)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w
+		}
+
+		if w := serve("/ws", "bearer, "+token+", chat"); w.Code != http.StatusOK || w.Body.String() != "bearer, chat " {
+			t.Fatalf("%s: subprotocol token answered %d %s", name, w.Code, w.Body)
+		}
+		if w := serve("/ws?"+AccessTokenQueryParam+"="+token+"&room=1", ""); w.Code != http.StatusOK || w.Body.String() != " room=1" {
+			t.Fatalf("%s: query token answered %d %s", name, w.Code, w.Body)
+		}
+		for what, target := range map[string][2]string{
+			"no token":      {"/ws", "chat"},
+			"invalid token": {"/ws", "bearer, " + token[:len(token)-4] + "AAAA"},
+			"invalid query": {"/ws?" + AccessTokenQueryParam + "=not.a.token", ""},
+		} {
+			w := serve(target[0], target[1])
+			if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+				t.Fatalf("%s: %s answered %d %v", name, what, w.Code, w.Header())
+			}
+			if upgraded {
+				t.Fatalf("%s: %s reached the upgrade handler", name, what)
+			}
+		}
+	}
+
+	// without the option the token of an upgrade request is ignored
+	r := gin.New()
+	r.GET("/ws", Verify(*config), func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodGet, "/ws?"+AccessTokenQueryParam+"="+token, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("upgrade token accepted without the option, answered %d", w.Code)
+	}
+}
@@ -0,0 +1,274 @@
+package gin_jwks_rsa
+
+import (
+	"context"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signTestToken(t testing.TB, config *Config, claims map[string]interface{}) string {
+	t.Helper()
+	token := jwt.New()
+	for name, value := range claims {
+		if err := token.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signed, err := config.signToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestVerifier(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	verifier := config.Verifier(
+		WithExpectedIssuer("https://issuer.example.com"),
+		WithExpectedAudience("api"),
+	)
+	valid := map[string]interface{}{
+		"iss": "https://issuer.example.com",
+		"aud": "api",
+		"exp": time.Now().Add(time.Hour),
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+		return claims
+	}
+
+	token := signTestToken(t, config, valid)
+	parsed, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Issuer() != "https://issuer.example.com" {
+		t.Fatalf("verified token has issuer %q", parsed.Issuer())
+	}
+
+	for name, claims := range map[string]map[string]interface{}{
+		"other issuer":   with("iss", "https://other.example.com"),
+		"other audience": with("aud", "other"),
+		"expired":        with("exp", time.Now().Add(-time.Hour)),
+		"not yet valid":  with("nbf", time.Now().Add(time.Hour)),
+	} {
+		if _, err = verifier.Verify(context.Background(), signTestToken(t, config, claims)); err == nil {
+			t.Errorf("%s token verified", name)
+		}
+	}
+
+	// a token of another key under the same kid
+	other := newTestConfig(t, NewConfigBuilder())
+	if _, err = verifier.Verify(context.Background(), signTestToken(t, other, valid)); err == nil {
+		t.Error("token of another key verified")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = verifier.Verify(ctx, token); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+}
+
+func TestVerifyBatchKeepsTheOrder(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	valid := signTestToken(t, config, map[string]interface{}{"exp": time.Now().Add(time.Hour)})
+	expired := signTestToken(t, config, map[string]interface{}{"exp": time.Now().Add(-time.Hour)})
+	tokens := []string{valid, expired, "not a token", valid}
+
+	results, err := config.Verifier().VerifyBatch(context.Background(), tokens, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(tokens) {
+		t.Fatalf("%d results for %d tokens", len(results), len(tokens))
+	}
+	for i, wantErr := range []bool{false, true, true, false} {
+		if (results[i].Err != nil) != wantErr {
+			t.Errorf("token %d verified with %v", i, results[i].Err)
+		}
+	}
+}
+
+func TestVerifierRefusesOversizedTokens(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	large := signTestToken(t, config, map[string]interface{}{"data": strings.Repeat("a", 10*1024)})
+	if _, err := config.Verifier().Verify(context.Background(), large); !errors.Is(err, ErrTokenLimitExceeded) {
+		t.Fatalf("expected ErrTokenLimitExceeded, got %v", err)
+	}
+	limits := TokenLimits{MaxTokenLength: 32 * 1024, MaxSegmentSize: 16 * 1024, MaxJSONDepth: 16, MaxAudiences: 32}
+	if _, err := config.Verifier(WithTokenLimits(limits)).Verify(context.Background(), large); err != nil {
+		t.Fatalf("token within raised limits refused: %v", err)
+	}
+}
+
+func TestVerifierSkipsTheKeysWithheldFromTheKeySet(t *testing.T) {
+	clock := newFakeClock()
+	builder := NewConfigBuilder().WithExpiredKeyPruning()
+	builder.config.keys.now = clock.Now
+	config, err := builder.ImportPrivateKey().WithRawKey(newECKey(t)).WithNotAfter(clock.Now().Add(time.Hour)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	token := signTestToken(t, config, map[string]interface{}{"exp": time.Now().Add(24 * time.Hour)})
+	if _, err = config.Verifier().Verify(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	// past its end of validity the key is no longer served, nor trusted
+	clock.Advance(2 * time.Hour)
+	if _, err = config.jwksKeys(); !errors.Is(err, ErrNoServableKey) {
+		t.Fatalf("expired key served: %v", err)
+	}
+	if _, err = config.Verifier().Verify(context.Background(), token); !errors.Is(err, ErrNoServableKey) {
+		t.Fatalf("token of the expired key verified: %v", err)
+	}
+}
+
+func TestExpiredKeyRefusesToSign(t *testing.T) {
+	clock := newFakeClock()
+	builder := NewConfigBuilder().WithExpiredKeyPruning()
+	builder.config.keys.now = clock.Now
+	config, err := builder.ImportPrivateKey().WithRawKey(newECKey(t)).WithNotAfter(clock.Now().Add(time.Hour)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	if _, err = config.signToken(jwt.New()); err != nil {
+		t.Fatal(err)
+	}
+
+	// no consumer could verify what the withheld key signs
+	clock.Advance(2 * time.Hour)
+	if _, err = config.signToken(jwt.New()); !errors.Is(err, ErrNoServableKey) {
+		t.Fatalf("expired key signed a token: %v", err)
+	}
+	if _, _, _, err = config.SignBytes([]byte("data")); !errors.Is(err, ErrNoServableKey) {
+		t.Fatalf("expired key signed bytes: %v", err)
+	}
+	if _, err = config.SignPayload([]byte("data")); !errors.Is(err, ErrNoServableKey) {
+		t.Fatalf("expired key signed a payload: %v", err)
+	}
+}
+
+func BenchmarkVerify10kTokens(b *testing.B) {
+	config, err := NewConfigBuilder().ImportPrivateKey().WithRawKey(newECKey(b)).WithKeyId("test").Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer config.Close()
+	tokens := make([]string, 10000)
+	for i := range tokens {
+		tokens[i] = signTestToken(b, config, map[string]interface{}{
+			"sub": "user-" + strconv.Itoa(i),
+			"exp": time.Now().Add(time.Hour),
+		})
+	}
+	verifier := config.Verifier()
+
+	// one op verifies the 10k tokens
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, token := range tokens {
+			if _, err = verifier.Verify(context.Background(), token); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestAuthorizedPartyValidator(t *testing.T) {
+	validator := AuthorizedPartyValidator("web", "mobile")
+	multiple := []string{"api", "billing"}
+
+	for name, tt := range map[string]struct {
+		aud     interface{}
+		azp     interface{} // absent when nil
+		err     error
+		outcome string
+	}{
+		"single audience":                   {aud: "api", outcome: VerificationOutcomeValid},
+		"single audience, matching azp":     {aud: "api", azp: "web", outcome: VerificationOutcomeValid},
+		"single audience, mismatched azp":   {aud: "api", azp: "other", err: ErrAzpMismatch, outcome: VerificationOutcomeAzpMismatch},
+		"several audiences, matching azp":   {aud: multiple, azp: "mobile", outcome: VerificationOutcomeValid},
+		"several audiences, mismatched azp": {aud: multiple, azp: "other", err: ErrAzpMismatch, outcome: VerificationOutcomeAzpMismatch},
+		"several audiences, missing azp":    {aud: multiple, err: ErrAzpMissing, outcome: VerificationOutcomeAzpMissing},
+		"other audiences, matching azp":     {aud: []string{"billing", "admin"}, azp: "web", outcome: VerificationOutcomeInvalid},
+		"other audience, mismatched azp":    {aud: "billing", azp: "other", outcome: VerificationOutcomeInvalid},
+		"other audiences, missing azp":      {aud: []string{"billing", "admin"}, outcome: VerificationOutcomeInvalid},
+		"several audiences, empty azp":      {aud: multiple, azp: "", err: ErrAzpMismatch, outcome: VerificationOutcomeAzpMismatch},
+	} {
+		token := jwt.New()
+		if err := token.Set(jwt.AudienceKey, tt.aud); err != nil {
+			t.Fatal(err)
+		}
+		if err := token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if tt.azp != nil {
+			if err := token.Set(AuthorizedPartyKey, tt.azp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the audience and the azp are checked in the same pass
+		err := jwt.Validate(token, jwt.WithAudience("api"), jwt.WithValidator(validator))
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, expected %v", name, err, tt.err)
+		}
+		if tt.outcome != VerificationOutcomeValid && err == nil {
+			t.Errorf("%s: validated", name)
+		}
+		if outcome := verificationOutcome(err); outcome != tt.outcome {
+			t.Errorf("%s: outcome %s (%v), expected %s", name, outcome, err, tt.outcome)
+		}
+	}
+}
+
+func TestVerifierAuthorizedParties(t *testing.T) {
+	config := newTestConfig(t, NewConfigBuilder())
+	verifier := config.Verifier(WithExpectedAudience("api"), WithAuthorizedParties("web", "mobile"))
+	multiple := []string{"api", "billing"}
+
+	for name, tt := range map[string]struct {
+		aud     interface{}
+		azp     interface{} // absent when nil
+		err     error
+		outcome string
+	}{
+		"single audience":                   {aud: "api", outcome: VerificationOutcomeValid},
+		"single audience, matching azp":     {aud: "api", azp: "web", outcome: VerificationOutcomeValid},
+		"single audience, mismatched azp":   {aud: "api", azp: "other", err: ErrAzpMismatch, outcome: VerificationOutcomeAzpMismatch},
+		"several audiences, matching azp":   {aud: multiple, azp: "mobile", outcome: VerificationOutcomeValid},
+		"several audiences, mismatched azp": {aud: multiple, azp: "other", err: ErrAzpMismatch, outcome: VerificationOutcomeAzpMismatch},
+		"several audiences, missing azp":    {aud: multiple, err: ErrAzpMissing, outcome: VerificationOutcomeAzpMissing},
+		"other audiences, matching azp":     {aud: []string{"billing", "admin"}, azp: "web", outcome: VerificationOutcomeInvalid},
+		"other audience, mismatched azp":    {aud: "billing", azp: "other", outcome: VerificationOutcomeInvalid},
+		"other audiences, missing azp":      {aud: []string{"billing", "admin"}, outcome: VerificationOutcomeInvalid},
+		"several audiences, empty azp":      {aud: multiple, azp: "", err: ErrAzpMismatch, outcome: VerificationOutcomeAzpMismatch},
+	} {
+		claims := map[string]interface{}{"aud": tt.aud, "exp": time.Now().Add(time.Hour)}
+		if tt.azp != nil {
+			claims[AuthorizedPartyKey] = tt.azp
+		}
+		_, err := verifier.Verify(context.Background(), signTestToken(t, config, claims))
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, expected %v", name, err, tt.err)
+		}
+		if tt.outcome != VerificationOutcomeValid && err == nil {
+			t.Errorf("%s: verified", name)
+		}
+		if outcome := verificationOutcome(err); outcome != tt.outcome {
+			t.Errorf("%s: outcome %s (%v), expected %s", name, outcome, err, tt.outcome)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package gin_jwks_rsa
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// subprotocol announcing that the next one is a bearer token
+	WebSocketBearerProtocol = "bearer"
+	// RFC 6750 query parameter
+	AccessTokenQueryParam = "access_token"
+)
+
+// Whether the request asks for a WebSocket upgrade
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketToken returns the bearer token of a WebSocket upgrade request, which
+// browsers cannot send in an Authorization header. The token is either passed
+// in the subprotocol list as "bearer, <token>" or in the access_token query
+// parameter. It is removed from the request so the protocol negotiated by the
+// upgrader can only be "bearer" or another protocol, never the token itself.
+// Requests which are not upgrades are left untouched.
+func WebSocketToken(r *http.Request) (string, bool) {
+	if !isWebSocketUpgrade(r) {
+		return "", false
+	}
+
+	var protocols []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	for i := 0; i+1 < len(protocols); i++ {
+		if !strings.EqualFold(protocols[i], WebSocketBearerProtocol) {
+			continue
+		}
+		token := protocols[i+1]
+		// keep "bearer", browsers fail the handshake when no protocol is echoed back
+		remaining := append(append([]string{}, protocols[:i+1]...), protocols[i+2:]...)
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(remaining, ", "))
+		return token, true
+	}
+
+	query := r.URL.Query()
+	if token := query.Get(AccessTokenQueryParam); token != "" {
+		query.Del(AccessTokenQueryParam)
+		r.URL.RawQuery = query.Encode()
+		return token, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,175 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"time"
+)
+
+// Plaintext of a wrapped key
+type wrappedKeyPayload struct {
+	Key        json.RawMessage `json:"key"`
+	CreatedAt  int64           `json:"created_at"`
+	ExportedAt int64           `json:"exported_at"`
+}
+
+// Wrapped key given to the import facet, cleared once imported
+type wrappedKeyOptions struct {
+	wrapped             string
+	recipientPrivateKey jwk.Key
+}
+
+// Key agreement or transport algorithm for the recipient key
+func wrapAlgorithm(recipient jwk.Key) (jwa.KeyEncryptionAlgorithm, error) {
+	switch recipient.KeyType() {
+	case jwa.RSA:
+		return jwa.RSA_OAEP_256, nil
+	case jwa.EC, jwa.OKP:
+		return jwa.ECDH_ES, nil
+	default:
+		return "", fmt.Errorf("unsupported recipient key type %q", recipient.KeyType())
+	}
+}
+
+// ExportWrappedKey returns the private key and its creation date as a compact
+// JWE encrypted to the recipient public key, with A256GCM content encryption.
+// The result holds the signing identity: never log it.
+func (c *Config) ExportWrappedKey(recipientPublicKey jwk.Key) (string, error) {
+	if recipientPublicKey == nil {
+		return "", fmt.Errorf("recipient key cannot be nil")
+	}
+	active := c.active()
+	if active == nil {
+		return "", fmt.Errorf("private key cannot be nil")
+	}
+	if active.multiPrimeKey != nil {
+		return "", fmt.Errorf("cannot export a multi-prime private key")
+	}
+	if active.signer != nil {
+		return "", fmt.Errorf("cannot export the key of a provider")
+	}
+	recipient, err := recipientPublicKey.PublicKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to create recipient public key %v", err)
+	}
+	alg, err := wrapAlgorithm(recipient)
+	if err != nil {
+		return "", err
+	}
+
+	var wrapped []byte
+	err = active.withPrivateKey(func(key jwk.Key) error {
+		if err := active.checkSigningKey(key); err != nil {
+			return err
+		}
+
+		// an unsealed key may have lost its alg, the public copy has it
+		if keyAlg := active.key.Algorithm(); keyAlg != nil && keyAlg.String() != "" {
+			if err := key.Set(jwk.AlgorithmKey, keyAlg); err != nil {
+				return fmt.Errorf("cannot set the algorithm of the private key %v", err)
+			}
+		}
+
+		keyJSON, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("cannot serialize private key %v", err)
+		}
+		defer wipe(keyJSON)
+
+		payload, err := json.Marshal(wrappedKeyPayload{
+			Key:        keyJSON,
+			CreatedAt:  active.createdAt.Unix(),
+			ExportedAt: time.Now().Unix(),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot serialize wrapped key %v", err)
+		}
+		defer wipe(payload)
+
+		wrapped, err = jwe.Encrypt(payload, jwe.WithKey(alg, recipient), jwe.WithContentEncryption(jwa.A256GCM))
+		if err != nil {
+			return fmt.Errorf("cannot encrypt private key %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c.audit(AuditEvent{
+		Action:  AuditKeyExported,
+		KeyID:   active.key.KeyID(),
+		Trigger: AuditTriggerAPI,
+		Details: map[string]string{"recipient_alg": alg.String()},
+	})
+
+	return string(wrapped), nil
+}
+
+// ImportWrappedKey imports a key produced by ExportWrappedKey, unwrapped with
+// the recipient private key
+func (n *ConfigBuilder) ImportWrappedKey(wrapped string, recipientPrivateKey jwk.Key) *ConfigImportKeyBuilder {
+	importer := n.ImportPrivateKey()
+	importer.initiateImportOptsIfNil()
+	importer.config.importPkOpts.wrapped = &wrappedKeyOptions{
+		wrapped:             wrapped,
+		recipientPrivateKey: recipientPrivateKey,
+	}
+	return importer
+}
+
+// Unwrap the private key, the creation date is zero if it was not exported
+func importWrappedKey(opts *wrappedKeyOptions) (jwk.Key, time.Time, error) {
+	// the wrapped key is not kept around once it has been used
+	defer func() {
+		opts.wrapped = ""
+		opts.recipientPrivateKey = nil
+	}()
+
+	if opts.recipientPrivateKey == nil {
+		return nil, time.Time{}, fmt.Errorf("recipient key cannot be nil")
+	}
+	alg, err := wrapAlgorithm(opts.recipientPrivateKey)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// errors never quote the wrapped key
+	msg, err := jwe.Parse([]byte(opts.wrapped))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot parse wrapped key")
+	}
+	if msg.ProtectedHeaders().ContentEncryption() != jwa.A256GCM {
+		return nil, time.Time{}, fmt.Errorf("wrapped key must use A256GCM content encryption")
+	}
+
+	plaintext, err := jwe.Decrypt([]byte(opts.wrapped), jwe.WithKey(alg, opts.recipientPrivateKey))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot decrypt wrapped key, wrong recipient key or tampered ciphertext")
+	}
+	defer wipe(plaintext)
+
+	var payload wrappedKeyPayload
+	if err = json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot parse wrapped key payload")
+	}
+	defer wipe(payload.Key)
+
+	if err = checkJWKJSON(payload.Key); err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot parse wrapped private key %v", err)
+	}
+	key, err := jwk.ParseKey(payload.Key)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot parse wrapped private key")
+	}
+
+	var createdAt time.Time
+	if payload.CreatedAt != 0 {
+		createdAt = time.Unix(payload.CreatedAt, 0)
+	}
+
+	return key, createdAt, nil
+}
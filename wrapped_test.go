@@ -0,0 +1,105 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"strings"
+	"testing"
+)
+
+func TestWrappedKeyRoundTrip(t *testing.T) {
+	ecRecipient, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, raw := range map[string]interface{}{"RSA recipient": newRSAKey(t), "EC recipient": ecRecipient} {
+		t.Run(name, func(t *testing.T) {
+			bootstrap, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).WithKeyId("shared").Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bootstrap.Close()
+
+			recipient, err := jwk.FromRaw(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recipientPublic, err := recipient.PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, err := bootstrap.ExportWrappedKey(recipientPublic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replica, err := NewConfigBuilder().ImportWrappedKey(wrapped, recipient).Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer replica.Close()
+
+			// the replica signs tokens the key set of the bootstrap instance validates
+			signed, err := replica.signToken(jwt.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = jwt.Parse(signed, jwt.WithKeySet(servedKeySet(t, bootstrap))); err != nil {
+				t.Fatalf("token of the replica does not verify against the bootstrap key set: %v", err)
+			}
+		})
+	}
+}
+
+func TestWrappedKeyRefusesTamperingAndOtherRecipients(t *testing.T) {
+	bootstrap, err := NewConfigBuilder().NewPrivateKey().WithKeyLength(2048).WithKeyId("shared").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bootstrap.Close()
+	recipient, err := jwk.FromRaw(newRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientPublic, err := recipient.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := bootstrap.ExportWrappedKey(recipientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := jwk.FromRaw(newRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a character of the ciphertext, the fourth segment
+	segments := strings.Split(wrapped, ".")
+	ciphertext := []byte(segments[3])
+	if ciphertext[0] == 'A' {
+		ciphertext[0] = 'B'
+	} else {
+		ciphertext[0] = 'A'
+	}
+	segments[3] = string(ciphertext)
+	tampered := strings.Join(segments, ".")
+
+	for name, tt := range map[string]struct {
+		wrapped string
+		key     jwk.Key
+	}{
+		"tampered ciphertext": {tampered, recipient},
+		"other recipient":     {wrapped, other},
+	} {
+		_, err := NewConfigBuilder().ImportWrappedKey(tt.wrapped, tt.key).Build()
+		if err == nil {
+			t.Fatalf("%s was imported", name)
+		}
+		if strings.Contains(err.Error(), segments[3]) {
+			t.Fatalf("%s: error %q quotes the wrapped key", name, err)
+		}
+	}
+}
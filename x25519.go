@@ -0,0 +1,50 @@
+package gin_jwks_rsa
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/x25519"
+)
+
+// Key agreement algorithms an X25519 key can decrypt
+var x25519KeyEncryptionAlgorithms = []jwa.KeyEncryptionAlgorithm{
+	jwa.ECDH_ES,
+	jwa.ECDH_ES_A128KW,
+	jwa.ECDH_ES_A192KW,
+	jwa.ECDH_ES_A256KW,
+}
+
+// Generate an X25519 private key
+func generateX25519Key() (jwk.Key, error) {
+	_, rawPrivateKey, err := x25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new X25519 private key: %v", err)
+	}
+
+	key, err := jwk.FromRaw(rawPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key: %v", err)
+	}
+
+	return key, nil
+}
+
+func isX25519PrivateKey(key jwk.Key) bool {
+	okpKey, ok := key.(jwk.OKPPrivateKey)
+	return ok && okpKey.Crv() == jwa.X25519
+}
+
+func isX25519PublicKey(key jwk.Key) bool {
+	okpKey, ok := key.(jwk.OKPPublicKey)
+	return ok && okpKey.Crv() == jwa.X25519
+}
+
+// Short name of the key used in error messages
+func keyDescription(key jwk.Key) string {
+	if okpKey, ok := key.(jwk.OKPPublicKey); ok {
+		return okpKey.Crv().String()
+	}
+	return key.KeyType().String()
+}
@@ -0,0 +1,91 @@
+package gin_jwks_rsa
+
+import (
+	"encoding/json"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestX25519RoundTrip(t *testing.T) {
+	generated, err := NewConfigBuilder().
+		NewPrivateKey().
+		WithKeyType(jwa.OKP).
+		WithCurve(jwa.X25519).
+		WithKeyUsage(KeyUsageAsEncryption).
+		WithKeyId("generated").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer generated.Close()
+
+	key, err := generateX25519Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "x25519.json")
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := NewConfigBuilder().ImportPrivateKey().WithPath(path).WithKeyId("imported").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.Close()
+
+	for kid, config := range map[string]*Config{"generated": generated, "imported": imported} {
+		published, ok := servedKeySet(t, config).LookupKeyID(kid)
+		if !ok {
+			t.Fatalf("%s key is not served", kid)
+		}
+		if published.KeyType() != jwa.OKP || published.KeyUsage() != KeyUsageAsEncryption {
+			t.Fatalf("%s key published as %s with use %q", kid, published.KeyType(), published.KeyUsage())
+		}
+		if crv, _ := published.Get("crv"); crv != jwa.X25519 {
+			t.Fatalf("%s key published on curve %v", kid, crv)
+		}
+
+		// a partner encrypts to the served public key
+		for _, alg := range []jwa.KeyEncryptionAlgorithm{jwa.ECDH_ES, jwa.ECDH_ES_A128KW, jwa.ECDH_ES_A256KW} {
+			encrypted, err := jwe.Encrypt([]byte("payload"), jwe.WithKey(alg, published))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plaintext, err := config.Decrypt(encrypted)
+			if err != nil {
+				t.Fatalf("%s key cannot decrypt %s: %v", kid, alg, err)
+			}
+			if string(plaintext) != "payload" {
+				t.Fatalf("%s key decrypted %q", kid, plaintext)
+			}
+		}
+
+		if _, err = config.signToken(jwt.New()); err == nil {
+			t.Fatalf("%s X25519 key signed a token", kid)
+		}
+	}
+}
+
+func TestX25519KeyCannotBeUsedForSigning(t *testing.T) {
+	_, err := NewConfigBuilder().
+		NewPrivateKey().
+		WithKeyType(jwa.OKP).
+		WithCurve(jwa.X25519).
+		WithKeyUsage(KeyUsageAsSignature).
+		Build()
+	if err == nil {
+		t.Fatal("an X25519 key was configured to sign")
+	}
+	if !strings.Contains(err.Error(), "X25519") {
+		t.Fatalf("error %q does not say the key is X25519", err)
+	}
+}